@@ -1,8 +1,9 @@
 package client
 
 import (
-	"bufio"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"github.com/jwambugu/pcbook-grpc/protos/pb"
 	"google.golang.org/grpc"
@@ -15,6 +16,18 @@ import (
 	"time"
 )
 
+const (
+	// imageUploadChunkSize is the size of the chunks UploadImage sends
+	imageUploadChunkSize = 32 << 10 // 32KB
+	// imageUploadAttempts is how many times UploadImage sends the rest of an image before giving up
+	imageUploadAttempts = 5
+	// imageUploadAttemptTimeout is how long UploadImage waits for the rest of an image to be sent
+	imageUploadAttemptTimeout = 30 * time.Second
+	// imageUploadRetryDelay is how long UploadImage waits before resuming an upload the first time, the delay doubles
+	// with every attempt
+	imageUploadRetryDelay = 200 * time.Millisecond
+)
+
 // LaptopClient is a client for the Laptop service RPCs
 type LaptopClient struct {
 	service pb.LaptopServiceClient
@@ -89,70 +102,152 @@ func (laptopClient *LaptopClient) SearchLaptop(filter *pb.Filter) {
 	}
 }
 
-// UploadImage calls the UploadImage RPC to upload an image of a laptop.
-func (laptopClient *LaptopClient) UploadImage(laptopID string, imagePath string) {
+// UploadImage uploads an image of a laptop in chunks and returns its id. When the upload breaks on a transient
+// error, it asks the server how much of the image it received and resumes from there.
+func (laptopClient *LaptopClient) UploadImage(laptopID string, imagePath string) (string, error) {
 	file, err := os.Open(imagePath)
 	if err != nil {
-		log.Fatalf("failed to open image file: %v", err)
+		return "", fmt.Errorf("failed to open image file: %v", err)
 	}
 
 	defer func(file *os.File) {
 		_ = file.Close()
 	}(file)
 
+	checksum := sha256.New()
+	if _, err := io.Copy(checksum, file); err != nil {
+		return "", fmt.Errorf("failed to read image file: %v", err)
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	stream, err := laptopClient.service.UploadImage(ctx)
+	req := &pb.InitImageUploadRequest{
+		Info: &pb.ImageInfo{
+			LaptopId:      laptopID,
+			FileExtension: filepath.Ext(imagePath),
+		},
+	}
+
+	initRes, err := laptopClient.service.InitImageUpload(ctx, req)
 	if err != nil {
-		log.Fatalf("failed to create upload laptop stream: %v", err)
+		return "", fmt.Errorf("failed to start image upload: %v", err)
 	}
 
-	req := &pb.UploadImageRequest{
-		Data: &pb.UploadImageRequest_Info{
-			Info: &pb.ImageInfo{
-				LaptopId:      laptopID,
-				FileExtension: filepath.Ext(imagePath),
-			},
-		},
+	uploadID := initRes.GetUploadId()
+	retryDelay := imageUploadRetryDelay
+
+	for attempt := 1; ; attempt++ {
+		err := laptopClient.uploadImageChunks(uploadID, file)
+		if err == nil {
+			break
+		}
+
+		if attempt == imageUploadAttempts || !isTransientError(err) {
+			return "", fmt.Errorf("failed to upload image: %w", err)
+		}
+
+		log.Printf("resuming upload %s in %s after: %v", uploadID, retryDelay, err)
+
+		time.Sleep(retryDelay)
+		retryDelay *= 2
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := laptopClient.service.FinalizeImageUpload(ctx, &pb.FinalizeImageUploadRequest{
+		UploadId: uploadID,
+		Sha256:   hex.EncodeToString(checksum.Sum(nil)),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to complete image upload: %w", err)
+	}
+
+	log.Printf("uploaded image with id %s, size - %d", res.GetId(), res.GetSize())
+
+	return res.GetId(), nil
+}
+
+// uploadImageChunks sends the part of the image file that the server has not received yet.
+func (laptopClient *LaptopClient) uploadImageChunks(uploadID string, file *os.File) error {
+	ctx, cancel := context.WithTimeout(context.Background(), imageUploadAttemptTimeout)
+	defer cancel()
+
+	statusRes, err := laptopClient.service.GetImageUploadStatus(ctx, &pb.GetImageUploadStatusRequest{
+		UploadId: uploadID,
+	})
+	if err != nil {
+		return err
+	}
+
+	offset := int64(statusRes.GetReceivedSize())
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to read image file info: %v", err)
+	}
+
+	// The server received the whole image before the previous stream broke.
+	if offset == fileInfo.Size() {
+		return nil
+	}
+
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek image file: %v", err)
 	}
 
-	err = stream.Send(req)
+	stream, err := laptopClient.service.UploadImageChunk(ctx)
 	if err != nil {
-		log.Fatalf("failed to send request: %v - %v", err, stream.RecvMsg(nil))
+		return err
 	}
 
-	reader := bufio.NewReader(file)
-	buffer := make([]byte, 1024)
+	buffer := make([]byte, imageUploadChunkSize)
 
 	for {
-		n, err := reader.Read(buffer)
+		n, err := io.ReadFull(file, buffer)
 		if err == io.EOF {
 			break
 		}
 
-		if err != nil {
-			log.Fatalf("failed to read chunk to buffer: %v", err)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return fmt.Errorf("failed to read chunk to buffer: %v", err)
 		}
 
-		req := &pb.UploadImageRequest{
-			Data: &pb.UploadImageRequest_ChunkData{
-				ChunkData: buffer[:n],
-			},
+		req := &pb.UploadImageChunkRequest{
+			UploadId:  uploadID,
+			Offset:    uint64(offset),
+			ChunkData: buffer[:n],
 		}
 
-		err = stream.Send(req)
-		if err != nil {
-			log.Fatalf("failed to send chunk: %v - %v", err, stream.RecvMsg(nil))
+		// The error of a stream the server closed is returned by CloseAndRecv.
+		if err := stream.Send(req); err != nil {
+			break
 		}
+
+		offset += int64(n)
 	}
 
 	res, err := stream.CloseAndRecv()
 	if err != nil {
-		log.Fatalf("failed to complete image upload: %v", err)
+		return err
 	}
 
-	log.Printf("uploaded image with id %s, size - %d", res.GetId(), res.GetSize())
+	if int64(res.GetReceivedSize()) != offset {
+		return fmt.Errorf("server received %d bytes of the image, sent %d", res.GetReceivedSize(), offset)
+	}
+
+	return nil
+}
+
+// isTransientError reports whether a failed call may succeed when retried.
+func isTransientError(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.Aborted, codes.ResourceExhausted:
+		return true
+	default:
+		return false
+	}
 }
 
 // RateLaptop calls the RateLaptop RPC to rate a laptop.
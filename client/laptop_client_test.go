@@ -0,0 +1,150 @@
+package client
+
+import (
+	"bytes"
+	"github.com/jwambugu/pcbook-grpc/factory"
+	"github.com/jwambugu/pcbook-grpc/protos/pb"
+	"github.com/jwambugu/pcbook-grpc/service"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// droppingStream is a server stream that fails once it received a number of messages
+type droppingStream struct {
+	grpc.ServerStream
+	remaining int
+	dropped   bool
+}
+
+// RecvMsg receives the next message unless the stream has to fail.
+func (stream *droppingStream) RecvMsg(m interface{}) error {
+	if stream.remaining == 0 {
+		stream.dropped = true
+		return io.ErrUnexpectedEOF
+	}
+
+	stream.remaining--
+
+	return stream.ServerStream.RecvMsg(m)
+}
+
+// dropUploadStreams returns an interceptor that breaks the first UploadImageChunk streams after they received a
+// chunk, as a dropped connection would.
+func dropUploadStreams(drops int) grpc.StreamServerInterceptor {
+	var mutex sync.Mutex
+
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		mutex.Lock()
+		drop := info.FullMethod == "/pcbook.LaptopService/UploadImageChunk" && drops > 0
+		if drop {
+			drops--
+		}
+		mutex.Unlock()
+
+		if !drop {
+			return handler(srv, ss)
+		}
+
+		stream := &droppingStream{ServerStream: ss, remaining: 1}
+
+		err := handler(srv, stream)
+		if stream.dropped {
+			return status.Error(codes.Unavailable, "connection dropped")
+		}
+
+		return err
+	}
+}
+
+func TestLaptopClient_UploadImage(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name        string
+		drops       int
+		expectedErr string
+	}{
+		{
+			name: "no drops",
+		},
+		{
+			name:  "resumed",
+			drops: 2,
+		},
+		{
+			name:        "too many drops",
+			drops:       imageUploadAttempts,
+			expectedErr: "connection dropped",
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			imageStore, err := service.OpenDiskImageStore(t.TempDir())
+			require.NoError(t, err)
+
+			laptopStore := service.NewInMemoryLaptopStore()
+
+			laptop := factory.NewLaptop()
+			require.NoError(t, laptopStore.Save(laptop))
+
+			grpcServer := grpc.NewServer(grpc.StreamInterceptor(dropUploadStreams(tc.drops)))
+			pb.RegisterLaptopServiceServer(grpcServer, service.NewLaptopServer(laptopStore, imageStore, nil))
+
+			listener, err := net.Listen("tcp", ":0")
+			require.NoError(t, err)
+
+			go func() {
+				_ = grpcServer.Serve(listener)
+			}()
+
+			t.Cleanup(grpcServer.Stop)
+
+			conn, err := grpc.Dial(listener.Addr().String(), grpc.WithInsecure())
+			require.NoError(t, err)
+
+			t.Cleanup(func() {
+				_ = conn.Close()
+			})
+
+			// The image spans more chunks than there are attempts, so every dropped stream leaves part of it on the
+			// server.
+			imageData := factory.NewImage(".png", 300, 300)
+			require.Greater(t, len(imageData), imageUploadAttempts*imageUploadChunkSize)
+
+			imagePath := filepath.Join(t.TempDir(), "laptop.png")
+			require.NoError(t, os.WriteFile(imagePath, imageData, 0o600))
+
+			imageID, err := NewLaptopClient(conn).UploadImage(laptop.GetId(), imagePath)
+			if tc.expectedErr != "" {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), tc.expectedErr)
+
+				return
+			}
+
+			require.NoError(t, err)
+
+			info, uploadedData, err := imageStore.Open(imageID)
+			require.NoError(t, err)
+			require.Equal(t, ".png", info.Extension)
+
+			data, err := io.ReadAll(uploadedData)
+			require.NoError(t, err)
+			require.NoError(t, uploadedData.Close())
+			require.True(t, bytes.Equal(imageData, data))
+		})
+	}
+}
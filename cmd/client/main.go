@@ -10,6 +10,7 @@ import (
 	"github.com/jwambugu/pcbook-grpc/protos/pb"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/protobuf/proto"
 	"io"
 	"log"
 	"os"
@@ -25,9 +26,9 @@ func testCreateLaptop(laptopClient *client.LaptopClient) {
 
 func testSearchLaptop(laptopClient *client.LaptopClient) {
 	filter := &pb.Filter{
-		MaxPriceUsd:     3000,
-		MinCpuCores:     4,
-		MinCpuFrequency: 2.5,
+		MaxPriceUsd:     proto.Float64(3000),
+		MinCpuCores:     proto.Uint32(4),
+		MinCpuFrequency: proto.Float64(2.5),
 		MinRam: &pb.Memory{
 			Value: 8,
 			Unit:  pb.Memory_GIGABYTE,
@@ -41,7 +42,9 @@ func testUploadImage(laptopClient *client.LaptopClient) {
 	laptop := factory.NewLaptop()
 
 	laptopClient.CreateLaptop(laptop)
-	laptopClient.UploadImage(laptop.GetId(), "tmp/laptop.jpg")
+	if _, err := laptopClient.UploadImage(laptop.GetId(), "tmp/laptop.jpg"); err != nil {
+		log.Fatalf("failed to upload image: %v", err)
+	}
 }
 
 func testRateLaptop(laptopClient *client.LaptopClient) {
@@ -81,9 +84,15 @@ func authMethods() map[string]struct{} {
 	const laptopServicePath = "/pcbook.LaptopService"
 
 	return map[string]struct{}{
-		fmt.Sprintf("%s/CreateLaptop", laptopServicePath): {},
-		fmt.Sprintf("%s/UploadImage", laptopServicePath):  {},
-		fmt.Sprintf("%s/RateLaptop", laptopServicePath):   {},
+		fmt.Sprintf("%s/CreateLaptop", laptopServicePath):         {},
+		fmt.Sprintf("%s/UpdateLaptop", laptopServicePath):         {},
+		fmt.Sprintf("%s/DeleteLaptop", laptopServicePath):         {},
+		fmt.Sprintf("%s/UploadImage", laptopServicePath):          {},
+		fmt.Sprintf("%s/InitImageUpload", laptopServicePath):      {},
+		fmt.Sprintf("%s/UploadImageChunk", laptopServicePath):     {},
+		fmt.Sprintf("%s/GetImageUploadStatus", laptopServicePath): {},
+		fmt.Sprintf("%s/FinalizeImageUpload", laptopServicePath):  {},
+		fmt.Sprintf("%s/RateLaptop", laptopServicePath):           {},
 	}
 }
 
@@ -4,6 +4,7 @@ import (
 	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
@@ -12,11 +13,14 @@ import (
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/reflection"
+	"google.golang.org/protobuf/proto"
 	"io"
 	"log"
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 )
 
@@ -24,6 +28,8 @@ const (
 	// TODO: read from config
 	jwtSecretKey     = "67#$>-,x?`TSZe]\"<B{}&}8}/Gj]b$T>"
 	jwtTokenDuration = 15 * time.Minute
+	// shutdownTimeout is how long a stopping server waits for the pending calls to finish
+	shutdownTimeout = 10 * time.Second
 
 	serverCertFile = "certs/server-cert.pem"
 	serverKeyFile  = "certs/server-key.pem"
@@ -32,7 +38,7 @@ const (
 type runServerOpts struct {
 	listener       net.Listener
 	authUserServer pb.AuthServiceServer
-	laptopServer   pb.LaptopServiceServer
+	laptopServer   *service.LaptopServer
 	jwtManager     *service.JWTManager
 	enableTLS      bool
 }
@@ -47,20 +53,148 @@ func createUser(userStore service.UserStore, username, password, role string) er
 }
 
 func seedUsers(userStore service.UserStore) error {
-	if err := createUser(userStore, "admin", "secret", "admin"); err != nil {
+	// Users persisted by an earlier run are kept as they are.
+	if err := createUser(userStore, "admin", "secret", "admin"); err != nil && !errors.Is(err, service.ErrRecordExists) {
 		return err
 	}
 
-	return createUser(userStore, "user", "secret", "user")
+	if err := createUser(userStore, "user", "secret", "user"); err != nil && !errors.Is(err, service.ErrRecordExists) {
+		return err
+	}
+
+	return nil
+}
+
+type stores struct {
+	userStore   service.UserStore
+	laptopStore service.LaptopStore
+	ratingStore service.RatingStore
+	imageStore  service.ImageStore
+	// closer closes the stores when the server stops, it is nil when the stores need no closing
+	closer io.Closer
+}
+
+type storeOpts struct {
+	backend      string
+	sqlitePath   string
+	boltPath     string
+	walDir       string
+	walSync      string
+	imagesFolder string
+}
+
+// walSyncModes maps the values of the wal-sync flag to the sync modes of the write-ahead log
+var walSyncModes = map[string]service.WALSyncMode{
+	"always":   service.WALSyncAlways,
+	"interval": service.WALSyncInterval,
+	"never":    service.WALSyncNever,
+}
+
+// openImageStore returns the image store of the backend, either disk, which is the image store of the stores, or s3.
+// The credentials of the s3 backend are read from the AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN
+// environment variables.
+func openImageStore(backend string, stores *stores, s3Config service.S3Config) (service.ImageStore, error) {
+	switch backend {
+	case "disk":
+		return stores.imageStore, nil
+	case "s3":
+		s3Config.AccessKeyID = os.Getenv("AWS_ACCESS_KEY_ID")
+		s3Config.SecretAccessKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+		s3Config.SessionToken = os.Getenv("AWS_SESSION_TOKEN")
+
+		return service.NewS3ImageStore(s3Config)
+	default:
+		return nil, fmt.Errorf("unknown image store backend %q", backend)
+	}
+}
+
+// openStores returns the stores of the backend, either memory, sqlite, bolt or wal
+func openStores(opts storeOpts) (*stores, error) {
+	imageStore, err := service.OpenDiskImageStore(opts.imagesFolder)
+	if err != nil {
+		return nil, err
+	}
+
+	switch opts.backend {
+	case "memory":
+		return &stores{
+			userStore:   service.NewInMemoryUserStore(),
+			laptopStore: service.NewInMemoryLaptopStore(),
+			ratingStore: service.NewInMemoryRatingStore(),
+			imageStore:  imageStore,
+		}, nil
+	case "sqlite":
+		db, err := service.OpenSQLite(opts.sqlitePath)
+		if err != nil {
+			return nil, err
+		}
+
+		return &stores{
+			userStore:   service.NewSQLiteUserStore(db),
+			laptopStore: service.NewSQLiteLaptopStore(db),
+			ratingStore: service.NewSQLiteRatingStore(db),
+			imageStore:  imageStore,
+			closer:      db,
+		}, nil
+	case "bolt":
+		db, err := service.OpenBolt(opts.boltPath)
+		if err != nil {
+			return nil, err
+		}
+
+		return &stores{
+			userStore:   service.NewInMemoryUserStore(),
+			laptopStore: service.NewBoltLaptopStore(db),
+			ratingStore: service.NewBoltRatingStore(db),
+			imageStore:  imageStore,
+			closer:      db,
+		}, nil
+	case "wal":
+		syncMode, ok := walSyncModes[opts.walSync]
+		if !ok {
+			return nil, fmt.Errorf("unknown write-ahead log sync mode %q", opts.walSync)
+		}
+
+		wal, err := service.OpenWAL(opts.walDir, service.WALStores{
+			Laptops: service.NewInMemoryLaptopStore(),
+			Ratings: service.NewInMemoryRatingStore(),
+			Users:   service.NewInMemoryUserStore(),
+			Images:  imageStore,
+		}, service.WALOptions{
+			Sync:             syncMode,
+			SnapshotRecords:  10000,
+			SnapshotInterval: time.Hour,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		return &stores{
+			userStore:   wal.UserStore(),
+			laptopStore: wal.LaptopStore(),
+			ratingStore: wal.RatingStore(),
+			imageStore:  wal.ImageStore(),
+			closer:      wal,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown store backend %q", opts.backend)
+	}
 }
 
 func accessibleRoles() map[string][]string {
 	const laptopServicePath = "/pcbook.LaptopService"
 
 	return map[string][]string{
-		fmt.Sprintf("%s/CreateLaptop", laptopServicePath): {"admin"},
-		fmt.Sprintf("%s/UploadImage", laptopServicePath):  {"admin"},
-		fmt.Sprintf("%s/RateLaptop", laptopServicePath):   {"admin", "user"},
+		fmt.Sprintf("%s/CreateLaptop", laptopServicePath):         {"admin"},
+		fmt.Sprintf("%s/UpdateLaptop", laptopServicePath):         {"admin"},
+		fmt.Sprintf("%s/DeleteLaptop", laptopServicePath):         {"admin"},
+		fmt.Sprintf("%s/UploadImage", laptopServicePath):          {"admin"},
+		fmt.Sprintf("%s/InitImageUpload", laptopServicePath):      {"admin"},
+		fmt.Sprintf("%s/UploadImageChunk", laptopServicePath):     {"admin"},
+		fmt.Sprintf("%s/GetImageUploadStatus", laptopServicePath): {"admin"},
+		fmt.Sprintf("%s/FinalizeImageUpload", laptopServicePath):  {"admin"},
+		fmt.Sprintf("%s/DeleteImage", laptopServicePath):          {"admin"},
+		fmt.Sprintf("%s/RateLaptop", laptopServicePath):           {"admin", "user"},
 	}
 }
 
@@ -97,14 +231,36 @@ func loadTLSCredentials() (credentials.TransportCredentials, error) {
 	return credentials.NewTLS(config), nil
 }
 
-// runGRPCServer runs the gRPC server with the given options
-func runGRPCServer(opts runServerOpts) error {
-	interceptor := service.NewAuthInterceptor(opts.jwtManager, accessibleRoles())
-
-	serverOptions := []grpc.ServerOption{
+// newGRPCServer creates a gRPC server of the services that authorizes calls with the interceptor
+func newGRPCServer(
+	opts runServerOpts, interceptor *service.AuthInterceptor, serverOptions ...grpc.ServerOption,
+) *grpc.Server {
+	serverOptions = append(serverOptions,
 		grpc.UnaryInterceptor(interceptor.Unary()),
 		grpc.StreamInterceptor(interceptor.Stream()),
-	}
+	)
+
+	grpcServer := grpc.NewServer(serverOptions...)
+
+	pb.RegisterLaptopServiceServer(grpcServer, opts.laptopServer)
+	pb.RegisterAuthServiceServer(grpcServer, opts.authUserServer)
+
+	return grpcServer
+}
+
+// stopGRPCServer stops the gRPC server once its pending calls finish, cancelling the calls still running after the
+// shutdown timeout, such as watches
+func stopGRPCServer(grpcServer *grpc.Server) {
+	timer := time.AfterFunc(shutdownTimeout, grpcServer.Stop)
+	defer timer.Stop()
+
+	grpcServer.GracefulStop()
+}
+
+// runGRPCServer runs the gRPC server with the given options until the context is done, when it stops once the
+// pending calls finish
+func runGRPCServer(ctx context.Context, opts runServerOpts) error {
+	var serverOptions []grpc.ServerOption
 
 	if opts.enableTLS {
 		tlsCredentials, err := loadTLSCredentials()
@@ -115,47 +271,177 @@ func runGRPCServer(opts runServerOpts) error {
 		serverOptions = append(serverOptions, grpc.Creds(tlsCredentials))
 	}
 
-	grpcServer := grpc.NewServer(serverOptions...)
-
-	pb.RegisterLaptopServiceServer(grpcServer, opts.laptopServer)
-	pb.RegisterAuthServiceServer(grpcServer, opts.authUserServer)
+	grpcServer := newGRPCServer(opts, service.NewAuthInterceptor(opts.jwtManager, accessibleRoles()), serverOptions...)
 	reflection.Register(grpcServer)
 
+	go func() {
+		<-ctx.Done()
+		stopGRPCServer(grpcServer)
+	}()
+
 	log.Printf("Starting GRPC server on %s, TLS = %t", opts.listener.Addr().String(), opts.enableTLS)
 	return grpcServer.Serve(opts.listener)
 }
 
-// runRESTServer runs the REST server with the given options
-func runRESTServer(opts runServerOpts) error {
-	mux := runtime.NewServeMux()
+// setLaptopETag sets the ETag header of REST responses that carry a laptop to the laptop's revision.
+func setLaptopETag(_ context.Context, w http.ResponseWriter, message proto.Message) error {
+	var laptop *pb.Laptop
+
+	switch res := message.(type) {
+	case *pb.GetLaptopResponse:
+		laptop = res.GetLaptop()
+	case *pb.UpdateLaptopResponse:
+		laptop = res.GetLaptop()
+	default:
+		return nil
+	}
 
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	w.Header().Set("ETag", service.FormatETag(laptop.GetRevision()))
+	return nil
+}
+
+// runRESTServer runs the REST server with the given options until the context is done, when it stops once the
+// pending requests finish. The gateway calls the services through a gRPC server on a loopback address, so that
+// requests are authorized by the same interceptors as gRPC calls.
+func runRESTServer(ctx context.Context, opts runServerOpts) error {
+	interceptor := service.NewAuthInterceptor(opts.jwtManager, accessibleRoles())
+
+	grpcListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return fmt.Errorf("could not listen for the gateway gRPC server: %v", err)
+	}
+
+	grpcServer := newGRPCServer(opts, interceptor)
+	defer stopGRPCServer(grpcServer)
+
+	go func() {
+		if err := grpcServer.Serve(grpcListener); err != nil {
+			log.Printf("could not run the gateway gRPC server: %v", err)
+		}
+	}()
+
+	conn, err := grpc.DialContext(ctx, grpcListener.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		return fmt.Errorf("could not connect to the gateway gRPC server: %v", err)
+	}
+
+	defer conn.Close()
 
-	if err := pb.RegisterAuthServiceHandlerServer(ctx, mux, opts.authUserServer); err != nil {
+	mux := runtime.NewServeMux(runtime.WithForwardResponseOption(setLaptopETag))
+
+	if err := pb.RegisterAuthServiceHandler(ctx, mux, conn); err != nil {
+		return err
+	}
+
+	if err := pb.RegisterLaptopServiceHandler(ctx, mux, conn); err != nil {
 		return err
 	}
 
-	if err := pb.RegisterLaptopServiceHandlerServer(ctx, mux, opts.laptopServer); err != nil {
+	// DownloadImage streams protobuf chunks, REST clients get the bytes of the image instead.
+	serveImage := interceptor.HTTP("/pcbook.LaptopService/DownloadImage", opts.laptopServer.ServeImage)
+	if err := mux.HandlePath(http.MethodGet, "/v1/laptop/images/{id}", serveImage); err != nil {
 		return err
 	}
 
+	server := &http.Server{Handler: mux}
+	shutdown := make(chan error, 1)
+
+	go func() {
+		<-ctx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+
+		// Requests still running after the shutdown timeout, such as watches, are cancelled.
+		err := server.Shutdown(shutdownCtx)
+		if errors.Is(err, context.DeadlineExceeded) {
+			err = server.Close()
+		}
+
+		shutdown <- err
+	}()
+
 	log.Printf("Starting REST server on %s, TLS = %t", opts.listener.Addr().String(), opts.enableTLS)
 
 	if opts.enableTLS {
-		return http.ServeTLS(opts.listener, mux, serverCertFile, serverKeyFile)
+		err = server.ServeTLS(opts.listener, serverCertFile, serverKeyFile)
+	} else {
+		err = server.Serve(opts.listener)
 	}
 
-	return http.Serve(opts.listener, mux)
+	if !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return <-shutdown
 }
 
 func main() {
 	port := flag.Int("port", 8080, "server port to listen on")
 	enableTLS := flag.Bool("enable-tls", false, "enables TLS")
 	serverType := flag.String("server-type", "grpc", "type of server to run -  (grpc/rest)")
+	storeBackend := flag.String(
+		"store", "memory", "where to store users, laptops and ratings - (memory/sqlite/bolt/wal)",
+	)
+	sqlitePath := flag.String("sqlite-path", "storage/pcbook.db", "path of the SQLite database of the sqlite store")
+	boltPath := flag.String("bolt-path", "storage/pcbook.bolt", "path of the bbolt database of the bolt store")
+	walDir := flag.String("wal-dir", "storage/wal", "directory of the write-ahead log of the wal store")
+	walSync := flag.String("wal-sync", "always", "when the wal store syncs its log to disk - (always/interval/never)")
+	maxImageSize := flag.Int64("max-image-size", service.DefaultMaxImageSize, "size limit of uploaded images in bytes")
+	imageUploadTimeout := flag.Duration(
+		"image-upload-timeout", service.DefaultImageUploadTimeout, "how long unfinished image uploads are kept without data",
+	)
+	imageStoreBackend := flag.String("image-store", "disk", "where to store uploaded images - (disk/s3)")
+	s3Endpoint := flag.String(
+		"s3-endpoint", "https://s3.amazonaws.com", "URL of the S3-compatible API of the s3 image store",
+	)
+	s3Region := flag.String("s3-region", "us-east-1", "region of the bucket of the s3 image store")
+	s3Bucket := flag.String("s3-bucket", "", "bucket of the s3 image store")
+	s3Prefix := flag.String("s3-prefix", "", "prefix of the keys of the objects of the s3 image store")
+	s3PartSize := flag.Int64(
+		"s3-part-size",
+		service.DefaultS3PartSize,
+		"size of the parts images are uploaded in by the s3 image store, at least 5MB",
+	)
+	renditionWorkers := flag.Int(
+		"rendition-workers", service.DefaultImageRenditionWorkers, "number of images resized at once, 0 disables renditions",
+	)
+	minRatingScore := flag.Float64(
+		"min-rating-score", service.DefaultMinRatingScore, "lowest score a laptop can be rated",
+	)
+	maxRatingScore := flag.Float64(
+		"max-rating-score", service.DefaultMaxRatingScore, "highest score a laptop can be rated",
+	)
 	flag.Parse()
 
-	userStore := service.NewInMemoryUserStore()
+	if *minRatingScore > *maxRatingScore {
+		log.Fatalf("min rating score %v is greater than max rating score %v", *minRatingScore, *maxRatingScore)
+	}
+
+	stores, err := openStores(storeOpts{
+		backend:      *storeBackend,
+		sqlitePath:   *sqlitePath,
+		boltPath:     *boltPath,
+		walDir:       *walDir,
+		walSync:      *walSync,
+		imagesFolder: "storage/public",
+	})
+	if err != nil {
+		log.Fatalf("could not open %s stores: %v", *storeBackend, err)
+	}
+
+	imageStore, err := openImageStore(*imageStoreBackend, stores, service.S3Config{
+		Endpoint: *s3Endpoint,
+		Region:   *s3Region,
+		Bucket:   *s3Bucket,
+		Prefix:   *s3Prefix,
+		PartSize: *s3PartSize,
+	})
+	if err != nil {
+		log.Fatalf("could not open %s image store: %v", *imageStoreBackend, err)
+	}
+
+	userStore := stores.userStore
 
 	if err := seedUsers(userStore); err != nil {
 		log.Fatalf("could not seed users: %v", err)
@@ -164,10 +450,34 @@ func main() {
 	jwtManager := service.NewJWTManager(jwtSecretKey, jwtTokenDuration)
 	authUserServer := service.NewAuthUserServer(userStore, jwtManager)
 
-	laptopStore := service.NewInMemoryLaptopStore()
-	imageStore := service.NewDiskImageStore("storage/public")
-	ratingStore := service.NewInMemoryRatingStore()
-	laptopServer := service.NewLaptopServer(laptopStore, imageStore, ratingStore)
+	laptopServerOptions := []service.LaptopServerOption{
+		service.WithMaxImageSize(*maxImageSize),
+		service.WithImageUploadTimeout(*imageUploadTimeout),
+		service.WithRatingScoreRange(*minRatingScore, *maxRatingScore),
+	}
+
+	var imageRenderer *service.ImageRenderer
+
+	if *renditionWorkers > 0 {
+		imageRenderer = service.NewImageRenderer(imageStore, *renditionWorkers, service.DefaultImageRenditionQueueSize)
+
+		laptopServerOptions = append(laptopServerOptions, service.WithImageRenderer(imageRenderer))
+	}
+
+	laptopServer := service.NewLaptopServer(
+		stores.laptopStore, imageStore, stores.ratingStore, laptopServerOptions...,
+	)
+
+	// The server stops on SIGINT or SIGTERM, so that the stores are closed and nothing written is lost.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// The renditions of the images still queued when the server stopped are generated again, unless it stops again.
+	go func() {
+		if err := laptopServer.ResumeRenditions(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("could not resume image renditions: %v", err)
+		}
+	}()
 
 	address := fmt.Sprintf("0.0.0.0:%d", *port)
 
@@ -185,9 +495,20 @@ func main() {
 	}
 
 	if *serverType == "grpc" {
-		err = runGRPCServer(opts)
+		err = runGRPCServer(ctx, opts)
 	} else {
-		err = runRESTServer(opts)
+		err = runRESTServer(ctx, opts)
+	}
+
+	// The renditions still queued are written to the stores before they are closed.
+	if imageRenderer != nil {
+		imageRenderer.Close()
+	}
+
+	if stores.closer != nil {
+		if err := stores.closer.Close(); err != nil {
+			log.Printf("could not close %s stores: %v", *storeBackend, err)
+		}
 	}
 
 	if err != nil {
@@ -0,0 +1,47 @@
+package factory
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"math/rand"
+)
+
+// NewImage returns a new sample image of random pixels encoded in the format of the extension - (.jpg/.png/.gif).
+// Any other extension gets a PNG image.
+func NewImage(extension string, width, height int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{
+				R: uint8(rand.Intn(256)),
+				G: uint8(rand.Intn(256)),
+				B: uint8(rand.Intn(256)),
+				A: 255,
+			})
+		}
+	}
+
+	var buffer bytes.Buffer
+	var err error
+
+	switch extension {
+	case ".jpg", ".jpeg":
+		err = jpeg.Encode(&buffer, img, &jpeg.Options{Quality: 90})
+	case ".gif":
+		err = gif.Encode(&buffer, img, nil)
+	default:
+		err = png.Encode(&buffer, img)
+	}
+
+	// Encoding to memory only fails on invalid options.
+	if err != nil {
+		panic(err)
+	}
+
+	return buffer.Bytes()
+}
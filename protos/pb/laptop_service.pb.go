@@ -10,6 +10,7 @@ import (
 	_ "google.golang.org/genproto/googleapis/api/annotations"
 	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
 	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	fieldmaskpb "google.golang.org/protobuf/types/known/fieldmaskpb"
 	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
 	reflect "reflect"
 	sync "sync"
@@ -22,6 +23,117 @@ const (
 	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
 )
 
+type WatchLaptopsResponse_Event int32
+
+const (
+	WatchLaptopsResponse_UNKNOWN WatchLaptopsResponse_Event = 0
+	// EXISTING is a laptop that matched the filter when the watch started
+	WatchLaptopsResponse_EXISTING WatchLaptopsResponse_Event = 1
+	// CREATED is a laptop that entered the filter, either created or updated to match it
+	WatchLaptopsResponse_CREATED WatchLaptopsResponse_Event = 2
+	// UPDATED is a laptop that still matches the filter after an update
+	WatchLaptopsResponse_UPDATED WatchLaptopsResponse_Event = 3
+	// DELETED is a laptop that left the filter, either deleted or updated to no longer match it
+	WatchLaptopsResponse_DELETED WatchLaptopsResponse_Event = 4
+)
+
+// Enum value maps for WatchLaptopsResponse_Event.
+var (
+	WatchLaptopsResponse_Event_name = map[int32]string{
+		0: "UNKNOWN",
+		1: "EXISTING",
+		2: "CREATED",
+		3: "UPDATED",
+		4: "DELETED",
+	}
+	WatchLaptopsResponse_Event_value = map[string]int32{
+		"UNKNOWN":  0,
+		"EXISTING": 1,
+		"CREATED":  2,
+		"UPDATED":  3,
+		"DELETED":  4,
+	}
+)
+
+func (x WatchLaptopsResponse_Event) Enum() *WatchLaptopsResponse_Event {
+	p := new(WatchLaptopsResponse_Event)
+	*p = x
+	return p
+}
+
+func (x WatchLaptopsResponse_Event) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (WatchLaptopsResponse_Event) Descriptor() protoreflect.EnumDescriptor {
+	return file_laptop_service_proto_enumTypes[0].Descriptor()
+}
+
+func (WatchLaptopsResponse_Event) Type() protoreflect.EnumType {
+	return &file_laptop_service_proto_enumTypes[0]
+}
+
+func (x WatchLaptopsResponse_Event) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use WatchLaptopsResponse_Event.Descriptor instead.
+func (WatchLaptopsResponse_Event) EnumDescriptor() ([]byte, []int) {
+	return file_laptop_service_proto_rawDescGZIP(), []int{20, 0}
+}
+
+type ImageDetails_RenditionStatus int32
+
+const (
+	ImageDetails_NONE    ImageDetails_RenditionStatus = 0
+	ImageDetails_PENDING ImageDetails_RenditionStatus = 1
+	ImageDetails_READY   ImageDetails_RenditionStatus = 2
+	ImageDetails_FAILED  ImageDetails_RenditionStatus = 3
+)
+
+// Enum value maps for ImageDetails_RenditionStatus.
+var (
+	ImageDetails_RenditionStatus_name = map[int32]string{
+		0: "NONE",
+		1: "PENDING",
+		2: "READY",
+		3: "FAILED",
+	}
+	ImageDetails_RenditionStatus_value = map[string]int32{
+		"NONE":    0,
+		"PENDING": 1,
+		"READY":   2,
+		"FAILED":  3,
+	}
+)
+
+func (x ImageDetails_RenditionStatus) Enum() *ImageDetails_RenditionStatus {
+	p := new(ImageDetails_RenditionStatus)
+	*p = x
+	return p
+}
+
+func (x ImageDetails_RenditionStatus) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (ImageDetails_RenditionStatus) Descriptor() protoreflect.EnumDescriptor {
+	return file_laptop_service_proto_enumTypes[1].Descriptor()
+}
+
+func (ImageDetails_RenditionStatus) Type() protoreflect.EnumType {
+	return &file_laptop_service_proto_enumTypes[1]
+}
+
+func (x ImageDetails_RenditionStatus) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use ImageDetails_RenditionStatus.Descriptor instead.
+func (ImageDetails_RenditionStatus) EnumDescriptor() ([]byte, []int) {
+	return file_laptop_service_proto_rawDescGZIP(), []int{38, 0}
+}
+
 // Laptop represents a laptop device
 type Laptop struct {
 	state         protoimpl.MessageState
@@ -44,6 +156,8 @@ type Laptop struct {
 	PriceUsd    float64                `protobuf:"fixed64,12,opt,name=price_usd,json=priceUsd,proto3" json:"price_usd,omitempty"`
 	ReleaseYear uint32                 `protobuf:"varint,13,opt,name=release_year,json=releaseYear,proto3" json:"release_year,omitempty"`
 	UpdatedAt   *timestamppb.Timestamp `protobuf:"bytes,14,opt,name=updated_at,json=updatedAt,proto3" json:"updated_at,omitempty"`
+	// Revision is incremented by the server on every write and is used as the laptop's etag.
+	Revision uint64 `protobuf:"varint,15,opt,name=revision,proto3" json:"revision,omitempty"`
 }
 
 func (x *Laptop) Reset() {
@@ -183,6 +297,13 @@ func (x *Laptop) GetUpdatedAt() *timestamppb.Timestamp {
 	return nil
 }
 
+func (x *Laptop) GetRevision() uint64 {
+	if x != nil {
+		return x.Revision
+	}
+	return 0
+}
+
 type isLaptop_Weight interface {
 	isLaptop_Weight()
 }
@@ -199,16 +320,41 @@ func (*Laptop_WeightKg) isLaptop_Weight() {}
 
 func (*Laptop_WeightLb) isLaptop_Weight() {}
 
-// Filter represents a filter for a laptop with the specified specs
+// Filter represents a filter for a laptop with the specified specs. Every criterion is optional and unset criteria
+// match every laptop, so an empty filter matches the whole catalog.
 type Filter struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	MaxPriceUsd     float64 `protobuf:"fixed64,1,opt,name=max_price_usd,json=maxPriceUsd,proto3" json:"max_price_usd,omitempty"`
-	MinCpuCores     uint32  `protobuf:"varint,2,opt,name=min_cpu_cores,json=minCpuCores,proto3" json:"min_cpu_cores,omitempty"`
-	MinCpuFrequency float64 `protobuf:"fixed64,3,opt,name=min_cpu_frequency,json=minCpuFrequency,proto3" json:"min_cpu_frequency,omitempty"`
-	MinRam          *Memory `protobuf:"bytes,4,opt,name=min_ram,json=minRam,proto3" json:"min_ram,omitempty"`
+	MaxPriceUsd     *float64 `protobuf:"fixed64,1,opt,name=max_price_usd,json=maxPriceUsd,proto3,oneof" json:"max_price_usd,omitempty"`
+	MinCpuCores     *uint32  `protobuf:"varint,2,opt,name=min_cpu_cores,json=minCpuCores,proto3,oneof" json:"min_cpu_cores,omitempty"`
+	MinCpuFrequency *float64 `protobuf:"fixed64,3,opt,name=min_cpu_frequency,json=minCpuFrequency,proto3,oneof" json:"min_cpu_frequency,omitempty"`
+	MinRam          *Memory  `protobuf:"bytes,4,opt,name=min_ram,json=minRam,proto3" json:"min_ram,omitempty"`
+	// Brand matches the laptop brand, ignoring case.
+	Brand string `protobuf:"bytes,5,opt,name=brand,proto3" json:"brand,omitempty"`
+	// Name matches laptops whose name contains it, ignoring case.
+	Name        string   `protobuf:"bytes,6,opt,name=name,proto3" json:"name,omitempty"`
+	MinPriceUsd *float64 `protobuf:"fixed64,7,opt,name=min_price_usd,json=minPriceUsd,proto3,oneof" json:"min_price_usd,omitempty"`
+	// GpuBrand and MinGpuMemory match laptops that have a GPU with the brand, ignoring case, and at least the memory.
+	GpuBrand     string  `protobuf:"bytes,8,opt,name=gpu_brand,json=gpuBrand,proto3" json:"gpu_brand,omitempty"`
+	MinGpuMemory *Memory `protobuf:"bytes,9,opt,name=min_gpu_memory,json=minGpuMemory,proto3" json:"min_gpu_memory,omitempty"`
+	// MinStorage is the minimum total capacity of the laptop storages using the storage_driver, or of all storages if
+	// the driver is unset.
+	MinStorage *Memory `protobuf:"bytes,10,opt,name=min_storage,json=minStorage,proto3" json:"min_storage,omitempty"`
+	// StorageDriver matches laptops that have a storage using the driver.
+	StorageDriver       Storage_Driver     `protobuf:"varint,11,opt,name=storage_driver,json=storageDriver,proto3,enum=pcbook.Storage_Driver" json:"storage_driver,omitempty"`
+	MinScreenSizeInches *float32           `protobuf:"fixed32,12,opt,name=min_screen_size_inches,json=minScreenSizeInches,proto3,oneof" json:"min_screen_size_inches,omitempty"`
+	MaxScreenSizeInches *float32           `protobuf:"fixed32,13,opt,name=max_screen_size_inches,json=maxScreenSizeInches,proto3,oneof" json:"max_screen_size_inches,omitempty"`
+	MinScreenResolution *Screen_Resolution `protobuf:"bytes,14,opt,name=min_screen_resolution,json=minScreenResolution,proto3" json:"min_screen_resolution,omitempty"`
+	ScreenPanel         Screen_Panel       `protobuf:"varint,15,opt,name=screen_panel,json=screenPanel,proto3,enum=pcbook.Screen_Panel" json:"screen_panel,omitempty"`
+	IsMultiTouch        *bool              `protobuf:"varint,16,opt,name=is_multi_touch,json=isMultiTouch,proto3,oneof" json:"is_multi_touch,omitempty"`
+	KeyboardLayout      Keyboard_Layout    `protobuf:"varint,17,opt,name=keyboard_layout,json=keyboardLayout,proto3,enum=pcbook.Keyboard_Layout" json:"keyboard_layout,omitempty"`
+	IsBacklit           *bool              `protobuf:"varint,18,opt,name=is_backlit,json=isBacklit,proto3,oneof" json:"is_backlit,omitempty"`
+	// MaxWeightKg is compared against the laptop weight in kilograms, whichever unit it was recorded in.
+	MaxWeightKg    *float64 `protobuf:"fixed64,19,opt,name=max_weight_kg,json=maxWeightKg,proto3,oneof" json:"max_weight_kg,omitempty"`
+	MinReleaseYear *uint32  `protobuf:"varint,20,opt,name=min_release_year,json=minReleaseYear,proto3,oneof" json:"min_release_year,omitempty"`
+	MaxReleaseYear *uint32  `protobuf:"varint,21,opt,name=max_release_year,json=maxReleaseYear,proto3,oneof" json:"max_release_year,omitempty"`
 }
 
 func (x *Filter) Reset() {
@@ -244,22 +390,22 @@ func (*Filter) Descriptor() ([]byte, []int) {
 }
 
 func (x *Filter) GetMaxPriceUsd() float64 {
-	if x != nil {
-		return x.MaxPriceUsd
+	if x != nil && x.MaxPriceUsd != nil {
+		return *x.MaxPriceUsd
 	}
 	return 0
 }
 
 func (x *Filter) GetMinCpuCores() uint32 {
-	if x != nil {
-		return x.MinCpuCores
+	if x != nil && x.MinCpuCores != nil {
+		return *x.MinCpuCores
 	}
 	return 0
 }
 
 func (x *Filter) GetMinCpuFrequency() float64 {
-	if x != nil {
-		return x.MinCpuFrequency
+	if x != nil && x.MinCpuFrequency != nil {
+		return *x.MinCpuFrequency
 	}
 	return 0
 }
@@ -271,6 +417,125 @@ func (x *Filter) GetMinRam() *Memory {
 	return nil
 }
 
+func (x *Filter) GetBrand() string {
+	if x != nil {
+		return x.Brand
+	}
+	return ""
+}
+
+func (x *Filter) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Filter) GetMinPriceUsd() float64 {
+	if x != nil && x.MinPriceUsd != nil {
+		return *x.MinPriceUsd
+	}
+	return 0
+}
+
+func (x *Filter) GetGpuBrand() string {
+	if x != nil {
+		return x.GpuBrand
+	}
+	return ""
+}
+
+func (x *Filter) GetMinGpuMemory() *Memory {
+	if x != nil {
+		return x.MinGpuMemory
+	}
+	return nil
+}
+
+func (x *Filter) GetMinStorage() *Memory {
+	if x != nil {
+		return x.MinStorage
+	}
+	return nil
+}
+
+func (x *Filter) GetStorageDriver() Storage_Driver {
+	if x != nil {
+		return x.StorageDriver
+	}
+	return Storage_UNKNOWN
+}
+
+func (x *Filter) GetMinScreenSizeInches() float32 {
+	if x != nil && x.MinScreenSizeInches != nil {
+		return *x.MinScreenSizeInches
+	}
+	return 0
+}
+
+func (x *Filter) GetMaxScreenSizeInches() float32 {
+	if x != nil && x.MaxScreenSizeInches != nil {
+		return *x.MaxScreenSizeInches
+	}
+	return 0
+}
+
+func (x *Filter) GetMinScreenResolution() *Screen_Resolution {
+	if x != nil {
+		return x.MinScreenResolution
+	}
+	return nil
+}
+
+func (x *Filter) GetScreenPanel() Screen_Panel {
+	if x != nil {
+		return x.ScreenPanel
+	}
+	return Screen_UNKNOWN
+}
+
+func (x *Filter) GetIsMultiTouch() bool {
+	if x != nil && x.IsMultiTouch != nil {
+		return *x.IsMultiTouch
+	}
+	return false
+}
+
+func (x *Filter) GetKeyboardLayout() Keyboard_Layout {
+	if x != nil {
+		return x.KeyboardLayout
+	}
+	return Keyboard_UNKNOWN
+}
+
+func (x *Filter) GetIsBacklit() bool {
+	if x != nil && x.IsBacklit != nil {
+		return *x.IsBacklit
+	}
+	return false
+}
+
+func (x *Filter) GetMaxWeightKg() float64 {
+	if x != nil && x.MaxWeightKg != nil {
+		return *x.MaxWeightKg
+	}
+	return 0
+}
+
+func (x *Filter) GetMinReleaseYear() uint32 {
+	if x != nil && x.MinReleaseYear != nil {
+		return *x.MinReleaseYear
+	}
+	return 0
+}
+
+func (x *Filter) GetMaxReleaseYear() uint32 {
+	if x != nil && x.MaxReleaseYear != nil {
+		return *x.MaxReleaseYear
+	}
+	return 0
+}
+
 // CreateLaptopRequest is the request message for the CreateLaptop RPC
 type CreateLaptopRequest struct {
 	state         protoimpl.MessageState
@@ -367,17 +632,17 @@ func (x *CreateLaptopResponse) GetId() string {
 	return ""
 }
 
-// SearchLaptopRequest represents the request message for the SearchLaptop RPC
-type SearchLaptopRequest struct {
+// GetLaptopRequest is the request message for the GetLaptop RPC
+type GetLaptopRequest struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	Filter *Filter `protobuf:"bytes,1,opt,name=filter,proto3" json:"filter,omitempty"`
+	Id string `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
 }
 
-func (x *SearchLaptopRequest) Reset() {
-	*x = SearchLaptopRequest{}
+func (x *GetLaptopRequest) Reset() {
+	*x = GetLaptopRequest{}
 	if protoimpl.UnsafeEnabled {
 		mi := &file_laptop_service_proto_msgTypes[4]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
@@ -385,13 +650,13 @@ func (x *SearchLaptopRequest) Reset() {
 	}
 }
 
-func (x *SearchLaptopRequest) String() string {
+func (x *GetLaptopRequest) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*SearchLaptopRequest) ProtoMessage() {}
+func (*GetLaptopRequest) ProtoMessage() {}
 
-func (x *SearchLaptopRequest) ProtoReflect() protoreflect.Message {
+func (x *GetLaptopRequest) ProtoReflect() protoreflect.Message {
 	mi := &file_laptop_service_proto_msgTypes[4]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
@@ -403,20 +668,20 @@ func (x *SearchLaptopRequest) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use SearchLaptopRequest.ProtoReflect.Descriptor instead.
-func (*SearchLaptopRequest) Descriptor() ([]byte, []int) {
+// Deprecated: Use GetLaptopRequest.ProtoReflect.Descriptor instead.
+func (*GetLaptopRequest) Descriptor() ([]byte, []int) {
 	return file_laptop_service_proto_rawDescGZIP(), []int{4}
 }
 
-func (x *SearchLaptopRequest) GetFilter() *Filter {
+func (x *GetLaptopRequest) GetId() string {
 	if x != nil {
-		return x.Filter
+		return x.Id
 	}
-	return nil
+	return ""
 }
 
-// SearchLaptopResponse represents the response message for the SearchLaptop RPC
-type SearchLaptopResponse struct {
+// GetLaptopResponse is the response message for the GetLaptop RPC
+type GetLaptopResponse struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
@@ -424,8 +689,8 @@ type SearchLaptopResponse struct {
 	Laptop *Laptop `protobuf:"bytes,1,opt,name=laptop,proto3" json:"laptop,omitempty"`
 }
 
-func (x *SearchLaptopResponse) Reset() {
-	*x = SearchLaptopResponse{}
+func (x *GetLaptopResponse) Reset() {
+	*x = GetLaptopResponse{}
 	if protoimpl.UnsafeEnabled {
 		mi := &file_laptop_service_proto_msgTypes[5]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
@@ -433,13 +698,13 @@ func (x *SearchLaptopResponse) Reset() {
 	}
 }
 
-func (x *SearchLaptopResponse) String() string {
+func (x *GetLaptopResponse) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*SearchLaptopResponse) ProtoMessage() {}
+func (*GetLaptopResponse) ProtoMessage() {}
 
-func (x *SearchLaptopResponse) ProtoReflect() protoreflect.Message {
+func (x *GetLaptopResponse) ProtoReflect() protoreflect.Message {
 	mi := &file_laptop_service_proto_msgTypes[5]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
@@ -451,30 +716,33 @@ func (x *SearchLaptopResponse) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use SearchLaptopResponse.ProtoReflect.Descriptor instead.
-func (*SearchLaptopResponse) Descriptor() ([]byte, []int) {
+// Deprecated: Use GetLaptopResponse.ProtoReflect.Descriptor instead.
+func (*GetLaptopResponse) Descriptor() ([]byte, []int) {
 	return file_laptop_service_proto_rawDescGZIP(), []int{5}
 }
 
-func (x *SearchLaptopResponse) GetLaptop() *Laptop {
+func (x *GetLaptopResponse) GetLaptop() *Laptop {
 	if x != nil {
 		return x.Laptop
 	}
 	return nil
 }
 
-// ImageInfo represents the information of an image
-type ImageInfo struct {
+// UpdateLaptopRequest is the request message for the UpdateLaptop RPC
+type UpdateLaptopRequest struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	LaptopId      string `protobuf:"bytes,1,opt,name=laptop_id,json=laptopId,proto3" json:"laptop_id,omitempty"`
-	FileExtension string `protobuf:"bytes,2,opt,name=file_extension,json=fileExtension,proto3" json:"file_extension,omitempty"`
+	Laptop *Laptop `protobuf:"bytes,1,opt,name=laptop,proto3" json:"laptop,omitempty"`
+	// UpdateMask lists the laptop fields to update. An empty mask replaces every field.
+	UpdateMask *fieldmaskpb.FieldMask `protobuf:"bytes,2,opt,name=update_mask,json=updateMask,proto3" json:"update_mask,omitempty"`
+	// Revision is the laptop revision the update is based on. A stale revision aborts the update, zero skips the check.
+	Revision uint64 `protobuf:"varint,3,opt,name=revision,proto3" json:"revision,omitempty"`
 }
 
-func (x *ImageInfo) Reset() {
-	*x = ImageInfo{}
+func (x *UpdateLaptopRequest) Reset() {
+	*x = UpdateLaptopRequest{}
 	if protoimpl.UnsafeEnabled {
 		mi := &file_laptop_service_proto_msgTypes[6]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
@@ -482,13 +750,13 @@ func (x *ImageInfo) Reset() {
 	}
 }
 
-func (x *ImageInfo) String() string {
+func (x *UpdateLaptopRequest) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*ImageInfo) ProtoMessage() {}
+func (*UpdateLaptopRequest) ProtoMessage() {}
 
-func (x *ImageInfo) ProtoReflect() protoreflect.Message {
+func (x *UpdateLaptopRequest) ProtoReflect() protoreflect.Message {
 	mi := &file_laptop_service_proto_msgTypes[6]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
@@ -500,39 +768,43 @@ func (x *ImageInfo) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use ImageInfo.ProtoReflect.Descriptor instead.
-func (*ImageInfo) Descriptor() ([]byte, []int) {
+// Deprecated: Use UpdateLaptopRequest.ProtoReflect.Descriptor instead.
+func (*UpdateLaptopRequest) Descriptor() ([]byte, []int) {
 	return file_laptop_service_proto_rawDescGZIP(), []int{6}
 }
 
-func (x *ImageInfo) GetLaptopId() string {
+func (x *UpdateLaptopRequest) GetLaptop() *Laptop {
 	if x != nil {
-		return x.LaptopId
+		return x.Laptop
 	}
-	return ""
+	return nil
 }
 
-func (x *ImageInfo) GetFileExtension() string {
+func (x *UpdateLaptopRequest) GetUpdateMask() *fieldmaskpb.FieldMask {
 	if x != nil {
-		return x.FileExtension
+		return x.UpdateMask
 	}
-	return ""
+	return nil
 }
 
-// UploadImageRequest represents the request message for the UploadImage RPC
-type UploadImageRequest struct {
+func (x *UpdateLaptopRequest) GetRevision() uint64 {
+	if x != nil {
+		return x.Revision
+	}
+	return 0
+}
+
+// UpdateLaptopResponse is the response message for the UpdateLaptop RPC
+type UpdateLaptopResponse struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	// Types that are assignable to Data:
-	//	*UploadImageRequest_Info
-	//	*UploadImageRequest_ChunkData
-	Data isUploadImageRequest_Data `protobuf_oneof:"data"`
+	Laptop *Laptop `protobuf:"bytes,1,opt,name=laptop,proto3" json:"laptop,omitempty"`
 }
 
-func (x *UploadImageRequest) Reset() {
-	*x = UploadImageRequest{}
+func (x *UpdateLaptopResponse) Reset() {
+	*x = UpdateLaptopResponse{}
 	if protoimpl.UnsafeEnabled {
 		mi := &file_laptop_service_proto_msgTypes[7]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
@@ -540,13 +812,13 @@ func (x *UploadImageRequest) Reset() {
 	}
 }
 
-func (x *UploadImageRequest) String() string {
+func (x *UpdateLaptopResponse) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*UploadImageRequest) ProtoMessage() {}
+func (*UpdateLaptopResponse) ProtoMessage() {}
 
-func (x *UploadImageRequest) ProtoReflect() protoreflect.Message {
+func (x *UpdateLaptopResponse) ProtoReflect() protoreflect.Message {
 	mi := &file_laptop_service_proto_msgTypes[7]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
@@ -558,60 +830,31 @@ func (x *UploadImageRequest) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use UploadImageRequest.ProtoReflect.Descriptor instead.
-func (*UploadImageRequest) Descriptor() ([]byte, []int) {
+// Deprecated: Use UpdateLaptopResponse.ProtoReflect.Descriptor instead.
+func (*UpdateLaptopResponse) Descriptor() ([]byte, []int) {
 	return file_laptop_service_proto_rawDescGZIP(), []int{7}
 }
 
-func (m *UploadImageRequest) GetData() isUploadImageRequest_Data {
-	if m != nil {
-		return m.Data
-	}
-	return nil
-}
-
-func (x *UploadImageRequest) GetInfo() *ImageInfo {
-	if x, ok := x.GetData().(*UploadImageRequest_Info); ok {
-		return x.Info
-	}
-	return nil
-}
-
-func (x *UploadImageRequest) GetChunkData() []byte {
-	if x, ok := x.GetData().(*UploadImageRequest_ChunkData); ok {
-		return x.ChunkData
+func (x *UpdateLaptopResponse) GetLaptop() *Laptop {
+	if x != nil {
+		return x.Laptop
 	}
 	return nil
 }
 
-type isUploadImageRequest_Data interface {
-	isUploadImageRequest_Data()
-}
-
-type UploadImageRequest_Info struct {
-	Info *ImageInfo `protobuf:"bytes,1,opt,name=info,proto3,oneof"`
-}
-
-type UploadImageRequest_ChunkData struct {
-	ChunkData []byte `protobuf:"bytes,2,opt,name=chunk_data,json=chunkData,proto3,oneof"`
-}
-
-func (*UploadImageRequest_Info) isUploadImageRequest_Data() {}
-
-func (*UploadImageRequest_ChunkData) isUploadImageRequest_Data() {}
-
-// UploadImageResponse represents the response message for the UploadImage RPC
-type UploadImageResponse struct {
+// DeleteLaptopRequest is the request message for the DeleteLaptop RPC
+type DeleteLaptopRequest struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	Id   string `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
-	Size uint32 `protobuf:"varint,2,opt,name=size,proto3" json:"size,omitempty"`
+	Id string `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	// Revision is the laptop revision the delete is based on. A stale revision aborts the delete, zero skips the check.
+	Revision uint64 `protobuf:"varint,2,opt,name=revision,proto3" json:"revision,omitempty"`
 }
 
-func (x *UploadImageResponse) Reset() {
-	*x = UploadImageResponse{}
+func (x *DeleteLaptopRequest) Reset() {
+	*x = DeleteLaptopRequest{}
 	if protoimpl.UnsafeEnabled {
 		mi := &file_laptop_service_proto_msgTypes[8]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
@@ -619,13 +862,13 @@ func (x *UploadImageResponse) Reset() {
 	}
 }
 
-func (x *UploadImageResponse) String() string {
+func (x *DeleteLaptopRequest) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*UploadImageResponse) ProtoMessage() {}
+func (*DeleteLaptopRequest) ProtoMessage() {}
 
-func (x *UploadImageResponse) ProtoReflect() protoreflect.Message {
+func (x *DeleteLaptopRequest) ProtoReflect() protoreflect.Message {
 	mi := &file_laptop_service_proto_msgTypes[8]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
@@ -637,37 +880,34 @@ func (x *UploadImageResponse) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use UploadImageResponse.ProtoReflect.Descriptor instead.
-func (*UploadImageResponse) Descriptor() ([]byte, []int) {
+// Deprecated: Use DeleteLaptopRequest.ProtoReflect.Descriptor instead.
+func (*DeleteLaptopRequest) Descriptor() ([]byte, []int) {
 	return file_laptop_service_proto_rawDescGZIP(), []int{8}
 }
 
-func (x *UploadImageResponse) GetId() string {
+func (x *DeleteLaptopRequest) GetId() string {
 	if x != nil {
 		return x.Id
 	}
 	return ""
 }
 
-func (x *UploadImageResponse) GetSize() uint32 {
+func (x *DeleteLaptopRequest) GetRevision() uint64 {
 	if x != nil {
-		return x.Size
+		return x.Revision
 	}
 	return 0
 }
 
-// RateLaptopRequest represents the request message for the RateLaptop RPC
-type RateLaptopRequest struct {
+// DeleteLaptopResponse is the response message for the DeleteLaptop RPC
+type DeleteLaptopResponse struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
-
-	LaptopId string  `protobuf:"bytes,1,opt,name=laptop_id,json=laptopId,proto3" json:"laptop_id,omitempty"`
-	Score    float64 `protobuf:"fixed64,2,opt,name=score,proto3" json:"score,omitempty"`
 }
 
-func (x *RateLaptopRequest) Reset() {
-	*x = RateLaptopRequest{}
+func (x *DeleteLaptopResponse) Reset() {
+	*x = DeleteLaptopResponse{}
 	if protoimpl.UnsafeEnabled {
 		mi := &file_laptop_service_proto_msgTypes[9]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
@@ -675,13 +915,13 @@ func (x *RateLaptopRequest) Reset() {
 	}
 }
 
-func (x *RateLaptopRequest) String() string {
+func (x *DeleteLaptopResponse) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*RateLaptopRequest) ProtoMessage() {}
+func (*DeleteLaptopResponse) ProtoMessage() {}
 
-func (x *RateLaptopRequest) ProtoReflect() protoreflect.Message {
+func (x *DeleteLaptopResponse) ProtoReflect() protoreflect.Message {
 	mi := &file_laptop_service_proto_msgTypes[9]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
@@ -693,292 +933,3294 @@ func (x *RateLaptopRequest) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use RateLaptopRequest.ProtoReflect.Descriptor instead.
-func (*RateLaptopRequest) Descriptor() ([]byte, []int) {
+// Deprecated: Use DeleteLaptopResponse.ProtoReflect.Descriptor instead.
+func (*DeleteLaptopResponse) Descriptor() ([]byte, []int) {
 	return file_laptop_service_proto_rawDescGZIP(), []int{9}
 }
 
-func (x *RateLaptopRequest) GetLaptopId() string {
-	if x != nil {
-		return x.LaptopId
-	}
+// ListLaptopsRequest is the request message for the ListLaptops RPC
+type ListLaptopsRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// PageSize is the maximum number of laptops to return. The server picks a default when unset.
+	PageSize uint32 `protobuf:"varint,1,opt,name=page_size,json=pageSize,proto3" json:"page_size,omitempty"`
+	// PageToken is the next_page_token of the previous page. Leave it empty to fetch the first page.
+	PageToken string `protobuf:"bytes,2,opt,name=page_token,json=pageToken,proto3" json:"page_token,omitempty"`
+}
+
+func (x *ListLaptopsRequest) Reset() {
+	*x = ListLaptopsRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_laptop_service_proto_msgTypes[10]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ListLaptopsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListLaptopsRequest) ProtoMessage() {}
+
+func (x *ListLaptopsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_laptop_service_proto_msgTypes[10]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListLaptopsRequest.ProtoReflect.Descriptor instead.
+func (*ListLaptopsRequest) Descriptor() ([]byte, []int) {
+	return file_laptop_service_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *ListLaptopsRequest) GetPageSize() uint32 {
+	if x != nil {
+		return x.PageSize
+	}
+	return 0
+}
+
+func (x *ListLaptopsRequest) GetPageToken() string {
+	if x != nil {
+		return x.PageToken
+	}
 	return ""
 }
 
-func (x *RateLaptopRequest) GetScore() float64 {
+// ListLaptopsResponse is the response message for the ListLaptops RPC
+type ListLaptopsResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// Laptops is the page of laptops ordered by their id.
+	Laptops []*Laptop `protobuf:"bytes,1,rep,name=laptops,proto3" json:"laptops,omitempty"`
+	// NextPageToken fetches the next page. It is empty on the last page.
+	NextPageToken string `protobuf:"bytes,2,opt,name=next_page_token,json=nextPageToken,proto3" json:"next_page_token,omitempty"`
+}
+
+func (x *ListLaptopsResponse) Reset() {
+	*x = ListLaptopsResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_laptop_service_proto_msgTypes[11]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ListLaptopsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListLaptopsResponse) ProtoMessage() {}
+
+func (x *ListLaptopsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_laptop_service_proto_msgTypes[11]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListLaptopsResponse.ProtoReflect.Descriptor instead.
+func (*ListLaptopsResponse) Descriptor() ([]byte, []int) {
+	return file_laptop_service_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *ListLaptopsResponse) GetLaptops() []*Laptop {
+	if x != nil {
+		return x.Laptops
+	}
+	return nil
+}
+
+func (x *ListLaptopsResponse) GetNextPageToken() string {
+	if x != nil {
+		return x.NextPageToken
+	}
+	return ""
+}
+
+// SearchLaptopRequest represents the request message for the SearchLaptop RPC
+type SearchLaptopRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Filter *Filter `protobuf:"bytes,1,opt,name=filter,proto3" json:"filter,omitempty"`
+	// OrderBy is a comma separated list of fields to order the results by, each optionally followed by "desc", e.g.
+	// "price_usd, ram desc". Supported fields are price_usd, release_year, cpu_cores, cpu_frequency, ram, weight and
+	// rating.
+	OrderBy string `protobuf:"bytes,2,opt,name=order_by,json=orderBy,proto3" json:"order_by,omitempty"`
+	// Query is a text search query such as "brand:Dell price<2000 ram>=16GB sort:-price". Its criteria take precedence
+	// over the filter and its sort field over order_by. It is sent as the q parameter over REST.
+	Query string `protobuf:"bytes,3,opt,name=query,json=q,proto3" json:"query,omitempty"`
+	// Text is free text matched against the laptop brand, name, CPU and GPU names, e.g. "thinkpad p53". Words match
+	// whole words or their prefixes and results are ranked by relevance unless order_by is set.
+	Text string `protobuf:"bytes,4,opt,name=text,proto3" json:"text,omitempty"`
+}
+
+func (x *SearchLaptopRequest) Reset() {
+	*x = SearchLaptopRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_laptop_service_proto_msgTypes[12]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *SearchLaptopRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SearchLaptopRequest) ProtoMessage() {}
+
+func (x *SearchLaptopRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_laptop_service_proto_msgTypes[12]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SearchLaptopRequest.ProtoReflect.Descriptor instead.
+func (*SearchLaptopRequest) Descriptor() ([]byte, []int) {
+	return file_laptop_service_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *SearchLaptopRequest) GetFilter() *Filter {
+	if x != nil {
+		return x.Filter
+	}
+	return nil
+}
+
+func (x *SearchLaptopRequest) GetOrderBy() string {
+	if x != nil {
+		return x.OrderBy
+	}
+	return ""
+}
+
+func (x *SearchLaptopRequest) GetQuery() string {
+	if x != nil {
+		return x.Query
+	}
+	return ""
+}
+
+func (x *SearchLaptopRequest) GetText() string {
+	if x != nil {
+		return x.Text
+	}
+	return ""
+}
+
+// SearchLaptopResponse represents the response message for the SearchLaptop RPC
+type SearchLaptopResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Laptop *Laptop `protobuf:"bytes,1,opt,name=laptop,proto3" json:"laptop,omitempty"`
+	// Score is the relevance of the laptop to the search text, zero if the search has no text.
+	Score float64 `protobuf:"fixed64,2,opt,name=score,proto3" json:"score,omitempty"`
+}
+
+func (x *SearchLaptopResponse) Reset() {
+	*x = SearchLaptopResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_laptop_service_proto_msgTypes[13]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *SearchLaptopResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SearchLaptopResponse) ProtoMessage() {}
+
+func (x *SearchLaptopResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_laptop_service_proto_msgTypes[13]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SearchLaptopResponse.ProtoReflect.Descriptor instead.
+func (*SearchLaptopResponse) Descriptor() ([]byte, []int) {
+	return file_laptop_service_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *SearchLaptopResponse) GetLaptop() *Laptop {
+	if x != nil {
+		return x.Laptop
+	}
+	return nil
+}
+
+func (x *SearchLaptopResponse) GetScore() float64 {
 	if x != nil {
 		return x.Score
 	}
 	return 0
 }
 
-// RateLaptopResponse is the response message for the RateLaptop RPC
-type RateLaptopResponse struct {
+// FacetCount is the number of laptops having a value
+type FacetCount struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	LaptopId     string  `protobuf:"bytes,1,opt,name=laptop_id,json=laptopId,proto3" json:"laptop_id,omitempty"`
-	RatingsCount uint32  `protobuf:"varint,2,opt,name=ratings_count,json=ratingsCount,proto3" json:"ratings_count,omitempty"`
-	AverageScore float64 `protobuf:"fixed64,3,opt,name=average_score,json=averageScore,proto3" json:"average_score,omitempty"`
+	Value string `protobuf:"bytes,1,opt,name=value,proto3" json:"value,omitempty"`
+	Count uint32 `protobuf:"varint,2,opt,name=count,proto3" json:"count,omitempty"`
 }
 
-func (x *RateLaptopResponse) Reset() {
-	*x = RateLaptopResponse{}
+func (x *FacetCount) Reset() {
+	*x = FacetCount{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_laptop_service_proto_msgTypes[10]
+		mi := &file_laptop_service_proto_msgTypes[14]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
 }
 
-func (x *RateLaptopResponse) String() string {
+func (x *FacetCount) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*RateLaptopResponse) ProtoMessage() {}
+func (*FacetCount) ProtoMessage() {}
 
-func (x *RateLaptopResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_laptop_service_proto_msgTypes[10]
+func (x *FacetCount) ProtoReflect() protoreflect.Message {
+	mi := &file_laptop_service_proto_msgTypes[14]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FacetCount.ProtoReflect.Descriptor instead.
+func (*FacetCount) Descriptor() ([]byte, []int) {
+	return file_laptop_service_proto_rawDescGZIP(), []int{14}
+}
+
+func (x *FacetCount) GetValue() string {
+	if x != nil {
+		return x.Value
+	}
+	return ""
+}
+
+func (x *FacetCount) GetCount() uint32 {
+	if x != nil {
+		return x.Count
+	}
+	return 0
+}
+
+// BucketCount is the number of laptops whose value is in the range [min, max)
+type BucketCount struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Min   float64 `protobuf:"fixed64,1,opt,name=min,proto3" json:"min,omitempty"`
+	Max   float64 `protobuf:"fixed64,2,opt,name=max,proto3" json:"max,omitempty"`
+	Count uint32  `protobuf:"varint,3,opt,name=count,proto3" json:"count,omitempty"`
+}
+
+func (x *BucketCount) Reset() {
+	*x = BucketCount{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_laptop_service_proto_msgTypes[15]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *BucketCount) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BucketCount) ProtoMessage() {}
+
+func (x *BucketCount) ProtoReflect() protoreflect.Message {
+	mi := &file_laptop_service_proto_msgTypes[15]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
 			ms.StoreMessageInfo(mi)
 		}
-		return ms
-	}
-	return mi.MessageOf(x)
-}
-
-// Deprecated: Use RateLaptopResponse.ProtoReflect.Descriptor instead.
-func (*RateLaptopResponse) Descriptor() ([]byte, []int) {
-	return file_laptop_service_proto_rawDescGZIP(), []int{10}
-}
-
-func (x *RateLaptopResponse) GetLaptopId() string {
-	if x != nil {
-		return x.LaptopId
-	}
-	return ""
-}
-
-func (x *RateLaptopResponse) GetRatingsCount() uint32 {
-	if x != nil {
-		return x.RatingsCount
-	}
-	return 0
-}
-
-func (x *RateLaptopResponse) GetAverageScore() float64 {
-	if x != nil {
-		return x.AverageScore
-	}
-	return 0
-}
-
-var File_laptop_service_proto protoreflect.FileDescriptor
-
-var file_laptop_service_proto_rawDesc = []byte{
-	0x0a, 0x14, 0x6c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x5f, 0x73, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65,
-	0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x06, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x1a, 0x1c,
-	0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2f, 0x61, 0x70, 0x69, 0x2f, 0x61, 0x6e, 0x6e, 0x6f, 0x74,
-	0x61, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x1a, 0x0f, 0x70, 0x72,
-	0x6f, 0x63, 0x65, 0x73, 0x73, 0x6f, 0x72, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x1a, 0x0c, 0x6d,
-	0x65, 0x6d, 0x6f, 0x72, 0x79, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x1a, 0x0d, 0x73, 0x74, 0x6f,
-	0x72, 0x61, 0x67, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x1a, 0x0c, 0x73, 0x63, 0x72, 0x65,
-	0x65, 0x6e, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x1a, 0x0e, 0x6b, 0x65, 0x79, 0x62, 0x6f, 0x61,
-	0x72, 0x64, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x1a, 0x1f, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65,
-	0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2f, 0x74, 0x69, 0x6d, 0x65, 0x73, 0x74,
-	0x61, 0x6d, 0x70, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x22, 0xea, 0x03, 0x0a, 0x06, 0x4c, 0x61,
-	0x70, 0x74, 0x6f, 0x70, 0x12, 0x0e, 0x0a, 0x02, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09,
-	0x52, 0x02, 0x69, 0x64, 0x12, 0x14, 0x0a, 0x05, 0x62, 0x72, 0x61, 0x6e, 0x64, 0x18, 0x02, 0x20,
-	0x01, 0x28, 0x09, 0x52, 0x05, 0x62, 0x72, 0x61, 0x6e, 0x64, 0x12, 0x12, 0x0a, 0x04, 0x6e, 0x61,
-	0x6d, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x6e, 0x61, 0x6d, 0x65, 0x12, 0x1d,
-	0x0a, 0x03, 0x63, 0x70, 0x75, 0x18, 0x04, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0b, 0x2e, 0x70, 0x63,
-	0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x43, 0x50, 0x55, 0x52, 0x03, 0x63, 0x70, 0x75, 0x12, 0x20, 0x0a,
-	0x03, 0x72, 0x61, 0x6d, 0x18, 0x05, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0e, 0x2e, 0x70, 0x63, 0x62,
-	0x6f, 0x6f, 0x6b, 0x2e, 0x4d, 0x65, 0x6d, 0x6f, 0x72, 0x79, 0x52, 0x03, 0x72, 0x61, 0x6d, 0x12,
-	0x1f, 0x0a, 0x04, 0x67, 0x70, 0x75, 0x73, 0x18, 0x06, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x0b, 0x2e,
-	0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x47, 0x50, 0x55, 0x52, 0x04, 0x67, 0x70, 0x75, 0x73,
-	0x12, 0x2b, 0x0a, 0x08, 0x73, 0x74, 0x6f, 0x72, 0x61, 0x67, 0x65, 0x73, 0x18, 0x07, 0x20, 0x03,
-	0x28, 0x0b, 0x32, 0x0f, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x53, 0x74, 0x6f, 0x72,
-	0x61, 0x67, 0x65, 0x52, 0x08, 0x73, 0x74, 0x6f, 0x72, 0x61, 0x67, 0x65, 0x73, 0x12, 0x26, 0x0a,
-	0x06, 0x73, 0x63, 0x72, 0x65, 0x65, 0x6e, 0x18, 0x08, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0e, 0x2e,
-	0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x53, 0x63, 0x72, 0x65, 0x65, 0x6e, 0x52, 0x06, 0x73,
-	0x63, 0x72, 0x65, 0x65, 0x6e, 0x12, 0x2c, 0x0a, 0x08, 0x6b, 0x65, 0x79, 0x62, 0x6f, 0x61, 0x72,
-	0x64, 0x18, 0x09, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x10, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b,
-	0x2e, 0x4b, 0x65, 0x79, 0x62, 0x6f, 0x61, 0x72, 0x64, 0x52, 0x08, 0x6b, 0x65, 0x79, 0x62, 0x6f,
-	0x61, 0x72, 0x64, 0x12, 0x1d, 0x0a, 0x09, 0x77, 0x65, 0x69, 0x67, 0x68, 0x74, 0x5f, 0x6b, 0x67,
-	0x18, 0x0a, 0x20, 0x01, 0x28, 0x01, 0x48, 0x00, 0x52, 0x08, 0x77, 0x65, 0x69, 0x67, 0x68, 0x74,
-	0x4b, 0x67, 0x12, 0x1d, 0x0a, 0x09, 0x77, 0x65, 0x69, 0x67, 0x68, 0x74, 0x5f, 0x6c, 0x62, 0x18,
-	0x0b, 0x20, 0x01, 0x28, 0x01, 0x48, 0x00, 0x52, 0x08, 0x77, 0x65, 0x69, 0x67, 0x68, 0x74, 0x4c,
-	0x62, 0x12, 0x1b, 0x0a, 0x09, 0x70, 0x72, 0x69, 0x63, 0x65, 0x5f, 0x75, 0x73, 0x64, 0x18, 0x0c,
-	0x20, 0x01, 0x28, 0x01, 0x52, 0x08, 0x70, 0x72, 0x69, 0x63, 0x65, 0x55, 0x73, 0x64, 0x12, 0x21,
-	0x0a, 0x0c, 0x72, 0x65, 0x6c, 0x65, 0x61, 0x73, 0x65, 0x5f, 0x79, 0x65, 0x61, 0x72, 0x18, 0x0d,
-	0x20, 0x01, 0x28, 0x0d, 0x52, 0x0b, 0x72, 0x65, 0x6c, 0x65, 0x61, 0x73, 0x65, 0x59, 0x65, 0x61,
-	0x72, 0x12, 0x39, 0x0a, 0x0a, 0x75, 0x70, 0x64, 0x61, 0x74, 0x65, 0x64, 0x5f, 0x61, 0x74, 0x18,
-	0x0e, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70,
-	0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d,
-	0x70, 0x52, 0x09, 0x75, 0x70, 0x64, 0x61, 0x74, 0x65, 0x64, 0x41, 0x74, 0x42, 0x08, 0x0a, 0x06,
-	0x77, 0x65, 0x69, 0x67, 0x68, 0x74, 0x22, 0xa5, 0x01, 0x0a, 0x06, 0x46, 0x69, 0x6c, 0x74, 0x65,
-	0x72, 0x12, 0x22, 0x0a, 0x0d, 0x6d, 0x61, 0x78, 0x5f, 0x70, 0x72, 0x69, 0x63, 0x65, 0x5f, 0x75,
-	0x73, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x01, 0x52, 0x0b, 0x6d, 0x61, 0x78, 0x50, 0x72, 0x69,
-	0x63, 0x65, 0x55, 0x73, 0x64, 0x12, 0x22, 0x0a, 0x0d, 0x6d, 0x69, 0x6e, 0x5f, 0x63, 0x70, 0x75,
-	0x5f, 0x63, 0x6f, 0x72, 0x65, 0x73, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x0b, 0x6d, 0x69,
-	0x6e, 0x43, 0x70, 0x75, 0x43, 0x6f, 0x72, 0x65, 0x73, 0x12, 0x2a, 0x0a, 0x11, 0x6d, 0x69, 0x6e,
-	0x5f, 0x63, 0x70, 0x75, 0x5f, 0x66, 0x72, 0x65, 0x71, 0x75, 0x65, 0x6e, 0x63, 0x79, 0x18, 0x03,
-	0x20, 0x01, 0x28, 0x01, 0x52, 0x0f, 0x6d, 0x69, 0x6e, 0x43, 0x70, 0x75, 0x46, 0x72, 0x65, 0x71,
-	0x75, 0x65, 0x6e, 0x63, 0x79, 0x12, 0x27, 0x0a, 0x07, 0x6d, 0x69, 0x6e, 0x5f, 0x72, 0x61, 0x6d,
-	0x18, 0x04, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0e, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e,
-	0x4d, 0x65, 0x6d, 0x6f, 0x72, 0x79, 0x52, 0x06, 0x6d, 0x69, 0x6e, 0x52, 0x61, 0x6d, 0x22, 0x3d,
-	0x0a, 0x13, 0x43, 0x72, 0x65, 0x61, 0x74, 0x65, 0x4c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x52, 0x65,
-	0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x26, 0x0a, 0x06, 0x6c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x18,
-	0x01, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0e, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x4c,
-	0x61, 0x70, 0x74, 0x6f, 0x70, 0x52, 0x06, 0x6c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x22, 0x26, 0x0a,
-	0x14, 0x43, 0x72, 0x65, 0x61, 0x74, 0x65, 0x4c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x52, 0x65, 0x73,
-	0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x0e, 0x0a, 0x02, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28,
-	0x09, 0x52, 0x02, 0x69, 0x64, 0x22, 0x3d, 0x0a, 0x13, 0x53, 0x65, 0x61, 0x72, 0x63, 0x68, 0x4c,
-	0x61, 0x70, 0x74, 0x6f, 0x70, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x26, 0x0a, 0x06,
-	0x66, 0x69, 0x6c, 0x74, 0x65, 0x72, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0e, 0x2e, 0x70,
-	0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x46, 0x69, 0x6c, 0x74, 0x65, 0x72, 0x52, 0x06, 0x66, 0x69,
-	0x6c, 0x74, 0x65, 0x72, 0x22, 0x3e, 0x0a, 0x14, 0x53, 0x65, 0x61, 0x72, 0x63, 0x68, 0x4c, 0x61,
-	0x70, 0x74, 0x6f, 0x70, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x26, 0x0a, 0x06,
-	0x6c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0e, 0x2e, 0x70,
-	0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x4c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x52, 0x06, 0x6c, 0x61,
-	0x70, 0x74, 0x6f, 0x70, 0x22, 0x4f, 0x0a, 0x09, 0x49, 0x6d, 0x61, 0x67, 0x65, 0x49, 0x6e, 0x66,
-	0x6f, 0x12, 0x1b, 0x0a, 0x09, 0x6c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x5f, 0x69, 0x64, 0x18, 0x01,
-	0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x6c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x49, 0x64, 0x12, 0x25,
-	0x0a, 0x0e, 0x66, 0x69, 0x6c, 0x65, 0x5f, 0x65, 0x78, 0x74, 0x65, 0x6e, 0x73, 0x69, 0x6f, 0x6e,
-	0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0d, 0x66, 0x69, 0x6c, 0x65, 0x45, 0x78, 0x74, 0x65,
-	0x6e, 0x73, 0x69, 0x6f, 0x6e, 0x22, 0x66, 0x0a, 0x12, 0x55, 0x70, 0x6c, 0x6f, 0x61, 0x64, 0x49,
-	0x6d, 0x61, 0x67, 0x65, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x27, 0x0a, 0x04, 0x69,
-	0x6e, 0x66, 0x6f, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x11, 0x2e, 0x70, 0x63, 0x62, 0x6f,
-	0x6f, 0x6b, 0x2e, 0x49, 0x6d, 0x61, 0x67, 0x65, 0x49, 0x6e, 0x66, 0x6f, 0x48, 0x00, 0x52, 0x04,
-	0x69, 0x6e, 0x66, 0x6f, 0x12, 0x1f, 0x0a, 0x0a, 0x63, 0x68, 0x75, 0x6e, 0x6b, 0x5f, 0x64, 0x61,
-	0x74, 0x61, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0c, 0x48, 0x00, 0x52, 0x09, 0x63, 0x68, 0x75, 0x6e,
-	0x6b, 0x44, 0x61, 0x74, 0x61, 0x42, 0x06, 0x0a, 0x04, 0x64, 0x61, 0x74, 0x61, 0x22, 0x39, 0x0a,
-	0x13, 0x55, 0x70, 0x6c, 0x6f, 0x61, 0x64, 0x49, 0x6d, 0x61, 0x67, 0x65, 0x52, 0x65, 0x73, 0x70,
-	0x6f, 0x6e, 0x73, 0x65, 0x12, 0x0e, 0x0a, 0x02, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09,
-	0x52, 0x02, 0x69, 0x64, 0x12, 0x12, 0x0a, 0x04, 0x73, 0x69, 0x7a, 0x65, 0x18, 0x02, 0x20, 0x01,
-	0x28, 0x0d, 0x52, 0x04, 0x73, 0x69, 0x7a, 0x65, 0x22, 0x46, 0x0a, 0x11, 0x52, 0x61, 0x74, 0x65,
-	0x4c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x1b, 0x0a,
-	0x09, 0x6c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x5f, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09,
-	0x52, 0x08, 0x6c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x49, 0x64, 0x12, 0x14, 0x0a, 0x05, 0x73, 0x63,
-	0x6f, 0x72, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x01, 0x52, 0x05, 0x73, 0x63, 0x6f, 0x72, 0x65,
-	0x22, 0x7b, 0x0a, 0x12, 0x52, 0x61, 0x74, 0x65, 0x4c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x52, 0x65,
-	0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x1b, 0x0a, 0x09, 0x6c, 0x61, 0x70, 0x74, 0x6f, 0x70,
-	0x5f, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x6c, 0x61, 0x70, 0x74, 0x6f,
-	0x70, 0x49, 0x64, 0x12, 0x23, 0x0a, 0x0d, 0x72, 0x61, 0x74, 0x69, 0x6e, 0x67, 0x73, 0x5f, 0x63,
-	0x6f, 0x75, 0x6e, 0x74, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x0c, 0x72, 0x61, 0x74, 0x69,
-	0x6e, 0x67, 0x73, 0x43, 0x6f, 0x75, 0x6e, 0x74, 0x12, 0x23, 0x0a, 0x0d, 0x61, 0x76, 0x65, 0x72,
-	0x61, 0x67, 0x65, 0x5f, 0x73, 0x63, 0x6f, 0x72, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x01, 0x52,
-	0x0c, 0x61, 0x76, 0x65, 0x72, 0x61, 0x67, 0x65, 0x53, 0x63, 0x6f, 0x72, 0x65, 0x32, 0xac, 0x03,
-	0x0a, 0x0d, 0x4c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x53, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65, 0x12,
-	0x60, 0x0a, 0x0c, 0x43, 0x72, 0x65, 0x61, 0x74, 0x65, 0x4c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x12,
-	0x1b, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x43, 0x72, 0x65, 0x61, 0x74, 0x65, 0x4c,
-	0x61, 0x70, 0x74, 0x6f, 0x70, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x1c, 0x2e, 0x70,
-	0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x43, 0x72, 0x65, 0x61, 0x74, 0x65, 0x4c, 0x61, 0x70, 0x74,
-	0x6f, 0x70, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x22, 0x15, 0x82, 0xd3, 0xe4, 0x93,
-	0x02, 0x0f, 0x22, 0x0a, 0x2f, 0x76, 0x31, 0x2f, 0x6c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x3a, 0x01,
-	0x2a, 0x12, 0x66, 0x0a, 0x0c, 0x53, 0x65, 0x61, 0x72, 0x63, 0x68, 0x4c, 0x61, 0x70, 0x74, 0x6f,
-	0x70, 0x12, 0x1b, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x53, 0x65, 0x61, 0x72, 0x63,
-	0x68, 0x4c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x1c,
-	0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x53, 0x65, 0x61, 0x72, 0x63, 0x68, 0x4c, 0x61,
-	0x70, 0x74, 0x6f, 0x70, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x22, 0x19, 0x82, 0xd3,
-	0xe4, 0x93, 0x02, 0x13, 0x12, 0x11, 0x2f, 0x76, 0x31, 0x2f, 0x6c, 0x61, 0x70, 0x74, 0x6f, 0x70,
-	0x2f, 0x73, 0x65, 0x61, 0x72, 0x63, 0x68, 0x30, 0x01, 0x12, 0x6c, 0x0a, 0x0b, 0x55, 0x70, 0x6c,
-	0x6f, 0x61, 0x64, 0x49, 0x6d, 0x61, 0x67, 0x65, 0x12, 0x1a, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f,
-	0x6b, 0x2e, 0x55, 0x70, 0x6c, 0x6f, 0x61, 0x64, 0x49, 0x6d, 0x61, 0x67, 0x65, 0x52, 0x65, 0x71,
-	0x75, 0x65, 0x73, 0x74, 0x1a, 0x1b, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x55, 0x70,
-	0x6c, 0x6f, 0x61, 0x64, 0x49, 0x6d, 0x61, 0x67, 0x65, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73,
-	0x65, 0x22, 0x22, 0x82, 0xd3, 0xe4, 0x93, 0x02, 0x1c, 0x22, 0x17, 0x2f, 0x76, 0x31, 0x2f, 0x6c,
-	0x61, 0x70, 0x74, 0x6f, 0x70, 0x2f, 0x75, 0x70, 0x6c, 0x6f, 0x61, 0x64, 0x2d, 0x69, 0x6d, 0x61,
-	0x67, 0x65, 0x3a, 0x01, 0x2a, 0x28, 0x01, 0x12, 0x63, 0x0a, 0x0a, 0x52, 0x61, 0x74, 0x65, 0x4c,
-	0x61, 0x70, 0x74, 0x6f, 0x70, 0x12, 0x19, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x52,
-	0x61, 0x74, 0x65, 0x4c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74,
-	0x1a, 0x1a, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x52, 0x61, 0x74, 0x65, 0x4c, 0x61,
-	0x70, 0x74, 0x6f, 0x70, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x22, 0x1a, 0x82, 0xd3,
-	0xe4, 0x93, 0x02, 0x14, 0x22, 0x0f, 0x2f, 0x76, 0x31, 0x2f, 0x6c, 0x61, 0x70, 0x74, 0x6f, 0x70,
-	0x2f, 0x72, 0x61, 0x74, 0x65, 0x3a, 0x01, 0x2a, 0x28, 0x01, 0x30, 0x01, 0x42, 0x27, 0x0a, 0x1d,
-	0x63, 0x6f, 0x6d, 0x2e, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x6a, 0x77, 0x61, 0x6d, 0x62,
-	0x75, 0x67, 0x75, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x70, 0x62, 0x50, 0x01, 0x5a,
-	0x04, 0x2e, 0x2f, 0x70, 0x62, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
-}
-
-var (
-	file_laptop_service_proto_rawDescOnce sync.Once
-	file_laptop_service_proto_rawDescData = file_laptop_service_proto_rawDesc
-)
-
-func file_laptop_service_proto_rawDescGZIP() []byte {
-	file_laptop_service_proto_rawDescOnce.Do(func() {
-		file_laptop_service_proto_rawDescData = protoimpl.X.CompressGZIP(file_laptop_service_proto_rawDescData)
-	})
-	return file_laptop_service_proto_rawDescData
-}
-
-var file_laptop_service_proto_msgTypes = make([]protoimpl.MessageInfo, 11)
-var file_laptop_service_proto_goTypes = []interface{}{
-	(*Laptop)(nil),                // 0: pcbook.Laptop
-	(*Filter)(nil),                // 1: pcbook.Filter
-	(*CreateLaptopRequest)(nil),   // 2: pcbook.CreateLaptopRequest
-	(*CreateLaptopResponse)(nil),  // 3: pcbook.CreateLaptopResponse
-	(*SearchLaptopRequest)(nil),   // 4: pcbook.SearchLaptopRequest
-	(*SearchLaptopResponse)(nil),  // 5: pcbook.SearchLaptopResponse
-	(*ImageInfo)(nil),             // 6: pcbook.ImageInfo
-	(*UploadImageRequest)(nil),    // 7: pcbook.UploadImageRequest
-	(*UploadImageResponse)(nil),   // 8: pcbook.UploadImageResponse
-	(*RateLaptopRequest)(nil),     // 9: pcbook.RateLaptopRequest
-	(*RateLaptopResponse)(nil),    // 10: pcbook.RateLaptopResponse
-	(*CPU)(nil),                   // 11: pcbook.CPU
-	(*Memory)(nil),                // 12: pcbook.Memory
-	(*GPU)(nil),                   // 13: pcbook.GPU
-	(*Storage)(nil),               // 14: pcbook.Storage
-	(*Screen)(nil),                // 15: pcbook.Screen
-	(*Keyboard)(nil),              // 16: pcbook.Keyboard
-	(*timestamppb.Timestamp)(nil), // 17: google.protobuf.Timestamp
-}
-var file_laptop_service_proto_depIdxs = []int32{
-	11, // 0: pcbook.Laptop.cpu:type_name -> pcbook.CPU
-	12, // 1: pcbook.Laptop.ram:type_name -> pcbook.Memory
-	13, // 2: pcbook.Laptop.gpus:type_name -> pcbook.GPU
-	14, // 3: pcbook.Laptop.storages:type_name -> pcbook.Storage
-	15, // 4: pcbook.Laptop.screen:type_name -> pcbook.Screen
-	16, // 5: pcbook.Laptop.keyboard:type_name -> pcbook.Keyboard
-	17, // 6: pcbook.Laptop.updated_at:type_name -> google.protobuf.Timestamp
-	12, // 7: pcbook.Filter.min_ram:type_name -> pcbook.Memory
-	0,  // 8: pcbook.CreateLaptopRequest.laptop:type_name -> pcbook.Laptop
-	1,  // 9: pcbook.SearchLaptopRequest.filter:type_name -> pcbook.Filter
-	0,  // 10: pcbook.SearchLaptopResponse.laptop:type_name -> pcbook.Laptop
-	6,  // 11: pcbook.UploadImageRequest.info:type_name -> pcbook.ImageInfo
-	2,  // 12: pcbook.LaptopService.CreateLaptop:input_type -> pcbook.CreateLaptopRequest
-	4,  // 13: pcbook.LaptopService.SearchLaptop:input_type -> pcbook.SearchLaptopRequest
-	7,  // 14: pcbook.LaptopService.UploadImage:input_type -> pcbook.UploadImageRequest
-	9,  // 15: pcbook.LaptopService.RateLaptop:input_type -> pcbook.RateLaptopRequest
-	3,  // 16: pcbook.LaptopService.CreateLaptop:output_type -> pcbook.CreateLaptopResponse
-	5,  // 17: pcbook.LaptopService.SearchLaptop:output_type -> pcbook.SearchLaptopResponse
-	8,  // 18: pcbook.LaptopService.UploadImage:output_type -> pcbook.UploadImageResponse
-	10, // 19: pcbook.LaptopService.RateLaptop:output_type -> pcbook.RateLaptopResponse
-	16, // [16:20] is the sub-list for method output_type
-	12, // [12:16] is the sub-list for method input_type
-	12, // [12:12] is the sub-list for extension type_name
-	12, // [12:12] is the sub-list for extension extendee
-	0,  // [0:12] is the sub-list for field type_name
-}
-
-func init() { file_laptop_service_proto_init() }
-func file_laptop_service_proto_init() {
-	if File_laptop_service_proto != nil {
-		return
-	}
-	file_processor_proto_init()
-	file_memory_proto_init()
-	file_storage_proto_init()
-	file_screen_proto_init()
-	file_keyboard_proto_init()
-	if !protoimpl.UnsafeEnabled {
-		file_laptop_service_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*Laptop); i {
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BucketCount.ProtoReflect.Descriptor instead.
+func (*BucketCount) Descriptor() ([]byte, []int) {
+	return file_laptop_service_proto_rawDescGZIP(), []int{15}
+}
+
+func (x *BucketCount) GetMin() float64 {
+	if x != nil {
+		return x.Min
+	}
+	return 0
+}
+
+func (x *BucketCount) GetMax() float64 {
+	if x != nil {
+		return x.Max
+	}
+	return 0
+}
+
+func (x *BucketCount) GetCount() uint32 {
+	if x != nil {
+		return x.Count
+	}
+	return 0
+}
+
+// LaptopFacets holds the number of laptops per value of the properties laptops are commonly filtered by. Values are
+// ordered by descending count and buckets by ascending range.
+type LaptopFacets struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// Total is the number of laptops the facets are computed over
+	Total     uint32        `protobuf:"varint,1,opt,name=total,proto3" json:"total,omitempty"`
+	Brands    []*FacetCount `protobuf:"bytes,2,rep,name=brands,proto3" json:"brands,omitempty"`
+	CpuBrands []*FacetCount `protobuf:"bytes,3,rep,name=cpu_brands,json=cpuBrands,proto3" json:"cpu_brands,omitempty"`
+	// GpuBrands counts a laptop once for every distinct brand of its GPUs
+	GpuBrands       []*FacetCount `protobuf:"bytes,4,rep,name=gpu_brands,json=gpuBrands,proto3" json:"gpu_brands,omitempty"`
+	ScreenPanels    []*FacetCount `protobuf:"bytes,5,rep,name=screen_panels,json=screenPanels,proto3" json:"screen_panels,omitempty"`
+	KeyboardLayouts []*FacetCount `protobuf:"bytes,6,rep,name=keyboard_layouts,json=keyboardLayouts,proto3" json:"keyboard_layouts,omitempty"`
+	// RamGb buckets the RAM size in gigabytes by powers of two, e.g. [8, 16), with anything below 1GB in [0, 1)
+	RamGb []*BucketCount `protobuf:"bytes,7,rep,name=ram_gb,json=ramGb,proto3" json:"ram_gb,omitempty"`
+	// PriceUsd buckets the price in buckets of the requested size
+	PriceUsd     []*BucketCount `protobuf:"bytes,8,rep,name=price_usd,json=priceUsd,proto3" json:"price_usd,omitempty"`
+	ReleaseYears []*FacetCount  `protobuf:"bytes,9,rep,name=release_years,json=releaseYears,proto3" json:"release_years,omitempty"`
+}
+
+func (x *LaptopFacets) Reset() {
+	*x = LaptopFacets{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_laptop_service_proto_msgTypes[16]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *LaptopFacets) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LaptopFacets) ProtoMessage() {}
+
+func (x *LaptopFacets) ProtoReflect() protoreflect.Message {
+	mi := &file_laptop_service_proto_msgTypes[16]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LaptopFacets.ProtoReflect.Descriptor instead.
+func (*LaptopFacets) Descriptor() ([]byte, []int) {
+	return file_laptop_service_proto_rawDescGZIP(), []int{16}
+}
+
+func (x *LaptopFacets) GetTotal() uint32 {
+	if x != nil {
+		return x.Total
+	}
+	return 0
+}
+
+func (x *LaptopFacets) GetBrands() []*FacetCount {
+	if x != nil {
+		return x.Brands
+	}
+	return nil
+}
+
+func (x *LaptopFacets) GetCpuBrands() []*FacetCount {
+	if x != nil {
+		return x.CpuBrands
+	}
+	return nil
+}
+
+func (x *LaptopFacets) GetGpuBrands() []*FacetCount {
+	if x != nil {
+		return x.GpuBrands
+	}
+	return nil
+}
+
+func (x *LaptopFacets) GetScreenPanels() []*FacetCount {
+	if x != nil {
+		return x.ScreenPanels
+	}
+	return nil
+}
+
+func (x *LaptopFacets) GetKeyboardLayouts() []*FacetCount {
+	if x != nil {
+		return x.KeyboardLayouts
+	}
+	return nil
+}
+
+func (x *LaptopFacets) GetRamGb() []*BucketCount {
+	if x != nil {
+		return x.RamGb
+	}
+	return nil
+}
+
+func (x *LaptopFacets) GetPriceUsd() []*BucketCount {
+	if x != nil {
+		return x.PriceUsd
+	}
+	return nil
+}
+
+func (x *LaptopFacets) GetReleaseYears() []*FacetCount {
+	if x != nil {
+		return x.ReleaseYears
+	}
+	return nil
+}
+
+// SearchFacetsRequest represents the request message for the SearchFacets RPC
+type SearchFacetsRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Filter *Filter `protobuf:"bytes,1,opt,name=filter,proto3" json:"filter,omitempty"`
+	// PriceBucketUsd is the size of the price histogram buckets, 500 if unset
+	PriceBucketUsd float64 `protobuf:"fixed64,2,opt,name=price_bucket_usd,json=priceBucketUsd,proto3" json:"price_bucket_usd,omitempty"`
+}
+
+func (x *SearchFacetsRequest) Reset() {
+	*x = SearchFacetsRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_laptop_service_proto_msgTypes[17]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *SearchFacetsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SearchFacetsRequest) ProtoMessage() {}
+
+func (x *SearchFacetsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_laptop_service_proto_msgTypes[17]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SearchFacetsRequest.ProtoReflect.Descriptor instead.
+func (*SearchFacetsRequest) Descriptor() ([]byte, []int) {
+	return file_laptop_service_proto_rawDescGZIP(), []int{17}
+}
+
+func (x *SearchFacetsRequest) GetFilter() *Filter {
+	if x != nil {
+		return x.Filter
+	}
+	return nil
+}
+
+func (x *SearchFacetsRequest) GetPriceBucketUsd() float64 {
+	if x != nil {
+		return x.PriceBucketUsd
+	}
+	return 0
+}
+
+// SearchFacetsResponse represents the response message for the SearchFacets RPC
+type SearchFacetsResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Facets *LaptopFacets `protobuf:"bytes,1,opt,name=facets,proto3" json:"facets,omitempty"`
+}
+
+func (x *SearchFacetsResponse) Reset() {
+	*x = SearchFacetsResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_laptop_service_proto_msgTypes[18]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *SearchFacetsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SearchFacetsResponse) ProtoMessage() {}
+
+func (x *SearchFacetsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_laptop_service_proto_msgTypes[18]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SearchFacetsResponse.ProtoReflect.Descriptor instead.
+func (*SearchFacetsResponse) Descriptor() ([]byte, []int) {
+	return file_laptop_service_proto_rawDescGZIP(), []int{18}
+}
+
+func (x *SearchFacetsResponse) GetFacets() *LaptopFacets {
+	if x != nil {
+		return x.Facets
+	}
+	return nil
+}
+
+// WatchLaptopsRequest represents the request message for the WatchLaptops RPC
+type WatchLaptopsRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Filter *Filter `protobuf:"bytes,1,opt,name=filter,proto3" json:"filter,omitempty"`
+}
+
+func (x *WatchLaptopsRequest) Reset() {
+	*x = WatchLaptopsRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_laptop_service_proto_msgTypes[19]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *WatchLaptopsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WatchLaptopsRequest) ProtoMessage() {}
+
+func (x *WatchLaptopsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_laptop_service_proto_msgTypes[19]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WatchLaptopsRequest.ProtoReflect.Descriptor instead.
+func (*WatchLaptopsRequest) Descriptor() ([]byte, []int) {
+	return file_laptop_service_proto_rawDescGZIP(), []int{19}
+}
+
+func (x *WatchLaptopsRequest) GetFilter() *Filter {
+	if x != nil {
+		return x.Filter
+	}
+	return nil
+}
+
+// WatchLaptopsResponse represents the response message for the WatchLaptops RPC
+type WatchLaptopsResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Event  WatchLaptopsResponse_Event `protobuf:"varint,1,opt,name=event,proto3,enum=pcbook.WatchLaptopsResponse_Event" json:"event,omitempty"`
+	Laptop *Laptop                    `protobuf:"bytes,2,opt,name=laptop,proto3" json:"laptop,omitempty"`
+}
+
+func (x *WatchLaptopsResponse) Reset() {
+	*x = WatchLaptopsResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_laptop_service_proto_msgTypes[20]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *WatchLaptopsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WatchLaptopsResponse) ProtoMessage() {}
+
+func (x *WatchLaptopsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_laptop_service_proto_msgTypes[20]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WatchLaptopsResponse.ProtoReflect.Descriptor instead.
+func (*WatchLaptopsResponse) Descriptor() ([]byte, []int) {
+	return file_laptop_service_proto_rawDescGZIP(), []int{20}
+}
+
+func (x *WatchLaptopsResponse) GetEvent() WatchLaptopsResponse_Event {
+	if x != nil {
+		return x.Event
+	}
+	return WatchLaptopsResponse_UNKNOWN
+}
+
+func (x *WatchLaptopsResponse) GetLaptop() *Laptop {
+	if x != nil {
+		return x.Laptop
+	}
+	return nil
+}
+
+// CompareLaptopsRequest represents the request message for the CompareLaptops RPC
+type CompareLaptopsRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// Ids holds the ids of the 2 to 5 laptops to compare
+	Ids []string `protobuf:"bytes,1,rep,name=ids,proto3" json:"ids,omitempty"`
+}
+
+func (x *CompareLaptopsRequest) Reset() {
+	*x = CompareLaptopsRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_laptop_service_proto_msgTypes[21]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *CompareLaptopsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CompareLaptopsRequest) ProtoMessage() {}
+
+func (x *CompareLaptopsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_laptop_service_proto_msgTypes[21]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CompareLaptopsRequest.ProtoReflect.Descriptor instead.
+func (*CompareLaptopsRequest) Descriptor() ([]byte, []int) {
+	return file_laptop_service_proto_rawDescGZIP(), []int{21}
+}
+
+func (x *CompareLaptopsRequest) GetIds() []string {
+	if x != nil {
+		return x.Ids
+	}
+	return nil
+}
+
+// SpecComparison compares a spec of laptops in common units
+type SpecComparison struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// Field is the name of the spec, e.g. ram
+	Field string `protobuf:"bytes,1,opt,name=field,proto3" json:"field,omitempty"`
+	// Unit is the unit of the values, e.g. GB
+	Unit string `protobuf:"bytes,2,opt,name=unit,proto3" json:"unit,omitempty"`
+	// Values holds the spec of each laptop in the order of the requested ids
+	Values []float64 `protobuf:"fixed64,3,rep,packed,name=values,proto3" json:"values,omitempty"`
+	// Winners holds the positions in the requested ids of the laptops with the best value, more than one on a tie.
+	// Laptops missing the spec, whose value is 0, never win.
+	Winners []uint32 `protobuf:"varint,4,rep,packed,name=winners,proto3" json:"winners,omitempty"`
+	// HigherIsBetter is true if the best value is the highest rather than the lowest
+	HigherIsBetter bool `protobuf:"varint,5,opt,name=higher_is_better,json=higherIsBetter,proto3" json:"higher_is_better,omitempty"`
+}
+
+func (x *SpecComparison) Reset() {
+	*x = SpecComparison{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_laptop_service_proto_msgTypes[22]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *SpecComparison) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SpecComparison) ProtoMessage() {}
+
+func (x *SpecComparison) ProtoReflect() protoreflect.Message {
+	mi := &file_laptop_service_proto_msgTypes[22]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SpecComparison.ProtoReflect.Descriptor instead.
+func (*SpecComparison) Descriptor() ([]byte, []int) {
+	return file_laptop_service_proto_rawDescGZIP(), []int{22}
+}
+
+func (x *SpecComparison) GetField() string {
+	if x != nil {
+		return x.Field
+	}
+	return ""
+}
+
+func (x *SpecComparison) GetUnit() string {
+	if x != nil {
+		return x.Unit
+	}
+	return ""
+}
+
+func (x *SpecComparison) GetValues() []float64 {
+	if x != nil {
+		return x.Values
+	}
+	return nil
+}
+
+func (x *SpecComparison) GetWinners() []uint32 {
+	if x != nil {
+		return x.Winners
+	}
+	return nil
+}
+
+func (x *SpecComparison) GetHigherIsBetter() bool {
+	if x != nil {
+		return x.HigherIsBetter
+	}
+	return false
+}
+
+// CompareLaptopsResponse represents the response message for the CompareLaptops RPC
+type CompareLaptopsResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// Laptops holds the compared laptops in the order of the requested ids
+	Laptops []*Laptop         `protobuf:"bytes,1,rep,name=laptops,proto3" json:"laptops,omitempty"`
+	Specs   []*SpecComparison `protobuf:"bytes,2,rep,name=specs,proto3" json:"specs,omitempty"`
+}
+
+func (x *CompareLaptopsResponse) Reset() {
+	*x = CompareLaptopsResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_laptop_service_proto_msgTypes[23]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *CompareLaptopsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CompareLaptopsResponse) ProtoMessage() {}
+
+func (x *CompareLaptopsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_laptop_service_proto_msgTypes[23]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CompareLaptopsResponse.ProtoReflect.Descriptor instead.
+func (*CompareLaptopsResponse) Descriptor() ([]byte, []int) {
+	return file_laptop_service_proto_rawDescGZIP(), []int{23}
+}
+
+func (x *CompareLaptopsResponse) GetLaptops() []*Laptop {
+	if x != nil {
+		return x.Laptops
+	}
+	return nil
+}
+
+func (x *CompareLaptopsResponse) GetSpecs() []*SpecComparison {
+	if x != nil {
+		return x.Specs
+	}
+	return nil
+}
+
+// SimilarLaptopsRequest represents the request message for the SimilarLaptops RPC
+type SimilarLaptopsRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// Id is the id of the laptop to find similar laptops to
+	Id string `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	// Limit is the maximum number of laptops to return, 5 if unset and at most 50
+	Limit uint32 `protobuf:"varint,2,opt,name=limit,proto3" json:"limit,omitempty"`
+	// Filter restricts the laptops that can be returned
+	Filter *Filter `protobuf:"bytes,3,opt,name=filter,proto3" json:"filter,omitempty"`
+	// Weights overrides the weight of features in the distance, each 1 by default and 0 to ignore the feature. The
+	// features are price, cpu_cores, cpu_frequency, ram, storage, gpu_memory, screen_size, screen_resolution and weight.
+	Weights map[string]float64 `protobuf:"bytes,4,rep,name=weights,proto3" json:"weights,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"fixed64,2,opt,name=value,proto3"`
+}
+
+func (x *SimilarLaptopsRequest) Reset() {
+	*x = SimilarLaptopsRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_laptop_service_proto_msgTypes[24]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *SimilarLaptopsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SimilarLaptopsRequest) ProtoMessage() {}
+
+func (x *SimilarLaptopsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_laptop_service_proto_msgTypes[24]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SimilarLaptopsRequest.ProtoReflect.Descriptor instead.
+func (*SimilarLaptopsRequest) Descriptor() ([]byte, []int) {
+	return file_laptop_service_proto_rawDescGZIP(), []int{24}
+}
+
+func (x *SimilarLaptopsRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *SimilarLaptopsRequest) GetLimit() uint32 {
+	if x != nil {
+		return x.Limit
+	}
+	return 0
+}
+
+func (x *SimilarLaptopsRequest) GetFilter() *Filter {
+	if x != nil {
+		return x.Filter
+	}
+	return nil
+}
+
+func (x *SimilarLaptopsRequest) GetWeights() map[string]float64 {
+	if x != nil {
+		return x.Weights
+	}
+	return nil
+}
+
+// SimilarLaptop is a laptop and its distance to the laptop similar laptops were requested for
+type SimilarLaptop struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Laptop *Laptop `protobuf:"bytes,1,opt,name=laptop,proto3" json:"laptop,omitempty"`
+	// Distance is zero for identical specs and grows as the specs differ
+	Distance float64 `protobuf:"fixed64,2,opt,name=distance,proto3" json:"distance,omitempty"`
+}
+
+func (x *SimilarLaptop) Reset() {
+	*x = SimilarLaptop{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_laptop_service_proto_msgTypes[25]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *SimilarLaptop) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SimilarLaptop) ProtoMessage() {}
+
+func (x *SimilarLaptop) ProtoReflect() protoreflect.Message {
+	mi := &file_laptop_service_proto_msgTypes[25]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SimilarLaptop.ProtoReflect.Descriptor instead.
+func (*SimilarLaptop) Descriptor() ([]byte, []int) {
+	return file_laptop_service_proto_rawDescGZIP(), []int{25}
+}
+
+func (x *SimilarLaptop) GetLaptop() *Laptop {
+	if x != nil {
+		return x.Laptop
+	}
+	return nil
+}
+
+func (x *SimilarLaptop) GetDistance() float64 {
+	if x != nil {
+		return x.Distance
+	}
+	return 0
+}
+
+// SimilarLaptopsResponse represents the response message for the SimilarLaptops RPC
+type SimilarLaptopsResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// Laptops holds the most similar laptops, nearest first
+	Laptops []*SimilarLaptop `protobuf:"bytes,1,rep,name=laptops,proto3" json:"laptops,omitempty"`
+}
+
+func (x *SimilarLaptopsResponse) Reset() {
+	*x = SimilarLaptopsResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_laptop_service_proto_msgTypes[26]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *SimilarLaptopsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SimilarLaptopsResponse) ProtoMessage() {}
+
+func (x *SimilarLaptopsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_laptop_service_proto_msgTypes[26]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SimilarLaptopsResponse.ProtoReflect.Descriptor instead.
+func (*SimilarLaptopsResponse) Descriptor() ([]byte, []int) {
+	return file_laptop_service_proto_rawDescGZIP(), []int{26}
+}
+
+func (x *SimilarLaptopsResponse) GetLaptops() []*SimilarLaptop {
+	if x != nil {
+		return x.Laptops
+	}
+	return nil
+}
+
+// ImageInfo represents the information of an image
+type ImageInfo struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	LaptopId      string `protobuf:"bytes,1,opt,name=laptop_id,json=laptopId,proto3" json:"laptop_id,omitempty"`
+	FileExtension string `protobuf:"bytes,2,opt,name=file_extension,json=fileExtension,proto3" json:"file_extension,omitempty"`
+}
+
+func (x *ImageInfo) Reset() {
+	*x = ImageInfo{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_laptop_service_proto_msgTypes[27]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ImageInfo) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ImageInfo) ProtoMessage() {}
+
+func (x *ImageInfo) ProtoReflect() protoreflect.Message {
+	mi := &file_laptop_service_proto_msgTypes[27]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ImageInfo.ProtoReflect.Descriptor instead.
+func (*ImageInfo) Descriptor() ([]byte, []int) {
+	return file_laptop_service_proto_rawDescGZIP(), []int{27}
+}
+
+func (x *ImageInfo) GetLaptopId() string {
+	if x != nil {
+		return x.LaptopId
+	}
+	return ""
+}
+
+func (x *ImageInfo) GetFileExtension() string {
+	if x != nil {
+		return x.FileExtension
+	}
+	return ""
+}
+
+// UploadImageRequest represents the request message for the UploadImage RPC
+type UploadImageRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// Types that are assignable to Data:
+	//	*UploadImageRequest_Info
+	//	*UploadImageRequest_ChunkData
+	Data isUploadImageRequest_Data `protobuf_oneof:"data"`
+}
+
+func (x *UploadImageRequest) Reset() {
+	*x = UploadImageRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_laptop_service_proto_msgTypes[28]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *UploadImageRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UploadImageRequest) ProtoMessage() {}
+
+func (x *UploadImageRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_laptop_service_proto_msgTypes[28]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UploadImageRequest.ProtoReflect.Descriptor instead.
+func (*UploadImageRequest) Descriptor() ([]byte, []int) {
+	return file_laptop_service_proto_rawDescGZIP(), []int{28}
+}
+
+func (m *UploadImageRequest) GetData() isUploadImageRequest_Data {
+	if m != nil {
+		return m.Data
+	}
+	return nil
+}
+
+func (x *UploadImageRequest) GetInfo() *ImageInfo {
+	if x, ok := x.GetData().(*UploadImageRequest_Info); ok {
+		return x.Info
+	}
+	return nil
+}
+
+func (x *UploadImageRequest) GetChunkData() []byte {
+	if x, ok := x.GetData().(*UploadImageRequest_ChunkData); ok {
+		return x.ChunkData
+	}
+	return nil
+}
+
+type isUploadImageRequest_Data interface {
+	isUploadImageRequest_Data()
+}
+
+type UploadImageRequest_Info struct {
+	Info *ImageInfo `protobuf:"bytes,1,opt,name=info,proto3,oneof"`
+}
+
+type UploadImageRequest_ChunkData struct {
+	ChunkData []byte `protobuf:"bytes,2,opt,name=chunk_data,json=chunkData,proto3,oneof"`
+}
+
+func (*UploadImageRequest_Info) isUploadImageRequest_Data() {}
+
+func (*UploadImageRequest_ChunkData) isUploadImageRequest_Data() {}
+
+// UploadImageResponse represents the response message for the UploadImage RPC
+type UploadImageResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Id   string `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Size uint32 `protobuf:"varint,2,opt,name=size,proto3" json:"size,omitempty"`
+	// deduplicated tells that the image has the same data as a stored image, which it shares instead of a copy
+	Deduplicated bool `protobuf:"varint,3,opt,name=deduplicated,proto3" json:"deduplicated,omitempty"`
+}
+
+func (x *UploadImageResponse) Reset() {
+	*x = UploadImageResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_laptop_service_proto_msgTypes[29]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *UploadImageResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UploadImageResponse) ProtoMessage() {}
+
+func (x *UploadImageResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_laptop_service_proto_msgTypes[29]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UploadImageResponse.ProtoReflect.Descriptor instead.
+func (*UploadImageResponse) Descriptor() ([]byte, []int) {
+	return file_laptop_service_proto_rawDescGZIP(), []int{29}
+}
+
+func (x *UploadImageResponse) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *UploadImageResponse) GetSize() uint32 {
+	if x != nil {
+		return x.Size
+	}
+	return 0
+}
+
+func (x *UploadImageResponse) GetDeduplicated() bool {
+	if x != nil {
+		return x.Deduplicated
+	}
+	return false
+}
+
+// InitImageUploadRequest represents the request message for the InitImageUpload RPC
+type InitImageUploadRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Info *ImageInfo `protobuf:"bytes,1,opt,name=info,proto3" json:"info,omitempty"`
+}
+
+func (x *InitImageUploadRequest) Reset() {
+	*x = InitImageUploadRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_laptop_service_proto_msgTypes[30]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *InitImageUploadRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*InitImageUploadRequest) ProtoMessage() {}
+
+func (x *InitImageUploadRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_laptop_service_proto_msgTypes[30]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use InitImageUploadRequest.ProtoReflect.Descriptor instead.
+func (*InitImageUploadRequest) Descriptor() ([]byte, []int) {
+	return file_laptop_service_proto_rawDescGZIP(), []int{30}
+}
+
+func (x *InitImageUploadRequest) GetInfo() *ImageInfo {
+	if x != nil {
+		return x.Info
+	}
+	return nil
+}
+
+// InitImageUploadResponse represents the response message for the InitImageUpload RPC
+type InitImageUploadResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	UploadId string `protobuf:"bytes,1,opt,name=upload_id,json=uploadId,proto3" json:"upload_id,omitempty"`
+	// expires_at is when the upload is dropped unless more of the image is received before then
+	ExpiresAt *timestamppb.Timestamp `protobuf:"bytes,2,opt,name=expires_at,json=expiresAt,proto3" json:"expires_at,omitempty"`
+}
+
+func (x *InitImageUploadResponse) Reset() {
+	*x = InitImageUploadResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_laptop_service_proto_msgTypes[31]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *InitImageUploadResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*InitImageUploadResponse) ProtoMessage() {}
+
+func (x *InitImageUploadResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_laptop_service_proto_msgTypes[31]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use InitImageUploadResponse.ProtoReflect.Descriptor instead.
+func (*InitImageUploadResponse) Descriptor() ([]byte, []int) {
+	return file_laptop_service_proto_rawDescGZIP(), []int{31}
+}
+
+func (x *InitImageUploadResponse) GetUploadId() string {
+	if x != nil {
+		return x.UploadId
+	}
+	return ""
+}
+
+func (x *InitImageUploadResponse) GetExpiresAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.ExpiresAt
+	}
+	return nil
+}
+
+// UploadImageChunkRequest represents the request message for the UploadImageChunk RPC
+type UploadImageChunkRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	UploadId string `protobuf:"bytes,1,opt,name=upload_id,json=uploadId,proto3" json:"upload_id,omitempty"`
+	// offset is the position of the chunk in the image, which has to match the number of bytes received so far
+	Offset    uint64 `protobuf:"varint,2,opt,name=offset,proto3" json:"offset,omitempty"`
+	ChunkData []byte `protobuf:"bytes,3,opt,name=chunk_data,json=chunkData,proto3" json:"chunk_data,omitempty"`
+}
+
+func (x *UploadImageChunkRequest) Reset() {
+	*x = UploadImageChunkRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_laptop_service_proto_msgTypes[32]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *UploadImageChunkRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UploadImageChunkRequest) ProtoMessage() {}
+
+func (x *UploadImageChunkRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_laptop_service_proto_msgTypes[32]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UploadImageChunkRequest.ProtoReflect.Descriptor instead.
+func (*UploadImageChunkRequest) Descriptor() ([]byte, []int) {
+	return file_laptop_service_proto_rawDescGZIP(), []int{32}
+}
+
+func (x *UploadImageChunkRequest) GetUploadId() string {
+	if x != nil {
+		return x.UploadId
+	}
+	return ""
+}
+
+func (x *UploadImageChunkRequest) GetOffset() uint64 {
+	if x != nil {
+		return x.Offset
+	}
+	return 0
+}
+
+func (x *UploadImageChunkRequest) GetChunkData() []byte {
+	if x != nil {
+		return x.ChunkData
+	}
+	return nil
+}
+
+// UploadImageChunkResponse represents the response message for the UploadImageChunk RPC
+type UploadImageChunkResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	ReceivedSize uint64 `protobuf:"varint,1,opt,name=received_size,json=receivedSize,proto3" json:"received_size,omitempty"`
+}
+
+func (x *UploadImageChunkResponse) Reset() {
+	*x = UploadImageChunkResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_laptop_service_proto_msgTypes[33]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *UploadImageChunkResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UploadImageChunkResponse) ProtoMessage() {}
+
+func (x *UploadImageChunkResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_laptop_service_proto_msgTypes[33]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UploadImageChunkResponse.ProtoReflect.Descriptor instead.
+func (*UploadImageChunkResponse) Descriptor() ([]byte, []int) {
+	return file_laptop_service_proto_rawDescGZIP(), []int{33}
+}
+
+func (x *UploadImageChunkResponse) GetReceivedSize() uint64 {
+	if x != nil {
+		return x.ReceivedSize
+	}
+	return 0
+}
+
+// GetImageUploadStatusRequest represents the request message for the GetImageUploadStatus RPC
+type GetImageUploadStatusRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	UploadId string `protobuf:"bytes,1,opt,name=upload_id,json=uploadId,proto3" json:"upload_id,omitempty"`
+}
+
+func (x *GetImageUploadStatusRequest) Reset() {
+	*x = GetImageUploadStatusRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_laptop_service_proto_msgTypes[34]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *GetImageUploadStatusRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetImageUploadStatusRequest) ProtoMessage() {}
+
+func (x *GetImageUploadStatusRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_laptop_service_proto_msgTypes[34]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetImageUploadStatusRequest.ProtoReflect.Descriptor instead.
+func (*GetImageUploadStatusRequest) Descriptor() ([]byte, []int) {
+	return file_laptop_service_proto_rawDescGZIP(), []int{34}
+}
+
+func (x *GetImageUploadStatusRequest) GetUploadId() string {
+	if x != nil {
+		return x.UploadId
+	}
+	return ""
+}
+
+// GetImageUploadStatusResponse represents the response message for the GetImageUploadStatus RPC
+type GetImageUploadStatusResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	UploadId     string                 `protobuf:"bytes,1,opt,name=upload_id,json=uploadId,proto3" json:"upload_id,omitempty"`
+	ReceivedSize uint64                 `protobuf:"varint,2,opt,name=received_size,json=receivedSize,proto3" json:"received_size,omitempty"`
+	ExpiresAt    *timestamppb.Timestamp `protobuf:"bytes,3,opt,name=expires_at,json=expiresAt,proto3" json:"expires_at,omitempty"`
+}
+
+func (x *GetImageUploadStatusResponse) Reset() {
+	*x = GetImageUploadStatusResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_laptop_service_proto_msgTypes[35]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *GetImageUploadStatusResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetImageUploadStatusResponse) ProtoMessage() {}
+
+func (x *GetImageUploadStatusResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_laptop_service_proto_msgTypes[35]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetImageUploadStatusResponse.ProtoReflect.Descriptor instead.
+func (*GetImageUploadStatusResponse) Descriptor() ([]byte, []int) {
+	return file_laptop_service_proto_rawDescGZIP(), []int{35}
+}
+
+func (x *GetImageUploadStatusResponse) GetUploadId() string {
+	if x != nil {
+		return x.UploadId
+	}
+	return ""
+}
+
+func (x *GetImageUploadStatusResponse) GetReceivedSize() uint64 {
+	if x != nil {
+		return x.ReceivedSize
+	}
+	return 0
+}
+
+func (x *GetImageUploadStatusResponse) GetExpiresAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.ExpiresAt
+	}
+	return nil
+}
+
+// FinalizeImageUploadRequest represents the request message for the FinalizeImageUpload RPC
+type FinalizeImageUploadRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	UploadId string `protobuf:"bytes,1,opt,name=upload_id,json=uploadId,proto3" json:"upload_id,omitempty"`
+	// sha256 is the hex encoded SHA-256 checksum of the whole image
+	Sha256 string `protobuf:"bytes,2,opt,name=sha256,proto3" json:"sha256,omitempty"`
+}
+
+func (x *FinalizeImageUploadRequest) Reset() {
+	*x = FinalizeImageUploadRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_laptop_service_proto_msgTypes[36]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *FinalizeImageUploadRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FinalizeImageUploadRequest) ProtoMessage() {}
+
+func (x *FinalizeImageUploadRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_laptop_service_proto_msgTypes[36]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FinalizeImageUploadRequest.ProtoReflect.Descriptor instead.
+func (*FinalizeImageUploadRequest) Descriptor() ([]byte, []int) {
+	return file_laptop_service_proto_rawDescGZIP(), []int{36}
+}
+
+func (x *FinalizeImageUploadRequest) GetUploadId() string {
+	if x != nil {
+		return x.UploadId
+	}
+	return ""
+}
+
+func (x *FinalizeImageUploadRequest) GetSha256() string {
+	if x != nil {
+		return x.Sha256
+	}
+	return ""
+}
+
+// FinalizeImageUploadResponse represents the response message for the FinalizeImageUpload RPC
+type FinalizeImageUploadResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Id   string `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Size uint64 `protobuf:"varint,2,opt,name=size,proto3" json:"size,omitempty"`
+	// deduplicated tells that the image has the same data as a stored image, which it shares instead of a copy
+	Deduplicated bool `protobuf:"varint,3,opt,name=deduplicated,proto3" json:"deduplicated,omitempty"`
+}
+
+func (x *FinalizeImageUploadResponse) Reset() {
+	*x = FinalizeImageUploadResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_laptop_service_proto_msgTypes[37]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *FinalizeImageUploadResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FinalizeImageUploadResponse) ProtoMessage() {}
+
+func (x *FinalizeImageUploadResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_laptop_service_proto_msgTypes[37]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FinalizeImageUploadResponse.ProtoReflect.Descriptor instead.
+func (*FinalizeImageUploadResponse) Descriptor() ([]byte, []int) {
+	return file_laptop_service_proto_rawDescGZIP(), []int{37}
+}
+
+func (x *FinalizeImageUploadResponse) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *FinalizeImageUploadResponse) GetSize() uint64 {
+	if x != nil {
+		return x.Size
+	}
+	return 0
+}
+
+func (x *FinalizeImageUploadResponse) GetDeduplicated() bool {
+	if x != nil {
+		return x.Deduplicated
+	}
+	return false
+}
+
+// ImageDetails describes a stored laptop image
+type ImageDetails struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Id          string `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	LaptopId    string `protobuf:"bytes,2,opt,name=laptop_id,json=laptopId,proto3" json:"laptop_id,omitempty"`
+	ContentType string `protobuf:"bytes,3,opt,name=content_type,json=contentType,proto3" json:"content_type,omitempty"`
+	Size        uint64 `protobuf:"varint,4,opt,name=size,proto3" json:"size,omitempty"`
+	Width       uint32 `protobuf:"varint,5,opt,name=width,proto3" json:"width,omitempty"`
+	Height      uint32 `protobuf:"varint,6,opt,name=height,proto3" json:"height,omitempty"`
+	// rendition is the size of a resized image - small, medium or large - and is empty for uploaded images
+	Rendition string `protobuf:"bytes,7,opt,name=rendition,proto3" json:"rendition,omitempty"`
+	// source_id is the id of the uploaded image a resized image was generated from
+	SourceId string `protobuf:"bytes,8,opt,name=source_id,json=sourceId,proto3" json:"source_id,omitempty"`
+	// renditions are the resized versions of an uploaded image
+	Renditions []*ImageDetails `protobuf:"bytes,9,rep,name=renditions,proto3" json:"renditions,omitempty"`
+	// rendition_status tells whether the renditions of an uploaded image were generated
+	RenditionStatus ImageDetails_RenditionStatus `protobuf:"varint,10,opt,name=rendition_status,json=renditionStatus,proto3,enum=pcbook.ImageDetails_RenditionStatus" json:"rendition_status,omitempty"`
+	// rendition_error tells why generating the renditions of an uploaded image failed
+	RenditionError string `protobuf:"bytes,11,opt,name=rendition_error,json=renditionError,proto3" json:"rendition_error,omitempty"`
+}
+
+func (x *ImageDetails) Reset() {
+	*x = ImageDetails{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_laptop_service_proto_msgTypes[38]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ImageDetails) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ImageDetails) ProtoMessage() {}
+
+func (x *ImageDetails) ProtoReflect() protoreflect.Message {
+	mi := &file_laptop_service_proto_msgTypes[38]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ImageDetails.ProtoReflect.Descriptor instead.
+func (*ImageDetails) Descriptor() ([]byte, []int) {
+	return file_laptop_service_proto_rawDescGZIP(), []int{38}
+}
+
+func (x *ImageDetails) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *ImageDetails) GetLaptopId() string {
+	if x != nil {
+		return x.LaptopId
+	}
+	return ""
+}
+
+func (x *ImageDetails) GetContentType() string {
+	if x != nil {
+		return x.ContentType
+	}
+	return ""
+}
+
+func (x *ImageDetails) GetSize() uint64 {
+	if x != nil {
+		return x.Size
+	}
+	return 0
+}
+
+func (x *ImageDetails) GetWidth() uint32 {
+	if x != nil {
+		return x.Width
+	}
+	return 0
+}
+
+func (x *ImageDetails) GetHeight() uint32 {
+	if x != nil {
+		return x.Height
+	}
+	return 0
+}
+
+func (x *ImageDetails) GetRendition() string {
+	if x != nil {
+		return x.Rendition
+	}
+	return ""
+}
+
+func (x *ImageDetails) GetSourceId() string {
+	if x != nil {
+		return x.SourceId
+	}
+	return ""
+}
+
+func (x *ImageDetails) GetRenditions() []*ImageDetails {
+	if x != nil {
+		return x.Renditions
+	}
+	return nil
+}
+
+func (x *ImageDetails) GetRenditionStatus() ImageDetails_RenditionStatus {
+	if x != nil {
+		return x.RenditionStatus
+	}
+	return ImageDetails_NONE
+}
+
+func (x *ImageDetails) GetRenditionError() string {
+	if x != nil {
+		return x.RenditionError
+	}
+	return ""
+}
+
+// DownloadImageRequest represents the request message for the DownloadImage RPC
+type DownloadImageRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Id string `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+}
+
+func (x *DownloadImageRequest) Reset() {
+	*x = DownloadImageRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_laptop_service_proto_msgTypes[39]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *DownloadImageRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DownloadImageRequest) ProtoMessage() {}
+
+func (x *DownloadImageRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_laptop_service_proto_msgTypes[39]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DownloadImageRequest.ProtoReflect.Descriptor instead.
+func (*DownloadImageRequest) Descriptor() ([]byte, []int) {
+	return file_laptop_service_proto_rawDescGZIP(), []int{39}
+}
+
+func (x *DownloadImageRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+// DownloadImageResponse represents the response message for the DownloadImage RPC, the details of the image are sent
+// first and its data in the following chunks
+type DownloadImageResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// Types that are assignable to Data:
+	//	*DownloadImageResponse_Info
+	//	*DownloadImageResponse_ChunkData
+	Data isDownloadImageResponse_Data `protobuf_oneof:"data"`
+}
+
+func (x *DownloadImageResponse) Reset() {
+	*x = DownloadImageResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_laptop_service_proto_msgTypes[40]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *DownloadImageResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DownloadImageResponse) ProtoMessage() {}
+
+func (x *DownloadImageResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_laptop_service_proto_msgTypes[40]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DownloadImageResponse.ProtoReflect.Descriptor instead.
+func (*DownloadImageResponse) Descriptor() ([]byte, []int) {
+	return file_laptop_service_proto_rawDescGZIP(), []int{40}
+}
+
+func (m *DownloadImageResponse) GetData() isDownloadImageResponse_Data {
+	if m != nil {
+		return m.Data
+	}
+	return nil
+}
+
+func (x *DownloadImageResponse) GetInfo() *ImageDetails {
+	if x, ok := x.GetData().(*DownloadImageResponse_Info); ok {
+		return x.Info
+	}
+	return nil
+}
+
+func (x *DownloadImageResponse) GetChunkData() []byte {
+	if x, ok := x.GetData().(*DownloadImageResponse_ChunkData); ok {
+		return x.ChunkData
+	}
+	return nil
+}
+
+type isDownloadImageResponse_Data interface {
+	isDownloadImageResponse_Data()
+}
+
+type DownloadImageResponse_Info struct {
+	Info *ImageDetails `protobuf:"bytes,1,opt,name=info,proto3,oneof"`
+}
+
+type DownloadImageResponse_ChunkData struct {
+	ChunkData []byte `protobuf:"bytes,2,opt,name=chunk_data,json=chunkData,proto3,oneof"`
+}
+
+func (*DownloadImageResponse_Info) isDownloadImageResponse_Data() {}
+
+func (*DownloadImageResponse_ChunkData) isDownloadImageResponse_Data() {}
+
+// ListImagesRequest represents the request message for the ListImages RPC
+type ListImagesRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	LaptopId string `protobuf:"bytes,1,opt,name=laptop_id,json=laptopId,proto3" json:"laptop_id,omitempty"`
+}
+
+func (x *ListImagesRequest) Reset() {
+	*x = ListImagesRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_laptop_service_proto_msgTypes[41]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ListImagesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListImagesRequest) ProtoMessage() {}
+
+func (x *ListImagesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_laptop_service_proto_msgTypes[41]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListImagesRequest.ProtoReflect.Descriptor instead.
+func (*ListImagesRequest) Descriptor() ([]byte, []int) {
+	return file_laptop_service_proto_rawDescGZIP(), []int{41}
+}
+
+func (x *ListImagesRequest) GetLaptopId() string {
+	if x != nil {
+		return x.LaptopId
+	}
+	return ""
+}
+
+// ListImagesResponse represents the response message for the ListImages RPC
+type ListImagesResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Images []*ImageDetails `protobuf:"bytes,1,rep,name=images,proto3" json:"images,omitempty"`
+}
+
+func (x *ListImagesResponse) Reset() {
+	*x = ListImagesResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_laptop_service_proto_msgTypes[42]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ListImagesResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListImagesResponse) ProtoMessage() {}
+
+func (x *ListImagesResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_laptop_service_proto_msgTypes[42]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListImagesResponse.ProtoReflect.Descriptor instead.
+func (*ListImagesResponse) Descriptor() ([]byte, []int) {
+	return file_laptop_service_proto_rawDescGZIP(), []int{42}
+}
+
+func (x *ListImagesResponse) GetImages() []*ImageDetails {
+	if x != nil {
+		return x.Images
+	}
+	return nil
+}
+
+// DeleteImageRequest represents the request message for the DeleteImage RPC
+type DeleteImageRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Id string `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+}
+
+func (x *DeleteImageRequest) Reset() {
+	*x = DeleteImageRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_laptop_service_proto_msgTypes[43]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *DeleteImageRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteImageRequest) ProtoMessage() {}
+
+func (x *DeleteImageRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_laptop_service_proto_msgTypes[43]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteImageRequest.ProtoReflect.Descriptor instead.
+func (*DeleteImageRequest) Descriptor() ([]byte, []int) {
+	return file_laptop_service_proto_rawDescGZIP(), []int{43}
+}
+
+func (x *DeleteImageRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+// DeleteImageResponse represents the response message for the DeleteImage RPC
+type DeleteImageResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+}
+
+func (x *DeleteImageResponse) Reset() {
+	*x = DeleteImageResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_laptop_service_proto_msgTypes[44]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *DeleteImageResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteImageResponse) ProtoMessage() {}
+
+func (x *DeleteImageResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_laptop_service_proto_msgTypes[44]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteImageResponse.ProtoReflect.Descriptor instead.
+func (*DeleteImageResponse) Descriptor() ([]byte, []int) {
+	return file_laptop_service_proto_rawDescGZIP(), []int{44}
+}
+
+// RateLaptopRequest represents the request message for the RateLaptop RPC
+type RateLaptopRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	LaptopId string  `protobuf:"bytes,1,opt,name=laptop_id,json=laptopId,proto3" json:"laptop_id,omitempty"`
+	Score    float64 `protobuf:"fixed64,2,opt,name=score,proto3" json:"score,omitempty"`
+}
+
+func (x *RateLaptopRequest) Reset() {
+	*x = RateLaptopRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_laptop_service_proto_msgTypes[45]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *RateLaptopRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RateLaptopRequest) ProtoMessage() {}
+
+func (x *RateLaptopRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_laptop_service_proto_msgTypes[45]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RateLaptopRequest.ProtoReflect.Descriptor instead.
+func (*RateLaptopRequest) Descriptor() ([]byte, []int) {
+	return file_laptop_service_proto_rawDescGZIP(), []int{45}
+}
+
+func (x *RateLaptopRequest) GetLaptopId() string {
+	if x != nil {
+		return x.LaptopId
+	}
+	return ""
+}
+
+func (x *RateLaptopRequest) GetScore() float64 {
+	if x != nil {
+		return x.Score
+	}
+	return 0
+}
+
+// RateLaptopResponse is the response message for the RateLaptop RPC
+type RateLaptopResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	LaptopId     string  `protobuf:"bytes,1,opt,name=laptop_id,json=laptopId,proto3" json:"laptop_id,omitempty"`
+	RatingsCount uint32  `protobuf:"varint,2,opt,name=ratings_count,json=ratingsCount,proto3" json:"ratings_count,omitempty"`
+	AverageScore float64 `protobuf:"fixed64,3,opt,name=average_score,json=averageScore,proto3" json:"average_score,omitempty"`
+}
+
+func (x *RateLaptopResponse) Reset() {
+	*x = RateLaptopResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_laptop_service_proto_msgTypes[46]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *RateLaptopResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RateLaptopResponse) ProtoMessage() {}
+
+func (x *RateLaptopResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_laptop_service_proto_msgTypes[46]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RateLaptopResponse.ProtoReflect.Descriptor instead.
+func (*RateLaptopResponse) Descriptor() ([]byte, []int) {
+	return file_laptop_service_proto_rawDescGZIP(), []int{46}
+}
+
+func (x *RateLaptopResponse) GetLaptopId() string {
+	if x != nil {
+		return x.LaptopId
+	}
+	return ""
+}
+
+func (x *RateLaptopResponse) GetRatingsCount() uint32 {
+	if x != nil {
+		return x.RatingsCount
+	}
+	return 0
+}
+
+func (x *RateLaptopResponse) GetAverageScore() float64 {
+	if x != nil {
+		return x.AverageScore
+	}
+	return 0
+}
+
+var File_laptop_service_proto protoreflect.FileDescriptor
+
+var file_laptop_service_proto_rawDesc = []byte{
+	0x0a, 0x14, 0x6c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x5f, 0x73, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65,
+	0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x06, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x1a, 0x1c,
+	0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2f, 0x61, 0x70, 0x69, 0x2f, 0x61, 0x6e, 0x6e, 0x6f, 0x74,
+	0x61, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x1a, 0x0f, 0x70, 0x72,
+	0x6f, 0x63, 0x65, 0x73, 0x73, 0x6f, 0x72, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x1a, 0x0c, 0x6d,
+	0x65, 0x6d, 0x6f, 0x72, 0x79, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x1a, 0x0d, 0x73, 0x74, 0x6f,
+	0x72, 0x61, 0x67, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x1a, 0x0c, 0x73, 0x63, 0x72, 0x65,
+	0x65, 0x6e, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x1a, 0x0e, 0x6b, 0x65, 0x79, 0x62, 0x6f, 0x61,
+	0x72, 0x64, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x1a, 0x1f, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65,
+	0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2f, 0x74, 0x69, 0x6d, 0x65, 0x73, 0x74,
+	0x61, 0x6d, 0x70, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x1a, 0x20, 0x67, 0x6f, 0x6f, 0x67, 0x6c,
+	0x65, 0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2f, 0x66, 0x69, 0x65, 0x6c, 0x64,
+	0x5f, 0x6d, 0x61, 0x73, 0x6b, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x22, 0x86, 0x04, 0x0a, 0x06,
+	0x4c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x12, 0x0e, 0x0a, 0x02, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x02, 0x69, 0x64, 0x12, 0x14, 0x0a, 0x05, 0x62, 0x72, 0x61, 0x6e, 0x64, 0x18,
+	0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x62, 0x72, 0x61, 0x6e, 0x64, 0x12, 0x12, 0x0a, 0x04,
+	0x6e, 0x61, 0x6d, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x6e, 0x61, 0x6d, 0x65,
+	0x12, 0x1d, 0x0a, 0x03, 0x63, 0x70, 0x75, 0x18, 0x04, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0b, 0x2e,
+	0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x43, 0x50, 0x55, 0x52, 0x03, 0x63, 0x70, 0x75, 0x12,
+	0x20, 0x0a, 0x03, 0x72, 0x61, 0x6d, 0x18, 0x05, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0e, 0x2e, 0x70,
+	0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x4d, 0x65, 0x6d, 0x6f, 0x72, 0x79, 0x52, 0x03, 0x72, 0x61,
+	0x6d, 0x12, 0x1f, 0x0a, 0x04, 0x67, 0x70, 0x75, 0x73, 0x18, 0x06, 0x20, 0x03, 0x28, 0x0b, 0x32,
+	0x0b, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x47, 0x50, 0x55, 0x52, 0x04, 0x67, 0x70,
+	0x75, 0x73, 0x12, 0x2b, 0x0a, 0x08, 0x73, 0x74, 0x6f, 0x72, 0x61, 0x67, 0x65, 0x73, 0x18, 0x07,
+	0x20, 0x03, 0x28, 0x0b, 0x32, 0x0f, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x53, 0x74,
+	0x6f, 0x72, 0x61, 0x67, 0x65, 0x52, 0x08, 0x73, 0x74, 0x6f, 0x72, 0x61, 0x67, 0x65, 0x73, 0x12,
+	0x26, 0x0a, 0x06, 0x73, 0x63, 0x72, 0x65, 0x65, 0x6e, 0x18, 0x08, 0x20, 0x01, 0x28, 0x0b, 0x32,
+	0x0e, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x53, 0x63, 0x72, 0x65, 0x65, 0x6e, 0x52,
+	0x06, 0x73, 0x63, 0x72, 0x65, 0x65, 0x6e, 0x12, 0x2c, 0x0a, 0x08, 0x6b, 0x65, 0x79, 0x62, 0x6f,
+	0x61, 0x72, 0x64, 0x18, 0x09, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x10, 0x2e, 0x70, 0x63, 0x62, 0x6f,
+	0x6f, 0x6b, 0x2e, 0x4b, 0x65, 0x79, 0x62, 0x6f, 0x61, 0x72, 0x64, 0x52, 0x08, 0x6b, 0x65, 0x79,
+	0x62, 0x6f, 0x61, 0x72, 0x64, 0x12, 0x1d, 0x0a, 0x09, 0x77, 0x65, 0x69, 0x67, 0x68, 0x74, 0x5f,
+	0x6b, 0x67, 0x18, 0x0a, 0x20, 0x01, 0x28, 0x01, 0x48, 0x00, 0x52, 0x08, 0x77, 0x65, 0x69, 0x67,
+	0x68, 0x74, 0x4b, 0x67, 0x12, 0x1d, 0x0a, 0x09, 0x77, 0x65, 0x69, 0x67, 0x68, 0x74, 0x5f, 0x6c,
+	0x62, 0x18, 0x0b, 0x20, 0x01, 0x28, 0x01, 0x48, 0x00, 0x52, 0x08, 0x77, 0x65, 0x69, 0x67, 0x68,
+	0x74, 0x4c, 0x62, 0x12, 0x1b, 0x0a, 0x09, 0x70, 0x72, 0x69, 0x63, 0x65, 0x5f, 0x75, 0x73, 0x64,
+	0x18, 0x0c, 0x20, 0x01, 0x28, 0x01, 0x52, 0x08, 0x70, 0x72, 0x69, 0x63, 0x65, 0x55, 0x73, 0x64,
+	0x12, 0x21, 0x0a, 0x0c, 0x72, 0x65, 0x6c, 0x65, 0x61, 0x73, 0x65, 0x5f, 0x79, 0x65, 0x61, 0x72,
+	0x18, 0x0d, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x0b, 0x72, 0x65, 0x6c, 0x65, 0x61, 0x73, 0x65, 0x59,
+	0x65, 0x61, 0x72, 0x12, 0x39, 0x0a, 0x0a, 0x75, 0x70, 0x64, 0x61, 0x74, 0x65, 0x64, 0x5f, 0x61,
+	0x74, 0x18, 0x0e, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65,
+	0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74,
+	0x61, 0x6d, 0x70, 0x52, 0x09, 0x75, 0x70, 0x64, 0x61, 0x74, 0x65, 0x64, 0x41, 0x74, 0x12, 0x1a,
+	0x0a, 0x08, 0x72, 0x65, 0x76, 0x69, 0x73, 0x69, 0x6f, 0x6e, 0x18, 0x0f, 0x20, 0x01, 0x28, 0x04,
+	0x52, 0x08, 0x72, 0x65, 0x76, 0x69, 0x73, 0x69, 0x6f, 0x6e, 0x42, 0x08, 0x0a, 0x06, 0x77, 0x65,
+	0x69, 0x67, 0x68, 0x74, 0x22, 0xbe, 0x09, 0x0a, 0x06, 0x46, 0x69, 0x6c, 0x74, 0x65, 0x72, 0x12,
+	0x27, 0x0a, 0x0d, 0x6d, 0x61, 0x78, 0x5f, 0x70, 0x72, 0x69, 0x63, 0x65, 0x5f, 0x75, 0x73, 0x64,
+	0x18, 0x01, 0x20, 0x01, 0x28, 0x01, 0x48, 0x00, 0x52, 0x0b, 0x6d, 0x61, 0x78, 0x50, 0x72, 0x69,
+	0x63, 0x65, 0x55, 0x73, 0x64, 0x88, 0x01, 0x01, 0x12, 0x27, 0x0a, 0x0d, 0x6d, 0x69, 0x6e, 0x5f,
+	0x63, 0x70, 0x75, 0x5f, 0x63, 0x6f, 0x72, 0x65, 0x73, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0d, 0x48,
+	0x01, 0x52, 0x0b, 0x6d, 0x69, 0x6e, 0x43, 0x70, 0x75, 0x43, 0x6f, 0x72, 0x65, 0x73, 0x88, 0x01,
+	0x01, 0x12, 0x2f, 0x0a, 0x11, 0x6d, 0x69, 0x6e, 0x5f, 0x63, 0x70, 0x75, 0x5f, 0x66, 0x72, 0x65,
+	0x71, 0x75, 0x65, 0x6e, 0x63, 0x79, 0x18, 0x03, 0x20, 0x01, 0x28, 0x01, 0x48, 0x02, 0x52, 0x0f,
+	0x6d, 0x69, 0x6e, 0x43, 0x70, 0x75, 0x46, 0x72, 0x65, 0x71, 0x75, 0x65, 0x6e, 0x63, 0x79, 0x88,
+	0x01, 0x01, 0x12, 0x27, 0x0a, 0x07, 0x6d, 0x69, 0x6e, 0x5f, 0x72, 0x61, 0x6d, 0x18, 0x04, 0x20,
+	0x01, 0x28, 0x0b, 0x32, 0x0e, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x4d, 0x65, 0x6d,
+	0x6f, 0x72, 0x79, 0x52, 0x06, 0x6d, 0x69, 0x6e, 0x52, 0x61, 0x6d, 0x12, 0x14, 0x0a, 0x05, 0x62,
+	0x72, 0x61, 0x6e, 0x64, 0x18, 0x05, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x62, 0x72, 0x61, 0x6e,
+	0x64, 0x12, 0x12, 0x0a, 0x04, 0x6e, 0x61, 0x6d, 0x65, 0x18, 0x06, 0x20, 0x01, 0x28, 0x09, 0x52,
+	0x04, 0x6e, 0x61, 0x6d, 0x65, 0x12, 0x27, 0x0a, 0x0d, 0x6d, 0x69, 0x6e, 0x5f, 0x70, 0x72, 0x69,
+	0x63, 0x65, 0x5f, 0x75, 0x73, 0x64, 0x18, 0x07, 0x20, 0x01, 0x28, 0x01, 0x48, 0x03, 0x52, 0x0b,
+	0x6d, 0x69, 0x6e, 0x50, 0x72, 0x69, 0x63, 0x65, 0x55, 0x73, 0x64, 0x88, 0x01, 0x01, 0x12, 0x1b,
+	0x0a, 0x09, 0x67, 0x70, 0x75, 0x5f, 0x62, 0x72, 0x61, 0x6e, 0x64, 0x18, 0x08, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x08, 0x67, 0x70, 0x75, 0x42, 0x72, 0x61, 0x6e, 0x64, 0x12, 0x34, 0x0a, 0x0e, 0x6d,
+	0x69, 0x6e, 0x5f, 0x67, 0x70, 0x75, 0x5f, 0x6d, 0x65, 0x6d, 0x6f, 0x72, 0x79, 0x18, 0x09, 0x20,
+	0x01, 0x28, 0x0b, 0x32, 0x0e, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x4d, 0x65, 0x6d,
+	0x6f, 0x72, 0x79, 0x52, 0x0c, 0x6d, 0x69, 0x6e, 0x47, 0x70, 0x75, 0x4d, 0x65, 0x6d, 0x6f, 0x72,
+	0x79, 0x12, 0x2f, 0x0a, 0x0b, 0x6d, 0x69, 0x6e, 0x5f, 0x73, 0x74, 0x6f, 0x72, 0x61, 0x67, 0x65,
+	0x18, 0x0a, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0e, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e,
+	0x4d, 0x65, 0x6d, 0x6f, 0x72, 0x79, 0x52, 0x0a, 0x6d, 0x69, 0x6e, 0x53, 0x74, 0x6f, 0x72, 0x61,
+	0x67, 0x65, 0x12, 0x3d, 0x0a, 0x0e, 0x73, 0x74, 0x6f, 0x72, 0x61, 0x67, 0x65, 0x5f, 0x64, 0x72,
+	0x69, 0x76, 0x65, 0x72, 0x18, 0x0b, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x16, 0x2e, 0x70, 0x63, 0x62,
+	0x6f, 0x6f, 0x6b, 0x2e, 0x53, 0x74, 0x6f, 0x72, 0x61, 0x67, 0x65, 0x2e, 0x44, 0x72, 0x69, 0x76,
+	0x65, 0x72, 0x52, 0x0d, 0x73, 0x74, 0x6f, 0x72, 0x61, 0x67, 0x65, 0x44, 0x72, 0x69, 0x76, 0x65,
+	0x72, 0x12, 0x38, 0x0a, 0x16, 0x6d, 0x69, 0x6e, 0x5f, 0x73, 0x63, 0x72, 0x65, 0x65, 0x6e, 0x5f,
+	0x73, 0x69, 0x7a, 0x65, 0x5f, 0x69, 0x6e, 0x63, 0x68, 0x65, 0x73, 0x18, 0x0c, 0x20, 0x01, 0x28,
+	0x02, 0x48, 0x04, 0x52, 0x13, 0x6d, 0x69, 0x6e, 0x53, 0x63, 0x72, 0x65, 0x65, 0x6e, 0x53, 0x69,
+	0x7a, 0x65, 0x49, 0x6e, 0x63, 0x68, 0x65, 0x73, 0x88, 0x01, 0x01, 0x12, 0x38, 0x0a, 0x16, 0x6d,
+	0x61, 0x78, 0x5f, 0x73, 0x63, 0x72, 0x65, 0x65, 0x6e, 0x5f, 0x73, 0x69, 0x7a, 0x65, 0x5f, 0x69,
+	0x6e, 0x63, 0x68, 0x65, 0x73, 0x18, 0x0d, 0x20, 0x01, 0x28, 0x02, 0x48, 0x05, 0x52, 0x13, 0x6d,
+	0x61, 0x78, 0x53, 0x63, 0x72, 0x65, 0x65, 0x6e, 0x53, 0x69, 0x7a, 0x65, 0x49, 0x6e, 0x63, 0x68,
+	0x65, 0x73, 0x88, 0x01, 0x01, 0x12, 0x4d, 0x0a, 0x15, 0x6d, 0x69, 0x6e, 0x5f, 0x73, 0x63, 0x72,
+	0x65, 0x65, 0x6e, 0x5f, 0x72, 0x65, 0x73, 0x6f, 0x6c, 0x75, 0x74, 0x69, 0x6f, 0x6e, 0x18, 0x0e,
+	0x20, 0x01, 0x28, 0x0b, 0x32, 0x19, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x53, 0x63,
+	0x72, 0x65, 0x65, 0x6e, 0x2e, 0x52, 0x65, 0x73, 0x6f, 0x6c, 0x75, 0x74, 0x69, 0x6f, 0x6e, 0x52,
+	0x13, 0x6d, 0x69, 0x6e, 0x53, 0x63, 0x72, 0x65, 0x65, 0x6e, 0x52, 0x65, 0x73, 0x6f, 0x6c, 0x75,
+	0x74, 0x69, 0x6f, 0x6e, 0x12, 0x37, 0x0a, 0x0c, 0x73, 0x63, 0x72, 0x65, 0x65, 0x6e, 0x5f, 0x70,
+	0x61, 0x6e, 0x65, 0x6c, 0x18, 0x0f, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x14, 0x2e, 0x70, 0x63, 0x62,
+	0x6f, 0x6f, 0x6b, 0x2e, 0x53, 0x63, 0x72, 0x65, 0x65, 0x6e, 0x2e, 0x50, 0x61, 0x6e, 0x65, 0x6c,
+	0x52, 0x0b, 0x73, 0x63, 0x72, 0x65, 0x65, 0x6e, 0x50, 0x61, 0x6e, 0x65, 0x6c, 0x12, 0x29, 0x0a,
+	0x0e, 0x69, 0x73, 0x5f, 0x6d, 0x75, 0x6c, 0x74, 0x69, 0x5f, 0x74, 0x6f, 0x75, 0x63, 0x68, 0x18,
+	0x10, 0x20, 0x01, 0x28, 0x08, 0x48, 0x06, 0x52, 0x0c, 0x69, 0x73, 0x4d, 0x75, 0x6c, 0x74, 0x69,
+	0x54, 0x6f, 0x75, 0x63, 0x68, 0x88, 0x01, 0x01, 0x12, 0x40, 0x0a, 0x0f, 0x6b, 0x65, 0x79, 0x62,
+	0x6f, 0x61, 0x72, 0x64, 0x5f, 0x6c, 0x61, 0x79, 0x6f, 0x75, 0x74, 0x18, 0x11, 0x20, 0x01, 0x28,
+	0x0e, 0x32, 0x17, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x4b, 0x65, 0x79, 0x62, 0x6f,
+	0x61, 0x72, 0x64, 0x2e, 0x4c, 0x61, 0x79, 0x6f, 0x75, 0x74, 0x52, 0x0e, 0x6b, 0x65, 0x79, 0x62,
+	0x6f, 0x61, 0x72, 0x64, 0x4c, 0x61, 0x79, 0x6f, 0x75, 0x74, 0x12, 0x22, 0x0a, 0x0a, 0x69, 0x73,
+	0x5f, 0x62, 0x61, 0x63, 0x6b, 0x6c, 0x69, 0x74, 0x18, 0x12, 0x20, 0x01, 0x28, 0x08, 0x48, 0x07,
+	0x52, 0x09, 0x69, 0x73, 0x42, 0x61, 0x63, 0x6b, 0x6c, 0x69, 0x74, 0x88, 0x01, 0x01, 0x12, 0x27,
+	0x0a, 0x0d, 0x6d, 0x61, 0x78, 0x5f, 0x77, 0x65, 0x69, 0x67, 0x68, 0x74, 0x5f, 0x6b, 0x67, 0x18,
+	0x13, 0x20, 0x01, 0x28, 0x01, 0x48, 0x08, 0x52, 0x0b, 0x6d, 0x61, 0x78, 0x57, 0x65, 0x69, 0x67,
+	0x68, 0x74, 0x4b, 0x67, 0x88, 0x01, 0x01, 0x12, 0x2d, 0x0a, 0x10, 0x6d, 0x69, 0x6e, 0x5f, 0x72,
+	0x65, 0x6c, 0x65, 0x61, 0x73, 0x65, 0x5f, 0x79, 0x65, 0x61, 0x72, 0x18, 0x14, 0x20, 0x01, 0x28,
+	0x0d, 0x48, 0x09, 0x52, 0x0e, 0x6d, 0x69, 0x6e, 0x52, 0x65, 0x6c, 0x65, 0x61, 0x73, 0x65, 0x59,
+	0x65, 0x61, 0x72, 0x88, 0x01, 0x01, 0x12, 0x2d, 0x0a, 0x10, 0x6d, 0x61, 0x78, 0x5f, 0x72, 0x65,
+	0x6c, 0x65, 0x61, 0x73, 0x65, 0x5f, 0x79, 0x65, 0x61, 0x72, 0x18, 0x15, 0x20, 0x01, 0x28, 0x0d,
+	0x48, 0x0a, 0x52, 0x0e, 0x6d, 0x61, 0x78, 0x52, 0x65, 0x6c, 0x65, 0x61, 0x73, 0x65, 0x59, 0x65,
+	0x61, 0x72, 0x88, 0x01, 0x01, 0x42, 0x10, 0x0a, 0x0e, 0x5f, 0x6d, 0x61, 0x78, 0x5f, 0x70, 0x72,
+	0x69, 0x63, 0x65, 0x5f, 0x75, 0x73, 0x64, 0x42, 0x10, 0x0a, 0x0e, 0x5f, 0x6d, 0x69, 0x6e, 0x5f,
+	0x63, 0x70, 0x75, 0x5f, 0x63, 0x6f, 0x72, 0x65, 0x73, 0x42, 0x14, 0x0a, 0x12, 0x5f, 0x6d, 0x69,
+	0x6e, 0x5f, 0x63, 0x70, 0x75, 0x5f, 0x66, 0x72, 0x65, 0x71, 0x75, 0x65, 0x6e, 0x63, 0x79, 0x42,
+	0x10, 0x0a, 0x0e, 0x5f, 0x6d, 0x69, 0x6e, 0x5f, 0x70, 0x72, 0x69, 0x63, 0x65, 0x5f, 0x75, 0x73,
+	0x64, 0x42, 0x19, 0x0a, 0x17, 0x5f, 0x6d, 0x69, 0x6e, 0x5f, 0x73, 0x63, 0x72, 0x65, 0x65, 0x6e,
+	0x5f, 0x73, 0x69, 0x7a, 0x65, 0x5f, 0x69, 0x6e, 0x63, 0x68, 0x65, 0x73, 0x42, 0x19, 0x0a, 0x17,
+	0x5f, 0x6d, 0x61, 0x78, 0x5f, 0x73, 0x63, 0x72, 0x65, 0x65, 0x6e, 0x5f, 0x73, 0x69, 0x7a, 0x65,
+	0x5f, 0x69, 0x6e, 0x63, 0x68, 0x65, 0x73, 0x42, 0x11, 0x0a, 0x0f, 0x5f, 0x69, 0x73, 0x5f, 0x6d,
+	0x75, 0x6c, 0x74, 0x69, 0x5f, 0x74, 0x6f, 0x75, 0x63, 0x68, 0x42, 0x0d, 0x0a, 0x0b, 0x5f, 0x69,
+	0x73, 0x5f, 0x62, 0x61, 0x63, 0x6b, 0x6c, 0x69, 0x74, 0x42, 0x10, 0x0a, 0x0e, 0x5f, 0x6d, 0x61,
+	0x78, 0x5f, 0x77, 0x65, 0x69, 0x67, 0x68, 0x74, 0x5f, 0x6b, 0x67, 0x42, 0x13, 0x0a, 0x11, 0x5f,
+	0x6d, 0x69, 0x6e, 0x5f, 0x72, 0x65, 0x6c, 0x65, 0x61, 0x73, 0x65, 0x5f, 0x79, 0x65, 0x61, 0x72,
+	0x42, 0x13, 0x0a, 0x11, 0x5f, 0x6d, 0x61, 0x78, 0x5f, 0x72, 0x65, 0x6c, 0x65, 0x61, 0x73, 0x65,
+	0x5f, 0x79, 0x65, 0x61, 0x72, 0x22, 0x3d, 0x0a, 0x13, 0x43, 0x72, 0x65, 0x61, 0x74, 0x65, 0x4c,
+	0x61, 0x70, 0x74, 0x6f, 0x70, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x26, 0x0a, 0x06,
+	0x6c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0e, 0x2e, 0x70,
+	0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x4c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x52, 0x06, 0x6c, 0x61,
+	0x70, 0x74, 0x6f, 0x70, 0x22, 0x26, 0x0a, 0x14, 0x43, 0x72, 0x65, 0x61, 0x74, 0x65, 0x4c, 0x61,
+	0x70, 0x74, 0x6f, 0x70, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x0e, 0x0a, 0x02,
+	0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x02, 0x69, 0x64, 0x22, 0x22, 0x0a, 0x10,
+	0x47, 0x65, 0x74, 0x4c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74,
+	0x12, 0x0e, 0x0a, 0x02, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x02, 0x69, 0x64,
+	0x22, 0x3b, 0x0a, 0x11, 0x47, 0x65, 0x74, 0x4c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x52, 0x65, 0x73,
+	0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x26, 0x0a, 0x06, 0x6c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x18,
+	0x01, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0e, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x4c,
+	0x61, 0x70, 0x74, 0x6f, 0x70, 0x52, 0x06, 0x6c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x22, 0x96, 0x01,
+	0x0a, 0x13, 0x55, 0x70, 0x64, 0x61, 0x74, 0x65, 0x4c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x52, 0x65,
+	0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x26, 0x0a, 0x06, 0x6c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x18,
+	0x01, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0e, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x4c,
+	0x61, 0x70, 0x74, 0x6f, 0x70, 0x52, 0x06, 0x6c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x12, 0x3b, 0x0a,
+	0x0b, 0x75, 0x70, 0x64, 0x61, 0x74, 0x65, 0x5f, 0x6d, 0x61, 0x73, 0x6b, 0x18, 0x02, 0x20, 0x01,
+	0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74,
+	0x6f, 0x62, 0x75, 0x66, 0x2e, 0x46, 0x69, 0x65, 0x6c, 0x64, 0x4d, 0x61, 0x73, 0x6b, 0x52, 0x0a,
+	0x75, 0x70, 0x64, 0x61, 0x74, 0x65, 0x4d, 0x61, 0x73, 0x6b, 0x12, 0x1a, 0x0a, 0x08, 0x72, 0x65,
+	0x76, 0x69, 0x73, 0x69, 0x6f, 0x6e, 0x18, 0x03, 0x20, 0x01, 0x28, 0x04, 0x52, 0x08, 0x72, 0x65,
+	0x76, 0x69, 0x73, 0x69, 0x6f, 0x6e, 0x22, 0x3e, 0x0a, 0x14, 0x55, 0x70, 0x64, 0x61, 0x74, 0x65,
+	0x4c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x26,
+	0x0a, 0x06, 0x6c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0e,
+	0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x4c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x52, 0x06,
+	0x6c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x22, 0x41, 0x0a, 0x13, 0x44, 0x65, 0x6c, 0x65, 0x74, 0x65,
+	0x4c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x0e, 0x0a,
+	0x02, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x02, 0x69, 0x64, 0x12, 0x1a, 0x0a,
+	0x08, 0x72, 0x65, 0x76, 0x69, 0x73, 0x69, 0x6f, 0x6e, 0x18, 0x02, 0x20, 0x01, 0x28, 0x04, 0x52,
+	0x08, 0x72, 0x65, 0x76, 0x69, 0x73, 0x69, 0x6f, 0x6e, 0x22, 0x16, 0x0a, 0x14, 0x44, 0x65, 0x6c,
+	0x65, 0x74, 0x65, 0x4c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73,
+	0x65, 0x22, 0x50, 0x0a, 0x12, 0x4c, 0x69, 0x73, 0x74, 0x4c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x73,
+	0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x1b, 0x0a, 0x09, 0x70, 0x61, 0x67, 0x65, 0x5f,
+	0x73, 0x69, 0x7a, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x08, 0x70, 0x61, 0x67, 0x65,
+	0x53, 0x69, 0x7a, 0x65, 0x12, 0x1d, 0x0a, 0x0a, 0x70, 0x61, 0x67, 0x65, 0x5f, 0x74, 0x6f, 0x6b,
+	0x65, 0x6e, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x70, 0x61, 0x67, 0x65, 0x54, 0x6f,
+	0x6b, 0x65, 0x6e, 0x22, 0x67, 0x0a, 0x13, 0x4c, 0x69, 0x73, 0x74, 0x4c, 0x61, 0x70, 0x74, 0x6f,
+	0x70, 0x73, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x28, 0x0a, 0x07, 0x6c, 0x61,
+	0x70, 0x74, 0x6f, 0x70, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x0e, 0x2e, 0x70, 0x63,
+	0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x4c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x52, 0x07, 0x6c, 0x61, 0x70,
+	0x74, 0x6f, 0x70, 0x73, 0x12, 0x26, 0x0a, 0x0f, 0x6e, 0x65, 0x78, 0x74, 0x5f, 0x70, 0x61, 0x67,
+	0x65, 0x5f, 0x74, 0x6f, 0x6b, 0x65, 0x6e, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0d, 0x6e,
+	0x65, 0x78, 0x74, 0x50, 0x61, 0x67, 0x65, 0x54, 0x6f, 0x6b, 0x65, 0x6e, 0x22, 0x7e, 0x0a, 0x13,
+	0x53, 0x65, 0x61, 0x72, 0x63, 0x68, 0x4c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x52, 0x65, 0x71, 0x75,
+	0x65, 0x73, 0x74, 0x12, 0x26, 0x0a, 0x06, 0x66, 0x69, 0x6c, 0x74, 0x65, 0x72, 0x18, 0x01, 0x20,
+	0x01, 0x28, 0x0b, 0x32, 0x0e, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x46, 0x69, 0x6c,
+	0x74, 0x65, 0x72, 0x52, 0x06, 0x66, 0x69, 0x6c, 0x74, 0x65, 0x72, 0x12, 0x19, 0x0a, 0x08, 0x6f,
+	0x72, 0x64, 0x65, 0x72, 0x5f, 0x62, 0x79, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x6f,
+	0x72, 0x64, 0x65, 0x72, 0x42, 0x79, 0x12, 0x10, 0x0a, 0x05, 0x71, 0x75, 0x65, 0x72, 0x79, 0x18,
+	0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x01, 0x71, 0x12, 0x12, 0x0a, 0x04, 0x74, 0x65, 0x78, 0x74,
+	0x18, 0x04, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x74, 0x65, 0x78, 0x74, 0x22, 0x54, 0x0a, 0x14,
+	0x53, 0x65, 0x61, 0x72, 0x63, 0x68, 0x4c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x52, 0x65, 0x73, 0x70,
+	0x6f, 0x6e, 0x73, 0x65, 0x12, 0x26, 0x0a, 0x06, 0x6c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x18, 0x01,
+	0x20, 0x01, 0x28, 0x0b, 0x32, 0x0e, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x4c, 0x61,
+	0x70, 0x74, 0x6f, 0x70, 0x52, 0x06, 0x6c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x12, 0x14, 0x0a, 0x05,
+	0x73, 0x63, 0x6f, 0x72, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x01, 0x52, 0x05, 0x73, 0x63, 0x6f,
+	0x72, 0x65, 0x22, 0x38, 0x0a, 0x0a, 0x46, 0x61, 0x63, 0x65, 0x74, 0x43, 0x6f, 0x75, 0x6e, 0x74,
+	0x12, 0x14, 0x0a, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52,
+	0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x12, 0x14, 0x0a, 0x05, 0x63, 0x6f, 0x75, 0x6e, 0x74, 0x18,
+	0x02, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x05, 0x63, 0x6f, 0x75, 0x6e, 0x74, 0x22, 0x47, 0x0a, 0x0b,
+	0x42, 0x75, 0x63, 0x6b, 0x65, 0x74, 0x43, 0x6f, 0x75, 0x6e, 0x74, 0x12, 0x10, 0x0a, 0x03, 0x6d,
+	0x69, 0x6e, 0x18, 0x01, 0x20, 0x01, 0x28, 0x01, 0x52, 0x03, 0x6d, 0x69, 0x6e, 0x12, 0x10, 0x0a,
+	0x03, 0x6d, 0x61, 0x78, 0x18, 0x02, 0x20, 0x01, 0x28, 0x01, 0x52, 0x03, 0x6d, 0x61, 0x78, 0x12,
+	0x14, 0x0a, 0x05, 0x63, 0x6f, 0x75, 0x6e, 0x74, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x05,
+	0x63, 0x6f, 0x75, 0x6e, 0x74, 0x22, 0xc5, 0x03, 0x0a, 0x0c, 0x4c, 0x61, 0x70, 0x74, 0x6f, 0x70,
+	0x46, 0x61, 0x63, 0x65, 0x74, 0x73, 0x12, 0x14, 0x0a, 0x05, 0x74, 0x6f, 0x74, 0x61, 0x6c, 0x18,
+	0x01, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x05, 0x74, 0x6f, 0x74, 0x61, 0x6c, 0x12, 0x2a, 0x0a, 0x06,
+	0x62, 0x72, 0x61, 0x6e, 0x64, 0x73, 0x18, 0x02, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x12, 0x2e, 0x70,
+	0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x46, 0x61, 0x63, 0x65, 0x74, 0x43, 0x6f, 0x75, 0x6e, 0x74,
+	0x52, 0x06, 0x62, 0x72, 0x61, 0x6e, 0x64, 0x73, 0x12, 0x31, 0x0a, 0x0a, 0x63, 0x70, 0x75, 0x5f,
+	0x62, 0x72, 0x61, 0x6e, 0x64, 0x73, 0x18, 0x03, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x12, 0x2e, 0x70,
+	0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x46, 0x61, 0x63, 0x65, 0x74, 0x43, 0x6f, 0x75, 0x6e, 0x74,
+	0x52, 0x09, 0x63, 0x70, 0x75, 0x42, 0x72, 0x61, 0x6e, 0x64, 0x73, 0x12, 0x31, 0x0a, 0x0a, 0x67,
+	0x70, 0x75, 0x5f, 0x62, 0x72, 0x61, 0x6e, 0x64, 0x73, 0x18, 0x04, 0x20, 0x03, 0x28, 0x0b, 0x32,
+	0x12, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x46, 0x61, 0x63, 0x65, 0x74, 0x43, 0x6f,
+	0x75, 0x6e, 0x74, 0x52, 0x09, 0x67, 0x70, 0x75, 0x42, 0x72, 0x61, 0x6e, 0x64, 0x73, 0x12, 0x37,
+	0x0a, 0x0d, 0x73, 0x63, 0x72, 0x65, 0x65, 0x6e, 0x5f, 0x70, 0x61, 0x6e, 0x65, 0x6c, 0x73, 0x18,
+	0x05, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x12, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x46,
+	0x61, 0x63, 0x65, 0x74, 0x43, 0x6f, 0x75, 0x6e, 0x74, 0x52, 0x0c, 0x73, 0x63, 0x72, 0x65, 0x65,
+	0x6e, 0x50, 0x61, 0x6e, 0x65, 0x6c, 0x73, 0x12, 0x3d, 0x0a, 0x10, 0x6b, 0x65, 0x79, 0x62, 0x6f,
+	0x61, 0x72, 0x64, 0x5f, 0x6c, 0x61, 0x79, 0x6f, 0x75, 0x74, 0x73, 0x18, 0x06, 0x20, 0x03, 0x28,
+	0x0b, 0x32, 0x12, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x46, 0x61, 0x63, 0x65, 0x74,
+	0x43, 0x6f, 0x75, 0x6e, 0x74, 0x52, 0x0f, 0x6b, 0x65, 0x79, 0x62, 0x6f, 0x61, 0x72, 0x64, 0x4c,
+	0x61, 0x79, 0x6f, 0x75, 0x74, 0x73, 0x12, 0x2a, 0x0a, 0x06, 0x72, 0x61, 0x6d, 0x5f, 0x67, 0x62,
+	0x18, 0x07, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x13, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e,
+	0x42, 0x75, 0x63, 0x6b, 0x65, 0x74, 0x43, 0x6f, 0x75, 0x6e, 0x74, 0x52, 0x05, 0x72, 0x61, 0x6d,
+	0x47, 0x62, 0x12, 0x30, 0x0a, 0x09, 0x70, 0x72, 0x69, 0x63, 0x65, 0x5f, 0x75, 0x73, 0x64, 0x18,
+	0x08, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x13, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x42,
+	0x75, 0x63, 0x6b, 0x65, 0x74, 0x43, 0x6f, 0x75, 0x6e, 0x74, 0x52, 0x08, 0x70, 0x72, 0x69, 0x63,
+	0x65, 0x55, 0x73, 0x64, 0x12, 0x37, 0x0a, 0x0d, 0x72, 0x65, 0x6c, 0x65, 0x61, 0x73, 0x65, 0x5f,
+	0x79, 0x65, 0x61, 0x72, 0x73, 0x18, 0x09, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x12, 0x2e, 0x70, 0x63,
+	0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x46, 0x61, 0x63, 0x65, 0x74, 0x43, 0x6f, 0x75, 0x6e, 0x74, 0x52,
+	0x0c, 0x72, 0x65, 0x6c, 0x65, 0x61, 0x73, 0x65, 0x59, 0x65, 0x61, 0x72, 0x73, 0x22, 0x67, 0x0a,
+	0x13, 0x53, 0x65, 0x61, 0x72, 0x63, 0x68, 0x46, 0x61, 0x63, 0x65, 0x74, 0x73, 0x52, 0x65, 0x71,
+	0x75, 0x65, 0x73, 0x74, 0x12, 0x26, 0x0a, 0x06, 0x66, 0x69, 0x6c, 0x74, 0x65, 0x72, 0x18, 0x01,
+	0x20, 0x01, 0x28, 0x0b, 0x32, 0x0e, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x46, 0x69,
+	0x6c, 0x74, 0x65, 0x72, 0x52, 0x06, 0x66, 0x69, 0x6c, 0x74, 0x65, 0x72, 0x12, 0x28, 0x0a, 0x10,
+	0x70, 0x72, 0x69, 0x63, 0x65, 0x5f, 0x62, 0x75, 0x63, 0x6b, 0x65, 0x74, 0x5f, 0x75, 0x73, 0x64,
+	0x18, 0x02, 0x20, 0x01, 0x28, 0x01, 0x52, 0x0e, 0x70, 0x72, 0x69, 0x63, 0x65, 0x42, 0x75, 0x63,
+	0x6b, 0x65, 0x74, 0x55, 0x73, 0x64, 0x22, 0x44, 0x0a, 0x14, 0x53, 0x65, 0x61, 0x72, 0x63, 0x68,
+	0x46, 0x61, 0x63, 0x65, 0x74, 0x73, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x2c,
+	0x0a, 0x06, 0x66, 0x61, 0x63, 0x65, 0x74, 0x73, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x14,
+	0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x4c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x46, 0x61,
+	0x63, 0x65, 0x74, 0x73, 0x52, 0x06, 0x66, 0x61, 0x63, 0x65, 0x74, 0x73, 0x22, 0x3d, 0x0a, 0x13,
+	0x57, 0x61, 0x74, 0x63, 0x68, 0x4c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x73, 0x52, 0x65, 0x71, 0x75,
+	0x65, 0x73, 0x74, 0x12, 0x26, 0x0a, 0x06, 0x66, 0x69, 0x6c, 0x74, 0x65, 0x72, 0x18, 0x01, 0x20,
+	0x01, 0x28, 0x0b, 0x32, 0x0e, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x46, 0x69, 0x6c,
+	0x74, 0x65, 0x72, 0x52, 0x06, 0x66, 0x69, 0x6c, 0x74, 0x65, 0x72, 0x22, 0xc3, 0x01, 0x0a, 0x14,
+	0x57, 0x61, 0x74, 0x63, 0x68, 0x4c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x73, 0x52, 0x65, 0x73, 0x70,
+	0x6f, 0x6e, 0x73, 0x65, 0x12, 0x38, 0x0a, 0x05, 0x65, 0x76, 0x65, 0x6e, 0x74, 0x18, 0x01, 0x20,
+	0x01, 0x28, 0x0e, 0x32, 0x22, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x57, 0x61, 0x74,
+	0x63, 0x68, 0x4c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x73, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73,
+	0x65, 0x2e, 0x45, 0x76, 0x65, 0x6e, 0x74, 0x52, 0x05, 0x65, 0x76, 0x65, 0x6e, 0x74, 0x12, 0x26,
+	0x0a, 0x06, 0x6c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0e,
+	0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x4c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x52, 0x06,
+	0x6c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x22, 0x49, 0x0a, 0x05, 0x45, 0x76, 0x65, 0x6e, 0x74, 0x12,
+	0x0b, 0x0a, 0x07, 0x55, 0x4e, 0x4b, 0x4e, 0x4f, 0x57, 0x4e, 0x10, 0x00, 0x12, 0x0c, 0x0a, 0x08,
+	0x45, 0x58, 0x49, 0x53, 0x54, 0x49, 0x4e, 0x47, 0x10, 0x01, 0x12, 0x0b, 0x0a, 0x07, 0x43, 0x52,
+	0x45, 0x41, 0x54, 0x45, 0x44, 0x10, 0x02, 0x12, 0x0b, 0x0a, 0x07, 0x55, 0x50, 0x44, 0x41, 0x54,
+	0x45, 0x44, 0x10, 0x03, 0x12, 0x0b, 0x0a, 0x07, 0x44, 0x45, 0x4c, 0x45, 0x54, 0x45, 0x44, 0x10,
+	0x04, 0x22, 0x29, 0x0a, 0x15, 0x43, 0x6f, 0x6d, 0x70, 0x61, 0x72, 0x65, 0x4c, 0x61, 0x70, 0x74,
+	0x6f, 0x70, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x10, 0x0a, 0x03, 0x69, 0x64,
+	0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x09, 0x52, 0x03, 0x69, 0x64, 0x73, 0x22, 0x96, 0x01, 0x0a,
+	0x0e, 0x53, 0x70, 0x65, 0x63, 0x43, 0x6f, 0x6d, 0x70, 0x61, 0x72, 0x69, 0x73, 0x6f, 0x6e, 0x12,
+	0x14, 0x0a, 0x05, 0x66, 0x69, 0x65, 0x6c, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05,
+	0x66, 0x69, 0x65, 0x6c, 0x64, 0x12, 0x12, 0x0a, 0x04, 0x75, 0x6e, 0x69, 0x74, 0x18, 0x02, 0x20,
+	0x01, 0x28, 0x09, 0x52, 0x04, 0x75, 0x6e, 0x69, 0x74, 0x12, 0x16, 0x0a, 0x06, 0x76, 0x61, 0x6c,
+	0x75, 0x65, 0x73, 0x18, 0x03, 0x20, 0x03, 0x28, 0x01, 0x52, 0x06, 0x76, 0x61, 0x6c, 0x75, 0x65,
+	0x73, 0x12, 0x18, 0x0a, 0x07, 0x77, 0x69, 0x6e, 0x6e, 0x65, 0x72, 0x73, 0x18, 0x04, 0x20, 0x03,
+	0x28, 0x0d, 0x52, 0x07, 0x77, 0x69, 0x6e, 0x6e, 0x65, 0x72, 0x73, 0x12, 0x28, 0x0a, 0x10, 0x68,
+	0x69, 0x67, 0x68, 0x65, 0x72, 0x5f, 0x69, 0x73, 0x5f, 0x62, 0x65, 0x74, 0x74, 0x65, 0x72, 0x18,
+	0x05, 0x20, 0x01, 0x28, 0x08, 0x52, 0x0e, 0x68, 0x69, 0x67, 0x68, 0x65, 0x72, 0x49, 0x73, 0x42,
+	0x65, 0x74, 0x74, 0x65, 0x72, 0x22, 0x70, 0x0a, 0x16, 0x43, 0x6f, 0x6d, 0x70, 0x61, 0x72, 0x65,
+	0x4c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x73, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12,
+	0x28, 0x0a, 0x07, 0x6c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0b,
+	0x32, 0x0e, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x4c, 0x61, 0x70, 0x74, 0x6f, 0x70,
+	0x52, 0x07, 0x6c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x73, 0x12, 0x2c, 0x0a, 0x05, 0x73, 0x70, 0x65,
+	0x63, 0x73, 0x18, 0x02, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x16, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f,
+	0x6b, 0x2e, 0x53, 0x70, 0x65, 0x63, 0x43, 0x6f, 0x6d, 0x70, 0x61, 0x72, 0x69, 0x73, 0x6f, 0x6e,
+	0x52, 0x05, 0x73, 0x70, 0x65, 0x63, 0x73, 0x22, 0xe7, 0x01, 0x0a, 0x15, 0x53, 0x69, 0x6d, 0x69,
+	0x6c, 0x61, 0x72, 0x4c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73,
+	0x74, 0x12, 0x0e, 0x0a, 0x02, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x02, 0x69,
+	0x64, 0x12, 0x14, 0x0a, 0x05, 0x6c, 0x69, 0x6d, 0x69, 0x74, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0d,
+	0x52, 0x05, 0x6c, 0x69, 0x6d, 0x69, 0x74, 0x12, 0x26, 0x0a, 0x06, 0x66, 0x69, 0x6c, 0x74, 0x65,
+	0x72, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0e, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b,
+	0x2e, 0x46, 0x69, 0x6c, 0x74, 0x65, 0x72, 0x52, 0x06, 0x66, 0x69, 0x6c, 0x74, 0x65, 0x72, 0x12,
+	0x44, 0x0a, 0x07, 0x77, 0x65, 0x69, 0x67, 0x68, 0x74, 0x73, 0x18, 0x04, 0x20, 0x03, 0x28, 0x0b,
+	0x32, 0x2a, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x53, 0x69, 0x6d, 0x69, 0x6c, 0x61,
+	0x72, 0x4c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x2e,
+	0x57, 0x65, 0x69, 0x67, 0x68, 0x74, 0x73, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x52, 0x07, 0x77, 0x65,
+	0x69, 0x67, 0x68, 0x74, 0x73, 0x1a, 0x3a, 0x0a, 0x0c, 0x57, 0x65, 0x69, 0x67, 0x68, 0x74, 0x73,
+	0x45, 0x6e, 0x74, 0x72, 0x79, 0x12, 0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18, 0x01, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x03, 0x6b, 0x65, 0x79, 0x12, 0x14, 0x0a, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65,
+	0x18, 0x02, 0x20, 0x01, 0x28, 0x01, 0x52, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x3a, 0x02, 0x38,
+	0x01, 0x22, 0x53, 0x0a, 0x0d, 0x53, 0x69, 0x6d, 0x69, 0x6c, 0x61, 0x72, 0x4c, 0x61, 0x70, 0x74,
+	0x6f, 0x70, 0x12, 0x26, 0x0a, 0x06, 0x6c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x18, 0x01, 0x20, 0x01,
+	0x28, 0x0b, 0x32, 0x0e, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x4c, 0x61, 0x70, 0x74,
+	0x6f, 0x70, 0x52, 0x06, 0x6c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x12, 0x1a, 0x0a, 0x08, 0x64, 0x69,
+	0x73, 0x74, 0x61, 0x6e, 0x63, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x01, 0x52, 0x08, 0x64, 0x69,
+	0x73, 0x74, 0x61, 0x6e, 0x63, 0x65, 0x22, 0x49, 0x0a, 0x16, 0x53, 0x69, 0x6d, 0x69, 0x6c, 0x61,
+	0x72, 0x4c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x73, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65,
+	0x12, 0x2f, 0x0a, 0x07, 0x6c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28,
+	0x0b, 0x32, 0x15, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x53, 0x69, 0x6d, 0x69, 0x6c,
+	0x61, 0x72, 0x4c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x52, 0x07, 0x6c, 0x61, 0x70, 0x74, 0x6f, 0x70,
+	0x73, 0x22, 0x4f, 0x0a, 0x09, 0x49, 0x6d, 0x61, 0x67, 0x65, 0x49, 0x6e, 0x66, 0x6f, 0x12, 0x1b,
+	0x0a, 0x09, 0x6c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x5f, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x08, 0x6c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x49, 0x64, 0x12, 0x25, 0x0a, 0x0e, 0x66,
+	0x69, 0x6c, 0x65, 0x5f, 0x65, 0x78, 0x74, 0x65, 0x6e, 0x73, 0x69, 0x6f, 0x6e, 0x18, 0x02, 0x20,
+	0x01, 0x28, 0x09, 0x52, 0x0d, 0x66, 0x69, 0x6c, 0x65, 0x45, 0x78, 0x74, 0x65, 0x6e, 0x73, 0x69,
+	0x6f, 0x6e, 0x22, 0x66, 0x0a, 0x12, 0x55, 0x70, 0x6c, 0x6f, 0x61, 0x64, 0x49, 0x6d, 0x61, 0x67,
+	0x65, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x27, 0x0a, 0x04, 0x69, 0x6e, 0x66, 0x6f,
+	0x18, 0x01, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x11, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e,
+	0x49, 0x6d, 0x61, 0x67, 0x65, 0x49, 0x6e, 0x66, 0x6f, 0x48, 0x00, 0x52, 0x04, 0x69, 0x6e, 0x66,
+	0x6f, 0x12, 0x1f, 0x0a, 0x0a, 0x63, 0x68, 0x75, 0x6e, 0x6b, 0x5f, 0x64, 0x61, 0x74, 0x61, 0x18,
+	0x02, 0x20, 0x01, 0x28, 0x0c, 0x48, 0x00, 0x52, 0x09, 0x63, 0x68, 0x75, 0x6e, 0x6b, 0x44, 0x61,
+	0x74, 0x61, 0x42, 0x06, 0x0a, 0x04, 0x64, 0x61, 0x74, 0x61, 0x22, 0x5d, 0x0a, 0x13, 0x55, 0x70,
+	0x6c, 0x6f, 0x61, 0x64, 0x49, 0x6d, 0x61, 0x67, 0x65, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73,
+	0x65, 0x12, 0x0e, 0x0a, 0x02, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x02, 0x69,
+	0x64, 0x12, 0x12, 0x0a, 0x04, 0x73, 0x69, 0x7a, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0d, 0x52,
+	0x04, 0x73, 0x69, 0x7a, 0x65, 0x12, 0x22, 0x0a, 0x0c, 0x64, 0x65, 0x64, 0x75, 0x70, 0x6c, 0x69,
+	0x63, 0x61, 0x74, 0x65, 0x64, 0x18, 0x03, 0x20, 0x01, 0x28, 0x08, 0x52, 0x0c, 0x64, 0x65, 0x64,
+	0x75, 0x70, 0x6c, 0x69, 0x63, 0x61, 0x74, 0x65, 0x64, 0x22, 0x3f, 0x0a, 0x16, 0x49, 0x6e, 0x69,
+	0x74, 0x49, 0x6d, 0x61, 0x67, 0x65, 0x55, 0x70, 0x6c, 0x6f, 0x61, 0x64, 0x52, 0x65, 0x71, 0x75,
+	0x65, 0x73, 0x74, 0x12, 0x25, 0x0a, 0x04, 0x69, 0x6e, 0x66, 0x6f, 0x18, 0x01, 0x20, 0x01, 0x28,
+	0x0b, 0x32, 0x11, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x49, 0x6d, 0x61, 0x67, 0x65,
+	0x49, 0x6e, 0x66, 0x6f, 0x52, 0x04, 0x69, 0x6e, 0x66, 0x6f, 0x22, 0x71, 0x0a, 0x17, 0x49, 0x6e,
+	0x69, 0x74, 0x49, 0x6d, 0x61, 0x67, 0x65, 0x55, 0x70, 0x6c, 0x6f, 0x61, 0x64, 0x52, 0x65, 0x73,
+	0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x1b, 0x0a, 0x09, 0x75, 0x70, 0x6c, 0x6f, 0x61, 0x64, 0x5f,
+	0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x75, 0x70, 0x6c, 0x6f, 0x61, 0x64,
+	0x49, 0x64, 0x12, 0x39, 0x0a, 0x0a, 0x65, 0x78, 0x70, 0x69, 0x72, 0x65, 0x73, 0x5f, 0x61, 0x74,
+	0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e,
+	0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61,
+	0x6d, 0x70, 0x52, 0x09, 0x65, 0x78, 0x70, 0x69, 0x72, 0x65, 0x73, 0x41, 0x74, 0x22, 0x6d, 0x0a,
+	0x17, 0x55, 0x70, 0x6c, 0x6f, 0x61, 0x64, 0x49, 0x6d, 0x61, 0x67, 0x65, 0x43, 0x68, 0x75, 0x6e,
+	0x6b, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x1b, 0x0a, 0x09, 0x75, 0x70, 0x6c, 0x6f,
+	0x61, 0x64, 0x5f, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x75, 0x70, 0x6c,
+	0x6f, 0x61, 0x64, 0x49, 0x64, 0x12, 0x16, 0x0a, 0x06, 0x6f, 0x66, 0x66, 0x73, 0x65, 0x74, 0x18,
+	0x02, 0x20, 0x01, 0x28, 0x04, 0x52, 0x06, 0x6f, 0x66, 0x66, 0x73, 0x65, 0x74, 0x12, 0x1d, 0x0a,
+	0x0a, 0x63, 0x68, 0x75, 0x6e, 0x6b, 0x5f, 0x64, 0x61, 0x74, 0x61, 0x18, 0x03, 0x20, 0x01, 0x28,
+	0x0c, 0x52, 0x09, 0x63, 0x68, 0x75, 0x6e, 0x6b, 0x44, 0x61, 0x74, 0x61, 0x22, 0x3f, 0x0a, 0x18,
+	0x55, 0x70, 0x6c, 0x6f, 0x61, 0x64, 0x49, 0x6d, 0x61, 0x67, 0x65, 0x43, 0x68, 0x75, 0x6e, 0x6b,
+	0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x23, 0x0a, 0x0d, 0x72, 0x65, 0x63, 0x65,
+	0x69, 0x76, 0x65, 0x64, 0x5f, 0x73, 0x69, 0x7a, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x04, 0x52,
+	0x0c, 0x72, 0x65, 0x63, 0x65, 0x69, 0x76, 0x65, 0x64, 0x53, 0x69, 0x7a, 0x65, 0x22, 0x3a, 0x0a,
+	0x1b, 0x47, 0x65, 0x74, 0x49, 0x6d, 0x61, 0x67, 0x65, 0x55, 0x70, 0x6c, 0x6f, 0x61, 0x64, 0x53,
+	0x74, 0x61, 0x74, 0x75, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x1b, 0x0a, 0x09,
+	0x75, 0x70, 0x6c, 0x6f, 0x61, 0x64, 0x5f, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52,
+	0x08, 0x75, 0x70, 0x6c, 0x6f, 0x61, 0x64, 0x49, 0x64, 0x22, 0x9b, 0x01, 0x0a, 0x1c, 0x47, 0x65,
+	0x74, 0x49, 0x6d, 0x61, 0x67, 0x65, 0x55, 0x70, 0x6c, 0x6f, 0x61, 0x64, 0x53, 0x74, 0x61, 0x74,
+	0x75, 0x73, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x1b, 0x0a, 0x09, 0x75, 0x70,
+	0x6c, 0x6f, 0x61, 0x64, 0x5f, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x75,
+	0x70, 0x6c, 0x6f, 0x61, 0x64, 0x49, 0x64, 0x12, 0x23, 0x0a, 0x0d, 0x72, 0x65, 0x63, 0x65, 0x69,
+	0x76, 0x65, 0x64, 0x5f, 0x73, 0x69, 0x7a, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x04, 0x52, 0x0c,
+	0x72, 0x65, 0x63, 0x65, 0x69, 0x76, 0x65, 0x64, 0x53, 0x69, 0x7a, 0x65, 0x12, 0x39, 0x0a, 0x0a,
+	0x65, 0x78, 0x70, 0x69, 0x72, 0x65, 0x73, 0x5f, 0x61, 0x74, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0b,
+	0x32, 0x1a, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62,
+	0x75, 0x66, 0x2e, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x52, 0x09, 0x65, 0x78,
+	0x70, 0x69, 0x72, 0x65, 0x73, 0x41, 0x74, 0x22, 0x51, 0x0a, 0x1a, 0x46, 0x69, 0x6e, 0x61, 0x6c,
+	0x69, 0x7a, 0x65, 0x49, 0x6d, 0x61, 0x67, 0x65, 0x55, 0x70, 0x6c, 0x6f, 0x61, 0x64, 0x52, 0x65,
+	0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x1b, 0x0a, 0x09, 0x75, 0x70, 0x6c, 0x6f, 0x61, 0x64, 0x5f,
+	0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x75, 0x70, 0x6c, 0x6f, 0x61, 0x64,
+	0x49, 0x64, 0x12, 0x16, 0x0a, 0x06, 0x73, 0x68, 0x61, 0x32, 0x35, 0x36, 0x18, 0x02, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x06, 0x73, 0x68, 0x61, 0x32, 0x35, 0x36, 0x22, 0x65, 0x0a, 0x1b, 0x46, 0x69,
+	0x6e, 0x61, 0x6c, 0x69, 0x7a, 0x65, 0x49, 0x6d, 0x61, 0x67, 0x65, 0x55, 0x70, 0x6c, 0x6f, 0x61,
+	0x64, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x0e, 0x0a, 0x02, 0x69, 0x64, 0x18,
+	0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x02, 0x69, 0x64, 0x12, 0x12, 0x0a, 0x04, 0x73, 0x69, 0x7a,
+	0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x04, 0x52, 0x04, 0x73, 0x69, 0x7a, 0x65, 0x12, 0x22, 0x0a,
+	0x0c, 0x64, 0x65, 0x64, 0x75, 0x70, 0x6c, 0x69, 0x63, 0x61, 0x74, 0x65, 0x64, 0x18, 0x03, 0x20,
+	0x01, 0x28, 0x08, 0x52, 0x0c, 0x64, 0x65, 0x64, 0x75, 0x70, 0x6c, 0x69, 0x63, 0x61, 0x74, 0x65,
+	0x64, 0x22, 0xcc, 0x03, 0x0a, 0x0c, 0x49, 0x6d, 0x61, 0x67, 0x65, 0x44, 0x65, 0x74, 0x61, 0x69,
+	0x6c, 0x73, 0x12, 0x0e, 0x0a, 0x02, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x02,
+	0x69, 0x64, 0x12, 0x1b, 0x0a, 0x09, 0x6c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x5f, 0x69, 0x64, 0x18,
+	0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x6c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x49, 0x64, 0x12,
+	0x21, 0x0a, 0x0c, 0x63, 0x6f, 0x6e, 0x74, 0x65, 0x6e, 0x74, 0x5f, 0x74, 0x79, 0x70, 0x65, 0x18,
+	0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0b, 0x63, 0x6f, 0x6e, 0x74, 0x65, 0x6e, 0x74, 0x54, 0x79,
+	0x70, 0x65, 0x12, 0x12, 0x0a, 0x04, 0x73, 0x69, 0x7a, 0x65, 0x18, 0x04, 0x20, 0x01, 0x28, 0x04,
+	0x52, 0x04, 0x73, 0x69, 0x7a, 0x65, 0x12, 0x14, 0x0a, 0x05, 0x77, 0x69, 0x64, 0x74, 0x68, 0x18,
+	0x05, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x05, 0x77, 0x69, 0x64, 0x74, 0x68, 0x12, 0x16, 0x0a, 0x06,
+	0x68, 0x65, 0x69, 0x67, 0x68, 0x74, 0x18, 0x06, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x06, 0x68, 0x65,
+	0x69, 0x67, 0x68, 0x74, 0x12, 0x1c, 0x0a, 0x09, 0x72, 0x65, 0x6e, 0x64, 0x69, 0x74, 0x69, 0x6f,
+	0x6e, 0x18, 0x07, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x72, 0x65, 0x6e, 0x64, 0x69, 0x74, 0x69,
+	0x6f, 0x6e, 0x12, 0x1b, 0x0a, 0x09, 0x73, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x5f, 0x69, 0x64, 0x18,
+	0x08, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x73, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x49, 0x64, 0x12,
+	0x34, 0x0a, 0x0a, 0x72, 0x65, 0x6e, 0x64, 0x69, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x18, 0x09, 0x20,
+	0x03, 0x28, 0x0b, 0x32, 0x14, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x49, 0x6d, 0x61,
+	0x67, 0x65, 0x44, 0x65, 0x74, 0x61, 0x69, 0x6c, 0x73, 0x52, 0x0a, 0x72, 0x65, 0x6e, 0x64, 0x69,
+	0x74, 0x69, 0x6f, 0x6e, 0x73, 0x12, 0x4f, 0x0a, 0x10, 0x72, 0x65, 0x6e, 0x64, 0x69, 0x74, 0x69,
+	0x6f, 0x6e, 0x5f, 0x73, 0x74, 0x61, 0x74, 0x75, 0x73, 0x18, 0x0a, 0x20, 0x01, 0x28, 0x0e, 0x32,
+	0x24, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x49, 0x6d, 0x61, 0x67, 0x65, 0x44, 0x65,
+	0x74, 0x61, 0x69, 0x6c, 0x73, 0x2e, 0x52, 0x65, 0x6e, 0x64, 0x69, 0x74, 0x69, 0x6f, 0x6e, 0x53,
+	0x74, 0x61, 0x74, 0x75, 0x73, 0x52, 0x0f, 0x72, 0x65, 0x6e, 0x64, 0x69, 0x74, 0x69, 0x6f, 0x6e,
+	0x53, 0x74, 0x61, 0x74, 0x75, 0x73, 0x12, 0x27, 0x0a, 0x0f, 0x72, 0x65, 0x6e, 0x64, 0x69, 0x74,
+	0x69, 0x6f, 0x6e, 0x5f, 0x65, 0x72, 0x72, 0x6f, 0x72, 0x18, 0x0b, 0x20, 0x01, 0x28, 0x09, 0x52,
+	0x0e, 0x72, 0x65, 0x6e, 0x64, 0x69, 0x74, 0x69, 0x6f, 0x6e, 0x45, 0x72, 0x72, 0x6f, 0x72, 0x22,
+	0x3f, 0x0a, 0x0f, 0x52, 0x65, 0x6e, 0x64, 0x69, 0x74, 0x69, 0x6f, 0x6e, 0x53, 0x74, 0x61, 0x74,
+	0x75, 0x73, 0x12, 0x08, 0x0a, 0x04, 0x4e, 0x4f, 0x4e, 0x45, 0x10, 0x00, 0x12, 0x0b, 0x0a, 0x07,
+	0x50, 0x45, 0x4e, 0x44, 0x49, 0x4e, 0x47, 0x10, 0x01, 0x12, 0x09, 0x0a, 0x05, 0x52, 0x45, 0x41,
+	0x44, 0x59, 0x10, 0x02, 0x12, 0x0a, 0x0a, 0x06, 0x46, 0x41, 0x49, 0x4c, 0x45, 0x44, 0x10, 0x03,
+	0x22, 0x26, 0x0a, 0x14, 0x44, 0x6f, 0x77, 0x6e, 0x6c, 0x6f, 0x61, 0x64, 0x49, 0x6d, 0x61, 0x67,
+	0x65, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x0e, 0x0a, 0x02, 0x69, 0x64, 0x18, 0x01,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x02, 0x69, 0x64, 0x22, 0x6c, 0x0a, 0x15, 0x44, 0x6f, 0x77, 0x6e,
+	0x6c, 0x6f, 0x61, 0x64, 0x49, 0x6d, 0x61, 0x67, 0x65, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73,
+	0x65, 0x12, 0x2a, 0x0a, 0x04, 0x69, 0x6e, 0x66, 0x6f, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0b, 0x32,
+	0x14, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x49, 0x6d, 0x61, 0x67, 0x65, 0x44, 0x65,
+	0x74, 0x61, 0x69, 0x6c, 0x73, 0x48, 0x00, 0x52, 0x04, 0x69, 0x6e, 0x66, 0x6f, 0x12, 0x1f, 0x0a,
+	0x0a, 0x63, 0x68, 0x75, 0x6e, 0x6b, 0x5f, 0x64, 0x61, 0x74, 0x61, 0x18, 0x02, 0x20, 0x01, 0x28,
+	0x0c, 0x48, 0x00, 0x52, 0x09, 0x63, 0x68, 0x75, 0x6e, 0x6b, 0x44, 0x61, 0x74, 0x61, 0x42, 0x06,
+	0x0a, 0x04, 0x64, 0x61, 0x74, 0x61, 0x22, 0x30, 0x0a, 0x11, 0x4c, 0x69, 0x73, 0x74, 0x49, 0x6d,
+	0x61, 0x67, 0x65, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x1b, 0x0a, 0x09, 0x6c,
+	0x61, 0x70, 0x74, 0x6f, 0x70, 0x5f, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08,
+	0x6c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x49, 0x64, 0x22, 0x42, 0x0a, 0x12, 0x4c, 0x69, 0x73, 0x74,
+	0x49, 0x6d, 0x61, 0x67, 0x65, 0x73, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x2c,
+	0x0a, 0x06, 0x69, 0x6d, 0x61, 0x67, 0x65, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x14,
+	0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x49, 0x6d, 0x61, 0x67, 0x65, 0x44, 0x65, 0x74,
+	0x61, 0x69, 0x6c, 0x73, 0x52, 0x06, 0x69, 0x6d, 0x61, 0x67, 0x65, 0x73, 0x22, 0x24, 0x0a, 0x12,
+	0x44, 0x65, 0x6c, 0x65, 0x74, 0x65, 0x49, 0x6d, 0x61, 0x67, 0x65, 0x52, 0x65, 0x71, 0x75, 0x65,
+	0x73, 0x74, 0x12, 0x0e, 0x0a, 0x02, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x02,
+	0x69, 0x64, 0x22, 0x15, 0x0a, 0x13, 0x44, 0x65, 0x6c, 0x65, 0x74, 0x65, 0x49, 0x6d, 0x61, 0x67,
+	0x65, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x22, 0x46, 0x0a, 0x11, 0x52, 0x61, 0x74,
+	0x65, 0x4c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x1b,
+	0x0a, 0x09, 0x6c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x5f, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x08, 0x6c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x49, 0x64, 0x12, 0x14, 0x0a, 0x05, 0x73,
+	0x63, 0x6f, 0x72, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x01, 0x52, 0x05, 0x73, 0x63, 0x6f, 0x72,
+	0x65, 0x22, 0x7b, 0x0a, 0x12, 0x52, 0x61, 0x74, 0x65, 0x4c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x52,
+	0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x1b, 0x0a, 0x09, 0x6c, 0x61, 0x70, 0x74, 0x6f,
+	0x70, 0x5f, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x6c, 0x61, 0x70, 0x74,
+	0x6f, 0x70, 0x49, 0x64, 0x12, 0x23, 0x0a, 0x0d, 0x72, 0x61, 0x74, 0x69, 0x6e, 0x67, 0x73, 0x5f,
+	0x63, 0x6f, 0x75, 0x6e, 0x74, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x0c, 0x72, 0x61, 0x74,
+	0x69, 0x6e, 0x67, 0x73, 0x43, 0x6f, 0x75, 0x6e, 0x74, 0x12, 0x23, 0x0a, 0x0d, 0x61, 0x76, 0x65,
+	0x72, 0x61, 0x67, 0x65, 0x5f, 0x73, 0x63, 0x6f, 0x72, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x01,
+	0x52, 0x0c, 0x61, 0x76, 0x65, 0x72, 0x61, 0x67, 0x65, 0x53, 0x63, 0x6f, 0x72, 0x65, 0x32, 0x9f,
+	0x10, 0x0a, 0x0d, 0x4c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x53, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65,
+	0x12, 0x60, 0x0a, 0x0c, 0x43, 0x72, 0x65, 0x61, 0x74, 0x65, 0x4c, 0x61, 0x70, 0x74, 0x6f, 0x70,
+	0x12, 0x1b, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x43, 0x72, 0x65, 0x61, 0x74, 0x65,
+	0x4c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x1c, 0x2e,
+	0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x43, 0x72, 0x65, 0x61, 0x74, 0x65, 0x4c, 0x61, 0x70,
+	0x74, 0x6f, 0x70, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x22, 0x15, 0x82, 0xd3, 0xe4,
+	0x93, 0x02, 0x0f, 0x22, 0x0a, 0x2f, 0x76, 0x31, 0x2f, 0x6c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x3a,
+	0x01, 0x2a, 0x12, 0x59, 0x0a, 0x09, 0x47, 0x65, 0x74, 0x4c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x12,
+	0x18, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x47, 0x65, 0x74, 0x4c, 0x61, 0x70, 0x74,
+	0x6f, 0x70, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x19, 0x2e, 0x70, 0x63, 0x62, 0x6f,
+	0x6f, 0x6b, 0x2e, 0x47, 0x65, 0x74, 0x4c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x52, 0x65, 0x73, 0x70,
+	0x6f, 0x6e, 0x73, 0x65, 0x22, 0x17, 0x82, 0xd3, 0xe4, 0x93, 0x02, 0x11, 0x12, 0x0f, 0x2f, 0x76,
+	0x31, 0x2f, 0x6c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x2f, 0x7b, 0x69, 0x64, 0x7d, 0x12, 0x71, 0x0a,
+	0x0c, 0x55, 0x70, 0x64, 0x61, 0x74, 0x65, 0x4c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x12, 0x1b, 0x2e,
+	0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x55, 0x70, 0x64, 0x61, 0x74, 0x65, 0x4c, 0x61, 0x70,
+	0x74, 0x6f, 0x70, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x1c, 0x2e, 0x70, 0x63, 0x62,
+	0x6f, 0x6f, 0x6b, 0x2e, 0x55, 0x70, 0x64, 0x61, 0x74, 0x65, 0x4c, 0x61, 0x70, 0x74, 0x6f, 0x70,
+	0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x22, 0x26, 0x82, 0xd3, 0xe4, 0x93, 0x02, 0x20,
+	0x32, 0x16, 0x2f, 0x76, 0x31, 0x2f, 0x6c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x2f, 0x7b, 0x6c, 0x61,
+	0x70, 0x74, 0x6f, 0x70, 0x2e, 0x69, 0x64, 0x7d, 0x3a, 0x06, 0x6c, 0x61, 0x70, 0x74, 0x6f, 0x70,
+	0x12, 0x62, 0x0a, 0x0c, 0x44, 0x65, 0x6c, 0x65, 0x74, 0x65, 0x4c, 0x61, 0x70, 0x74, 0x6f, 0x70,
+	0x12, 0x1b, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x44, 0x65, 0x6c, 0x65, 0x74, 0x65,
+	0x4c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x1c, 0x2e,
+	0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x44, 0x65, 0x6c, 0x65, 0x74, 0x65, 0x4c, 0x61, 0x70,
+	0x74, 0x6f, 0x70, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x22, 0x17, 0x82, 0xd3, 0xe4,
+	0x93, 0x02, 0x11, 0x2a, 0x0f, 0x2f, 0x76, 0x31, 0x2f, 0x6c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x2f,
+	0x7b, 0x69, 0x64, 0x7d, 0x12, 0x5a, 0x0a, 0x0b, 0x4c, 0x69, 0x73, 0x74, 0x4c, 0x61, 0x70, 0x74,
+	0x6f, 0x70, 0x73, 0x12, 0x1a, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x4c, 0x69, 0x73,
+	0x74, 0x4c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a,
+	0x1b, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x4c, 0x69, 0x73, 0x74, 0x4c, 0x61, 0x70,
+	0x74, 0x6f, 0x70, 0x73, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x22, 0x12, 0x82, 0xd3,
+	0xe4, 0x93, 0x02, 0x0c, 0x12, 0x0a, 0x2f, 0x76, 0x31, 0x2f, 0x6c, 0x61, 0x70, 0x74, 0x6f, 0x70,
+	0x12, 0x66, 0x0a, 0x0c, 0x53, 0x65, 0x61, 0x72, 0x63, 0x68, 0x4c, 0x61, 0x70, 0x74, 0x6f, 0x70,
+	0x12, 0x1b, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x53, 0x65, 0x61, 0x72, 0x63, 0x68,
+	0x4c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x1c, 0x2e,
+	0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x53, 0x65, 0x61, 0x72, 0x63, 0x68, 0x4c, 0x61, 0x70,
+	0x74, 0x6f, 0x70, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x22, 0x19, 0x82, 0xd3, 0xe4,
+	0x93, 0x02, 0x13, 0x12, 0x11, 0x2f, 0x76, 0x31, 0x2f, 0x6c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x2f,
+	0x73, 0x65, 0x61, 0x72, 0x63, 0x68, 0x30, 0x01, 0x12, 0x64, 0x0a, 0x0c, 0x53, 0x65, 0x61, 0x72,
+	0x63, 0x68, 0x46, 0x61, 0x63, 0x65, 0x74, 0x73, 0x12, 0x1b, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f,
+	0x6b, 0x2e, 0x53, 0x65, 0x61, 0x72, 0x63, 0x68, 0x46, 0x61, 0x63, 0x65, 0x74, 0x73, 0x52, 0x65,
+	0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x1c, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x53,
+	0x65, 0x61, 0x72, 0x63, 0x68, 0x46, 0x61, 0x63, 0x65, 0x74, 0x73, 0x52, 0x65, 0x73, 0x70, 0x6f,
+	0x6e, 0x73, 0x65, 0x22, 0x19, 0x82, 0xd3, 0xe4, 0x93, 0x02, 0x13, 0x12, 0x11, 0x2f, 0x76, 0x31,
+	0x2f, 0x6c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x2f, 0x66, 0x61, 0x63, 0x65, 0x74, 0x73, 0x12, 0x65,
+	0x0a, 0x0c, 0x57, 0x61, 0x74, 0x63, 0x68, 0x4c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x73, 0x12, 0x1b,
+	0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x57, 0x61, 0x74, 0x63, 0x68, 0x4c, 0x61, 0x70,
+	0x74, 0x6f, 0x70, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x1c, 0x2e, 0x70, 0x63,
+	0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x57, 0x61, 0x74, 0x63, 0x68, 0x4c, 0x61, 0x70, 0x74, 0x6f, 0x70,
+	0x73, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x22, 0x18, 0x82, 0xd3, 0xe4, 0x93, 0x02,
+	0x12, 0x12, 0x10, 0x2f, 0x76, 0x31, 0x2f, 0x6c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x2f, 0x77, 0x61,
+	0x74, 0x63, 0x68, 0x30, 0x01, 0x12, 0x6b, 0x0a, 0x0e, 0x43, 0x6f, 0x6d, 0x70, 0x61, 0x72, 0x65,
+	0x4c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x73, 0x12, 0x1d, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b,
+	0x2e, 0x43, 0x6f, 0x6d, 0x70, 0x61, 0x72, 0x65, 0x4c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x73, 0x52,
+	0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x1e, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e,
+	0x43, 0x6f, 0x6d, 0x70, 0x61, 0x72, 0x65, 0x4c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x73, 0x52, 0x65,
+	0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x22, 0x1a, 0x82, 0xd3, 0xe4, 0x93, 0x02, 0x14, 0x12, 0x12,
+	0x2f, 0x76, 0x31, 0x2f, 0x6c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x2f, 0x63, 0x6f, 0x6d, 0x70, 0x61,
+	0x72, 0x65, 0x12, 0x70, 0x0a, 0x0e, 0x53, 0x69, 0x6d, 0x69, 0x6c, 0x61, 0x72, 0x4c, 0x61, 0x70,
+	0x74, 0x6f, 0x70, 0x73, 0x12, 0x1d, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x53, 0x69,
+	0x6d, 0x69, 0x6c, 0x61, 0x72, 0x4c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x73, 0x52, 0x65, 0x71, 0x75,
+	0x65, 0x73, 0x74, 0x1a, 0x1e, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x53, 0x69, 0x6d,
+	0x69, 0x6c, 0x61, 0x72, 0x4c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x73, 0x52, 0x65, 0x73, 0x70, 0x6f,
+	0x6e, 0x73, 0x65, 0x22, 0x1f, 0x82, 0xd3, 0xe4, 0x93, 0x02, 0x19, 0x12, 0x17, 0x2f, 0x76, 0x31,
+	0x2f, 0x6c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x2f, 0x7b, 0x69, 0x64, 0x7d, 0x2f, 0x73, 0x69, 0x6d,
+	0x69, 0x6c, 0x61, 0x72, 0x12, 0x6c, 0x0a, 0x0b, 0x55, 0x70, 0x6c, 0x6f, 0x61, 0x64, 0x49, 0x6d,
+	0x61, 0x67, 0x65, 0x12, 0x1a, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x55, 0x70, 0x6c,
+	0x6f, 0x61, 0x64, 0x49, 0x6d, 0x61, 0x67, 0x65, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a,
+	0x1b, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x55, 0x70, 0x6c, 0x6f, 0x61, 0x64, 0x49,
+	0x6d, 0x61, 0x67, 0x65, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x22, 0x22, 0x82, 0xd3,
+	0xe4, 0x93, 0x02, 0x1c, 0x22, 0x17, 0x2f, 0x76, 0x31, 0x2f, 0x6c, 0x61, 0x70, 0x74, 0x6f, 0x70,
+	0x2f, 0x75, 0x70, 0x6c, 0x6f, 0x61, 0x64, 0x2d, 0x69, 0x6d, 0x61, 0x67, 0x65, 0x3a, 0x01, 0x2a,
+	0x28, 0x01, 0x12, 0x71, 0x0a, 0x0f, 0x49, 0x6e, 0x69, 0x74, 0x49, 0x6d, 0x61, 0x67, 0x65, 0x55,
+	0x70, 0x6c, 0x6f, 0x61, 0x64, 0x12, 0x1e, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x49,
+	0x6e, 0x69, 0x74, 0x49, 0x6d, 0x61, 0x67, 0x65, 0x55, 0x70, 0x6c, 0x6f, 0x61, 0x64, 0x52, 0x65,
+	0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x1f, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x49,
+	0x6e, 0x69, 0x74, 0x49, 0x6d, 0x61, 0x67, 0x65, 0x55, 0x70, 0x6c, 0x6f, 0x61, 0x64, 0x52, 0x65,
+	0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x22, 0x1d, 0x82, 0xd3, 0xe4, 0x93, 0x02, 0x17, 0x22, 0x12,
+	0x2f, 0x76, 0x31, 0x2f, 0x6c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x2f, 0x75, 0x70, 0x6c, 0x6f, 0x61,
+	0x64, 0x73, 0x3a, 0x01, 0x2a, 0x12, 0x7d, 0x0a, 0x10, 0x55, 0x70, 0x6c, 0x6f, 0x61, 0x64, 0x49,
+	0x6d, 0x61, 0x67, 0x65, 0x43, 0x68, 0x75, 0x6e, 0x6b, 0x12, 0x1f, 0x2e, 0x70, 0x63, 0x62, 0x6f,
+	0x6f, 0x6b, 0x2e, 0x55, 0x70, 0x6c, 0x6f, 0x61, 0x64, 0x49, 0x6d, 0x61, 0x67, 0x65, 0x43, 0x68,
+	0x75, 0x6e, 0x6b, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x20, 0x2e, 0x70, 0x63, 0x62,
+	0x6f, 0x6f, 0x6b, 0x2e, 0x55, 0x70, 0x6c, 0x6f, 0x61, 0x64, 0x49, 0x6d, 0x61, 0x67, 0x65, 0x43,
+	0x68, 0x75, 0x6e, 0x6b, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x22, 0x24, 0x82, 0xd3,
+	0xe4, 0x93, 0x02, 0x1e, 0x22, 0x19, 0x2f, 0x76, 0x31, 0x2f, 0x6c, 0x61, 0x70, 0x74, 0x6f, 0x70,
+	0x2f, 0x75, 0x70, 0x6c, 0x6f, 0x61, 0x64, 0x73, 0x2f, 0x63, 0x68, 0x75, 0x6e, 0x6b, 0x73, 0x3a,
+	0x01, 0x2a, 0x28, 0x01, 0x12, 0x89, 0x01, 0x0a, 0x14, 0x47, 0x65, 0x74, 0x49, 0x6d, 0x61, 0x67,
+	0x65, 0x55, 0x70, 0x6c, 0x6f, 0x61, 0x64, 0x53, 0x74, 0x61, 0x74, 0x75, 0x73, 0x12, 0x23, 0x2e,
+	0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x47, 0x65, 0x74, 0x49, 0x6d, 0x61, 0x67, 0x65, 0x55,
+	0x70, 0x6c, 0x6f, 0x61, 0x64, 0x53, 0x74, 0x61, 0x74, 0x75, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65,
+	0x73, 0x74, 0x1a, 0x24, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x47, 0x65, 0x74, 0x49,
+	0x6d, 0x61, 0x67, 0x65, 0x55, 0x70, 0x6c, 0x6f, 0x61, 0x64, 0x53, 0x74, 0x61, 0x74, 0x75, 0x73,
+	0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x22, 0x26, 0x82, 0xd3, 0xe4, 0x93, 0x02, 0x20,
+	0x12, 0x1e, 0x2f, 0x76, 0x31, 0x2f, 0x6c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x2f, 0x75, 0x70, 0x6c,
+	0x6f, 0x61, 0x64, 0x73, 0x2f, 0x7b, 0x75, 0x70, 0x6c, 0x6f, 0x61, 0x64, 0x5f, 0x69, 0x64, 0x7d,
+	0x12, 0x92, 0x01, 0x0a, 0x13, 0x46, 0x69, 0x6e, 0x61, 0x6c, 0x69, 0x7a, 0x65, 0x49, 0x6d, 0x61,
+	0x67, 0x65, 0x55, 0x70, 0x6c, 0x6f, 0x61, 0x64, 0x12, 0x22, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f,
+	0x6b, 0x2e, 0x46, 0x69, 0x6e, 0x61, 0x6c, 0x69, 0x7a, 0x65, 0x49, 0x6d, 0x61, 0x67, 0x65, 0x55,
+	0x70, 0x6c, 0x6f, 0x61, 0x64, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x23, 0x2e, 0x70,
+	0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x46, 0x69, 0x6e, 0x61, 0x6c, 0x69, 0x7a, 0x65, 0x49, 0x6d,
+	0x61, 0x67, 0x65, 0x55, 0x70, 0x6c, 0x6f, 0x61, 0x64, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73,
+	0x65, 0x22, 0x32, 0x82, 0xd3, 0xe4, 0x93, 0x02, 0x2c, 0x22, 0x27, 0x2f, 0x76, 0x31, 0x2f, 0x6c,
+	0x61, 0x70, 0x74, 0x6f, 0x70, 0x2f, 0x75, 0x70, 0x6c, 0x6f, 0x61, 0x64, 0x73, 0x2f, 0x7b, 0x75,
+	0x70, 0x6c, 0x6f, 0x61, 0x64, 0x5f, 0x69, 0x64, 0x7d, 0x2f, 0x66, 0x69, 0x6e, 0x61, 0x6c, 0x69,
+	0x7a, 0x65, 0x3a, 0x01, 0x2a, 0x12, 0x50, 0x0a, 0x0d, 0x44, 0x6f, 0x77, 0x6e, 0x6c, 0x6f, 0x61,
+	0x64, 0x49, 0x6d, 0x61, 0x67, 0x65, 0x12, 0x1c, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e,
+	0x44, 0x6f, 0x77, 0x6e, 0x6c, 0x6f, 0x61, 0x64, 0x49, 0x6d, 0x61, 0x67, 0x65, 0x52, 0x65, 0x71,
+	0x75, 0x65, 0x73, 0x74, 0x1a, 0x1d, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x44, 0x6f,
+	0x77, 0x6e, 0x6c, 0x6f, 0x61, 0x64, 0x49, 0x6d, 0x61, 0x67, 0x65, 0x52, 0x65, 0x73, 0x70, 0x6f,
+	0x6e, 0x73, 0x65, 0x22, 0x00, 0x30, 0x01, 0x12, 0x6a, 0x0a, 0x0a, 0x4c, 0x69, 0x73, 0x74, 0x49,
+	0x6d, 0x61, 0x67, 0x65, 0x73, 0x12, 0x19, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x4c,
+	0x69, 0x73, 0x74, 0x49, 0x6d, 0x61, 0x67, 0x65, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74,
+	0x1a, 0x1a, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x4c, 0x69, 0x73, 0x74, 0x49, 0x6d,
+	0x61, 0x67, 0x65, 0x73, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x22, 0x25, 0x82, 0xd3,
+	0xe4, 0x93, 0x02, 0x1f, 0x12, 0x1d, 0x2f, 0x76, 0x31, 0x2f, 0x6c, 0x61, 0x70, 0x74, 0x6f, 0x70,
+	0x2f, 0x7b, 0x6c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x5f, 0x69, 0x64, 0x7d, 0x2f, 0x69, 0x6d, 0x61,
+	0x67, 0x65, 0x73, 0x12, 0x66, 0x0a, 0x0b, 0x44, 0x65, 0x6c, 0x65, 0x74, 0x65, 0x49, 0x6d, 0x61,
+	0x67, 0x65, 0x12, 0x1a, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x44, 0x65, 0x6c, 0x65,
+	0x74, 0x65, 0x49, 0x6d, 0x61, 0x67, 0x65, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x1b,
+	0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x44, 0x65, 0x6c, 0x65, 0x74, 0x65, 0x49, 0x6d,
+	0x61, 0x67, 0x65, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x22, 0x1e, 0x82, 0xd3, 0xe4,
+	0x93, 0x02, 0x18, 0x2a, 0x16, 0x2f, 0x76, 0x31, 0x2f, 0x6c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x2f,
+	0x69, 0x6d, 0x61, 0x67, 0x65, 0x73, 0x2f, 0x7b, 0x69, 0x64, 0x7d, 0x12, 0x63, 0x0a, 0x0a, 0x52,
+	0x61, 0x74, 0x65, 0x4c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x12, 0x19, 0x2e, 0x70, 0x63, 0x62, 0x6f,
+	0x6f, 0x6b, 0x2e, 0x52, 0x61, 0x74, 0x65, 0x4c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x52, 0x65, 0x71,
+	0x75, 0x65, 0x73, 0x74, 0x1a, 0x1a, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x52, 0x61,
+	0x74, 0x65, 0x4c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65,
+	0x22, 0x1a, 0x82, 0xd3, 0xe4, 0x93, 0x02, 0x14, 0x22, 0x0f, 0x2f, 0x76, 0x31, 0x2f, 0x6c, 0x61,
+	0x70, 0x74, 0x6f, 0x70, 0x2f, 0x72, 0x61, 0x74, 0x65, 0x3a, 0x01, 0x2a, 0x28, 0x01, 0x30, 0x01,
+	0x42, 0x27, 0x0a, 0x1d, 0x63, 0x6f, 0x6d, 0x2e, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x6a,
+	0x77, 0x61, 0x6d, 0x62, 0x75, 0x67, 0x75, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x70,
+	0x62, 0x50, 0x01, 0x5a, 0x04, 0x2e, 0x2f, 0x70, 0x62, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f,
+	0x33,
+}
+
+var (
+	file_laptop_service_proto_rawDescOnce sync.Once
+	file_laptop_service_proto_rawDescData = file_laptop_service_proto_rawDesc
+)
+
+func file_laptop_service_proto_rawDescGZIP() []byte {
+	file_laptop_service_proto_rawDescOnce.Do(func() {
+		file_laptop_service_proto_rawDescData = protoimpl.X.CompressGZIP(file_laptop_service_proto_rawDescData)
+	})
+	return file_laptop_service_proto_rawDescData
+}
+
+var file_laptop_service_proto_enumTypes = make([]protoimpl.EnumInfo, 2)
+var file_laptop_service_proto_msgTypes = make([]protoimpl.MessageInfo, 48)
+var file_laptop_service_proto_goTypes = []interface{}{
+	(WatchLaptopsResponse_Event)(0),      // 0: pcbook.WatchLaptopsResponse.Event
+	(ImageDetails_RenditionStatus)(0),    // 1: pcbook.ImageDetails.RenditionStatus
+	(*Laptop)(nil),                       // 2: pcbook.Laptop
+	(*Filter)(nil),                       // 3: pcbook.Filter
+	(*CreateLaptopRequest)(nil),          // 4: pcbook.CreateLaptopRequest
+	(*CreateLaptopResponse)(nil),         // 5: pcbook.CreateLaptopResponse
+	(*GetLaptopRequest)(nil),             // 6: pcbook.GetLaptopRequest
+	(*GetLaptopResponse)(nil),            // 7: pcbook.GetLaptopResponse
+	(*UpdateLaptopRequest)(nil),          // 8: pcbook.UpdateLaptopRequest
+	(*UpdateLaptopResponse)(nil),         // 9: pcbook.UpdateLaptopResponse
+	(*DeleteLaptopRequest)(nil),          // 10: pcbook.DeleteLaptopRequest
+	(*DeleteLaptopResponse)(nil),         // 11: pcbook.DeleteLaptopResponse
+	(*ListLaptopsRequest)(nil),           // 12: pcbook.ListLaptopsRequest
+	(*ListLaptopsResponse)(nil),          // 13: pcbook.ListLaptopsResponse
+	(*SearchLaptopRequest)(nil),          // 14: pcbook.SearchLaptopRequest
+	(*SearchLaptopResponse)(nil),         // 15: pcbook.SearchLaptopResponse
+	(*FacetCount)(nil),                   // 16: pcbook.FacetCount
+	(*BucketCount)(nil),                  // 17: pcbook.BucketCount
+	(*LaptopFacets)(nil),                 // 18: pcbook.LaptopFacets
+	(*SearchFacetsRequest)(nil),          // 19: pcbook.SearchFacetsRequest
+	(*SearchFacetsResponse)(nil),         // 20: pcbook.SearchFacetsResponse
+	(*WatchLaptopsRequest)(nil),          // 21: pcbook.WatchLaptopsRequest
+	(*WatchLaptopsResponse)(nil),         // 22: pcbook.WatchLaptopsResponse
+	(*CompareLaptopsRequest)(nil),        // 23: pcbook.CompareLaptopsRequest
+	(*SpecComparison)(nil),               // 24: pcbook.SpecComparison
+	(*CompareLaptopsResponse)(nil),       // 25: pcbook.CompareLaptopsResponse
+	(*SimilarLaptopsRequest)(nil),        // 26: pcbook.SimilarLaptopsRequest
+	(*SimilarLaptop)(nil),                // 27: pcbook.SimilarLaptop
+	(*SimilarLaptopsResponse)(nil),       // 28: pcbook.SimilarLaptopsResponse
+	(*ImageInfo)(nil),                    // 29: pcbook.ImageInfo
+	(*UploadImageRequest)(nil),           // 30: pcbook.UploadImageRequest
+	(*UploadImageResponse)(nil),          // 31: pcbook.UploadImageResponse
+	(*InitImageUploadRequest)(nil),       // 32: pcbook.InitImageUploadRequest
+	(*InitImageUploadResponse)(nil),      // 33: pcbook.InitImageUploadResponse
+	(*UploadImageChunkRequest)(nil),      // 34: pcbook.UploadImageChunkRequest
+	(*UploadImageChunkResponse)(nil),     // 35: pcbook.UploadImageChunkResponse
+	(*GetImageUploadStatusRequest)(nil),  // 36: pcbook.GetImageUploadStatusRequest
+	(*GetImageUploadStatusResponse)(nil), // 37: pcbook.GetImageUploadStatusResponse
+	(*FinalizeImageUploadRequest)(nil),   // 38: pcbook.FinalizeImageUploadRequest
+	(*FinalizeImageUploadResponse)(nil),  // 39: pcbook.FinalizeImageUploadResponse
+	(*ImageDetails)(nil),                 // 40: pcbook.ImageDetails
+	(*DownloadImageRequest)(nil),         // 41: pcbook.DownloadImageRequest
+	(*DownloadImageResponse)(nil),        // 42: pcbook.DownloadImageResponse
+	(*ListImagesRequest)(nil),            // 43: pcbook.ListImagesRequest
+	(*ListImagesResponse)(nil),           // 44: pcbook.ListImagesResponse
+	(*DeleteImageRequest)(nil),           // 45: pcbook.DeleteImageRequest
+	(*DeleteImageResponse)(nil),          // 46: pcbook.DeleteImageResponse
+	(*RateLaptopRequest)(nil),            // 47: pcbook.RateLaptopRequest
+	(*RateLaptopResponse)(nil),           // 48: pcbook.RateLaptopResponse
+	nil,                                  // 49: pcbook.SimilarLaptopsRequest.WeightsEntry
+	(*CPU)(nil),                          // 50: pcbook.CPU
+	(*Memory)(nil),                       // 51: pcbook.Memory
+	(*GPU)(nil),                          // 52: pcbook.GPU
+	(*Storage)(nil),                      // 53: pcbook.Storage
+	(*Screen)(nil),                       // 54: pcbook.Screen
+	(*Keyboard)(nil),                     // 55: pcbook.Keyboard
+	(*timestamppb.Timestamp)(nil),        // 56: google.protobuf.Timestamp
+	(Storage_Driver)(0),                  // 57: pcbook.Storage.Driver
+	(*Screen_Resolution)(nil),            // 58: pcbook.Screen.Resolution
+	(Screen_Panel)(0),                    // 59: pcbook.Screen.Panel
+	(Keyboard_Layout)(0),                 // 60: pcbook.Keyboard.Layout
+	(*fieldmaskpb.FieldMask)(nil),        // 61: google.protobuf.FieldMask
+}
+var file_laptop_service_proto_depIdxs = []int32{
+	50, // 0: pcbook.Laptop.cpu:type_name -> pcbook.CPU
+	51, // 1: pcbook.Laptop.ram:type_name -> pcbook.Memory
+	52, // 2: pcbook.Laptop.gpus:type_name -> pcbook.GPU
+	53, // 3: pcbook.Laptop.storages:type_name -> pcbook.Storage
+	54, // 4: pcbook.Laptop.screen:type_name -> pcbook.Screen
+	55, // 5: pcbook.Laptop.keyboard:type_name -> pcbook.Keyboard
+	56, // 6: pcbook.Laptop.updated_at:type_name -> google.protobuf.Timestamp
+	51, // 7: pcbook.Filter.min_ram:type_name -> pcbook.Memory
+	51, // 8: pcbook.Filter.min_gpu_memory:type_name -> pcbook.Memory
+	51, // 9: pcbook.Filter.min_storage:type_name -> pcbook.Memory
+	57, // 10: pcbook.Filter.storage_driver:type_name -> pcbook.Storage.Driver
+	58, // 11: pcbook.Filter.min_screen_resolution:type_name -> pcbook.Screen.Resolution
+	59, // 12: pcbook.Filter.screen_panel:type_name -> pcbook.Screen.Panel
+	60, // 13: pcbook.Filter.keyboard_layout:type_name -> pcbook.Keyboard.Layout
+	2,  // 14: pcbook.CreateLaptopRequest.laptop:type_name -> pcbook.Laptop
+	2,  // 15: pcbook.GetLaptopResponse.laptop:type_name -> pcbook.Laptop
+	2,  // 16: pcbook.UpdateLaptopRequest.laptop:type_name -> pcbook.Laptop
+	61, // 17: pcbook.UpdateLaptopRequest.update_mask:type_name -> google.protobuf.FieldMask
+	2,  // 18: pcbook.UpdateLaptopResponse.laptop:type_name -> pcbook.Laptop
+	2,  // 19: pcbook.ListLaptopsResponse.laptops:type_name -> pcbook.Laptop
+	3,  // 20: pcbook.SearchLaptopRequest.filter:type_name -> pcbook.Filter
+	2,  // 21: pcbook.SearchLaptopResponse.laptop:type_name -> pcbook.Laptop
+	16, // 22: pcbook.LaptopFacets.brands:type_name -> pcbook.FacetCount
+	16, // 23: pcbook.LaptopFacets.cpu_brands:type_name -> pcbook.FacetCount
+	16, // 24: pcbook.LaptopFacets.gpu_brands:type_name -> pcbook.FacetCount
+	16, // 25: pcbook.LaptopFacets.screen_panels:type_name -> pcbook.FacetCount
+	16, // 26: pcbook.LaptopFacets.keyboard_layouts:type_name -> pcbook.FacetCount
+	17, // 27: pcbook.LaptopFacets.ram_gb:type_name -> pcbook.BucketCount
+	17, // 28: pcbook.LaptopFacets.price_usd:type_name -> pcbook.BucketCount
+	16, // 29: pcbook.LaptopFacets.release_years:type_name -> pcbook.FacetCount
+	3,  // 30: pcbook.SearchFacetsRequest.filter:type_name -> pcbook.Filter
+	18, // 31: pcbook.SearchFacetsResponse.facets:type_name -> pcbook.LaptopFacets
+	3,  // 32: pcbook.WatchLaptopsRequest.filter:type_name -> pcbook.Filter
+	0,  // 33: pcbook.WatchLaptopsResponse.event:type_name -> pcbook.WatchLaptopsResponse.Event
+	2,  // 34: pcbook.WatchLaptopsResponse.laptop:type_name -> pcbook.Laptop
+	2,  // 35: pcbook.CompareLaptopsResponse.laptops:type_name -> pcbook.Laptop
+	24, // 36: pcbook.CompareLaptopsResponse.specs:type_name -> pcbook.SpecComparison
+	3,  // 37: pcbook.SimilarLaptopsRequest.filter:type_name -> pcbook.Filter
+	49, // 38: pcbook.SimilarLaptopsRequest.weights:type_name -> pcbook.SimilarLaptopsRequest.WeightsEntry
+	2,  // 39: pcbook.SimilarLaptop.laptop:type_name -> pcbook.Laptop
+	27, // 40: pcbook.SimilarLaptopsResponse.laptops:type_name -> pcbook.SimilarLaptop
+	29, // 41: pcbook.UploadImageRequest.info:type_name -> pcbook.ImageInfo
+	29, // 42: pcbook.InitImageUploadRequest.info:type_name -> pcbook.ImageInfo
+	56, // 43: pcbook.InitImageUploadResponse.expires_at:type_name -> google.protobuf.Timestamp
+	56, // 44: pcbook.GetImageUploadStatusResponse.expires_at:type_name -> google.protobuf.Timestamp
+	40, // 45: pcbook.ImageDetails.renditions:type_name -> pcbook.ImageDetails
+	1,  // 46: pcbook.ImageDetails.rendition_status:type_name -> pcbook.ImageDetails.RenditionStatus
+	40, // 47: pcbook.DownloadImageResponse.info:type_name -> pcbook.ImageDetails
+	40, // 48: pcbook.ListImagesResponse.images:type_name -> pcbook.ImageDetails
+	4,  // 49: pcbook.LaptopService.CreateLaptop:input_type -> pcbook.CreateLaptopRequest
+	6,  // 50: pcbook.LaptopService.GetLaptop:input_type -> pcbook.GetLaptopRequest
+	8,  // 51: pcbook.LaptopService.UpdateLaptop:input_type -> pcbook.UpdateLaptopRequest
+	10, // 52: pcbook.LaptopService.DeleteLaptop:input_type -> pcbook.DeleteLaptopRequest
+	12, // 53: pcbook.LaptopService.ListLaptops:input_type -> pcbook.ListLaptopsRequest
+	14, // 54: pcbook.LaptopService.SearchLaptop:input_type -> pcbook.SearchLaptopRequest
+	19, // 55: pcbook.LaptopService.SearchFacets:input_type -> pcbook.SearchFacetsRequest
+	21, // 56: pcbook.LaptopService.WatchLaptops:input_type -> pcbook.WatchLaptopsRequest
+	23, // 57: pcbook.LaptopService.CompareLaptops:input_type -> pcbook.CompareLaptopsRequest
+	26, // 58: pcbook.LaptopService.SimilarLaptops:input_type -> pcbook.SimilarLaptopsRequest
+	30, // 59: pcbook.LaptopService.UploadImage:input_type -> pcbook.UploadImageRequest
+	32, // 60: pcbook.LaptopService.InitImageUpload:input_type -> pcbook.InitImageUploadRequest
+	34, // 61: pcbook.LaptopService.UploadImageChunk:input_type -> pcbook.UploadImageChunkRequest
+	36, // 62: pcbook.LaptopService.GetImageUploadStatus:input_type -> pcbook.GetImageUploadStatusRequest
+	38, // 63: pcbook.LaptopService.FinalizeImageUpload:input_type -> pcbook.FinalizeImageUploadRequest
+	41, // 64: pcbook.LaptopService.DownloadImage:input_type -> pcbook.DownloadImageRequest
+	43, // 65: pcbook.LaptopService.ListImages:input_type -> pcbook.ListImagesRequest
+	45, // 66: pcbook.LaptopService.DeleteImage:input_type -> pcbook.DeleteImageRequest
+	47, // 67: pcbook.LaptopService.RateLaptop:input_type -> pcbook.RateLaptopRequest
+	5,  // 68: pcbook.LaptopService.CreateLaptop:output_type -> pcbook.CreateLaptopResponse
+	7,  // 69: pcbook.LaptopService.GetLaptop:output_type -> pcbook.GetLaptopResponse
+	9,  // 70: pcbook.LaptopService.UpdateLaptop:output_type -> pcbook.UpdateLaptopResponse
+	11, // 71: pcbook.LaptopService.DeleteLaptop:output_type -> pcbook.DeleteLaptopResponse
+	13, // 72: pcbook.LaptopService.ListLaptops:output_type -> pcbook.ListLaptopsResponse
+	15, // 73: pcbook.LaptopService.SearchLaptop:output_type -> pcbook.SearchLaptopResponse
+	20, // 74: pcbook.LaptopService.SearchFacets:output_type -> pcbook.SearchFacetsResponse
+	22, // 75: pcbook.LaptopService.WatchLaptops:output_type -> pcbook.WatchLaptopsResponse
+	25, // 76: pcbook.LaptopService.CompareLaptops:output_type -> pcbook.CompareLaptopsResponse
+	28, // 77: pcbook.LaptopService.SimilarLaptops:output_type -> pcbook.SimilarLaptopsResponse
+	31, // 78: pcbook.LaptopService.UploadImage:output_type -> pcbook.UploadImageResponse
+	33, // 79: pcbook.LaptopService.InitImageUpload:output_type -> pcbook.InitImageUploadResponse
+	35, // 80: pcbook.LaptopService.UploadImageChunk:output_type -> pcbook.UploadImageChunkResponse
+	37, // 81: pcbook.LaptopService.GetImageUploadStatus:output_type -> pcbook.GetImageUploadStatusResponse
+	39, // 82: pcbook.LaptopService.FinalizeImageUpload:output_type -> pcbook.FinalizeImageUploadResponse
+	42, // 83: pcbook.LaptopService.DownloadImage:output_type -> pcbook.DownloadImageResponse
+	44, // 84: pcbook.LaptopService.ListImages:output_type -> pcbook.ListImagesResponse
+	46, // 85: pcbook.LaptopService.DeleteImage:output_type -> pcbook.DeleteImageResponse
+	48, // 86: pcbook.LaptopService.RateLaptop:output_type -> pcbook.RateLaptopResponse
+	68, // [68:87] is the sub-list for method output_type
+	49, // [49:68] is the sub-list for method input_type
+	49, // [49:49] is the sub-list for extension type_name
+	49, // [49:49] is the sub-list for extension extendee
+	0,  // [0:49] is the sub-list for field type_name
+}
+
+func init() { file_laptop_service_proto_init() }
+func file_laptop_service_proto_init() {
+	if File_laptop_service_proto != nil {
+		return
+	}
+	file_processor_proto_init()
+	file_memory_proto_init()
+	file_storage_proto_init()
+	file_screen_proto_init()
+	file_keyboard_proto_init()
+	if !protoimpl.UnsafeEnabled {
+		file_laptop_service_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Laptop); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_laptop_service_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Filter); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_laptop_service_proto_msgTypes[2].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*CreateLaptopRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_laptop_service_proto_msgTypes[3].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*CreateLaptopResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_laptop_service_proto_msgTypes[4].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*GetLaptopRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_laptop_service_proto_msgTypes[5].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*GetLaptopResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_laptop_service_proto_msgTypes[6].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*UpdateLaptopRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_laptop_service_proto_msgTypes[7].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*UpdateLaptopResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_laptop_service_proto_msgTypes[8].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*DeleteLaptopRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_laptop_service_proto_msgTypes[9].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*DeleteLaptopResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_laptop_service_proto_msgTypes[10].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ListLaptopsRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_laptop_service_proto_msgTypes[11].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ListLaptopsResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_laptop_service_proto_msgTypes[12].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*SearchLaptopRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_laptop_service_proto_msgTypes[13].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*SearchLaptopResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_laptop_service_proto_msgTypes[14].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*FacetCount); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_laptop_service_proto_msgTypes[15].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*BucketCount); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_laptop_service_proto_msgTypes[16].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*LaptopFacets); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_laptop_service_proto_msgTypes[17].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*SearchFacetsRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_laptop_service_proto_msgTypes[18].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*SearchFacetsResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_laptop_service_proto_msgTypes[19].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*WatchLaptopsRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_laptop_service_proto_msgTypes[20].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*WatchLaptopsResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_laptop_service_proto_msgTypes[21].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*CompareLaptopsRequest); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -989,8 +4231,8 @@ func file_laptop_service_proto_init() {
 				return nil
 			}
 		}
-		file_laptop_service_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*Filter); i {
+		file_laptop_service_proto_msgTypes[22].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*SpecComparison); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -1001,8 +4243,8 @@ func file_laptop_service_proto_init() {
 				return nil
 			}
 		}
-		file_laptop_service_proto_msgTypes[2].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*CreateLaptopRequest); i {
+		file_laptop_service_proto_msgTypes[23].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*CompareLaptopsResponse); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -1013,8 +4255,8 @@ func file_laptop_service_proto_init() {
 				return nil
 			}
 		}
-		file_laptop_service_proto_msgTypes[3].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*CreateLaptopResponse); i {
+		file_laptop_service_proto_msgTypes[24].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*SimilarLaptopsRequest); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -1025,8 +4267,8 @@ func file_laptop_service_proto_init() {
 				return nil
 			}
 		}
-		file_laptop_service_proto_msgTypes[4].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*SearchLaptopRequest); i {
+		file_laptop_service_proto_msgTypes[25].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*SimilarLaptop); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -1037,8 +4279,8 @@ func file_laptop_service_proto_init() {
 				return nil
 			}
 		}
-		file_laptop_service_proto_msgTypes[5].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*SearchLaptopResponse); i {
+		file_laptop_service_proto_msgTypes[26].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*SimilarLaptopsResponse); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -1049,7 +4291,7 @@ func file_laptop_service_proto_init() {
 				return nil
 			}
 		}
-		file_laptop_service_proto_msgTypes[6].Exporter = func(v interface{}, i int) interface{} {
+		file_laptop_service_proto_msgTypes[27].Exporter = func(v interface{}, i int) interface{} {
 			switch v := v.(*ImageInfo); i {
 			case 0:
 				return &v.state
@@ -1061,7 +4303,7 @@ func file_laptop_service_proto_init() {
 				return nil
 			}
 		}
-		file_laptop_service_proto_msgTypes[7].Exporter = func(v interface{}, i int) interface{} {
+		file_laptop_service_proto_msgTypes[28].Exporter = func(v interface{}, i int) interface{} {
 			switch v := v.(*UploadImageRequest); i {
 			case 0:
 				return &v.state
@@ -1073,7 +4315,7 @@ func file_laptop_service_proto_init() {
 				return nil
 			}
 		}
-		file_laptop_service_proto_msgTypes[8].Exporter = func(v interface{}, i int) interface{} {
+		file_laptop_service_proto_msgTypes[29].Exporter = func(v interface{}, i int) interface{} {
 			switch v := v.(*UploadImageResponse); i {
 			case 0:
 				return &v.state
@@ -1085,7 +4327,187 @@ func file_laptop_service_proto_init() {
 				return nil
 			}
 		}
-		file_laptop_service_proto_msgTypes[9].Exporter = func(v interface{}, i int) interface{} {
+		file_laptop_service_proto_msgTypes[30].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*InitImageUploadRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_laptop_service_proto_msgTypes[31].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*InitImageUploadResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_laptop_service_proto_msgTypes[32].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*UploadImageChunkRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_laptop_service_proto_msgTypes[33].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*UploadImageChunkResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_laptop_service_proto_msgTypes[34].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*GetImageUploadStatusRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_laptop_service_proto_msgTypes[35].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*GetImageUploadStatusResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_laptop_service_proto_msgTypes[36].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*FinalizeImageUploadRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_laptop_service_proto_msgTypes[37].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*FinalizeImageUploadResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_laptop_service_proto_msgTypes[38].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ImageDetails); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_laptop_service_proto_msgTypes[39].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*DownloadImageRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_laptop_service_proto_msgTypes[40].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*DownloadImageResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_laptop_service_proto_msgTypes[41].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ListImagesRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_laptop_service_proto_msgTypes[42].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ListImagesResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_laptop_service_proto_msgTypes[43].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*DeleteImageRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_laptop_service_proto_msgTypes[44].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*DeleteImageResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_laptop_service_proto_msgTypes[45].Exporter = func(v interface{}, i int) interface{} {
 			switch v := v.(*RateLaptopRequest); i {
 			case 0:
 				return &v.state
@@ -1097,7 +4519,7 @@ func file_laptop_service_proto_init() {
 				return nil
 			}
 		}
-		file_laptop_service_proto_msgTypes[10].Exporter = func(v interface{}, i int) interface{} {
+		file_laptop_service_proto_msgTypes[46].Exporter = func(v interface{}, i int) interface{} {
 			switch v := v.(*RateLaptopResponse); i {
 			case 0:
 				return &v.state
@@ -1114,22 +4536,28 @@ func file_laptop_service_proto_init() {
 		(*Laptop_WeightKg)(nil),
 		(*Laptop_WeightLb)(nil),
 	}
-	file_laptop_service_proto_msgTypes[7].OneofWrappers = []interface{}{
+	file_laptop_service_proto_msgTypes[1].OneofWrappers = []interface{}{}
+	file_laptop_service_proto_msgTypes[28].OneofWrappers = []interface{}{
 		(*UploadImageRequest_Info)(nil),
 		(*UploadImageRequest_ChunkData)(nil),
 	}
+	file_laptop_service_proto_msgTypes[40].OneofWrappers = []interface{}{
+		(*DownloadImageResponse_Info)(nil),
+		(*DownloadImageResponse_ChunkData)(nil),
+	}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: file_laptop_service_proto_rawDesc,
-			NumEnums:      0,
-			NumMessages:   11,
+			NumEnums:      2,
+			NumMessages:   48,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
 		GoTypes:           file_laptop_service_proto_goTypes,
 		DependencyIndexes: file_laptop_service_proto_depIdxs,
+		EnumInfos:         file_laptop_service_proto_enumTypes,
 		MessageInfos:      file_laptop_service_proto_msgTypes,
 	}.Build()
 	File_laptop_service_proto = out.File
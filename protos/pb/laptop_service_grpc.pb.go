@@ -19,8 +19,24 @@ const _ = grpc.SupportPackageIsVersion7
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type LaptopServiceClient interface {
 	CreateLaptop(ctx context.Context, in *CreateLaptopRequest, opts ...grpc.CallOption) (*CreateLaptopResponse, error)
+	GetLaptop(ctx context.Context, in *GetLaptopRequest, opts ...grpc.CallOption) (*GetLaptopResponse, error)
+	UpdateLaptop(ctx context.Context, in *UpdateLaptopRequest, opts ...grpc.CallOption) (*UpdateLaptopResponse, error)
+	DeleteLaptop(ctx context.Context, in *DeleteLaptopRequest, opts ...grpc.CallOption) (*DeleteLaptopResponse, error)
+	ListLaptops(ctx context.Context, in *ListLaptopsRequest, opts ...grpc.CallOption) (*ListLaptopsResponse, error)
 	SearchLaptop(ctx context.Context, in *SearchLaptopRequest, opts ...grpc.CallOption) (LaptopService_SearchLaptopClient, error)
+	SearchFacets(ctx context.Context, in *SearchFacetsRequest, opts ...grpc.CallOption) (*SearchFacetsResponse, error)
+	WatchLaptops(ctx context.Context, in *WatchLaptopsRequest, opts ...grpc.CallOption) (LaptopService_WatchLaptopsClient, error)
+	CompareLaptops(ctx context.Context, in *CompareLaptopsRequest, opts ...grpc.CallOption) (*CompareLaptopsResponse, error)
+	SimilarLaptops(ctx context.Context, in *SimilarLaptopsRequest, opts ...grpc.CallOption) (*SimilarLaptopsResponse, error)
 	UploadImage(ctx context.Context, opts ...grpc.CallOption) (LaptopService_UploadImageClient, error)
+	InitImageUpload(ctx context.Context, in *InitImageUploadRequest, opts ...grpc.CallOption) (*InitImageUploadResponse, error)
+	UploadImageChunk(ctx context.Context, opts ...grpc.CallOption) (LaptopService_UploadImageChunkClient, error)
+	GetImageUploadStatus(ctx context.Context, in *GetImageUploadStatusRequest, opts ...grpc.CallOption) (*GetImageUploadStatusResponse, error)
+	FinalizeImageUpload(ctx context.Context, in *FinalizeImageUploadRequest, opts ...grpc.CallOption) (*FinalizeImageUploadResponse, error)
+	// DownloadImage has no REST mapping, the REST server serves the bytes of an image at GET /v1/laptop/images/{id}.
+	DownloadImage(ctx context.Context, in *DownloadImageRequest, opts ...grpc.CallOption) (LaptopService_DownloadImageClient, error)
+	ListImages(ctx context.Context, in *ListImagesRequest, opts ...grpc.CallOption) (*ListImagesResponse, error)
+	DeleteImage(ctx context.Context, in *DeleteImageRequest, opts ...grpc.CallOption) (*DeleteImageResponse, error)
 	RateLaptop(ctx context.Context, opts ...grpc.CallOption) (LaptopService_RateLaptopClient, error)
 }
 
@@ -41,6 +57,42 @@ func (c *laptopServiceClient) CreateLaptop(ctx context.Context, in *CreateLaptop
 	return out, nil
 }
 
+func (c *laptopServiceClient) GetLaptop(ctx context.Context, in *GetLaptopRequest, opts ...grpc.CallOption) (*GetLaptopResponse, error) {
+	out := new(GetLaptopResponse)
+	err := c.cc.Invoke(ctx, "/pcbook.LaptopService/GetLaptop", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *laptopServiceClient) UpdateLaptop(ctx context.Context, in *UpdateLaptopRequest, opts ...grpc.CallOption) (*UpdateLaptopResponse, error) {
+	out := new(UpdateLaptopResponse)
+	err := c.cc.Invoke(ctx, "/pcbook.LaptopService/UpdateLaptop", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *laptopServiceClient) DeleteLaptop(ctx context.Context, in *DeleteLaptopRequest, opts ...grpc.CallOption) (*DeleteLaptopResponse, error) {
+	out := new(DeleteLaptopResponse)
+	err := c.cc.Invoke(ctx, "/pcbook.LaptopService/DeleteLaptop", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *laptopServiceClient) ListLaptops(ctx context.Context, in *ListLaptopsRequest, opts ...grpc.CallOption) (*ListLaptopsResponse, error) {
+	out := new(ListLaptopsResponse)
+	err := c.cc.Invoke(ctx, "/pcbook.LaptopService/ListLaptops", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *laptopServiceClient) SearchLaptop(ctx context.Context, in *SearchLaptopRequest, opts ...grpc.CallOption) (LaptopService_SearchLaptopClient, error) {
 	stream, err := c.cc.NewStream(ctx, &LaptopService_ServiceDesc.Streams[0], "/pcbook.LaptopService/SearchLaptop", opts...)
 	if err != nil {
@@ -73,8 +125,67 @@ func (x *laptopServiceSearchLaptopClient) Recv() (*SearchLaptopResponse, error)
 	return m, nil
 }
 
+func (c *laptopServiceClient) SearchFacets(ctx context.Context, in *SearchFacetsRequest, opts ...grpc.CallOption) (*SearchFacetsResponse, error) {
+	out := new(SearchFacetsResponse)
+	err := c.cc.Invoke(ctx, "/pcbook.LaptopService/SearchFacets", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *laptopServiceClient) WatchLaptops(ctx context.Context, in *WatchLaptopsRequest, opts ...grpc.CallOption) (LaptopService_WatchLaptopsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &LaptopService_ServiceDesc.Streams[1], "/pcbook.LaptopService/WatchLaptops", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &laptopServiceWatchLaptopsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type LaptopService_WatchLaptopsClient interface {
+	Recv() (*WatchLaptopsResponse, error)
+	grpc.ClientStream
+}
+
+type laptopServiceWatchLaptopsClient struct {
+	grpc.ClientStream
+}
+
+func (x *laptopServiceWatchLaptopsClient) Recv() (*WatchLaptopsResponse, error) {
+	m := new(WatchLaptopsResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *laptopServiceClient) CompareLaptops(ctx context.Context, in *CompareLaptopsRequest, opts ...grpc.CallOption) (*CompareLaptopsResponse, error) {
+	out := new(CompareLaptopsResponse)
+	err := c.cc.Invoke(ctx, "/pcbook.LaptopService/CompareLaptops", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *laptopServiceClient) SimilarLaptops(ctx context.Context, in *SimilarLaptopsRequest, opts ...grpc.CallOption) (*SimilarLaptopsResponse, error) {
+	out := new(SimilarLaptopsResponse)
+	err := c.cc.Invoke(ctx, "/pcbook.LaptopService/SimilarLaptops", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *laptopServiceClient) UploadImage(ctx context.Context, opts ...grpc.CallOption) (LaptopService_UploadImageClient, error) {
-	stream, err := c.cc.NewStream(ctx, &LaptopService_ServiceDesc.Streams[1], "/pcbook.LaptopService/UploadImage", opts...)
+	stream, err := c.cc.NewStream(ctx, &LaptopService_ServiceDesc.Streams[2], "/pcbook.LaptopService/UploadImage", opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -107,8 +218,119 @@ func (x *laptopServiceUploadImageClient) CloseAndRecv() (*UploadImageResponse, e
 	return m, nil
 }
 
+func (c *laptopServiceClient) InitImageUpload(ctx context.Context, in *InitImageUploadRequest, opts ...grpc.CallOption) (*InitImageUploadResponse, error) {
+	out := new(InitImageUploadResponse)
+	err := c.cc.Invoke(ctx, "/pcbook.LaptopService/InitImageUpload", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *laptopServiceClient) UploadImageChunk(ctx context.Context, opts ...grpc.CallOption) (LaptopService_UploadImageChunkClient, error) {
+	stream, err := c.cc.NewStream(ctx, &LaptopService_ServiceDesc.Streams[3], "/pcbook.LaptopService/UploadImageChunk", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &laptopServiceUploadImageChunkClient{stream}
+	return x, nil
+}
+
+type LaptopService_UploadImageChunkClient interface {
+	Send(*UploadImageChunkRequest) error
+	CloseAndRecv() (*UploadImageChunkResponse, error)
+	grpc.ClientStream
+}
+
+type laptopServiceUploadImageChunkClient struct {
+	grpc.ClientStream
+}
+
+func (x *laptopServiceUploadImageChunkClient) Send(m *UploadImageChunkRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *laptopServiceUploadImageChunkClient) CloseAndRecv() (*UploadImageChunkResponse, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(UploadImageChunkResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *laptopServiceClient) GetImageUploadStatus(ctx context.Context, in *GetImageUploadStatusRequest, opts ...grpc.CallOption) (*GetImageUploadStatusResponse, error) {
+	out := new(GetImageUploadStatusResponse)
+	err := c.cc.Invoke(ctx, "/pcbook.LaptopService/GetImageUploadStatus", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *laptopServiceClient) FinalizeImageUpload(ctx context.Context, in *FinalizeImageUploadRequest, opts ...grpc.CallOption) (*FinalizeImageUploadResponse, error) {
+	out := new(FinalizeImageUploadResponse)
+	err := c.cc.Invoke(ctx, "/pcbook.LaptopService/FinalizeImageUpload", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *laptopServiceClient) DownloadImage(ctx context.Context, in *DownloadImageRequest, opts ...grpc.CallOption) (LaptopService_DownloadImageClient, error) {
+	stream, err := c.cc.NewStream(ctx, &LaptopService_ServiceDesc.Streams[4], "/pcbook.LaptopService/DownloadImage", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &laptopServiceDownloadImageClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type LaptopService_DownloadImageClient interface {
+	Recv() (*DownloadImageResponse, error)
+	grpc.ClientStream
+}
+
+type laptopServiceDownloadImageClient struct {
+	grpc.ClientStream
+}
+
+func (x *laptopServiceDownloadImageClient) Recv() (*DownloadImageResponse, error) {
+	m := new(DownloadImageResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *laptopServiceClient) ListImages(ctx context.Context, in *ListImagesRequest, opts ...grpc.CallOption) (*ListImagesResponse, error) {
+	out := new(ListImagesResponse)
+	err := c.cc.Invoke(ctx, "/pcbook.LaptopService/ListImages", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *laptopServiceClient) DeleteImage(ctx context.Context, in *DeleteImageRequest, opts ...grpc.CallOption) (*DeleteImageResponse, error) {
+	out := new(DeleteImageResponse)
+	err := c.cc.Invoke(ctx, "/pcbook.LaptopService/DeleteImage", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *laptopServiceClient) RateLaptop(ctx context.Context, opts ...grpc.CallOption) (LaptopService_RateLaptopClient, error) {
-	stream, err := c.cc.NewStream(ctx, &LaptopService_ServiceDesc.Streams[2], "/pcbook.LaptopService/RateLaptop", opts...)
+	stream, err := c.cc.NewStream(ctx, &LaptopService_ServiceDesc.Streams[5], "/pcbook.LaptopService/RateLaptop", opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -143,8 +365,24 @@ func (x *laptopServiceRateLaptopClient) Recv() (*RateLaptopResponse, error) {
 // for forward compatibility
 type LaptopServiceServer interface {
 	CreateLaptop(context.Context, *CreateLaptopRequest) (*CreateLaptopResponse, error)
+	GetLaptop(context.Context, *GetLaptopRequest) (*GetLaptopResponse, error)
+	UpdateLaptop(context.Context, *UpdateLaptopRequest) (*UpdateLaptopResponse, error)
+	DeleteLaptop(context.Context, *DeleteLaptopRequest) (*DeleteLaptopResponse, error)
+	ListLaptops(context.Context, *ListLaptopsRequest) (*ListLaptopsResponse, error)
 	SearchLaptop(*SearchLaptopRequest, LaptopService_SearchLaptopServer) error
+	SearchFacets(context.Context, *SearchFacetsRequest) (*SearchFacetsResponse, error)
+	WatchLaptops(*WatchLaptopsRequest, LaptopService_WatchLaptopsServer) error
+	CompareLaptops(context.Context, *CompareLaptopsRequest) (*CompareLaptopsResponse, error)
+	SimilarLaptops(context.Context, *SimilarLaptopsRequest) (*SimilarLaptopsResponse, error)
 	UploadImage(LaptopService_UploadImageServer) error
+	InitImageUpload(context.Context, *InitImageUploadRequest) (*InitImageUploadResponse, error)
+	UploadImageChunk(LaptopService_UploadImageChunkServer) error
+	GetImageUploadStatus(context.Context, *GetImageUploadStatusRequest) (*GetImageUploadStatusResponse, error)
+	FinalizeImageUpload(context.Context, *FinalizeImageUploadRequest) (*FinalizeImageUploadResponse, error)
+	// DownloadImage has no REST mapping, the REST server serves the bytes of an image at GET /v1/laptop/images/{id}.
+	DownloadImage(*DownloadImageRequest, LaptopService_DownloadImageServer) error
+	ListImages(context.Context, *ListImagesRequest) (*ListImagesResponse, error)
+	DeleteImage(context.Context, *DeleteImageRequest) (*DeleteImageResponse, error)
 	RateLaptop(LaptopService_RateLaptopServer) error
 	mustEmbedUnimplementedLaptopServiceServer()
 }
@@ -156,12 +394,57 @@ type UnimplementedLaptopServiceServer struct {
 func (UnimplementedLaptopServiceServer) CreateLaptop(context.Context, *CreateLaptopRequest) (*CreateLaptopResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method CreateLaptop not implemented")
 }
+func (UnimplementedLaptopServiceServer) GetLaptop(context.Context, *GetLaptopRequest) (*GetLaptopResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetLaptop not implemented")
+}
+func (UnimplementedLaptopServiceServer) UpdateLaptop(context.Context, *UpdateLaptopRequest) (*UpdateLaptopResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateLaptop not implemented")
+}
+func (UnimplementedLaptopServiceServer) DeleteLaptop(context.Context, *DeleteLaptopRequest) (*DeleteLaptopResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteLaptop not implemented")
+}
+func (UnimplementedLaptopServiceServer) ListLaptops(context.Context, *ListLaptopsRequest) (*ListLaptopsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListLaptops not implemented")
+}
 func (UnimplementedLaptopServiceServer) SearchLaptop(*SearchLaptopRequest, LaptopService_SearchLaptopServer) error {
 	return status.Errorf(codes.Unimplemented, "method SearchLaptop not implemented")
 }
+func (UnimplementedLaptopServiceServer) SearchFacets(context.Context, *SearchFacetsRequest) (*SearchFacetsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SearchFacets not implemented")
+}
+func (UnimplementedLaptopServiceServer) WatchLaptops(*WatchLaptopsRequest, LaptopService_WatchLaptopsServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchLaptops not implemented")
+}
+func (UnimplementedLaptopServiceServer) CompareLaptops(context.Context, *CompareLaptopsRequest) (*CompareLaptopsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CompareLaptops not implemented")
+}
+func (UnimplementedLaptopServiceServer) SimilarLaptops(context.Context, *SimilarLaptopsRequest) (*SimilarLaptopsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SimilarLaptops not implemented")
+}
 func (UnimplementedLaptopServiceServer) UploadImage(LaptopService_UploadImageServer) error {
 	return status.Errorf(codes.Unimplemented, "method UploadImage not implemented")
 }
+func (UnimplementedLaptopServiceServer) InitImageUpload(context.Context, *InitImageUploadRequest) (*InitImageUploadResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method InitImageUpload not implemented")
+}
+func (UnimplementedLaptopServiceServer) UploadImageChunk(LaptopService_UploadImageChunkServer) error {
+	return status.Errorf(codes.Unimplemented, "method UploadImageChunk not implemented")
+}
+func (UnimplementedLaptopServiceServer) GetImageUploadStatus(context.Context, *GetImageUploadStatusRequest) (*GetImageUploadStatusResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetImageUploadStatus not implemented")
+}
+func (UnimplementedLaptopServiceServer) FinalizeImageUpload(context.Context, *FinalizeImageUploadRequest) (*FinalizeImageUploadResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method FinalizeImageUpload not implemented")
+}
+func (UnimplementedLaptopServiceServer) DownloadImage(*DownloadImageRequest, LaptopService_DownloadImageServer) error {
+	return status.Errorf(codes.Unimplemented, "method DownloadImage not implemented")
+}
+func (UnimplementedLaptopServiceServer) ListImages(context.Context, *ListImagesRequest) (*ListImagesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListImages not implemented")
+}
+func (UnimplementedLaptopServiceServer) DeleteImage(context.Context, *DeleteImageRequest) (*DeleteImageResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteImage not implemented")
+}
 func (UnimplementedLaptopServiceServer) RateLaptop(LaptopService_RateLaptopServer) error {
 	return status.Errorf(codes.Unimplemented, "method RateLaptop not implemented")
 }
@@ -196,6 +479,78 @@ func _LaptopService_CreateLaptop_Handler(srv interface{}, ctx context.Context, d
 	return interceptor(ctx, in, info, handler)
 }
 
+func _LaptopService_GetLaptop_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetLaptopRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LaptopServiceServer).GetLaptop(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pcbook.LaptopService/GetLaptop",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LaptopServiceServer).GetLaptop(ctx, req.(*GetLaptopRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LaptopService_UpdateLaptop_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateLaptopRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LaptopServiceServer).UpdateLaptop(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pcbook.LaptopService/UpdateLaptop",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LaptopServiceServer).UpdateLaptop(ctx, req.(*UpdateLaptopRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LaptopService_DeleteLaptop_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteLaptopRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LaptopServiceServer).DeleteLaptop(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pcbook.LaptopService/DeleteLaptop",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LaptopServiceServer).DeleteLaptop(ctx, req.(*DeleteLaptopRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LaptopService_ListLaptops_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListLaptopsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LaptopServiceServer).ListLaptops(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pcbook.LaptopService/ListLaptops",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LaptopServiceServer).ListLaptops(ctx, req.(*ListLaptopsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _LaptopService_SearchLaptop_Handler(srv interface{}, stream grpc.ServerStream) error {
 	m := new(SearchLaptopRequest)
 	if err := stream.RecvMsg(m); err != nil {
@@ -217,6 +572,81 @@ func (x *laptopServiceSearchLaptopServer) Send(m *SearchLaptopResponse) error {
 	return x.ServerStream.SendMsg(m)
 }
 
+func _LaptopService_SearchFacets_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SearchFacetsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LaptopServiceServer).SearchFacets(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pcbook.LaptopService/SearchFacets",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LaptopServiceServer).SearchFacets(ctx, req.(*SearchFacetsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LaptopService_WatchLaptops_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchLaptopsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(LaptopServiceServer).WatchLaptops(m, &laptopServiceWatchLaptopsServer{stream})
+}
+
+type LaptopService_WatchLaptopsServer interface {
+	Send(*WatchLaptopsResponse) error
+	grpc.ServerStream
+}
+
+type laptopServiceWatchLaptopsServer struct {
+	grpc.ServerStream
+}
+
+func (x *laptopServiceWatchLaptopsServer) Send(m *WatchLaptopsResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _LaptopService_CompareLaptops_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CompareLaptopsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LaptopServiceServer).CompareLaptops(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pcbook.LaptopService/CompareLaptops",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LaptopServiceServer).CompareLaptops(ctx, req.(*CompareLaptopsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LaptopService_SimilarLaptops_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SimilarLaptopsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LaptopServiceServer).SimilarLaptops(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pcbook.LaptopService/SimilarLaptops",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LaptopServiceServer).SimilarLaptops(ctx, req.(*SimilarLaptopsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _LaptopService_UploadImage_Handler(srv interface{}, stream grpc.ServerStream) error {
 	return srv.(LaptopServiceServer).UploadImage(&laptopServiceUploadImageServer{stream})
 }
@@ -243,6 +673,143 @@ func (x *laptopServiceUploadImageServer) Recv() (*UploadImageRequest, error) {
 	return m, nil
 }
 
+func _LaptopService_InitImageUpload_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(InitImageUploadRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LaptopServiceServer).InitImageUpload(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pcbook.LaptopService/InitImageUpload",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LaptopServiceServer).InitImageUpload(ctx, req.(*InitImageUploadRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LaptopService_UploadImageChunk_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(LaptopServiceServer).UploadImageChunk(&laptopServiceUploadImageChunkServer{stream})
+}
+
+type LaptopService_UploadImageChunkServer interface {
+	SendAndClose(*UploadImageChunkResponse) error
+	Recv() (*UploadImageChunkRequest, error)
+	grpc.ServerStream
+}
+
+type laptopServiceUploadImageChunkServer struct {
+	grpc.ServerStream
+}
+
+func (x *laptopServiceUploadImageChunkServer) SendAndClose(m *UploadImageChunkResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *laptopServiceUploadImageChunkServer) Recv() (*UploadImageChunkRequest, error) {
+	m := new(UploadImageChunkRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _LaptopService_GetImageUploadStatus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetImageUploadStatusRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LaptopServiceServer).GetImageUploadStatus(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pcbook.LaptopService/GetImageUploadStatus",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LaptopServiceServer).GetImageUploadStatus(ctx, req.(*GetImageUploadStatusRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LaptopService_FinalizeImageUpload_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(FinalizeImageUploadRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LaptopServiceServer).FinalizeImageUpload(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pcbook.LaptopService/FinalizeImageUpload",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LaptopServiceServer).FinalizeImageUpload(ctx, req.(*FinalizeImageUploadRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LaptopService_DownloadImage_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(DownloadImageRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(LaptopServiceServer).DownloadImage(m, &laptopServiceDownloadImageServer{stream})
+}
+
+type LaptopService_DownloadImageServer interface {
+	Send(*DownloadImageResponse) error
+	grpc.ServerStream
+}
+
+type laptopServiceDownloadImageServer struct {
+	grpc.ServerStream
+}
+
+func (x *laptopServiceDownloadImageServer) Send(m *DownloadImageResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _LaptopService_ListImages_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListImagesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LaptopServiceServer).ListImages(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pcbook.LaptopService/ListImages",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LaptopServiceServer).ListImages(ctx, req.(*ListImagesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LaptopService_DeleteImage_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteImageRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LaptopServiceServer).DeleteImage(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pcbook.LaptopService/DeleteImage",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LaptopServiceServer).DeleteImage(ctx, req.(*DeleteImageRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _LaptopService_RateLaptop_Handler(srv interface{}, stream grpc.ServerStream) error {
 	return srv.(LaptopServiceServer).RateLaptop(&laptopServiceRateLaptopServer{stream})
 }
@@ -280,6 +847,54 @@ var LaptopService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "CreateLaptop",
 			Handler:    _LaptopService_CreateLaptop_Handler,
 		},
+		{
+			MethodName: "GetLaptop",
+			Handler:    _LaptopService_GetLaptop_Handler,
+		},
+		{
+			MethodName: "UpdateLaptop",
+			Handler:    _LaptopService_UpdateLaptop_Handler,
+		},
+		{
+			MethodName: "DeleteLaptop",
+			Handler:    _LaptopService_DeleteLaptop_Handler,
+		},
+		{
+			MethodName: "ListLaptops",
+			Handler:    _LaptopService_ListLaptops_Handler,
+		},
+		{
+			MethodName: "SearchFacets",
+			Handler:    _LaptopService_SearchFacets_Handler,
+		},
+		{
+			MethodName: "CompareLaptops",
+			Handler:    _LaptopService_CompareLaptops_Handler,
+		},
+		{
+			MethodName: "SimilarLaptops",
+			Handler:    _LaptopService_SimilarLaptops_Handler,
+		},
+		{
+			MethodName: "InitImageUpload",
+			Handler:    _LaptopService_InitImageUpload_Handler,
+		},
+		{
+			MethodName: "GetImageUploadStatus",
+			Handler:    _LaptopService_GetImageUploadStatus_Handler,
+		},
+		{
+			MethodName: "FinalizeImageUpload",
+			Handler:    _LaptopService_FinalizeImageUpload_Handler,
+		},
+		{
+			MethodName: "ListImages",
+			Handler:    _LaptopService_ListImages_Handler,
+		},
+		{
+			MethodName: "DeleteImage",
+			Handler:    _LaptopService_DeleteImage_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
 		{
@@ -287,11 +902,26 @@ var LaptopService_ServiceDesc = grpc.ServiceDesc{
 			Handler:       _LaptopService_SearchLaptop_Handler,
 			ServerStreams: true,
 		},
+		{
+			StreamName:    "WatchLaptops",
+			Handler:       _LaptopService_WatchLaptops_Handler,
+			ServerStreams: true,
+		},
 		{
 			StreamName:    "UploadImage",
 			Handler:       _LaptopService_UploadImage_Handler,
 			ClientStreams: true,
 		},
+		{
+			StreamName:    "UploadImageChunk",
+			Handler:       _LaptopService_UploadImageChunk_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "DownloadImage",
+			Handler:       _LaptopService_DownloadImage_Handler,
+			ServerStreams: true,
+		},
 		{
 			StreamName:    "RateLaptop",
 			Handler:       _LaptopService_RateLaptop_Handler,
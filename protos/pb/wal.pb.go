@@ -0,0 +1,883 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.27.1
+// 	protoc        v3.17.3
+// source: wal.proto
+
+package pb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// StoredRating is the rating of a laptop as the rating store keeps it
+type StoredRating struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	LaptopId string `protobuf:"bytes,1,opt,name=laptop_id,json=laptopId,proto3" json:"laptop_id,omitempty"`
+	// scores maps the users who rated the laptop to their score
+	Scores map[string]float64 `protobuf:"bytes,2,rep,name=scores,proto3" json:"scores,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"fixed64,2,opt,name=value,proto3"`
+}
+
+func (x *StoredRating) Reset() {
+	*x = StoredRating{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_wal_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *StoredRating) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StoredRating) ProtoMessage() {}
+
+func (x *StoredRating) ProtoReflect() protoreflect.Message {
+	mi := &file_wal_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StoredRating.ProtoReflect.Descriptor instead.
+func (*StoredRating) Descriptor() ([]byte, []int) {
+	return file_wal_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *StoredRating) GetLaptopId() string {
+	if x != nil {
+		return x.LaptopId
+	}
+	return ""
+}
+
+func (x *StoredRating) GetScores() map[string]float64 {
+	if x != nil {
+		return x.Scores
+	}
+	return nil
+}
+
+// StoredUser is a user as the user store keeps it
+type StoredUser struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Username       string `protobuf:"bytes,1,opt,name=username,proto3" json:"username,omitempty"`
+	HashedPassword string `protobuf:"bytes,2,opt,name=hashed_password,json=hashedPassword,proto3" json:"hashed_password,omitempty"`
+	Role           string `protobuf:"bytes,3,opt,name=role,proto3" json:"role,omitempty"`
+}
+
+func (x *StoredUser) Reset() {
+	*x = StoredUser{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_wal_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *StoredUser) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StoredUser) ProtoMessage() {}
+
+func (x *StoredUser) ProtoReflect() protoreflect.Message {
+	mi := &file_wal_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StoredUser.ProtoReflect.Descriptor instead.
+func (*StoredUser) Descriptor() ([]byte, []int) {
+	return file_wal_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *StoredUser) GetUsername() string {
+	if x != nil {
+		return x.Username
+	}
+	return ""
+}
+
+func (x *StoredUser) GetHashedPassword() string {
+	if x != nil {
+		return x.HashedPassword
+	}
+	return ""
+}
+
+func (x *StoredUser) GetRole() string {
+	if x != nil {
+		return x.Role
+	}
+	return ""
+}
+
+// StoredImage is the metadata of an image as the image store keeps it
+type StoredImage struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Id             string `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	LaptopId       string `protobuf:"bytes,2,opt,name=laptop_id,json=laptopId,proto3" json:"laptop_id,omitempty"`
+	Extension      string `protobuf:"bytes,3,opt,name=extension,proto3" json:"extension,omitempty"`
+	Path           string `protobuf:"bytes,4,opt,name=path,proto3" json:"path,omitempty"`
+	Size           uint64 `protobuf:"varint,5,opt,name=size,proto3" json:"size,omitempty"`
+	Width          uint32 `protobuf:"varint,6,opt,name=width,proto3" json:"width,omitempty"`
+	Height         uint32 `protobuf:"varint,7,opt,name=height,proto3" json:"height,omitempty"`
+	SourceId       string `protobuf:"bytes,8,opt,name=source_id,json=sourceId,proto3" json:"source_id,omitempty"`
+	Rendition      string `protobuf:"bytes,9,opt,name=rendition,proto3" json:"rendition,omitempty"`
+	RenditionError string `protobuf:"bytes,10,opt,name=rendition_error,json=renditionError,proto3" json:"rendition_error,omitempty"`
+	// sha256 is the hex encoded SHA-256 checksum of the data, which names the file images with the same data share
+	Sha256 string `protobuf:"bytes,11,opt,name=sha256,proto3" json:"sha256,omitempty"`
+}
+
+func (x *StoredImage) Reset() {
+	*x = StoredImage{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_wal_proto_msgTypes[2]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *StoredImage) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StoredImage) ProtoMessage() {}
+
+func (x *StoredImage) ProtoReflect() protoreflect.Message {
+	mi := &file_wal_proto_msgTypes[2]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StoredImage.ProtoReflect.Descriptor instead.
+func (*StoredImage) Descriptor() ([]byte, []int) {
+	return file_wal_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *StoredImage) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *StoredImage) GetLaptopId() string {
+	if x != nil {
+		return x.LaptopId
+	}
+	return ""
+}
+
+func (x *StoredImage) GetExtension() string {
+	if x != nil {
+		return x.Extension
+	}
+	return ""
+}
+
+func (x *StoredImage) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+func (x *StoredImage) GetSize() uint64 {
+	if x != nil {
+		return x.Size
+	}
+	return 0
+}
+
+func (x *StoredImage) GetWidth() uint32 {
+	if x != nil {
+		return x.Width
+	}
+	return 0
+}
+
+func (x *StoredImage) GetHeight() uint32 {
+	if x != nil {
+		return x.Height
+	}
+	return 0
+}
+
+func (x *StoredImage) GetSourceId() string {
+	if x != nil {
+		return x.SourceId
+	}
+	return ""
+}
+
+func (x *StoredImage) GetRendition() string {
+	if x != nil {
+		return x.Rendition
+	}
+	return ""
+}
+
+func (x *StoredImage) GetRenditionError() string {
+	if x != nil {
+		return x.RenditionError
+	}
+	return ""
+}
+
+func (x *StoredImage) GetSha256() string {
+	if x != nil {
+		return x.Sha256
+	}
+	return ""
+}
+
+// ImageIndex is the metadata of all images of a DiskImageStore as it keeps it next to the images
+type ImageIndex struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Images []*StoredImage `protobuf:"bytes,1,rep,name=images,proto3" json:"images,omitempty"`
+}
+
+func (x *ImageIndex) Reset() {
+	*x = ImageIndex{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_wal_proto_msgTypes[3]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ImageIndex) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ImageIndex) ProtoMessage() {}
+
+func (x *ImageIndex) ProtoReflect() protoreflect.Message {
+	mi := &file_wal_proto_msgTypes[3]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ImageIndex.ProtoReflect.Descriptor instead.
+func (*ImageIndex) Descriptor() ([]byte, []int) {
+	return file_wal_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *ImageIndex) GetImages() []*StoredImage {
+	if x != nil {
+		return x.Images
+	}
+	return nil
+}
+
+// LaptopRated records the score a user rated a laptop, replacing the user's previous score of the laptop
+type LaptopRated struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	LaptopId string  `protobuf:"bytes,1,opt,name=laptop_id,json=laptopId,proto3" json:"laptop_id,omitempty"`
+	Username string  `protobuf:"bytes,2,opt,name=username,proto3" json:"username,omitempty"`
+	Score    float64 `protobuf:"fixed64,3,opt,name=score,proto3" json:"score,omitempty"`
+}
+
+func (x *LaptopRated) Reset() {
+	*x = LaptopRated{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_wal_proto_msgTypes[4]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *LaptopRated) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LaptopRated) ProtoMessage() {}
+
+func (x *LaptopRated) ProtoReflect() protoreflect.Message {
+	mi := &file_wal_proto_msgTypes[4]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LaptopRated.ProtoReflect.Descriptor instead.
+func (*LaptopRated) Descriptor() ([]byte, []int) {
+	return file_wal_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *LaptopRated) GetLaptopId() string {
+	if x != nil {
+		return x.LaptopId
+	}
+	return ""
+}
+
+func (x *LaptopRated) GetUsername() string {
+	if x != nil {
+		return x.Username
+	}
+	return ""
+}
+
+func (x *LaptopRated) GetScore() float64 {
+	if x != nil {
+		return x.Score
+	}
+	return 0
+}
+
+// WALRecord is a mutation of the stores as the write-ahead log keeps it
+type WALRecord struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// sequence numbers the records of the log, starting after the sequence of the snapshot it was started from
+	Sequence uint64 `protobuf:"varint,1,opt,name=sequence,proto3" json:"sequence,omitempty"`
+	// Types that are assignable to Mutation:
+	//	*WALRecord_LaptopSaved
+	//	*WALRecord_LaptopUpdated
+	//	*WALRecord_LaptopDeleted
+	//	*WALRecord_LaptopRated
+	//	*WALRecord_UserSaved
+	//	*WALRecord_ImageSaved
+	//	*WALRecord_ImageDeleted
+	Mutation isWALRecord_Mutation `protobuf_oneof:"mutation"`
+}
+
+func (x *WALRecord) Reset() {
+	*x = WALRecord{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_wal_proto_msgTypes[5]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *WALRecord) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WALRecord) ProtoMessage() {}
+
+func (x *WALRecord) ProtoReflect() protoreflect.Message {
+	mi := &file_wal_proto_msgTypes[5]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WALRecord.ProtoReflect.Descriptor instead.
+func (*WALRecord) Descriptor() ([]byte, []int) {
+	return file_wal_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *WALRecord) GetSequence() uint64 {
+	if x != nil {
+		return x.Sequence
+	}
+	return 0
+}
+
+func (m *WALRecord) GetMutation() isWALRecord_Mutation {
+	if m != nil {
+		return m.Mutation
+	}
+	return nil
+}
+
+func (x *WALRecord) GetLaptopSaved() *Laptop {
+	if x, ok := x.GetMutation().(*WALRecord_LaptopSaved); ok {
+		return x.LaptopSaved
+	}
+	return nil
+}
+
+func (x *WALRecord) GetLaptopUpdated() *Laptop {
+	if x, ok := x.GetMutation().(*WALRecord_LaptopUpdated); ok {
+		return x.LaptopUpdated
+	}
+	return nil
+}
+
+func (x *WALRecord) GetLaptopDeleted() string {
+	if x, ok := x.GetMutation().(*WALRecord_LaptopDeleted); ok {
+		return x.LaptopDeleted
+	}
+	return ""
+}
+
+func (x *WALRecord) GetLaptopRated() *LaptopRated {
+	if x, ok := x.GetMutation().(*WALRecord_LaptopRated); ok {
+		return x.LaptopRated
+	}
+	return nil
+}
+
+func (x *WALRecord) GetUserSaved() *StoredUser {
+	if x, ok := x.GetMutation().(*WALRecord_UserSaved); ok {
+		return x.UserSaved
+	}
+	return nil
+}
+
+func (x *WALRecord) GetImageSaved() *StoredImage {
+	if x, ok := x.GetMutation().(*WALRecord_ImageSaved); ok {
+		return x.ImageSaved
+	}
+	return nil
+}
+
+func (x *WALRecord) GetImageDeleted() string {
+	if x, ok := x.GetMutation().(*WALRecord_ImageDeleted); ok {
+		return x.ImageDeleted
+	}
+	return ""
+}
+
+type isWALRecord_Mutation interface {
+	isWALRecord_Mutation()
+}
+
+type WALRecord_LaptopSaved struct {
+	LaptopSaved *Laptop `protobuf:"bytes,2,opt,name=laptop_saved,json=laptopSaved,proto3,oneof"`
+}
+
+type WALRecord_LaptopUpdated struct {
+	LaptopUpdated *Laptop `protobuf:"bytes,3,opt,name=laptop_updated,json=laptopUpdated,proto3,oneof"`
+}
+
+type WALRecord_LaptopDeleted struct {
+	LaptopDeleted string `protobuf:"bytes,4,opt,name=laptop_deleted,json=laptopDeleted,proto3,oneof"`
+}
+
+type WALRecord_LaptopRated struct {
+	LaptopRated *LaptopRated `protobuf:"bytes,5,opt,name=laptop_rated,json=laptopRated,proto3,oneof"`
+}
+
+type WALRecord_UserSaved struct {
+	UserSaved *StoredUser `protobuf:"bytes,6,opt,name=user_saved,json=userSaved,proto3,oneof"`
+}
+
+type WALRecord_ImageSaved struct {
+	ImageSaved *StoredImage `protobuf:"bytes,7,opt,name=image_saved,json=imageSaved,proto3,oneof"`
+}
+
+type WALRecord_ImageDeleted struct {
+	ImageDeleted string `protobuf:"bytes,8,opt,name=image_deleted,json=imageDeleted,proto3,oneof"`
+}
+
+func (*WALRecord_LaptopSaved) isWALRecord_Mutation() {}
+
+func (*WALRecord_LaptopUpdated) isWALRecord_Mutation() {}
+
+func (*WALRecord_LaptopDeleted) isWALRecord_Mutation() {}
+
+func (*WALRecord_LaptopRated) isWALRecord_Mutation() {}
+
+func (*WALRecord_UserSaved) isWALRecord_Mutation() {}
+
+func (*WALRecord_ImageSaved) isWALRecord_Mutation() {}
+
+func (*WALRecord_ImageDeleted) isWALRecord_Mutation() {}
+
+// WALSnapshot is the state of the stores after the record with the sequence was applied
+type WALSnapshot struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Sequence uint64          `protobuf:"varint,1,opt,name=sequence,proto3" json:"sequence,omitempty"`
+	Laptops  []*Laptop       `protobuf:"bytes,2,rep,name=laptops,proto3" json:"laptops,omitempty"`
+	Ratings  []*StoredRating `protobuf:"bytes,3,rep,name=ratings,proto3" json:"ratings,omitempty"`
+	Users    []*StoredUser   `protobuf:"bytes,4,rep,name=users,proto3" json:"users,omitempty"`
+	Images   []*StoredImage  `protobuf:"bytes,5,rep,name=images,proto3" json:"images,omitempty"`
+}
+
+func (x *WALSnapshot) Reset() {
+	*x = WALSnapshot{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_wal_proto_msgTypes[6]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *WALSnapshot) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WALSnapshot) ProtoMessage() {}
+
+func (x *WALSnapshot) ProtoReflect() protoreflect.Message {
+	mi := &file_wal_proto_msgTypes[6]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WALSnapshot.ProtoReflect.Descriptor instead.
+func (*WALSnapshot) Descriptor() ([]byte, []int) {
+	return file_wal_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *WALSnapshot) GetSequence() uint64 {
+	if x != nil {
+		return x.Sequence
+	}
+	return 0
+}
+
+func (x *WALSnapshot) GetLaptops() []*Laptop {
+	if x != nil {
+		return x.Laptops
+	}
+	return nil
+}
+
+func (x *WALSnapshot) GetRatings() []*StoredRating {
+	if x != nil {
+		return x.Ratings
+	}
+	return nil
+}
+
+func (x *WALSnapshot) GetUsers() []*StoredUser {
+	if x != nil {
+		return x.Users
+	}
+	return nil
+}
+
+func (x *WALSnapshot) GetImages() []*StoredImage {
+	if x != nil {
+		return x.Images
+	}
+	return nil
+}
+
+var File_wal_proto protoreflect.FileDescriptor
+
+var file_wal_proto_rawDesc = []byte{
+	0x0a, 0x09, 0x77, 0x61, 0x6c, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x06, 0x70, 0x63, 0x62,
+	0x6f, 0x6f, 0x6b, 0x1a, 0x14, 0x6c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x5f, 0x73, 0x65, 0x72, 0x76,
+	0x69, 0x63, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x22, 0xa0, 0x01, 0x0a, 0x0c, 0x53, 0x74,
+	0x6f, 0x72, 0x65, 0x64, 0x52, 0x61, 0x74, 0x69, 0x6e, 0x67, 0x12, 0x1b, 0x0a, 0x09, 0x6c, 0x61,
+	0x70, 0x74, 0x6f, 0x70, 0x5f, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x6c,
+	0x61, 0x70, 0x74, 0x6f, 0x70, 0x49, 0x64, 0x12, 0x38, 0x0a, 0x06, 0x73, 0x63, 0x6f, 0x72, 0x65,
+	0x73, 0x18, 0x02, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x20, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b,
+	0x2e, 0x53, 0x74, 0x6f, 0x72, 0x65, 0x64, 0x52, 0x61, 0x74, 0x69, 0x6e, 0x67, 0x2e, 0x53, 0x63,
+	0x6f, 0x72, 0x65, 0x73, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x52, 0x06, 0x73, 0x63, 0x6f, 0x72, 0x65,
+	0x73, 0x1a, 0x39, 0x0a, 0x0b, 0x53, 0x63, 0x6f, 0x72, 0x65, 0x73, 0x45, 0x6e, 0x74, 0x72, 0x79,
+	0x12, 0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x6b,
+	0x65, 0x79, 0x12, 0x14, 0x0a, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28,
+	0x01, 0x52, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x3a, 0x02, 0x38, 0x01, 0x22, 0x65, 0x0a, 0x0a,
+	0x53, 0x74, 0x6f, 0x72, 0x65, 0x64, 0x55, 0x73, 0x65, 0x72, 0x12, 0x1a, 0x0a, 0x08, 0x75, 0x73,
+	0x65, 0x72, 0x6e, 0x61, 0x6d, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x75, 0x73,
+	0x65, 0x72, 0x6e, 0x61, 0x6d, 0x65, 0x12, 0x27, 0x0a, 0x0f, 0x68, 0x61, 0x73, 0x68, 0x65, 0x64,
+	0x5f, 0x70, 0x61, 0x73, 0x73, 0x77, 0x6f, 0x72, 0x64, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52,
+	0x0e, 0x68, 0x61, 0x73, 0x68, 0x65, 0x64, 0x50, 0x61, 0x73, 0x73, 0x77, 0x6f, 0x72, 0x64, 0x12,
+	0x12, 0x0a, 0x04, 0x72, 0x6f, 0x6c, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x72,
+	0x6f, 0x6c, 0x65, 0x22, 0xaa, 0x02, 0x0a, 0x0b, 0x53, 0x74, 0x6f, 0x72, 0x65, 0x64, 0x49, 0x6d,
+	0x61, 0x67, 0x65, 0x12, 0x0e, 0x0a, 0x02, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52,
+	0x02, 0x69, 0x64, 0x12, 0x1b, 0x0a, 0x09, 0x6c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x5f, 0x69, 0x64,
+	0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x6c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x49, 0x64,
+	0x12, 0x1c, 0x0a, 0x09, 0x65, 0x78, 0x74, 0x65, 0x6e, 0x73, 0x69, 0x6f, 0x6e, 0x18, 0x03, 0x20,
+	0x01, 0x28, 0x09, 0x52, 0x09, 0x65, 0x78, 0x74, 0x65, 0x6e, 0x73, 0x69, 0x6f, 0x6e, 0x12, 0x12,
+	0x0a, 0x04, 0x70, 0x61, 0x74, 0x68, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x70, 0x61,
+	0x74, 0x68, 0x12, 0x12, 0x0a, 0x04, 0x73, 0x69, 0x7a, 0x65, 0x18, 0x05, 0x20, 0x01, 0x28, 0x04,
+	0x52, 0x04, 0x73, 0x69, 0x7a, 0x65, 0x12, 0x14, 0x0a, 0x05, 0x77, 0x69, 0x64, 0x74, 0x68, 0x18,
+	0x06, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x05, 0x77, 0x69, 0x64, 0x74, 0x68, 0x12, 0x16, 0x0a, 0x06,
+	0x68, 0x65, 0x69, 0x67, 0x68, 0x74, 0x18, 0x07, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x06, 0x68, 0x65,
+	0x69, 0x67, 0x68, 0x74, 0x12, 0x1b, 0x0a, 0x09, 0x73, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x5f, 0x69,
+	0x64, 0x18, 0x08, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x73, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x49,
+	0x64, 0x12, 0x1c, 0x0a, 0x09, 0x72, 0x65, 0x6e, 0x64, 0x69, 0x74, 0x69, 0x6f, 0x6e, 0x18, 0x09,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x72, 0x65, 0x6e, 0x64, 0x69, 0x74, 0x69, 0x6f, 0x6e, 0x12,
+	0x27, 0x0a, 0x0f, 0x72, 0x65, 0x6e, 0x64, 0x69, 0x74, 0x69, 0x6f, 0x6e, 0x5f, 0x65, 0x72, 0x72,
+	0x6f, 0x72, 0x18, 0x0a, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0e, 0x72, 0x65, 0x6e, 0x64, 0x69, 0x74,
+	0x69, 0x6f, 0x6e, 0x45, 0x72, 0x72, 0x6f, 0x72, 0x12, 0x16, 0x0a, 0x06, 0x73, 0x68, 0x61, 0x32,
+	0x35, 0x36, 0x18, 0x0b, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x73, 0x68, 0x61, 0x32, 0x35, 0x36,
+	0x22, 0x39, 0x0a, 0x0a, 0x49, 0x6d, 0x61, 0x67, 0x65, 0x49, 0x6e, 0x64, 0x65, 0x78, 0x12, 0x2b,
+	0x0a, 0x06, 0x69, 0x6d, 0x61, 0x67, 0x65, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x13,
+	0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x53, 0x74, 0x6f, 0x72, 0x65, 0x64, 0x49, 0x6d,
+	0x61, 0x67, 0x65, 0x52, 0x06, 0x69, 0x6d, 0x61, 0x67, 0x65, 0x73, 0x22, 0x5c, 0x0a, 0x0b, 0x4c,
+	0x61, 0x70, 0x74, 0x6f, 0x70, 0x52, 0x61, 0x74, 0x65, 0x64, 0x12, 0x1b, 0x0a, 0x09, 0x6c, 0x61,
+	0x70, 0x74, 0x6f, 0x70, 0x5f, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x6c,
+	0x61, 0x70, 0x74, 0x6f, 0x70, 0x49, 0x64, 0x12, 0x1a, 0x0a, 0x08, 0x75, 0x73, 0x65, 0x72, 0x6e,
+	0x61, 0x6d, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x75, 0x73, 0x65, 0x72, 0x6e,
+	0x61, 0x6d, 0x65, 0x12, 0x14, 0x0a, 0x05, 0x73, 0x63, 0x6f, 0x72, 0x65, 0x18, 0x03, 0x20, 0x01,
+	0x28, 0x01, 0x52, 0x05, 0x73, 0x63, 0x6f, 0x72, 0x65, 0x22, 0x98, 0x03, 0x0a, 0x09, 0x57, 0x41,
+	0x4c, 0x52, 0x65, 0x63, 0x6f, 0x72, 0x64, 0x12, 0x1a, 0x0a, 0x08, 0x73, 0x65, 0x71, 0x75, 0x65,
+	0x6e, 0x63, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x04, 0x52, 0x08, 0x73, 0x65, 0x71, 0x75, 0x65,
+	0x6e, 0x63, 0x65, 0x12, 0x33, 0x0a, 0x0c, 0x6c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x5f, 0x73, 0x61,
+	0x76, 0x65, 0x64, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0e, 0x2e, 0x70, 0x63, 0x62, 0x6f,
+	0x6f, 0x6b, 0x2e, 0x4c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x48, 0x00, 0x52, 0x0b, 0x6c, 0x61, 0x70,
+	0x74, 0x6f, 0x70, 0x53, 0x61, 0x76, 0x65, 0x64, 0x12, 0x37, 0x0a, 0x0e, 0x6c, 0x61, 0x70, 0x74,
+	0x6f, 0x70, 0x5f, 0x75, 0x70, 0x64, 0x61, 0x74, 0x65, 0x64, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0b,
+	0x32, 0x0e, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x4c, 0x61, 0x70, 0x74, 0x6f, 0x70,
+	0x48, 0x00, 0x52, 0x0d, 0x6c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x55, 0x70, 0x64, 0x61, 0x74, 0x65,
+	0x64, 0x12, 0x27, 0x0a, 0x0e, 0x6c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x5f, 0x64, 0x65, 0x6c, 0x65,
+	0x74, 0x65, 0x64, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09, 0x48, 0x00, 0x52, 0x0d, 0x6c, 0x61, 0x70,
+	0x74, 0x6f, 0x70, 0x44, 0x65, 0x6c, 0x65, 0x74, 0x65, 0x64, 0x12, 0x38, 0x0a, 0x0c, 0x6c, 0x61,
+	0x70, 0x74, 0x6f, 0x70, 0x5f, 0x72, 0x61, 0x74, 0x65, 0x64, 0x18, 0x05, 0x20, 0x01, 0x28, 0x0b,
+	0x32, 0x13, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x4c, 0x61, 0x70, 0x74, 0x6f, 0x70,
+	0x52, 0x61, 0x74, 0x65, 0x64, 0x48, 0x00, 0x52, 0x0b, 0x6c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x52,
+	0x61, 0x74, 0x65, 0x64, 0x12, 0x33, 0x0a, 0x0a, 0x75, 0x73, 0x65, 0x72, 0x5f, 0x73, 0x61, 0x76,
+	0x65, 0x64, 0x18, 0x06, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x12, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f,
+	0x6b, 0x2e, 0x53, 0x74, 0x6f, 0x72, 0x65, 0x64, 0x55, 0x73, 0x65, 0x72, 0x48, 0x00, 0x52, 0x09,
+	0x75, 0x73, 0x65, 0x72, 0x53, 0x61, 0x76, 0x65, 0x64, 0x12, 0x36, 0x0a, 0x0b, 0x69, 0x6d, 0x61,
+	0x67, 0x65, 0x5f, 0x73, 0x61, 0x76, 0x65, 0x64, 0x18, 0x07, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x13,
+	0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x53, 0x74, 0x6f, 0x72, 0x65, 0x64, 0x49, 0x6d,
+	0x61, 0x67, 0x65, 0x48, 0x00, 0x52, 0x0a, 0x69, 0x6d, 0x61, 0x67, 0x65, 0x53, 0x61, 0x76, 0x65,
+	0x64, 0x12, 0x25, 0x0a, 0x0d, 0x69, 0x6d, 0x61, 0x67, 0x65, 0x5f, 0x64, 0x65, 0x6c, 0x65, 0x74,
+	0x65, 0x64, 0x18, 0x08, 0x20, 0x01, 0x28, 0x09, 0x48, 0x00, 0x52, 0x0c, 0x69, 0x6d, 0x61, 0x67,
+	0x65, 0x44, 0x65, 0x6c, 0x65, 0x74, 0x65, 0x64, 0x42, 0x0a, 0x0a, 0x08, 0x6d, 0x75, 0x74, 0x61,
+	0x74, 0x69, 0x6f, 0x6e, 0x22, 0xda, 0x01, 0x0a, 0x0b, 0x57, 0x41, 0x4c, 0x53, 0x6e, 0x61, 0x70,
+	0x73, 0x68, 0x6f, 0x74, 0x12, 0x1a, 0x0a, 0x08, 0x73, 0x65, 0x71, 0x75, 0x65, 0x6e, 0x63, 0x65,
+	0x18, 0x01, 0x20, 0x01, 0x28, 0x04, 0x52, 0x08, 0x73, 0x65, 0x71, 0x75, 0x65, 0x6e, 0x63, 0x65,
+	0x12, 0x28, 0x0a, 0x07, 0x6c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x73, 0x18, 0x02, 0x20, 0x03, 0x28,
+	0x0b, 0x32, 0x0e, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x4c, 0x61, 0x70, 0x74, 0x6f,
+	0x70, 0x52, 0x07, 0x6c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x73, 0x12, 0x2e, 0x0a, 0x07, 0x72, 0x61,
+	0x74, 0x69, 0x6e, 0x67, 0x73, 0x18, 0x03, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x14, 0x2e, 0x70, 0x63,
+	0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x53, 0x74, 0x6f, 0x72, 0x65, 0x64, 0x52, 0x61, 0x74, 0x69, 0x6e,
+	0x67, 0x52, 0x07, 0x72, 0x61, 0x74, 0x69, 0x6e, 0x67, 0x73, 0x12, 0x28, 0x0a, 0x05, 0x75, 0x73,
+	0x65, 0x72, 0x73, 0x18, 0x04, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x12, 0x2e, 0x70, 0x63, 0x62, 0x6f,
+	0x6f, 0x6b, 0x2e, 0x53, 0x74, 0x6f, 0x72, 0x65, 0x64, 0x55, 0x73, 0x65, 0x72, 0x52, 0x05, 0x75,
+	0x73, 0x65, 0x72, 0x73, 0x12, 0x2b, 0x0a, 0x06, 0x69, 0x6d, 0x61, 0x67, 0x65, 0x73, 0x18, 0x05,
+	0x20, 0x03, 0x28, 0x0b, 0x32, 0x13, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x53, 0x74,
+	0x6f, 0x72, 0x65, 0x64, 0x49, 0x6d, 0x61, 0x67, 0x65, 0x52, 0x06, 0x69, 0x6d, 0x61, 0x67, 0x65,
+	0x73, 0x42, 0x27, 0x0a, 0x1d, 0x63, 0x6f, 0x6d, 0x2e, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e,
+	0x6a, 0x77, 0x61, 0x6d, 0x62, 0x75, 0x67, 0x75, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e,
+	0x70, 0x62, 0x50, 0x01, 0x5a, 0x04, 0x2e, 0x2f, 0x70, 0x62, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74,
+	0x6f, 0x33,
+}
+
+var (
+	file_wal_proto_rawDescOnce sync.Once
+	file_wal_proto_rawDescData = file_wal_proto_rawDesc
+)
+
+func file_wal_proto_rawDescGZIP() []byte {
+	file_wal_proto_rawDescOnce.Do(func() {
+		file_wal_proto_rawDescData = protoimpl.X.CompressGZIP(file_wal_proto_rawDescData)
+	})
+	return file_wal_proto_rawDescData
+}
+
+var file_wal_proto_msgTypes = make([]protoimpl.MessageInfo, 8)
+var file_wal_proto_goTypes = []interface{}{
+	(*StoredRating)(nil), // 0: pcbook.StoredRating
+	(*StoredUser)(nil),   // 1: pcbook.StoredUser
+	(*StoredImage)(nil),  // 2: pcbook.StoredImage
+	(*ImageIndex)(nil),   // 3: pcbook.ImageIndex
+	(*LaptopRated)(nil),  // 4: pcbook.LaptopRated
+	(*WALRecord)(nil),    // 5: pcbook.WALRecord
+	(*WALSnapshot)(nil),  // 6: pcbook.WALSnapshot
+	nil,                  // 7: pcbook.StoredRating.ScoresEntry
+	(*Laptop)(nil),       // 8: pcbook.Laptop
+}
+var file_wal_proto_depIdxs = []int32{
+	7,  // 0: pcbook.StoredRating.scores:type_name -> pcbook.StoredRating.ScoresEntry
+	2,  // 1: pcbook.ImageIndex.images:type_name -> pcbook.StoredImage
+	8,  // 2: pcbook.WALRecord.laptop_saved:type_name -> pcbook.Laptop
+	8,  // 3: pcbook.WALRecord.laptop_updated:type_name -> pcbook.Laptop
+	4,  // 4: pcbook.WALRecord.laptop_rated:type_name -> pcbook.LaptopRated
+	1,  // 5: pcbook.WALRecord.user_saved:type_name -> pcbook.StoredUser
+	2,  // 6: pcbook.WALRecord.image_saved:type_name -> pcbook.StoredImage
+	8,  // 7: pcbook.WALSnapshot.laptops:type_name -> pcbook.Laptop
+	0,  // 8: pcbook.WALSnapshot.ratings:type_name -> pcbook.StoredRating
+	1,  // 9: pcbook.WALSnapshot.users:type_name -> pcbook.StoredUser
+	2,  // 10: pcbook.WALSnapshot.images:type_name -> pcbook.StoredImage
+	11, // [11:11] is the sub-list for method output_type
+	11, // [11:11] is the sub-list for method input_type
+	11, // [11:11] is the sub-list for extension type_name
+	11, // [11:11] is the sub-list for extension extendee
+	0,  // [0:11] is the sub-list for field type_name
+}
+
+func init() { file_wal_proto_init() }
+func file_wal_proto_init() {
+	if File_wal_proto != nil {
+		return
+	}
+	file_laptop_service_proto_init()
+	if !protoimpl.UnsafeEnabled {
+		file_wal_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*StoredRating); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_wal_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*StoredUser); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_wal_proto_msgTypes[2].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*StoredImage); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_wal_proto_msgTypes[3].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ImageIndex); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_wal_proto_msgTypes[4].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*LaptopRated); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_wal_proto_msgTypes[5].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*WALRecord); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_wal_proto_msgTypes[6].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*WALSnapshot); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	file_wal_proto_msgTypes[5].OneofWrappers = []interface{}{
+		(*WALRecord_LaptopSaved)(nil),
+		(*WALRecord_LaptopUpdated)(nil),
+		(*WALRecord_LaptopDeleted)(nil),
+		(*WALRecord_LaptopRated)(nil),
+		(*WALRecord_UserSaved)(nil),
+		(*WALRecord_ImageSaved)(nil),
+		(*WALRecord_ImageDeleted)(nil),
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_wal_proto_rawDesc,
+			NumEnums:      0,
+			NumMessages:   8,
+			NumExtensions: 0,
+			NumServices:   0,
+		},
+		GoTypes:           file_wal_proto_goTypes,
+		DependencyIndexes: file_wal_proto_depIdxs,
+		MessageInfos:      file_wal_proto_msgTypes,
+	}.Build()
+	File_wal_proto = out.File
+	file_wal_proto_rawDesc = nil
+	file_wal_proto_goTypes = nil
+	file_wal_proto_depIdxs = nil
+}
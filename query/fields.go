@@ -0,0 +1,356 @@
+package query
+
+import (
+	"fmt"
+	"github.com/jwambugu/pcbook-grpc/protos/pb"
+	"github.com/jwambugu/pcbook-grpc/units"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// fields maps the query fields to the function that compiles their terms into the filter
+var fields = map[string]func(filter *pb.Filter, t term) error{
+	"brand": func(filter *pb.Filter, t term) error {
+		return compileString(t, &filter.Brand)
+	},
+	"name": func(filter *pb.Filter, t term) error {
+		return compileString(t, &filter.Name)
+	},
+	"gpu.brand": func(filter *pb.Filter, t term) error {
+		return compileString(t, &filter.GpuBrand)
+	},
+	"price": func(filter *pb.Filter, t term) error {
+		return compileRange(t, parseFloat, &filter.MinPriceUsd, &filter.MaxPriceUsd)
+	},
+	"screen": func(filter *pb.Filter, t term) error {
+		return compileRange(t, parseFloat32, &filter.MinScreenSizeInches, &filter.MaxScreenSizeInches)
+	},
+	"year": func(filter *pb.Filter, t term) error {
+		return compileRange(t, parseUint32, &filter.MinReleaseYear, &filter.MaxReleaseYear)
+	},
+	"cores": func(filter *pb.Filter, t term) error {
+		return compileRange(t, parseUint32, &filter.MinCpuCores, nil)
+	},
+	"cpu.freq": func(filter *pb.Filter, t term) error {
+		return compileRange(t, parseFloat, &filter.MinCpuFrequency, nil)
+	},
+	"weight": func(filter *pb.Filter, t term) error {
+		return compileRange(t, parseWeight, nil, &filter.MaxWeightKg)
+	},
+	"ram": func(filter *pb.Filter, t term) error {
+		return compileMemory(t, &filter.MinRam)
+	},
+	"gpu.memory": func(filter *pb.Filter, t term) error {
+		return compileMemory(t, &filter.MinGpuMemory)
+	},
+	"storage": func(filter *pb.Filter, t term) error {
+		return compileMemory(t, &filter.MinStorage)
+	},
+	"resolution": compileResolution,
+	"storage.driver": func(filter *pb.Filter, t term) error {
+		value, err := compileEnum(t, pb.Storage_Driver_value)
+		filter.StorageDriver = pb.Storage_Driver(value)
+		return err
+	},
+	"panel": func(filter *pb.Filter, t term) error {
+		value, err := compileEnum(t, pb.Screen_Panel_value)
+		filter.ScreenPanel = pb.Screen_Panel(value)
+		return err
+	},
+	"layout": func(filter *pb.Filter, t term) error {
+		value, err := compileEnum(t, pb.Keyboard_Layout_value)
+		filter.KeyboardLayout = pb.Keyboard_Layout(value)
+		return err
+	},
+	"touch": func(filter *pb.Filter, t term) error {
+		return compileBool(t, &filter.IsMultiTouch)
+	},
+	"backlit": func(filter *pb.Filter, t term) error {
+		return compileBool(t, &filter.IsBacklit)
+	},
+}
+
+// bound is the lower or upper bound a term constrains its field to.
+type bound struct {
+	value    string
+	strict   bool
+	position int
+}
+
+// bounds returns the lower and upper bounds of a term. A nil bound leaves that side of the field unconstrained.
+func bounds(t term) (lower, upper *bound) {
+	switch t.operator {
+	case "<":
+		return nil, &bound{value: t.value, strict: true, position: t.valuePosition}
+	case "<=":
+		return nil, &bound{value: t.value, position: t.valuePosition}
+	case ">":
+		return &bound{value: t.value, strict: true, position: t.valuePosition}, nil
+	case ">=":
+		return &bound{value: t.value, position: t.valuePosition}, nil
+	}
+
+	i := strings.Index(t.value, "..")
+	if i < 0 {
+		return &bound{value: t.value, position: t.valuePosition}, &bound{value: t.value, position: t.valuePosition}
+	}
+
+	if i > 0 {
+		lower = &bound{value: t.value[:i], position: t.valuePosition}
+	}
+
+	if i+2 < len(t.value) {
+		upper = &bound{value: t.value[i+2:], position: t.valuePosition + i + 2}
+	}
+
+	return lower, upper
+}
+
+func (b *bound) errorf(format string, args ...interface{}) *SyntaxError {
+	return &SyntaxError{Position: b.position + 1, Message: fmt.Sprintf(format, args...)}
+}
+
+// number is a numeric field type. The next function returns the closest value above (up) or below a value, turning
+// a strict bound into an inclusive one, or false if the type has no such value.
+type number interface {
+	next(up bool) (number, bool)
+}
+
+type float64Number float64
+
+func (n float64Number) next(up bool) (number, bool) {
+	if up {
+		return float64Number(math.Nextafter(float64(n), math.Inf(1))), true
+	}
+
+	return float64Number(math.Nextafter(float64(n), math.Inf(-1))), true
+}
+
+type float32Number float32
+
+func (n float32Number) next(up bool) (number, bool) {
+	if up {
+		return float32Number(math.Nextafter32(float32(n), float32(math.Inf(1)))), true
+	}
+
+	return float32Number(math.Nextafter32(float32(n), float32(math.Inf(-1)))), true
+}
+
+type uint32Number uint32
+
+func (n uint32Number) next(up bool) (number, bool) {
+	if up {
+		return n + 1, n < math.MaxUint32
+	}
+
+	return n - 1, n > 0
+}
+
+func parseFloat(value string) (number, error) {
+	n, err := strconv.ParseFloat(value, 64)
+	return float64Number(n), err
+}
+
+func parseFloat32(value string) (number, error) {
+	n, err := strconv.ParseFloat(value, 32)
+	return float32Number(n), err
+}
+
+func parseUint32(value string) (number, error) {
+	n, err := strconv.ParseUint(value, 10, 32)
+	return uint32Number(n), err
+}
+
+// parseWeight parses a weight in kilograms, converting it from pounds if it has a lb suffix.
+func parseWeight(value string) (number, error) {
+	value = strings.ToLower(value)
+	factor := 1.0
+
+	if strings.HasSuffix(value, "lb") {
+		value = strings.TrimSuffix(value, "lb")
+		factor = units.KilogramsPerPound
+	}
+
+	n, err := strconv.ParseFloat(strings.TrimSuffix(value, "kg"), 64)
+	return float64Number(n * factor), err
+}
+
+// compileRange compiles a term of a numeric field. A nil min or max means the field cannot be bounded on that side.
+func compileRange(t term, parse func(value string) (number, error), min, max interface{}) error {
+	lower, upper := bounds(t)
+
+	if lower == nil && upper == nil {
+		return t.valueErrorf("expected a range with at least one bound")
+	}
+
+	for _, b := range []struct {
+		bound  *bound
+		target interface{}
+		up     bool
+	}{{lower, min, true}, {upper, max, false}} {
+		if b.bound == nil {
+			continue
+		}
+
+		if b.target == nil {
+			side := "minimum"
+			if b.up {
+				side = "maximum"
+			}
+
+			return t.errorf("%s only supports a %s, the %s operator is not supported", t.field, side, t.operator)
+		}
+
+		n, err := parse(b.bound.value)
+		if err != nil {
+			return b.bound.errorf("invalid %s value %q", t.field, b.bound.value)
+		}
+
+		if b.bound.strict {
+			var ok bool
+
+			if n, ok = n.next(b.up); !ok {
+				return b.bound.errorf("%s%s%s matches no laptop", t.field, t.operator, b.bound.value)
+			}
+		}
+
+		switch target := b.target.(type) {
+		case **float64:
+			v := float64(n.(float64Number))
+			*target = &v
+		case **float32:
+			v := float32(n.(float32Number))
+			*target = &v
+		case **uint32:
+			v := uint32(n.(uint32Number))
+			*target = &v
+		}
+	}
+
+	return nil
+}
+
+// memoryUnits maps the memory size suffixes to their units, longest suffix first
+var memoryUnits = []struct {
+	suffix string
+	unit   pb.Memory_Unit
+}{
+	{"TB", pb.Memory_TERABYTE},
+	{"GB", pb.Memory_GIGABYTE},
+	{"MB", pb.Memory_MEGABYTE},
+	{"KB", pb.Memory_KILOBYTE},
+	{"B", pb.Memory_BYTE},
+}
+
+// compileMemory compiles a term of a memory size field, which only supports a minimum.
+func compileMemory(t term, min **pb.Memory) error {
+	lower, upper := bounds(t)
+	if upper != nil || lower == nil {
+		return t.errorf("%s only supports a minimum, the %s operator is not supported", t.field, t.operator)
+	}
+
+	value := strings.ToUpper(lower.value)
+
+	for _, memoryUnit := range memoryUnits {
+		if !strings.HasSuffix(value, memoryUnit.suffix) {
+			continue
+		}
+
+		n, err := strconv.ParseUint(strings.TrimSuffix(value, memoryUnit.suffix), 10, 64)
+		if err != nil {
+			return lower.errorf("invalid %s size %q", t.field, lower.value)
+		}
+
+		if n > units.MaxMemoryValue(memoryUnit.unit) {
+			return lower.errorf("%s size %q is too large", t.field, lower.value)
+		}
+
+		*min = &pb.Memory{Value: n, Unit: memoryUnit.unit}
+
+		if lower.strict {
+			bits := units.MemoryBits(*min)
+			if bits == math.MaxUint64 {
+				return lower.errorf("%s%s%s matches no laptop", t.field, t.operator, lower.value)
+			}
+
+			// A strict bound is one bit above the size.
+			*min = &pb.Memory{Value: bits + 1, Unit: pb.Memory_BIT}
+		}
+
+		return nil
+	}
+
+	return lower.errorf("%s size %q is missing a unit such as GB", t.field, lower.value)
+}
+
+// compileResolution compiles a screen resolution term such as resolution>=1920x1080, which only supports a minimum.
+func compileResolution(filter *pb.Filter, t term) error {
+	lower, upper := bounds(t)
+	if upper != nil || lower == nil {
+		return t.errorf("resolution only supports a minimum, the %s operator is not supported", t.operator)
+	}
+
+	parts := strings.Split(strings.ToLower(lower.value), "x")
+	if len(parts) != 2 {
+		return lower.errorf("invalid resolution %q, expected WIDTHxHEIGHT", lower.value)
+	}
+
+	width, widthErr := strconv.ParseUint(parts[0], 10, 32)
+	height, heightErr := strconv.ParseUint(parts[1], 10, 32)
+
+	if widthErr != nil || heightErr != nil {
+		return lower.errorf("invalid resolution %q, expected WIDTHxHEIGHT", lower.value)
+	}
+
+	if lower.strict {
+		if width == math.MaxUint32 || height == math.MaxUint32 {
+			return lower.errorf("resolution%s%s matches no laptop", t.operator, lower.value)
+		}
+
+		width++
+		height++
+	}
+
+	filter.MinScreenResolution = &pb.Screen_Resolution{Width: uint32(width), Height: uint32(height)}
+	return nil
+}
+
+// compileString compiles a term of a text field, which only supports matching a value.
+func compileString(t term, target *string) error {
+	if t.operator != ":" && t.operator != "=" {
+		return t.errorf("%s does not support the %s operator", t.field, t.operator)
+	}
+
+	*target = t.value
+	return nil
+}
+
+// compileEnum compiles a term of an enum field using the enum's value map, ignoring case.
+func compileEnum(t term, values map[string]int32) (int32, error) {
+	if t.operator != ":" && t.operator != "=" {
+		return 0, t.errorf("%s does not support the %s operator", t.field, t.operator)
+	}
+
+	value, ok := values[strings.ToUpper(t.value)]
+	if !ok || value == 0 {
+		return 0, t.valueErrorf("invalid %s %q", t.field, t.value)
+	}
+
+	return value, nil
+}
+
+// compileBool compiles a term of a boolean field.
+func compileBool(t term, target **bool) error {
+	if t.operator != ":" && t.operator != "=" {
+		return t.errorf("%s does not support the %s operator", t.field, t.operator)
+	}
+
+	value, err := strconv.ParseBool(t.value)
+	if err != nil {
+		return t.valueErrorf("invalid %s value %q, expected true or false", t.field, t.value)
+	}
+
+	*target = &value
+	return nil
+}
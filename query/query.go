@@ -0,0 +1,200 @@
+// Package query compiles text search queries such as
+//
+//	brand:Dell price<2000 ram>=16GB gpu.brand:NVIDIA year:2018..2019 sort:-price
+//
+// into a laptop filter and an order by specification.
+//
+// A query is a list of whitespace separated terms. Each term is a field, an operator and a value. The ":" and "="
+// operators match a value exactly, or a range of values written as "min..max". The "<", "<=", ">" and ">=" operators
+// compare numeric fields. Values containing spaces can be double quoted.
+//
+// Supported fields are brand, name, price, cores, cpu.freq, ram, gpu.brand, gpu.memory, storage, storage.driver,
+// screen, resolution, panel, touch, layout, backlit, weight, year and sort. Memory sizes take a unit suffix such as
+// 16GB, weights take a kg or lb suffix and resolutions are written as 1920x1080. The sort field takes a comma separated
+// list of price, year, cores, cpu.freq, ram, weight and rating, each prefixed with "-" to sort in descending order.
+package query
+
+import (
+	"fmt"
+	"github.com/jwambugu/pcbook-grpc/protos/pb"
+	"strings"
+)
+
+// Query is a compiled text search query.
+type Query struct {
+	// Filter holds the criteria of the query.
+	Filter *pb.Filter
+	// OrderBy is the order by specification of the query's sort field, empty if the query has none.
+	OrderBy string
+}
+
+// SyntaxError is returned when a query cannot be parsed.
+type SyntaxError struct {
+	// Position is the 1-based position in the query of the character the error was found at.
+	Position int
+	// Message describes the error.
+	Message string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("query syntax error at position %d: %s", e.Position, e.Message)
+}
+
+// term is a single field, operator and value of a query.
+type term struct {
+	field    string
+	operator string
+	value    string
+	// position is the 0-based offset of the term's field in the query.
+	position int
+	// valuePosition is the 0-based offset of the term's value in the query.
+	valuePosition int
+}
+
+func (t term) errorf(format string, args ...interface{}) *SyntaxError {
+	return &SyntaxError{Position: t.position + 1, Message: fmt.Sprintf(format, args...)}
+}
+
+func (t term) valueErrorf(format string, args ...interface{}) *SyntaxError {
+	return &SyntaxError{Position: t.valuePosition + 1, Message: fmt.Sprintf(format, args...)}
+}
+
+// Parse compiles the query into a filter and an order by specification.
+func Parse(input string) (*Query, error) {
+	terms, err := scan(input)
+	if err != nil {
+		return nil, err
+	}
+
+	query := &Query{Filter: &pb.Filter{}}
+
+	for _, t := range terms {
+		if t.field == "sort" {
+			if query.OrderBy, err = compileSort(t); err != nil {
+				return nil, err
+			}
+
+			continue
+		}
+
+		field, ok := fields[t.field]
+		if !ok {
+			return nil, t.errorf("unknown field %q", t.field)
+		}
+
+		if err := field(query.Filter, t); err != nil {
+			return nil, err
+		}
+	}
+
+	return query, nil
+}
+
+// scan splits the query into terms.
+func scan(input string) ([]term, error) {
+	var terms []term
+
+	for i := 0; i < len(input); {
+		if input[i] == ' ' || input[i] == '\t' || input[i] == '\n' {
+			i++
+			continue
+		}
+
+		t := term{position: i}
+
+		start := i
+		for i < len(input) && isFieldChar(input[i]) {
+			i++
+		}
+
+		t.field = strings.ToLower(input[start:i])
+		if t.field == "" {
+			return nil, &SyntaxError{Position: i + 1, Message: fmt.Sprintf("expected a field name, found %q", input[i])}
+		}
+
+		t.operator = scanOperator(input[i:])
+		if t.operator == "" {
+			return nil, &SyntaxError{Position: i + 1, Message: fmt.Sprintf("expected an operator after field %q", t.field)}
+		}
+
+		i += len(t.operator)
+		t.valuePosition = i
+
+		if i < len(input) && input[i] == '"' {
+			end := strings.IndexByte(input[i+1:], '"')
+			if end < 0 {
+				return nil, &SyntaxError{Position: i + 1, Message: "unterminated quoted value"}
+			}
+
+			t.value = input[i+1 : i+1+end]
+			i += end + 2
+		} else {
+			start = i
+			for i < len(input) && input[i] != ' ' && input[i] != '\t' && input[i] != '\n' {
+				i++
+			}
+
+			t.value = input[start:i]
+		}
+
+		if t.value == "" {
+			return nil, t.valueErrorf("expected a value for field %q", t.field)
+		}
+
+		terms = append(terms, t)
+	}
+
+	return terms, nil
+}
+
+func isFieldChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '.' || c == '_'
+}
+
+func scanOperator(input string) string {
+	for _, operator := range []string{"<=", ">=", ":", "=", "<", ">"} {
+		if strings.HasPrefix(input, operator) {
+			return operator
+		}
+	}
+
+	return ""
+}
+
+// sortFields maps the query sort fields to the order by fields of a search
+var sortFields = map[string]string{
+	"price":    "price_usd",
+	"year":     "release_year",
+	"cores":    "cpu_cores",
+	"cpu.freq": "cpu_frequency",
+	"ram":      "ram",
+	"weight":   "weight",
+	"rating":   "rating",
+}
+
+// compileSort compiles a sort term such as "-price,year" into an order by specification.
+func compileSort(t term) (string, error) {
+	if t.operator != ":" && t.operator != "=" {
+		return "", t.errorf("sort does not support the %s operator", t.operator)
+	}
+
+	var keys []string
+
+	for _, key := range strings.Split(t.value, ",") {
+		descending := strings.HasPrefix(key, "-")
+		key = strings.ToLower(strings.TrimPrefix(key, "-"))
+
+		field, ok := sortFields[key]
+		if !ok {
+			return "", t.valueErrorf("cannot sort by %q", key)
+		}
+
+		if descending {
+			field += " desc"
+		}
+
+		keys = append(keys, field)
+	}
+
+	return strings.Join(keys, ", "), nil
+}
@@ -0,0 +1,126 @@
+package query
+
+import (
+	"github.com/jwambugu/pcbook-grpc/protos/pb"
+	"github.com/jwambugu/pcbook-grpc/units"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"math"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+
+	weightLb := 4.4
+
+	testCases := []struct {
+		name            string
+		query           string
+		expectedFilter  *pb.Filter
+		expectedOrderBy string
+	}{
+		{
+			name:  "parses the example query",
+			query: `brand:Dell price<2000 ram>=16GB gpu.brand:NVIDIA year:2018..2019 sort:-price`,
+			expectedFilter: &pb.Filter{
+				Brand:          "Dell",
+				MaxPriceUsd:    proto.Float64(math.Nextafter(2000, 0)),
+				MinRam:         &pb.Memory{Value: 16, Unit: pb.Memory_GIGABYTE},
+				GpuBrand:       "NVIDIA",
+				MinReleaseYear: proto.Uint32(2018),
+				MaxReleaseYear: proto.Uint32(2019),
+			},
+			expectedOrderBy: "price_usd desc",
+		},
+		{
+			name:  "parses quoted values, open ranges and strict integer bounds",
+			query: `name:"Thinkpad P53" screen:14.. cores>4 cpu.freq>=3.5 weight<=4.4lb resolution>=1920x1080`,
+			expectedFilter: &pb.Filter{
+				Name:                "Thinkpad P53",
+				MinScreenSizeInches: proto.Float32(14),
+				MinCpuCores:         proto.Uint32(5),
+				MinCpuFrequency:     proto.Float64(3.5),
+				MaxWeightKg:         proto.Float64(weightLb * units.KilogramsPerPound),
+				MinScreenResolution: &pb.Screen_Resolution{Width: 1920, Height: 1080},
+			},
+		},
+		{
+			name:  "parses enums, booleans and several sort keys",
+			query: `panel:oled layout:QWERTY storage.driver:ssd storage>=1TB touch:true backlit:false sort:ram,-rating`,
+			expectedFilter: &pb.Filter{
+				ScreenPanel:    pb.Screen_OLED,
+				KeyboardLayout: pb.Keyboard_QWERTY,
+				StorageDriver:  pb.Storage_SSD,
+				MinStorage:     &pb.Memory{Value: 1, Unit: pb.Memory_TERABYTE},
+				IsMultiTouch:   proto.Bool(true),
+				IsBacklit:      proto.Bool(false),
+			},
+			expectedOrderBy: "ram, rating desc",
+		},
+		{
+			name:           "parses a strict memory bound in bits",
+			query:          `gpu.memory>1KB`,
+			expectedFilter: &pb.Filter{MinGpuMemory: &pb.Memory{Value: 1<<13 + 1, Unit: pb.Memory_BIT}},
+		},
+		{
+			name:           "parses an empty query",
+			query:          "  ",
+			expectedFilter: &pb.Filter{},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			query, err := Parse(tc.query)
+			require.NoError(t, err)
+			require.True(t, proto.Equal(tc.expectedFilter, query.Filter), "got filter %v", query.Filter)
+			require.Equal(t, tc.expectedOrderBy, query.OrderBy)
+		})
+	}
+}
+
+func TestParseSyntaxError(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name             string
+		query            string
+		expectedPosition int
+	}{
+		{name: "unknown field", query: "brand:Dell colour:red", expectedPosition: 12},
+		{name: "missing operator", query: "price 2000", expectedPosition: 6},
+		{name: "missing value", query: "price< brand:Dell", expectedPosition: 7},
+		{name: "invalid number", query: "price<abc", expectedPosition: 7},
+		{name: "invalid range upper bound", query: "year:2018..20x9", expectedPosition: 12},
+		{name: "unsupported bound", query: "cores<4", expectedPosition: 1},
+		{name: "memory without unit", query: "ram>=16", expectedPosition: 6},
+		{name: "invalid enum", query: "panel:tn", expectedPosition: 7},
+		{name: "unterminated quote", query: `name:"Thinkpad`, expectedPosition: 6},
+		{name: "invalid sort field", query: "sort:-colour", expectedPosition: 6},
+		{name: "operator without field", query: ">2000", expectedPosition: 1},
+		{name: "strict bound below the smallest value", query: "year<0", expectedPosition: 6},
+		{name: "strict bound above the largest value", query: "cores>4294967295", expectedPosition: 7},
+		{name: "strict resolution above the largest value", query: "resolution>4294967295x1080", expectedPosition: 12},
+		{name: "memory size overflowing bits", query: "ram>=2097152TB", expectedPosition: 6},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			query, err := Parse(tc.query)
+			require.Nil(t, query)
+
+			syntaxError, ok := err.(*SyntaxError)
+			require.True(t, ok, "expected a syntax error, got %v", err)
+			require.Equal(t, tc.expectedPosition, syntaxError.Position, syntaxError.Error())
+		})
+	}
+}
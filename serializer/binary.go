@@ -0,0 +1,25 @@
+package serializer
+
+import (
+	"fmt"
+	"google.golang.org/protobuf/proto"
+)
+
+// ProtobufToBinary converts a protobuf message to its binary wire format.
+func ProtobufToBinary(message proto.Message) ([]byte, error) {
+	data, err := proto.Marshal(message)
+	if err != nil {
+		return nil, fmt.Errorf("cannot serialize proto message to binary: %w", err)
+	}
+
+	return data, nil
+}
+
+// BinaryToProtobuf parses the binary wire format of a protobuf message into the message.
+func BinaryToProtobuf(data []byte, message proto.Message) error {
+	if err := proto.Unmarshal(data, message); err != nil {
+		return fmt.Errorf("cannot serialize binary to proto message: %w", err)
+	}
+
+	return nil
+}
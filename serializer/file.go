@@ -9,9 +9,9 @@ import (
 
 // WriteProtobufToBinaryFile writes protocol buffer message to binary file
 func WriteProtobufToBinaryFile(message proto.Message, filename string) error {
-	data, err := proto.Marshal(message)
+	data, err := ProtobufToBinary(message)
 	if err != nil {
-		return fmt.Errorf("cannot serialize proto message to binary: %w", err)
+		return err
 	}
 
 	if err := os.WriteFile(filename, data, 0644); err != nil {
@@ -28,16 +28,14 @@ func ReadProtobufFromBinaryFile(filename string, message proto.Message) error {
 		return fmt.Errorf("cannot open binary file: %w", err)
 	}
 
+	defer file.Close()
+
 	data, err := io.ReadAll(file)
 	if err != nil {
 		return fmt.Errorf("cannot read binary file: %w", err)
 	}
 
-	if err := proto.Unmarshal(data, message); err != nil {
-		return fmt.Errorf("cannot serialize binary to proto message: %w", err)
-	}
-
-	return nil
+	return BinaryToProtobuf(data, message)
 }
 
 // WriteProtobufToJSONFile writes protocol buffer message to JSON file
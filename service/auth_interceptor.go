@@ -7,6 +7,7 @@ import (
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 	"log"
+	"net/http"
 )
 
 // AuthInterceptor is a server interceptor for authentication and authorization.
@@ -15,6 +16,27 @@ type AuthInterceptor struct {
 	accessibleRoles map[string][]string
 }
 
+// userClaimsKey is the context key of the claims of the user who made a call
+type userClaimsKey struct{}
+
+// authServerStream is a server stream whose context carries the claims of the user who made the call
+type authServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+// Context returns the context of the stream with the claims of the user.
+func (stream *authServerStream) Context() context.Context {
+	return stream.ctx
+}
+
+// UserClaimsFromContext returns the claims of the user who made a call, which the AuthInterceptor adds to the context
+// of the calls it authorized with an access token.
+func UserClaimsFromContext(ctx context.Context) (*UserClaims, bool) {
+	claims, ok := ctx.Value(userClaimsKey{}).(*UserClaims)
+	return claims, ok
+}
+
 // NewAuthInterceptor creates a new AuthInterceptor.
 func NewAuthInterceptor(jwtManager *JWTManager, accessibleRoles map[string][]string) *AuthInterceptor {
 	return &AuthInterceptor{
@@ -23,36 +45,37 @@ func NewAuthInterceptor(jwtManager *JWTManager, accessibleRoles map[string][]str
 	}
 }
 
-func (i *AuthInterceptor) authorize(ctx context.Context, method string) error {
+// authorize returns the claims of the access token of the call, or nil if the method is accessible without one
+func (i *AuthInterceptor) authorize(ctx context.Context, method string) (*UserClaims, error) {
 	roles, ok := i.accessibleRoles[method]
 	if !ok {
 		// Method is accessible by all users.
-		return nil
+		return nil, nil
 	}
 
 	ctxMetadata, ok := metadata.FromIncomingContext(ctx)
 	if !ok {
-		return status.Errorf(codes.Unauthenticated, "missing metadata")
+		return nil, status.Errorf(codes.Unauthenticated, "missing metadata")
 	}
 
 	values := ctxMetadata["authorization"]
 	if len(values) == 0 {
-		return status.Errorf(codes.Unauthenticated, "missing authorization token")
+		return nil, status.Errorf(codes.Unauthenticated, "missing authorization token")
 	}
 
 	accessToken := values[0]
 	claims, err := i.jwtManager.Verify(accessToken)
 	if err != nil {
-		return status.Errorf(codes.Unauthenticated, "invalid access token provided: %v", err)
+		return nil, status.Errorf(codes.Unauthenticated, "invalid access token provided: %v", err)
 	}
 
 	for _, role := range roles {
 		if role == claims.Role {
-			return nil
+			return claims, nil
 		}
 	}
 
-	return status.Errorf(codes.PermissionDenied, "not authorized to access %q", method)
+	return nil, status.Errorf(codes.PermissionDenied, "not authorized to access %q", method)
 }
 
 // Unary returns a new unary server interceptor for authentication and authorization of unary RPC calls.
@@ -65,10 +88,15 @@ func (i *AuthInterceptor) Unary() grpc.UnaryServerInterceptor {
 		log.Printf("[*] unaryInterceptor(_) %v", info.FullMethod)
 
 		// Check if the method is accessible by the user.
-		if err := i.authorize(ctx, info.FullMethod); err != nil {
+		claims, err := i.authorize(ctx, info.FullMethod)
+		if err != nil {
 			return nil, err
 		}
 
+		if claims != nil {
+			ctx = context.WithValue(ctx, userClaimsKey{}, claims)
+		}
+
 		return handler(ctx, req)
 	}
 }
@@ -79,9 +107,50 @@ func (i *AuthInterceptor) Stream() grpc.StreamServerInterceptor {
 		log.Printf("[*] streamInterceptor(_) %v", info.FullMethod)
 
 		// Check if the method is accessible by the user.
-		if err := i.authorize(ss.Context(), info.FullMethod); err != nil {
+		claims, err := i.authorize(ss.Context(), info.FullMethod)
+		if err != nil {
 			return err
 		}
+
+		if claims != nil {
+			ss = &authServerStream{ServerStream: ss, ctx: context.WithValue(ss.Context(), userClaimsKey{}, claims)}
+		}
+
 		return handler(srv, ss)
 	}
 }
+
+// HTTP returns a handler of the REST gateway that authorizes the requests of a handler serving the method outside the
+// gRPC server as calls of the method, with the access token of their Authorization header.
+func (i *AuthInterceptor) HTTP(
+	method string, handler func(w http.ResponseWriter, r *http.Request, pathParams map[string]string),
+) func(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+	return func(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+		log.Printf("[*] httpInterceptor(_) %v", method)
+
+		ctxMetadata := metadata.MD{}
+		if accessToken := r.Header.Get("Authorization"); accessToken != "" {
+			ctxMetadata.Set("authorization", accessToken)
+		}
+
+		ctx := metadata.NewIncomingContext(r.Context(), ctxMetadata)
+
+		// Check if the method is accessible by the user.
+		claims, err := i.authorize(ctx, method)
+		if err != nil {
+			code := http.StatusUnauthorized
+			if status.Code(err) == codes.PermissionDenied {
+				code = http.StatusForbidden
+			}
+
+			http.Error(w, status.Convert(err).Message(), code)
+			return
+		}
+
+		if claims != nil {
+			r = r.WithContext(context.WithValue(r.Context(), userClaimsKey{}, claims))
+		}
+
+		handler(w, r, pathParams)
+	}
+}
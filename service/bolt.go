@@ -0,0 +1,96 @@
+package service
+
+import (
+	"encoding/binary"
+	"fmt"
+	bolt "go.etcd.io/bbolt"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var (
+	// boltLaptopsBucket maps laptop ids to their protobuf bytes
+	boltLaptopsBucket = []byte("laptops")
+	// boltTermsBucket indexes the text of the laptops, mapping a term and a laptop id to the term's frequency
+	boltTermsBucket = []byte("laptop_terms")
+	// boltRatingsBucket maps a laptop id and a username to the bits of the score the user rated the laptop
+	boltRatingsBucket = []byte("ratings")
+)
+
+// boltIndexBucket returns the name of the secondary index bucket of one of the indexedFields. Its keys are the
+// indexed value followed by the laptop id, its values empty.
+func boltIndexBucket(field string) []byte {
+	return []byte("laptops_by_" + field)
+}
+
+// OpenBolt opens the bbolt database at the path, creating it and its buckets if needed
+func OpenBolt(path string) (*bolt.DB, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("error creating bolt database directory: %v", err)
+	}
+
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("error opening bolt database: %v", err)
+	}
+
+	buckets := [][]byte{boltLaptopsBucket, boltTermsBucket, boltRatingsBucket}
+	for _, field := range indexedFields {
+		buckets = append(buckets, boltIndexBucket(field))
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range buckets {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return fmt.Errorf("error creating bolt bucket %s: %v", bucket, err)
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// boltSortableFloat encodes the value so that encodings sort bytewise in the order of the values
+func boltSortableFloat(value float64) []byte {
+	bits := math.Float64bits(value)
+
+	if bits&(1<<63) != 0 {
+		bits = ^bits
+	} else {
+		bits |= 1 << 63
+	}
+
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, bits)
+	return key
+}
+
+// boltIndexKey returns the key of the laptop in an index of the value
+func boltIndexKey(value float64, id string) []byte {
+	return append(boltSortableFloat(value), id...)
+}
+
+// boltTermKey returns the key of the laptop in the text index of the term. Terms never contain a zero byte.
+func boltTermKey(term, id string) []byte {
+	key := make([]byte, 0, len(term)+1+len(id))
+	key = append(key, term...)
+	key = append(key, 0)
+	return append(key, id...)
+}
+
+// boltRatingKey returns the key of the score a user rated a laptop. Laptop ids never contain a zero byte.
+func boltRatingKey(laptopID, username string) []byte {
+	key := make([]byte, 0, len(laptopID)+1+len(username))
+	key = append(key, laptopID...)
+	key = append(key, 0)
+	return append(key, username...)
+}
@@ -0,0 +1,95 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"google.golang.org/grpc/metadata"
+	"strconv"
+	"strings"
+)
+
+// ifMatchMetadataKey is the metadata key the REST gateway forwards the If-Match header under.
+const ifMatchMetadataKey = "grpcgateway-if-match"
+
+// FormatETag formats a record revision as an HTTP entity tag.
+func FormatETag(revision uint64) string {
+	return strconv.Quote(strconv.FormatUint(revision, 10))
+}
+
+// parseIfMatch parses an If-Match header value, a comma separated list of entity tags created by FormatETag or a
+// wildcard, as defined by RFC 9110. A wildcard matches any revision and returns zero. Since a write can only be based
+// on one revision, a list naming more than one revision is rejected, as are weak tags which never match.
+func parseIfMatch(header string) (uint64, error) {
+	header = strings.TrimSpace(header)
+	if header == "*" {
+		return 0, nil
+	}
+
+	var (
+		revision uint64
+		found    bool
+	)
+
+	for rest := header; rest != ""; {
+		var etag string
+
+		switch {
+		case strings.HasPrefix(rest, ","):
+			rest = strings.TrimLeft(rest[1:], " \t")
+			continue
+		case strings.HasPrefix(rest, "W/"):
+			return 0, fmt.Errorf("weak etag in %s never matches", header)
+		case strings.HasPrefix(rest, `"`):
+			end := strings.IndexByte(rest[1:], '"')
+			if end < 0 {
+				return 0, fmt.Errorf("invalid etag list %s", header)
+			}
+
+			etag, rest = rest[:end+2], strings.TrimLeft(rest[end+2:], " \t")
+		default:
+			return 0, fmt.Errorf("invalid etag list %s", header)
+		}
+
+		if rest != "" && !strings.HasPrefix(rest, ",") {
+			return 0, fmt.Errorf("invalid etag list %s", header)
+		}
+
+		tagRevision, err := strconv.ParseUint(etag[1:len(etag)-1], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid etag %s", etag)
+		}
+
+		if found && tagRevision != revision {
+			return 0, fmt.Errorf("etag list %s names more than one revision", header)
+		}
+
+		revision, found = tagRevision, true
+	}
+
+	if !found {
+		return 0, fmt.Errorf("empty etag list")
+	}
+
+	return revision, nil
+}
+
+// expectedRevision returns the revision a write is based on, falling back to the If-Match header forwarded by the
+// REST gateway when the request does not carry one.
+func expectedRevision(ctx context.Context, revision uint64) (uint64, error) {
+	if revision != 0 {
+		return revision, nil
+	}
+
+	ctxMetadata, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return 0, nil
+	}
+
+	values := ctxMetadata[ifMatchMetadataKey]
+	if len(values) == 0 {
+		return 0, nil
+	}
+
+	// A header repeated on several lines is equivalent to a single comma separated list.
+	return parseIfMatch(strings.Join(values, ","))
+}
@@ -0,0 +1,46 @@
+package service
+
+import (
+	"fmt"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"strings"
+)
+
+// applyFieldMask copies the fields listed in the mask from src into dst. A field listed in the mask but unset in src
+// is cleared on dst. An empty mask copies every field.
+func applyFieldMask(dst, src proto.Message, mask *fieldmaskpb.FieldMask) error {
+	if len(mask.GetPaths()) == 0 {
+		proto.Reset(dst)
+		proto.Merge(dst, src)
+		return nil
+	}
+
+	if !mask.IsValid(dst) {
+		return fmt.Errorf("invalid field mask: %v", mask.GetPaths())
+	}
+
+	for _, path := range mask.GetPaths() {
+		applyFieldPath(dst.ProtoReflect(), src.ProtoReflect(), strings.Split(path, "."))
+	}
+
+	return nil
+}
+
+// applyFieldPath copies the field identified by the path segments from src into dst.
+func applyFieldPath(dst, src protoreflect.Message, path []string) {
+	field := dst.Descriptor().Fields().ByName(protoreflect.Name(path[0]))
+
+	if len(path) == 1 {
+		if src.Has(field) {
+			dst.Set(field, src.Get(field))
+			return
+		}
+
+		dst.Clear(field)
+		return
+	}
+
+	applyFieldPath(dst.Mutable(field).Message(), src.Get(field).Message(), path[1:])
+}
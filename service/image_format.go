@@ -0,0 +1,314 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	_ "golang.org/x/image/webp" // registers the WebP decoder
+	"image"
+	_ "image/gif"  // registers the GIF decoder
+	_ "image/jpeg" // registers the JPEG decoder
+	_ "image/png"  // registers the PNG decoder
+	"strings"
+)
+
+const (
+	// imageSniffSize is the number of bytes needed to tell the format of an image
+	imageSniffSize = 12
+	// maxImageHeaderSize is the number of bytes of an image read at most to find its dimensions
+	maxImageHeaderSize = 256 << 10 // 256KB
+)
+
+// ErrInvalidImage is returned when uploaded data is not an image of a supported format or does not match the format
+// its extension names.
+var ErrInvalidImage = errors.New("invalid image")
+
+// imageFormat is a format of the images that can be uploaded
+type imageFormat struct {
+	name        string
+	extension   string
+	contentType string
+	magic       func(header []byte) bool
+}
+
+// imageFormats are the formats of the images that can be uploaded
+var imageFormats = []*imageFormat{
+	{
+		name:        "jpeg",
+		extension:   ".jpg",
+		contentType: "image/jpeg",
+		magic: func(header []byte) bool {
+			return bytes.HasPrefix(header, []byte("\xff\xd8\xff"))
+		},
+	},
+	{
+		name:        "png",
+		extension:   ".png",
+		contentType: "image/png",
+		magic: func(header []byte) bool {
+			return bytes.HasPrefix(header, []byte("\x89PNG\r\n\x1a\n"))
+		},
+	},
+	{
+		name:        "gif",
+		extension:   ".gif",
+		contentType: "image/gif",
+		magic: func(header []byte) bool {
+			return bytes.HasPrefix(header, []byte("GIF87a")) || bytes.HasPrefix(header, []byte("GIF89a"))
+		},
+	},
+	{
+		name:        "webp",
+		extension:   ".webp",
+		contentType: "image/webp",
+		magic: func(header []byte) bool {
+			return bytes.HasPrefix(header, []byte("RIFF")) && bytes.Equal(header[8:12], []byte("WEBP"))
+		},
+	},
+}
+
+// imageFormatByExtension returns the format named by the file extension, or nil if it names none
+func imageFormatByExtension(extension string) *imageFormat {
+	extension = strings.ToLower(extension)
+	if !strings.HasPrefix(extension, ".") {
+		extension = "." + extension
+	}
+
+	if extension == ".jpeg" {
+		extension = ".jpg"
+	}
+
+	for _, format := range imageFormats {
+		if format.extension == extension {
+			return format
+		}
+	}
+
+	return nil
+}
+
+// sniffImageFormat returns the format of the image starting with the header, or nil if it is not a supported image
+func sniffImageFormat(header []byte) *imageFormat {
+	if len(header) < imageSniffSize {
+		return nil
+	}
+
+	for _, format := range imageFormats {
+		if format.magic(header) {
+			return format
+		}
+	}
+
+	return nil
+}
+
+// checkImageExtension checks that the extension sent with an upload is empty or names a supported format, and
+// returns that format
+func checkImageExtension(extension string) (*imageFormat, error) {
+	if extension == "" {
+		return nil, nil
+	}
+
+	format := imageFormatByExtension(extension)
+	if format == nil {
+		return nil, fmt.Errorf("%w: unsupported extension %q", ErrInvalidImage, extension)
+	}
+
+	return format, nil
+}
+
+// imageHeader collects the start of an uploaded image until its format and dimensions are known
+type imageHeader struct {
+	expected *imageFormat
+	format   *imageFormat
+	data     []byte
+	width    int
+	height   int
+	decoded  bool
+	// nextDecode is the size of the data at which decoding the dimensions is tried next
+	nextDecode int
+	// jpegMetadata drops the metadata of a JPEG image before it is collected
+	jpegMetadata *jpegMetadataFilter
+}
+
+// jpegMetadataFilter drops the application and comment segments of a JPEG image as it is written, which hold
+// metadata such as EXIF, ICC profiles and thumbnails that can be far larger than maxImageHeaderSize, and keeps the
+// segments its dimensions are decoded from.
+type jpegMetadataFilter struct {
+	// marker holds the bytes read of the marker and length of the next segment
+	marker []byte
+	// keep and skip are the numbers of bytes left of the segment being kept or dropped
+	keep int
+	skip int
+	// invalid is set once the data is not a sequence of segments, it is then kept for the decoder to reject
+	invalid bool
+}
+
+// newImageHeader creates a new imageHeader of an image whose extension names the expected format, if any
+func newImageHeader(expected *imageFormat) *imageHeader {
+	return &imageHeader{expected: expected}
+}
+
+// write adds the next part of the image, failing with ErrInvalidImage as soon as the data is known not to be an
+// image of the expected format.
+func (header *imageHeader) write(p []byte) error {
+	if header.decoded {
+		return nil
+	}
+
+	// The data is collected whole until its format is known, as the metadata of a JPEG image is dropped.
+	if header.format == nil {
+		header.data = append(header.data, p...)
+
+		if len(header.data) < imageSniffSize {
+			return nil
+		}
+
+		if err := header.sniff(); err != nil {
+			return err
+		}
+
+		p, header.data = header.data, nil
+	}
+
+	if header.jpegMetadata != nil {
+		p = header.jpegMetadata.filter(p)
+	}
+
+	if free := maxImageHeaderSize - len(header.data); len(p) > free {
+		p = p[:free]
+	}
+
+	header.data = append(header.data, p...)
+
+	// The dimensions are read as soon as the header holds them, until then the decoder runs out of data. As decoding
+	// reads the header from its start, it is only tried again once the header doubled.
+	if len(header.data) < header.nextDecode && len(header.data) < maxImageHeaderSize {
+		return nil
+	}
+
+	err := header.decode()
+	if err != nil && len(header.data) == maxImageHeaderSize {
+		return err
+	}
+
+	header.nextDecode = 2 * len(header.data)
+
+	return nil
+}
+
+// sniff finds the format of the image from the first bytes of its data
+func (header *imageHeader) sniff() error {
+	format := sniffImageFormat(header.data)
+	if format == nil {
+		return fmt.Errorf("%w: the data is not a JPEG, PNG, WebP or GIF image", ErrInvalidImage)
+	}
+
+	if header.expected != nil && header.expected != format {
+		return fmt.Errorf(
+			"%w: the %s extension does not match the %s data", ErrInvalidImage, header.expected.extension, format.name,
+		)
+	}
+
+	header.format = format
+
+	if format.name == "jpeg" {
+		// The start of image marker has no length.
+		header.jpegMetadata = &jpegMetadataFilter{keep: 2}
+	}
+
+	return nil
+}
+
+// decode reads the dimensions of the image from its header
+func (header *imageHeader) decode() error {
+	config, name, err := image.DecodeConfig(bytes.NewReader(header.data))
+	if err != nil {
+		return fmt.Errorf("%w: failed to read the %s header: %v", ErrInvalidImage, header.format.name, err)
+	}
+
+	if name != header.format.name {
+		return fmt.Errorf("%w: the %s data decodes as %s", ErrInvalidImage, header.format.name, name)
+	}
+
+	header.width = config.Width
+	header.height = config.Height
+	header.decoded = true
+	header.data = nil
+
+	return nil
+}
+
+// filter returns the bytes of the next part of the image that are not metadata
+func (filter *jpegMetadataFilter) filter(p []byte) []byte {
+	var kept []byte
+
+	for len(p) > 0 {
+		switch {
+		case filter.invalid:
+			return append(kept, p...)
+		case filter.skip > 0:
+			n := filter.skip
+			if n > len(p) {
+				n = len(p)
+			}
+
+			filter.skip -= n
+			p = p[n:]
+		case filter.keep > 0:
+			n := filter.keep
+			if n > len(p) {
+				n = len(p)
+			}
+
+			kept = append(kept, p[:n]...)
+			filter.keep -= n
+			p = p[n:]
+		default:
+			filter.marker = append(filter.marker, p[0])
+			p = p[1:]
+
+			if len(filter.marker) < 4 {
+				continue
+			}
+
+			marker := filter.marker
+			filter.marker = nil
+
+			length := int(marker[2])<<8 | int(marker[3])
+			if marker[0] != 0xff || marker[1] == 0xff || length < 2 {
+				filter.invalid = true
+				kept = append(kept, marker...)
+
+				continue
+			}
+
+			// APP0 and APP14 are kept, as the decoder reads the color model from them.
+			if (marker[1] >= 0xe1 && marker[1] <= 0xef && marker[1] != 0xee) || marker[1] == 0xfe {
+				filter.skip = length - 2
+			} else {
+				kept = append(kept, marker...)
+				filter.keep = length - 2
+			}
+		}
+	}
+
+	return kept
+}
+
+// finish checks that the whole image was a valid image and returns its format
+func (header *imageHeader) finish() (*imageFormat, error) {
+	if header.format == nil {
+		if err := header.sniff(); err != nil {
+			return nil, err
+		}
+	}
+
+	if !header.decoded {
+		if err := header.decode(); err != nil {
+			return nil, err
+		}
+	}
+
+	return header.format, nil
+}
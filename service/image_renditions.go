@@ -0,0 +1,313 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jwambugu/pcbook-grpc/protos/pb"
+	"golang.org/x/image/draw"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"sync"
+)
+
+const (
+	// DefaultImageRenditionWorkers is the number of images the server resizes at once when it is not given a number
+	DefaultImageRenditionWorkers = 2
+	// DefaultImageRenditionQueueSize is the number of uploaded images waiting to be resized the server keeps at most
+	DefaultImageRenditionQueueSize = 100
+
+	// maxRenditionSourcePixels is the number of pixels of the largest image that is resized, as its decoded pixels
+	// are held in memory
+	maxRenditionSourcePixels = 50 << 20
+	// renditionJPEGQuality is the quality of the JPEG renditions
+	renditionJPEGQuality = 85
+)
+
+var (
+	// ErrRenditionQueueFull is returned when an uploaded image cannot be queued to be resized.
+	ErrRenditionQueueFull = errors.New("image rendition queue is full")
+	// ErrImageRendererClosed is returned when an uploaded image is queued after the renderer was closed.
+	ErrImageRendererClosed = errors.New("image renderer is closed")
+)
+
+// imageRendition is a size uploaded images are resized to
+type imageRendition struct {
+	name string
+	// size is the length of the longest side of the resized image, smaller images are not enlarged
+	size int
+}
+
+// imageRenditions are the sizes every uploaded image is resized to
+var imageRenditions = []*imageRendition{
+	{name: "small", size: 160},
+	{name: "medium", size: 480},
+	{name: "large", size: 1024},
+}
+
+// ImageRenderer generates the renditions of uploaded images in the background with a fixed number of workers.
+type ImageRenderer struct {
+	imageStore ImageStore
+	jobs       chan string
+	wg         sync.WaitGroup
+
+	mutex   sync.Mutex
+	pending map[string]bool
+	closed  bool
+	// dequeued is signalled when a worker finished an image or the renderer is closed
+	dequeued *sync.Cond
+}
+
+// NewImageRenderer creates a new ImageRenderer that resizes the images of the store with the number of workers,
+// queueing at most queueSize images.
+func NewImageRenderer(imageStore ImageStore, workers, queueSize int) *ImageRenderer {
+	renderer := &ImageRenderer{
+		imageStore: imageStore,
+		jobs:       make(chan string, queueSize),
+		pending:    make(map[string]bool),
+	}
+
+	renderer.dequeued = sync.NewCond(&renderer.mutex)
+	renderer.wg.Add(workers)
+
+	for i := 0; i < workers; i++ {
+		go renderer.work()
+	}
+
+	return renderer
+}
+
+// Enqueue queues an uploaded image to be resized. An image that cannot be queued is marked as failed.
+func (renderer *ImageRenderer) Enqueue(imageID string) {
+	if err := renderer.queue(imageID); err != nil {
+		renderer.fail(imageID, err)
+	}
+}
+
+// Resume queues an uploaded image whose renditions were not generated, such as an image still queued when the server
+// stopped. Unlike Enqueue, it waits for room in the queue instead of failing the image when the queue is full.
+func (renderer *ImageRenderer) Resume(imageID string) error {
+	renderer.mutex.Lock()
+	defer renderer.mutex.Unlock()
+
+	// A queue without a buffer only takes images a worker is waiting for, so there is no room to wait for.
+	for !renderer.closed && cap(renderer.jobs) > 0 && len(renderer.jobs) == cap(renderer.jobs) {
+		renderer.dequeued.Wait()
+	}
+
+	if renderer.pending[imageID] {
+		return nil
+	}
+
+	return renderer.push(imageID)
+}
+
+// queue adds an image to the queue unless the queue is full or closed
+func (renderer *ImageRenderer) queue(imageID string) error {
+	renderer.mutex.Lock()
+	defer renderer.mutex.Unlock()
+
+	return renderer.push(imageID)
+}
+
+// push adds an image to the queue unless the queue is full or closed. The caller holds the mutex.
+func (renderer *ImageRenderer) push(imageID string) error {
+	if renderer.closed {
+		return ErrImageRendererClosed
+	}
+
+	// The image is marked as pending before a worker can take it, which needs the mutex to unmark it.
+	select {
+	case renderer.jobs <- imageID:
+		renderer.pending[imageID] = true
+		return nil
+	default:
+		return ErrRenditionQueueFull
+	}
+}
+
+// fail records why the renditions of an image could not be generated
+func (renderer *ImageRenderer) fail(imageID string, err error) {
+	log.Printf("failed to generate image %s renditions: %v", imageID, err)
+
+	if err := renderer.imageStore.SetRenditionError(imageID, err.Error()); err != nil {
+		log.Printf("failed to save image %s rendition error: %v", imageID, err)
+	}
+}
+
+// Pending tells whether the renditions of an image are queued or being generated.
+func (renderer *ImageRenderer) Pending(imageID string) bool {
+	renderer.mutex.Lock()
+	defer renderer.mutex.Unlock()
+
+	return renderer.pending[imageID]
+}
+
+// Close stops queueing images and waits for the queued ones to be resized.
+func (renderer *ImageRenderer) Close() {
+	renderer.mutex.Lock()
+	if !renderer.closed {
+		renderer.closed = true
+		close(renderer.jobs)
+		renderer.dequeued.Broadcast()
+	}
+	renderer.mutex.Unlock()
+
+	renderer.wg.Wait()
+}
+
+// work generates the renditions of the queued images until the queue is closed
+func (renderer *ImageRenderer) work() {
+	defer renderer.wg.Done()
+
+	for imageID := range renderer.jobs {
+		err := renderer.render(imageID)
+
+		// An image deleted before it was resized needs no renditions.
+		if err != nil && !errors.Is(err, ErrRecordNotFound) {
+			renderer.fail(imageID, err)
+		}
+
+		renderer.mutex.Lock()
+		delete(renderer.pending, imageID)
+		renderer.dequeued.Broadcast()
+		renderer.mutex.Unlock()
+	}
+}
+
+// render stores every rendition of an image
+func (renderer *ImageRenderer) render(imageID string) error {
+	info, imageData, err := renderer.imageStore.Open(imageID)
+	if err != nil {
+		return err
+	}
+
+	defer imageData.Close()
+
+	if info.Width*info.Height > maxRenditionSourcePixels {
+		return fmt.Errorf("the %dx%d image is too large to resize", info.Width, info.Height)
+	}
+
+	source, _, err := image.Decode(imageData)
+	if err != nil {
+		return fmt.Errorf("failed to decode image: %v", err)
+	}
+
+	extension := renditionExtension(info.Extension, source)
+
+	images, err := renderer.imageStore.List(info.LaptopID)
+	if err != nil {
+		return fmt.Errorf("failed to list image renditions: %w", err)
+	}
+
+	// Renditions stored before the server stopped are kept.
+	stored := make(map[string]bool)
+	for _, renditionInfo := range images {
+		if renditionInfo.SourceID == imageID {
+			stored[renditionInfo.Rendition] = true
+		}
+	}
+
+	for _, rendition := range imageRenditions {
+		if stored[rendition.name] {
+			continue
+		}
+
+		if err := renderer.save(imageID, rendition, extension, resizeImage(source, rendition.size)); err != nil {
+			return fmt.Errorf("failed to save %s rendition: %w", rendition.name, err)
+		}
+	}
+
+	return nil
+}
+
+// save encodes a rendition of an image in the format of the extension and stores it
+func (renderer *ImageRenderer) save(
+	imageID string, rendition *imageRendition, extension string, img image.Image,
+) error {
+	imageWriter, err := renderer.imageStore.CreateRendition(imageID, rendition.name, extension)
+	if err != nil {
+		return err
+	}
+
+	defer imageWriter.Abort()
+
+	if err := encodeImage(imageWriter, img, extension); err != nil {
+		return err
+	}
+
+	_, err = imageWriter.Commit()
+	return err
+}
+
+// renditionExtension returns the extension of the format the renditions of an image with the extension are encoded in.
+// Photos are resized to JPEG, drawings and images that may be transparent to PNG. WebP images are either, so they are
+// resized to JPEG unless the decoded source has transparent pixels.
+func renditionExtension(extension string, source image.Image) string {
+	switch extension {
+	case ".jpg":
+		return ".jpg"
+	case ".webp":
+		if opaque, ok := source.(interface{ Opaque() bool }); ok && opaque.Opaque() {
+			return ".jpg"
+		}
+	}
+
+	return ".png"
+}
+
+// resizeImage scales the image down so that its longest side is at most size pixels
+func resizeImage(img image.Image, size int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	if width <= size && height <= size {
+		return img
+	}
+
+	if width >= height {
+		height = height * size / width
+		width = size
+	} else {
+		width = width * size / height
+		height = size
+	}
+
+	if width < 1 {
+		width = 1
+	}
+
+	if height < 1 {
+		height = 1
+	}
+
+	resized := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(resized, resized.Bounds(), img, bounds, draw.Src, nil)
+
+	return resized
+}
+
+// encodeImage writes the image in the format of the extension - (.jpg/.png)
+func encodeImage(w io.Writer, img image.Image, extension string) error {
+	if extension == ".jpg" {
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: renditionJPEGQuality})
+	}
+
+	return png.Encode(w, img)
+}
+
+// renditionStatus returns whether the renditions of an uploaded image were generated
+func renditionStatus(info *ImageInfo, renditions []*ImageInfo, pending bool) pb.ImageDetails_RenditionStatus {
+	switch {
+	case info.RenditionError != "":
+		return pb.ImageDetails_FAILED
+	case len(renditions) == len(imageRenditions):
+		return pb.ImageDetails_READY
+	case pending:
+		return pb.ImageDetails_PENDING
+	default:
+		return pb.ImageDetails_NONE
+	}
+}
@@ -1,70 +1,655 @@
 package service
 
 import (
-	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"github.com/google/uuid"
+	"github.com/jwambugu/pcbook-grpc/protos/pb"
+	"github.com/jwambugu/pcbook-grpc/serializer"
+	"hash"
+	"io"
+	"mime"
 	"os"
+	"path/filepath"
+	"sort"
 	"sync"
 )
 
+const (
+	// diskImageIndexFileName is the name of the file the DiskImageStore keeps the metadata of its images in
+	diskImageIndexFileName = "images.index"
+	// diskImageUploadPattern is the pattern of the names of the files the DiskImageStore writes new images to
+	diskImageUploadPattern = "*.upload"
+)
+
+// ErrImageWriterClosed is returned when an image writer is used after it was committed or aborted.
+var ErrImageWriterClosed = errors.New("image writer is closed")
+
 // ImageStore is an interface for storing laptop images.
 type ImageStore interface {
-	// Save stores the image in the store.
-	Save(laptopID, extension string, imageData bytes.Buffer) (string, error)
+	// Create starts writing a new image of a laptop, which is only stored once the returned writer is committed.
+	Create(laptopID, extension string) (ImageWriter, error)
+	// Find returns the metadata of an image, or nil if there is none.
+	Find(imageID string) (*ImageInfo, error)
+	// Open returns the metadata of an image and a reader of its data, which the caller closes.
+	Open(imageID string) (*ImageInfo, io.ReadCloser, error)
+	// List returns the metadata of the images of a laptop ordered by their id.
+	List(laptopID string) ([]*ImageInfo, error)
+	// Delete removes an image from the store together with its renditions.
+	Delete(imageID string) error
+	// CreateRendition starts writing a resized version of an image, which is only stored once the returned writer is
+	// committed and the image still exists.
+	CreateRendition(sourceID, rendition, extension string) (ImageWriter, error)
+	// SetRenditionError records why the renditions of an image could not be generated.
+	SetRenditionError(imageID, message string) error
+}
+
+// ImageWriter writes the data of a new image.
+type ImageWriter interface {
+	io.Writer
+	// Commit stores the written image and returns its id.
+	Commit() (string, error)
+	// Abort discards the written image. It does nothing once the image is committed.
+	Abort() error
+	// Deduplicated tells whether the committed image has the same data as an image stored before, which it shares.
+	Deduplicated() bool
+	// Checksum returns the hex encoded SHA-256 checksum of the data written so far.
+	Checksum() string
 }
 
 type (
 	// ImageInfo stores information about an image.
 	ImageInfo struct {
+		ID        string
 		LaptopID  string
 		Extension string
 		Path      string
+		Size      int64
+		Width     int
+		Height    int
+		// SourceID is the id of the image a rendition was generated from, it is empty for uploaded images.
+		SourceID string
+		// Rendition is the name of the size of a rendition.
+		Rendition string
+		// RenditionError tells why the renditions of an uploaded image could not be generated.
+		RenditionError string
+		// SHA256 is the hex encoded SHA-256 checksum of the image data.
+		SHA256 string
 	}
 
-	// DiskImageStore stores images on disk and images info on memory.
+	// DiskImageStore stores images on disk and images info on memory, keeping a copy of the info in an index file
+	// next to the images. The files are named by the SHA-256 checksum of their data, so images with the same data
+	// share a file, which is removed with the last image referencing it.
 	DiskImageStore struct {
 		mutex        sync.RWMutex
 		imagesFolder string
 		images       map[string]*ImageInfo
+		references   map[string]int
+	}
+
+	// diskImageWriter writes a new image of a DiskImageStore to a temporary file, which is renamed once committed.
+	diskImageWriter struct {
+		store        *DiskImageStore
+		laptopID     string
+		sourceID     string
+		rendition    string
+		header       *imageHeader
+		checksum     hash.Hash
+		file         *os.File
+		size         int64
+		closed       bool
+		deduplicated bool
 	}
 )
 
-// NewDiskImageStore creates a new DiskImageStore.
-func NewDiskImageStore(imagesFolder string) *DiskImageStore {
-	return &DiskImageStore{
+// SaveImage stores the image read from imageData in the store and returns its id.
+func SaveImage(store ImageStore, laptopID, extension string, imageData io.Reader) (string, error) {
+	imageWriter, err := store.Create(laptopID, extension)
+	if err != nil {
+		return "", err
+	}
+
+	defer imageWriter.Abort()
+
+	if _, err := io.Copy(imageWriter, imageData); err != nil {
+		return "", err
+	}
+
+	return imageWriter.Commit()
+}
+
+// OpenDiskImageStore opens the DiskImageStore in the folder, creating the folder if needed and loading the info of
+// the images stored before.
+func OpenDiskImageStore(imagesFolder string) (*DiskImageStore, error) {
+	if err := os.MkdirAll(imagesFolder, 0o755); err != nil {
+		return nil, fmt.Errorf("error creating images folder: %v", err)
+	}
+
+	store := &DiskImageStore{
 		imagesFolder: imagesFolder,
 		images:       make(map[string]*ImageInfo),
+		references:   make(map[string]int),
+	}
+
+	index := &pb.ImageIndex{}
+
+	err := serializer.ReadProtobufFromBinaryFile(filepath.Join(imagesFolder, diskImageIndexFileName), index)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("error reading image index: %w", err)
+	}
+
+	for _, image := range index.GetImages() {
+		store.add(imageInfo(image))
+	}
+
+	// Uploads that were still being written when the server stopped are never committed.
+	uploadPaths, err := filepath.Glob(filepath.Join(imagesFolder, diskImageUploadPattern))
+	if err != nil {
+		return nil, fmt.Errorf("error finding unfinished uploads: %v", err)
+	}
+
+	for _, uploadPath := range uploadPaths {
+		if err := os.Remove(uploadPath); err != nil {
+			return nil, fmt.Errorf("error removing unfinished upload: %v", err)
+		}
+	}
+
+	return store, nil
+}
+
+// imageInfo returns the info of a stored image
+func imageInfo(image *pb.StoredImage) *ImageInfo {
+	return &ImageInfo{
+		ID:             image.GetId(),
+		LaptopID:       image.GetLaptopId(),
+		Extension:      image.GetExtension(),
+		Path:           image.GetPath(),
+		Size:           int64(image.GetSize()),
+		Width:          int(image.GetWidth()),
+		Height:         int(image.GetHeight()),
+		SourceID:       image.GetSourceId(),
+		Rendition:      image.GetRendition(),
+		RenditionError: image.GetRenditionError(),
+		SHA256:         image.GetSha256(),
+	}
+}
+
+// storedImage returns the image as the stores keep it
+func storedImage(info *ImageInfo) *pb.StoredImage {
+	return &pb.StoredImage{
+		Id:             info.ID,
+		LaptopId:       info.LaptopID,
+		Extension:      info.Extension,
+		Path:           info.Path,
+		Size:           uint64(info.Size),
+		Width:          uint32(info.Width),
+		Height:         uint32(info.Height),
+		SourceId:       info.SourceID,
+		Rendition:      info.Rendition,
+		RenditionError: info.RenditionError,
+		Sha256:         info.SHA256,
+	}
+}
+
+// imageContentType returns the media type of images with the extension
+func imageContentType(extension string) string {
+	if format := imageFormatByExtension(extension); format != nil {
+		return format.contentType
+	}
+
+	if contentType := mime.TypeByExtension(extension); contentType != "" {
+		return contentType
+	}
+
+	return "application/octet-stream"
+}
+
+// imageDetails returns the details of an image sent to clients
+func imageDetails(info *ImageInfo) *pb.ImageDetails {
+	return &pb.ImageDetails{
+		Id:          info.ID,
+		LaptopId:    info.LaptopID,
+		ContentType: imageContentType(info.Extension),
+		Size:        uint64(info.Size),
+		Width:       uint32(info.Width),
+		Height:      uint32(info.Height),
+		Rendition:   info.Rendition,
+		SourceId:    info.SourceID,
+	}
+}
+
+// Clone returns a copy of the image info.
+func (info *ImageInfo) Clone() *ImageInfo {
+	clone := *info
+	return &clone
+}
+
+// Create starts writing a new image of a laptop, which is only stored once the returned writer is committed.
+func (store *DiskImageStore) Create(laptopID, extension string) (ImageWriter, error) {
+	imageWriter, err := store.create(laptopID, "", "", extension)
+	if err != nil {
+		return nil, err
+	}
+
+	return imageWriter, nil
+}
+
+// CreateRendition starts writing a resized version of an image, which is only stored once the returned writer is
+// committed and the image still exists.
+func (store *DiskImageStore) CreateRendition(sourceID, rendition, extension string) (ImageWriter, error) {
+	imageWriter, err := store.createRendition(sourceID, rendition, extension)
+	if err != nil {
+		return nil, err
+	}
+
+	return imageWriter, nil
+}
+
+// createRendition starts writing a resized version of an image if the image exists
+func (store *DiskImageStore) createRendition(sourceID, rendition, extension string) (*diskImageWriter, error) {
+	source, err := store.Find(sourceID)
+	if err != nil {
+		return nil, err
+	}
+
+	if source == nil {
+		return nil, ErrRecordNotFound
 	}
+
+	return store.create(source.LaptopID, sourceID, rendition, extension)
 }
 
-// Save stores the image in the store.
-func (store *DiskImageStore) Save(laptopID, extension string, imageData bytes.Buffer) (string, error) {
+// create starts writing a new image of a laptop, which is a rendition of the source image if it has one
+func (store *DiskImageStore) create(laptopID, sourceID, rendition, extension string) (*diskImageWriter, error) {
+	format, err := checkImageExtension(extension)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.CreateTemp(store.imagesFolder, diskImageUploadPattern)
+	if err != nil {
+		return nil, fmt.Errorf("error creating laptop %s image file: %v", laptopID, err)
+	}
+
+	imageWriter := &diskImageWriter{
+		store:     store,
+		laptopID:  laptopID,
+		sourceID:  sourceID,
+		rendition: rendition,
+		header:    newImageHeader(format),
+		checksum:  sha256.New(),
+		file:      file,
+	}
+
+	return imageWriter, nil
+}
+
+// Write writes the next part of the image to its temporary file.
+func (w *diskImageWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, ErrImageWriterClosed
+	}
+
+	if err := w.header.write(p); err != nil {
+		return 0, err
+	}
+
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	_, _ = w.checksum.Write(p[:n])
+
+	if err != nil {
+		return n, fmt.Errorf("error writing laptop %s image to file: %v", w.laptopID, err)
+	}
+
+	return n, nil
+}
+
+// Commit stores the image metadata and moves the temporary file of the image to the path named by its checksum,
+// unless a stored image already has the same data.
+func (w *diskImageWriter) Commit() (string, error) {
+	info, err := w.finish()
+	if err != nil {
+		return "", err
+	}
+
+	store := w.store
+
+	// The file is moved while the store is locked, so that it is not removed with the last image referencing it.
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if err := w.move(info); err != nil {
+		return "", err
+	}
+
+	if err := store.save(info); err != nil {
+		_ = store.removeUnreferenced(info)
+		return "", err
+	}
+
+	return info.ID, nil
+}
+
+// finish closes the temporary file of the image and returns the metadata the image is stored with
+func (w *diskImageWriter) finish() (*ImageInfo, error) {
+	if w.closed {
+		return nil, ErrImageWriterClosed
+	}
+
+	// The stored extension is the one of the format the data has, not the one sent by the client.
+	format, err := w.header.finish()
+	if err != nil {
+		_ = w.Abort()
+		return nil, err
+	}
+
 	imageID, err := uuid.NewRandom()
 	if err != nil {
-		return "", fmt.Errorf("error generating image ID: %w", err)
+		return nil, fmt.Errorf("error generating image ID: %w", err)
+	}
+
+	if err := w.file.Close(); err != nil {
+		_ = w.Abort()
+		return nil, fmt.Errorf("error writing laptop %s image - %s to file: %v", w.laptopID, imageID, err)
+	}
+
+	checksum := w.Checksum()
+
+	return &ImageInfo{
+		ID:        imageID.String(),
+		LaptopID:  w.laptopID,
+		Extension: format.extension,
+		Path:      fmt.Sprintf("%s/%s%s", w.store.imagesFolder, checksum, format.extension),
+		Size:      w.size,
+		Width:     w.header.width,
+		Height:    w.header.height,
+		SourceID:  w.sourceID,
+		Rendition: w.rendition,
+		SHA256:    checksum,
+	}, nil
+}
+
+// move moves the temporary file of the image to its path, unless a stored image already has the same data. The
+// caller holds the mutex of the store.
+func (w *diskImageWriter) move(info *ImageInfo) error {
+	store := w.store
+
+	// A rendition of an image deleted while it was generated is not kept.
+	if w.sourceID != "" && store.images[w.sourceID] == nil {
+		_ = w.Abort()
+		return ErrRecordNotFound
+	}
+
+	if store.references[info.Path] > 0 {
+		w.deduplicated = true
+		_ = w.Abort()
+	} else if err := os.Rename(w.file.Name(), info.Path); err != nil {
+		_ = w.Abort()
+		return fmt.Errorf("error writing laptop %s image - %s to file: %v", w.laptopID, info.ID, err)
+	}
+
+	w.closed = true
+	return nil
+}
+
+// Deduplicated tells whether the committed image has the same data as an image stored before, which it shares.
+func (w *diskImageWriter) Deduplicated() bool {
+	return w.deduplicated
+}
+
+// Checksum returns the hex encoded SHA-256 checksum of the data written so far.
+func (w *diskImageWriter) Checksum() string {
+	return hex.EncodeToString(w.checksum.Sum(nil))
+}
+
+// Abort removes the temporary file of the image.
+func (w *diskImageWriter) Abort() error {
+	if w.closed {
+		return nil
+	}
+
+	w.closed = true
+
+	_ = w.file.Close()
+
+	if err := os.Remove(w.file.Name()); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("error removing laptop %s image file: %v", w.laptopID, err)
+	}
+
+	return nil
+}
+
+// Find returns the metadata of an image, or nil if there is none.
+func (store *DiskImageStore) Find(imageID string) (*ImageInfo, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	info := store.images[imageID]
+	if info == nil {
+		return nil, nil
+	}
+
+	return info.Clone(), nil
+}
+
+// Open returns the metadata of an image and a reader of its data, which the caller closes.
+func (store *DiskImageStore) Open(imageID string) (*ImageInfo, io.ReadCloser, error) {
+	info, err := store.Find(imageID)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	imagePath := fmt.Sprintf("%s/%s%s", store.imagesFolder, imageID, extension)
+	if info == nil {
+		return nil, nil, ErrRecordNotFound
+	}
+
+	// The file of an image deleted since it was found may be gone already.
+	file, err := os.Open(info.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil, ErrRecordNotFound
+	}
 
-	file, err := os.Create(imagePath)
 	if err != nil {
-		return "", fmt.Errorf("error creating laptop %s image - %s file: %v", laptopID, imageID, err)
+		return nil, nil, fmt.Errorf("error opening image %s file: %v", imageID, err)
+	}
+
+	return info, file, nil
+}
+
+// List returns the metadata of the images of a laptop ordered by their id.
+func (store *DiskImageStore) List(laptopID string) ([]*ImageInfo, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	var images []*ImageInfo
+
+	for _, info := range store.images {
+		if info.LaptopID == laptopID {
+			images = append(images, info.Clone())
+		}
+	}
+
+	sort.Slice(images, func(i, j int) bool {
+		return images[i].ID < images[j].ID
+	})
+
+	return images, nil
+}
+
+// Delete removes an image from the store together with its renditions.
+func (store *DiskImageStore) Delete(imageID string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if store.images[imageID] == nil {
+		return ErrRecordNotFound
+	}
+
+	deleted := store.delete(imageID)
+
+	if err := store.writeIndex(); err != nil {
+		for _, info := range deleted {
+			store.add(info)
+		}
+
+		return err
+	}
+
+	// The images are gone once the index no longer holds them, a file left behind is only wasted space.
+	for _, info := range deleted {
+		if err := store.removeUnreferenced(info); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SetRenditionError records why the renditions of an image could not be generated.
+func (store *DiskImageStore) SetRenditionError(imageID, message string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	info := store.images[imageID]
+	if info == nil {
+		return ErrRecordNotFound
+	}
+
+	previous := info.RenditionError
+	info.RenditionError = message
+
+	if err := store.writeIndex(); err != nil {
+		info.RenditionError = previous
+		return err
+	}
+
+	return nil
+}
+
+// save stores the metadata of a committed image and writes the index. The caller holds the mutex.
+func (store *DiskImageStore) save(info *ImageInfo) error {
+	store.add(info)
+
+	if err := store.writeIndex(); err != nil {
+		store.delete(info.ID)
+		return err
+	}
+
+	return nil
+}
+
+// add stores the metadata of an image and references its file. The caller holds the mutex.
+func (store *DiskImageStore) add(info *ImageInfo) {
+	store.images[info.ID] = info
+	store.references[info.Path]++
+}
+
+// delete removes the metadata of an image and its renditions, dropping their references to their files, and returns
+// what it removed. The caller holds the mutex.
+func (store *DiskImageStore) delete(imageID string) []*ImageInfo {
+	var deleted []*ImageInfo
+
+	for id, info := range store.images {
+		if id == imageID || info.SourceID == imageID {
+			deleted = append(deleted, info)
+			delete(store.images, id)
+			store.unreference(info)
+		}
+	}
+
+	return deleted
+}
+
+// unreference drops the reference of an image to its file. The caller holds the mutex.
+func (store *DiskImageStore) unreference(info *ImageInfo) {
+	store.references[info.Path]--
+	if store.references[info.Path] <= 0 {
+		delete(store.references, info.Path)
+	}
+}
+
+// removeUnreferenced removes the file of a deleted image unless another image references it. The caller holds the
+// mutex.
+func (store *DiskImageStore) removeUnreferenced(info *ImageInfo) error {
+	if store.references[info.Path] > 0 {
+		return nil
+	}
+
+	if err := os.Remove(info.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("error removing image %s file: %v", info.ID, err)
 	}
 
-	_, err = imageData.WriteTo(file)
+	return nil
+}
+
+// writeIndex replaces the index file with the info of the stored images. The caller holds the mutex.
+func (store *DiskImageStore) writeIndex() error {
+	index := &pb.ImageIndex{}
+
+	for _, info := range store.images {
+		index.Images = append(index.Images, storedImage(info))
+	}
+
+	sort.Slice(index.Images, func(i, j int) bool {
+		return index.Images[i].GetId() < index.Images[j].GetId()
+	})
+
+	data, err := serializer.ProtobufToBinary(index)
 	if err != nil {
-		return "", fmt.Errorf("error writing laptop %s image - %s to file: %v", laptopID, imageID, err)
+		return fmt.Errorf("error writing image index: %w", err)
+	}
+
+	indexPath := filepath.Join(store.imagesFolder, diskImageIndexFileName)
+
+	if err := writeFileSynced(indexPath+".tmp", data); err != nil {
+		return fmt.Errorf("error writing image index: %v", err)
+	}
+
+	if err := os.Rename(indexPath+".tmp", indexPath); err != nil {
+		return fmt.Errorf("error writing image index: %v", err)
+	}
+
+	// Syncing the folder makes the renamed index survive a crash, along with the image files renamed before it.
+	if err := syncDir(store.imagesFolder); err != nil {
+		return fmt.Errorf("error writing image index: %v", err)
 	}
 
+	return nil
+}
+
+// all returns the metadata of all stored images
+func (store *DiskImageStore) all() []*pb.StoredImage {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	images := make([]*pb.StoredImage, 0, len(store.images))
+
+	for _, info := range store.images {
+		images = append(images, storedImage(info))
+	}
+
+	return images
+}
+
+// restore stores the metadata of an image as it is, replacing any stored metadata of the image
+func (store *DiskImageStore) restore(image *pb.StoredImage) {
 	store.mutex.Lock()
 	defer store.mutex.Unlock()
 
-	store.images[imageID.String()] = &ImageInfo{
-		LaptopID:  laptopID,
-		Extension: extension,
-		Path:      imagePath,
+	if info := store.images[image.GetId()]; info != nil {
+		store.unreference(info)
 	}
 
-	return imageID.String(), nil
+	store.add(imageInfo(image))
+}
+
+// remove removes the metadata of an image and its renditions, if it is stored
+func (store *DiskImageStore) remove(imageID string) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.delete(imageID)
 }
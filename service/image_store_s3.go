@@ -0,0 +1,591 @@
+package service
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/jwambugu/pcbook-grpc/protos/pb"
+	"github.com/jwambugu/pcbook-grpc/serializer"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// MinS3PartSize is the smallest size S3 accepts for the parts of a multipart upload but the last one
+	MinS3PartSize = 5 << 20 // 5MB
+	// DefaultS3PartSize is the size of the parts images are uploaded in when the S3ImageStore is not given one
+	DefaultS3PartSize = MinS3PartSize
+	// defaultS3Region is the region requests are signed for when the S3ImageStore is not given one
+	defaultS3Region = "us-east-1"
+)
+
+// S3Config configures an S3ImageStore.
+type S3Config struct {
+	// Endpoint is the URL of the S3-compatible API, the bucket is addressed in its path.
+	Endpoint string
+	Region   string
+	Bucket   string
+	// Prefix is prepended to the keys of all objects of the store, e.g. "pcbook/".
+	Prefix          string
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	// PartSize is the size of the parts of multipart uploads, at least MinS3PartSize. Images up to that size are
+	// uploaded in one request.
+	PartSize   int64
+	HTTPClient *http.Client
+}
+
+type (
+	// S3ImageStore stores images and their metadata in a bucket of an S3-compatible service, so that several servers
+	// can share them. Like the DiskImageStore, it names the data of the images by its SHA-256 checksum and shares it
+	// between images with the same data, keeping an object per image referencing the data.
+	//
+	// The bucket holds, under the prefix:
+	//  - images/<id> the metadata of an image as a StoredImage
+	//  - laptops/<laptop id>/<id> an empty object per image of a laptop, which List lists
+	//  - blobs/<checksum><extension> the data of images
+	//  - references/<checksum><extension>/<id> an empty object per image referencing the data
+	//  - uploads/<uuid> images larger than a part while they are uploaded
+	//
+	// S3 offers no transactions, so an image sharing data with an image deleted at the same time by another server
+	// can lose its data.
+	S3ImageStore struct {
+		client   *s3Client
+		prefix   string
+		partSize int64
+	}
+
+	// s3ImageWriter writes a new image of an S3ImageStore. The image is buffered up to the part size, larger images
+	// are sent in the parts of a multipart upload.
+	s3ImageWriter struct {
+		store        *S3ImageStore
+		laptopID     string
+		sourceID     string
+		rendition    string
+		header       *imageHeader
+		checksum     hash.Hash
+		buffer       bytes.Buffer
+		size         int64
+		uploadKey    string
+		uploadID     string
+		parts        []s3Part
+		closed       bool
+		deduplicated bool
+	}
+)
+
+// NewS3ImageStore creates a new S3ImageStore with the config.
+func NewS3ImageStore(config S3Config) (*S3ImageStore, error) {
+	endpoint, err := url.Parse(config.Endpoint)
+	if err != nil || endpoint.Scheme == "" || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid S3 endpoint %q", config.Endpoint)
+	}
+
+	if config.Bucket == "" {
+		return nil, errors.New("the S3 bucket is required")
+	}
+
+	if config.AccessKeyID == "" || config.SecretAccessKey == "" {
+		return nil, errors.New("the S3 access key ID and secret access key are required")
+	}
+
+	region := config.Region
+	if region == "" {
+		region = defaultS3Region
+	}
+
+	partSize := config.PartSize
+	if partSize <= 0 {
+		partSize = DefaultS3PartSize
+	}
+
+	if partSize < MinS3PartSize {
+		return nil, fmt.Errorf("the S3 part size must be at least %d bytes", MinS3PartSize)
+	}
+
+	httpClient := config.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	store := &S3ImageStore{
+		client: &s3Client{
+			httpClient:      httpClient,
+			endpoint:        endpoint,
+			region:          region,
+			bucket:          config.Bucket,
+			accessKeyID:     config.AccessKeyID,
+			secretAccessKey: config.SecretAccessKey,
+			sessionToken:    config.SessionToken,
+			now:             time.Now,
+		},
+		prefix:   config.Prefix,
+		partSize: partSize,
+	}
+
+	return store, nil
+}
+
+// imageKey returns the key of the metadata of an image
+func (store *S3ImageStore) imageKey(imageID string) string {
+	return store.prefix + "images/" + imageID
+}
+
+// laptopImagesKey returns the prefix of the keys listing the images of a laptop
+func (store *S3ImageStore) laptopImagesKey(laptopID string) string {
+	return store.prefix + "laptops/" + laptopID + "/"
+}
+
+// referencesKey returns the prefix of the keys of the images referencing the data in the blob
+func (store *S3ImageStore) referencesKey(blobKey string) string {
+	return store.prefix + "references/" + strings.TrimPrefix(blobKey, store.prefix+"blobs/") + "/"
+}
+
+// Create starts writing a new image of a laptop, which is only stored once the returned writer is committed.
+func (store *S3ImageStore) Create(laptopID, extension string) (ImageWriter, error) {
+	return store.create(laptopID, "", "", extension)
+}
+
+// CreateRendition starts writing a resized version of an image, which is only stored once the returned writer is
+// committed and the image still exists.
+func (store *S3ImageStore) CreateRendition(sourceID, rendition, extension string) (ImageWriter, error) {
+	source, err := store.Find(sourceID)
+	if err != nil {
+		return nil, err
+	}
+
+	if source == nil {
+		return nil, ErrRecordNotFound
+	}
+
+	return store.create(source.LaptopID, sourceID, rendition, extension)
+}
+
+// create starts writing a new image of a laptop, which is a rendition of the source image if it has one
+func (store *S3ImageStore) create(laptopID, sourceID, rendition, extension string) (ImageWriter, error) {
+	format, err := checkImageExtension(extension)
+	if err != nil {
+		return nil, err
+	}
+
+	imageWriter := &s3ImageWriter{
+		store:     store,
+		laptopID:  laptopID,
+		sourceID:  sourceID,
+		rendition: rendition,
+		header:    newImageHeader(format),
+		checksum:  sha256.New(),
+	}
+
+	return imageWriter, nil
+}
+
+// Write buffers the next part of the image, sending the buffer as a part of a multipart upload once it is full.
+func (w *s3ImageWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, ErrImageWriterClosed
+	}
+
+	if err := w.header.write(p); err != nil {
+		return 0, err
+	}
+
+	_, _ = w.buffer.Write(p)
+	_, _ = w.checksum.Write(p)
+	w.size += int64(len(p))
+
+	for int64(w.buffer.Len()) >= w.store.partSize {
+		// The part is gone from the buffer, the image cannot be completed without it.
+		if err := w.uploadPart(w.buffer.Next(int(w.store.partSize))); err != nil {
+			_ = w.Abort()
+			return 0, err
+		}
+	}
+
+	return len(p), nil
+}
+
+// uploadPart sends a part of the image, starting the multipart upload with the first part
+func (w *s3ImageWriter) uploadPart(data []byte) error {
+	client := w.store.client
+
+	if w.uploadID == "" {
+		uploadID, err := uuid.NewRandom()
+		if err != nil {
+			return fmt.Errorf("error generating upload ID: %w", err)
+		}
+
+		w.uploadKey = w.store.prefix + "uploads/" + uploadID.String()
+
+		w.uploadID, err = client.createMultipartUpload(w.uploadKey)
+		if err != nil {
+			return fmt.Errorf("error starting laptop %s image upload: %w", w.laptopID, err)
+		}
+	}
+
+	part, err := client.uploadPart(w.uploadKey, w.uploadID, len(w.parts)+1, data)
+	if err != nil {
+		return fmt.Errorf("error uploading laptop %s image part: %w", w.laptopID, err)
+	}
+
+	w.parts = append(w.parts, part)
+
+	return nil
+}
+
+// Commit stores the data of the image under its checksum, unless a stored image already has the same data, and then
+// stores the image metadata.
+func (w *s3ImageWriter) Commit() (string, error) {
+	if w.closed {
+		return "", ErrImageWriterClosed
+	}
+
+	// The stored extension is the one of the format the data has, not the one sent by the client.
+	format, err := w.header.finish()
+	if err != nil {
+		_ = w.Abort()
+		return "", err
+	}
+
+	imageID, err := uuid.NewRandom()
+	if err != nil {
+		return "", fmt.Errorf("error generating image ID: %w", err)
+	}
+
+	store := w.store
+	client := store.client
+
+	// A rendition of an image deleted while it was generated is not kept.
+	if w.sourceID != "" {
+		source, err := store.Find(w.sourceID)
+		if err != nil || source == nil {
+			_ = w.Abort()
+			return "", ErrRecordNotFound
+		}
+	}
+
+	checksum := w.Checksum()
+	blobKey := store.prefix + "blobs/" + checksum + format.extension
+	referenceKey := store.referencesKey(blobKey) + imageID.String()
+
+	// The reference is stored before the data is looked for, so that the data is not removed with another image.
+	if err := client.putObject(referenceKey, nil); err != nil {
+		_ = w.Abort()
+		return "", fmt.Errorf("error referencing laptop %s image data: %w", w.laptopID, err)
+	}
+
+	if err := w.storeBlob(blobKey); err != nil {
+		_ = w.Abort()
+		_ = store.release(blobKey, imageID.String())
+
+		return "", fmt.Errorf("error writing laptop %s image - %s: %w", w.laptopID, imageID, err)
+	}
+
+	w.closed = true
+
+	info := &ImageInfo{
+		ID:        imageID.String(),
+		LaptopID:  w.laptopID,
+		Extension: format.extension,
+		Path:      blobKey,
+		Size:      w.size,
+		Width:     w.header.width,
+		Height:    w.header.height,
+		SourceID:  w.sourceID,
+		Rendition: w.rendition,
+		SHA256:    checksum,
+	}
+
+	if err := store.put(info); err != nil {
+		_ = store.release(blobKey, info.ID)
+		return "", err
+	}
+
+	if err := client.putObject(store.laptopImagesKey(w.laptopID)+info.ID, nil); err != nil {
+		_ = client.deleteObject(store.imageKey(info.ID))
+		_ = store.release(blobKey, info.ID)
+
+		return "", fmt.Errorf("error listing laptop %s image - %s: %w", w.laptopID, info.ID, err)
+	}
+
+	return info.ID, nil
+}
+
+// storeBlob stores the data of the image under the key unless it is already stored, completing or aborting the
+// multipart upload
+func (w *s3ImageWriter) storeBlob(blobKey string) error {
+	client := w.store.client
+
+	exists, err := client.objectExists(blobKey)
+	if err != nil {
+		return err
+	}
+
+	if exists {
+		w.deduplicated = true
+		return w.abortUpload()
+	}
+
+	if w.uploadID == "" {
+		return client.putObject(blobKey, w.buffer.Bytes())
+	}
+
+	if w.buffer.Len() > 0 {
+		if err := w.uploadPart(w.buffer.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	if err := client.completeMultipartUpload(w.uploadKey, w.uploadID, w.parts); err != nil {
+		return err
+	}
+
+	w.uploadID = ""
+
+	// The key of the upload was chosen before the checksum of the data was known.
+	if err := client.copyObject(w.uploadKey, blobKey); err != nil {
+		_ = client.deleteObject(w.uploadKey)
+		return err
+	}
+
+	return client.deleteObject(w.uploadKey)
+}
+
+// Abort discards the buffered image and its multipart upload.
+func (w *s3ImageWriter) Abort() error {
+	if w.closed {
+		return nil
+	}
+
+	w.closed = true
+	w.buffer.Reset()
+
+	return w.abortUpload()
+}
+
+// abortUpload discards the multipart upload of the image, if it was started
+func (w *s3ImageWriter) abortUpload() error {
+	if w.uploadID == "" {
+		return nil
+	}
+
+	uploadID := w.uploadID
+	w.uploadID = ""
+
+	if err := w.store.client.abortMultipartUpload(w.uploadKey, uploadID); err != nil {
+		return fmt.Errorf("error aborting laptop %s image upload: %w", w.laptopID, err)
+	}
+
+	return nil
+}
+
+// Deduplicated tells whether the committed image has the same data as an image stored before, which it shares.
+func (w *s3ImageWriter) Deduplicated() bool {
+	return w.deduplicated
+}
+
+// Checksum returns the hex encoded SHA-256 checksum of the data written so far.
+func (w *s3ImageWriter) Checksum() string {
+	return hex.EncodeToString(w.checksum.Sum(nil))
+}
+
+// put stores the metadata of an image
+func (store *S3ImageStore) put(info *ImageInfo) error {
+	data, err := serializer.ProtobufToBinary(storedImage(info))
+	if err != nil {
+		return fmt.Errorf("error writing image %s metadata: %w", info.ID, err)
+	}
+
+	if err := store.client.putObject(store.imageKey(info.ID), data); err != nil {
+		return fmt.Errorf("error writing image %s metadata: %w", info.ID, err)
+	}
+
+	return nil
+}
+
+// Find returns the metadata of an image, or nil if there is none.
+func (store *S3ImageStore) Find(imageID string) (*ImageInfo, error) {
+	data, err := store.client.readObject(store.imageKey(imageID))
+	if errors.Is(err, ErrRecordNotFound) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("error reading image %s metadata: %w", imageID, err)
+	}
+
+	image := &pb.StoredImage{}
+	if err := serializer.BinaryToProtobuf(data, image); err != nil {
+		return nil, fmt.Errorf("error reading image %s metadata: %w", imageID, err)
+	}
+
+	return imageInfo(image), nil
+}
+
+// Open returns the metadata of an image and a reader of its data, which the caller closes.
+func (store *S3ImageStore) Open(imageID string) (*ImageInfo, io.ReadCloser, error) {
+	info, err := store.Find(imageID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if info == nil {
+		return nil, nil, ErrRecordNotFound
+	}
+
+	imageData, err := store.client.getObject(info.Path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error opening image %s data: %w", imageID, err)
+	}
+
+	return info, imageData, nil
+}
+
+// List returns the metadata of the images of a laptop ordered by their id.
+func (store *S3ImageStore) List(laptopID string) ([]*ImageInfo, error) {
+	prefix := store.laptopImagesKey(laptopID)
+
+	keys, err := store.client.listObjects(prefix)
+	if err != nil {
+		return nil, fmt.Errorf("error listing laptop %s images: %w", laptopID, err)
+	}
+
+	var images []*ImageInfo
+
+	for _, key := range keys {
+		info, err := store.Find(strings.TrimPrefix(key, prefix))
+		if err != nil {
+			return nil, err
+		}
+
+		// An image deleted since the listing is skipped.
+		if info != nil {
+			images = append(images, info)
+		}
+	}
+
+	sort.Slice(images, func(i, j int) bool {
+		return images[i].ID < images[j].ID
+	})
+
+	return images, nil
+}
+
+// Delete removes an image from the store together with its renditions.
+func (store *S3ImageStore) Delete(imageID string) error {
+	info, err := store.Find(imageID)
+	if err != nil {
+		return err
+	}
+
+	if info == nil {
+		return ErrRecordNotFound
+	}
+
+	images, err := store.List(info.LaptopID)
+	if err != nil {
+		return err
+	}
+
+	for _, image := range images {
+		if image.SourceID == imageID {
+			if err := store.delete(image); err != nil {
+				return err
+			}
+		}
+	}
+
+	return store.delete(info)
+}
+
+// delete removes the metadata of an image and its reference to its data
+func (store *S3ImageStore) delete(info *ImageInfo) error {
+	// The image is gone once its metadata is, what is left behind is only wasted space.
+	if err := store.client.deleteObject(store.imageKey(info.ID)); err != nil {
+		return fmt.Errorf("error removing image %s metadata: %w", info.ID, err)
+	}
+
+	if err := store.client.deleteObject(store.laptopImagesKey(info.LaptopID) + info.ID); err != nil {
+		return fmt.Errorf("error removing image %s from laptop %s: %w", info.ID, info.LaptopID, err)
+	}
+
+	if err := store.release(info.Path, info.ID); err != nil {
+		return err
+	}
+
+	// A SetRenditionError that found the image before it was removed may have written its metadata back. It removes
+	// metadata it writes once the reference is gone, so removing the metadata again after the reference leaves none.
+	if err := store.client.deleteObject(store.imageKey(info.ID)); err != nil {
+		return fmt.Errorf("error removing image %s metadata: %w", info.ID, err)
+	}
+
+	return nil
+}
+
+// release removes the reference of an image to the data in the blob, removing the data once nothing references it
+func (store *S3ImageStore) release(blobKey, imageID string) error {
+	referencesKey := store.referencesKey(blobKey)
+
+	if err := store.client.deleteObject(referencesKey + imageID); err != nil {
+		return fmt.Errorf("error removing image %s data reference: %w", imageID, err)
+	}
+
+	references, err := store.client.listObjects(referencesKey)
+	if err != nil {
+		return fmt.Errorf("error listing image %s data references: %w", imageID, err)
+	}
+
+	if len(references) > 0 {
+		return nil
+	}
+
+	if err := store.client.deleteObject(blobKey); err != nil {
+		return fmt.Errorf("error removing image %s data: %w", imageID, err)
+	}
+
+	return nil
+}
+
+// SetRenditionError records why the renditions of an image could not be generated.
+func (store *S3ImageStore) SetRenditionError(imageID, message string) error {
+	info, err := store.Find(imageID)
+	if err != nil {
+		return err
+	}
+
+	if info == nil {
+		return ErrRecordNotFound
+	}
+
+	info.RenditionError = message
+
+	if err := store.put(info); err != nil {
+		return err
+	}
+
+	// The image may have been deleted since it was found, which the metadata just written must not outlive. Delete
+	// removes the reference of the image before removing its metadata a second time.
+	referenced, err := store.client.objectExists(store.referencesKey(info.Path) + info.ID)
+	if err != nil {
+		return fmt.Errorf("error finding image %s data reference: %w", info.ID, err)
+	}
+
+	if !referenced {
+		if err := store.client.deleteObject(store.imageKey(info.ID)); err != nil {
+			return fmt.Errorf("error removing image %s metadata: %w", info.ID, err)
+		}
+
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
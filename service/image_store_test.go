@@ -0,0 +1,229 @@
+package service
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/jwambugu/pcbook-grpc/factory"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// openTestDiskImageStore opens the DiskImageStore in the folder
+func openTestDiskImageStore(t *testing.T, imagesFolder string) *DiskImageStore {
+	store, err := OpenDiskImageStore(imagesFolder)
+	require.NoError(t, err)
+
+	return store
+}
+
+func TestDiskImageStore_Reopen(t *testing.T) {
+	t.Parallel()
+
+	imagesFolder := filepath.Join(t.TempDir(), "images")
+	store := openTestDiskImageStore(t, imagesFolder)
+
+	image := factory.NewImage(".jpg", 2, 2)
+
+	imageID, err := SaveImage(store, "laptop", ".jpg", bytes.NewReader(image))
+	require.NoError(t, err)
+
+	deletedImageID, err := SaveImage(store, "laptop", ".jpg", bytes.NewReader(factory.NewImage(".jpg", 1, 1)))
+	require.NoError(t, err)
+
+	deletedInfo, err := store.Find(deletedImageID)
+	require.NoError(t, err)
+
+	err = store.Delete(deletedImageID)
+	require.NoError(t, err)
+	require.NoFileExists(t, deletedInfo.Path)
+
+	// The images outlive the store.
+	store = openTestDiskImageStore(t, imagesFolder)
+
+	images, err := store.List("laptop")
+	require.NoError(t, err)
+	require.Len(t, images, 1)
+	require.Equal(t, imageID, images[0].ID)
+	require.Equal(t, int64(len(image)), images[0].Size)
+	require.Equal(t, 2, images[0].Width)
+
+	data, err := os.ReadFile(images[0].Path)
+	require.NoError(t, err)
+	require.Equal(t, image, data)
+}
+
+func TestDiskImageStore_CorruptedIndex(t *testing.T) {
+	t.Parallel()
+
+	imagesFolder := t.TempDir()
+
+	err := os.WriteFile(filepath.Join(imagesFolder, diskImageIndexFileName), []byte{0xff}, 0o600)
+	require.NoError(t, err)
+
+	_, err = OpenDiskImageStore(imagesFolder)
+	require.Error(t, err)
+}
+
+func TestDiskImageStore_OpenDeleted(t *testing.T) {
+	t.Parallel()
+
+	store := openTestDiskImageStore(t, t.TempDir())
+
+	imageID, err := SaveImage(store, "laptop", ".jpg", bytes.NewReader(factory.NewImage(".jpg", 1, 1)))
+	require.NoError(t, err)
+
+	info, err := store.Find(imageID)
+	require.NoError(t, err)
+
+	// The file is gone as it is when a Delete runs between finding the image and opening its file.
+	require.NoError(t, os.Remove(info.Path))
+
+	_, _, err = store.Open(imageID)
+	require.ErrorIs(t, err, ErrRecordNotFound)
+}
+
+func TestDiskImageStore_Upload(t *testing.T) {
+	t.Parallel()
+
+	imagesFolder := t.TempDir()
+	store := openTestDiskImageStore(t, imagesFolder)
+
+	imageWriter, err := store.Create("laptop", ".jpg")
+	require.NoError(t, err)
+
+	image := factory.NewImage(".jpg", 1, 1)
+
+	_, err = imageWriter.Write(image)
+	require.NoError(t, err)
+
+	// The image is only moved into place when it is committed, named by the checksum of its data.
+	uploadPaths, err := filepath.Glob(filepath.Join(imagesFolder, diskImageUploadPattern))
+	require.NoError(t, err)
+	require.Len(t, uploadPaths, 1)
+
+	_, err = imageWriter.Commit()
+	require.NoError(t, err)
+	require.NoFileExists(t, uploadPaths[0])
+
+	checksum := sha256.Sum256(image)
+	require.FileExists(t, filepath.Join(imagesFolder, hex.EncodeToString(checksum[:])+".jpg"))
+
+	abortedWriter, err := store.Create("laptop", ".jpg")
+	require.NoError(t, err)
+	require.NoError(t, abortedWriter.Abort())
+
+	// Uploads left behind by a server that stopped are removed when the store is opened.
+	unfinishedWriter, err := store.Create("laptop", ".jpg")
+	require.NoError(t, err)
+
+	_, err = unfinishedWriter.Write(factory.NewImage(".jpg", 1, 1))
+	require.NoError(t, err)
+
+	openTestDiskImageStore(t, imagesFolder)
+
+	uploadPaths, err = filepath.Glob(filepath.Join(imagesFolder, diskImageUploadPattern))
+	require.NoError(t, err)
+	require.Empty(t, uploadPaths)
+}
+
+func TestDiskImageStore_Deduplicate(t *testing.T) {
+	t.Parallel()
+
+	imagesFolder := t.TempDir()
+	store := openTestDiskImageStore(t, imagesFolder)
+
+	image := factory.NewImage(".png", 4, 4)
+
+	var imageIDS []string
+
+	for i, laptopID := range []string{"laptop", "other-laptop", "laptop"} {
+		imageWriter, err := store.Create(laptopID, ".png")
+		require.NoError(t, err)
+
+		_, err = imageWriter.Write(image)
+		require.NoError(t, err)
+
+		imageID, err := imageWriter.Commit()
+		require.NoError(t, err)
+		require.Equal(t, i > 0, imageWriter.Deduplicated())
+
+		imageIDS = append(imageIDS, imageID)
+	}
+
+	info, err := store.Find(imageIDS[0])
+	require.NoError(t, err)
+
+	checksum := sha256.Sum256(image)
+	require.Equal(t, hex.EncodeToString(checksum[:]), info.SHA256)
+
+	// The images share a single file.
+	imagePaths, err := filepath.Glob(filepath.Join(imagesFolder, "*.png"))
+	require.NoError(t, err)
+	require.Equal(t, []string{info.Path}, imagePaths)
+
+	require.NoError(t, store.Delete(imageIDS[0]))
+	require.FileExists(t, info.Path)
+
+	// The references are counted again when the store is opened.
+	store = openTestDiskImageStore(t, imagesFolder)
+
+	require.NoError(t, store.Delete(imageIDS[1]))
+	require.FileExists(t, info.Path)
+
+	_, imageData, err := store.Open(imageIDS[2])
+	require.NoError(t, err)
+	require.NoError(t, imageData.Close())
+
+	// The file is removed with the last image referencing it.
+	require.NoError(t, store.Delete(imageIDS[2]))
+	require.NoFileExists(t, info.Path)
+
+	// A deleted file is written again by the next image with its data.
+	imageID, err := SaveImage(store, "laptop", ".png", bytes.NewReader(image))
+	require.NoError(t, err)
+
+	_, imageData, err = store.Open(imageID)
+	require.NoError(t, err)
+	require.NoError(t, imageData.Close())
+	require.FileExists(t, info.Path)
+}
+
+func TestDiskImageStore_JPEGMetadata(t *testing.T) {
+	t.Parallel()
+
+	store := openTestDiskImageStore(t, t.TempDir())
+
+	// EXIF data, ICC profiles and thumbnails before the frame header can take far more than the header kept to read
+	// the dimensions, as an APP1 segment holds up to 64KB.
+	jpegImage := factory.NewImage(".jpg", 3, 2)
+	metadata := bytes.Repeat([]byte("\xff\xe1\xff\xff"+string(make([]byte, 0xfffd))), 2*maxImageHeaderSize/0xffff)
+
+	image := append(append(append([]byte{}, jpegImage[:2]...), metadata...), jpegImage[2:]...)
+
+	imageWriter, err := store.Create("laptop", ".jpg")
+	require.NoError(t, err)
+
+	for chunk := image; len(chunk) > 0; {
+		n := 1000
+		if n > len(chunk) {
+			n = len(chunk)
+		}
+
+		_, err := imageWriter.Write(chunk[:n])
+		require.NoError(t, err)
+
+		chunk = chunk[n:]
+	}
+
+	imageID, err := imageWriter.Commit()
+	require.NoError(t, err)
+
+	info, err := store.Find(imageID)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(image)), info.Size)
+	require.Equal(t, 3, info.Width)
+	require.Equal(t, 2, info.Height)
+}
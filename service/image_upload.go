@@ -0,0 +1,194 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultImageUploadTimeout is how long an image upload is kept without receiving any data when the server is not
+// given a timeout
+const DefaultImageUploadTimeout = 30 * time.Minute
+
+var (
+	// ErrImageUploadOffset is returned when a chunk of an image upload does not start where the received data ends.
+	ErrImageUploadOffset = errors.New("image upload chunk offset mismatch")
+	// ErrImageUploadChecksum is returned when an uploaded image does not match its checksum.
+	ErrImageUploadChecksum = errors.New("image upload checksum mismatch")
+	// ErrImageTooLarge is returned when an uploaded image exceeds the size limit.
+	ErrImageTooLarge = errors.New("image too large")
+)
+
+type (
+	// imageUpload is an image being uploaded in chunks, possibly over several streams
+	imageUpload struct {
+		mutex     sync.Mutex
+		uploads   *imageUploads
+		id        string
+		writer    ImageWriter
+		size      int64
+		expiresAt time.Time
+		timer     *time.Timer
+		done      bool
+	}
+
+	// imageUploads keeps the image uploads in progress, dropping those that receive no data within the timeout
+	imageUploads struct {
+		mutex   sync.Mutex
+		timeout time.Duration
+		uploads map[string]*imageUpload
+	}
+)
+
+// newImageUploads creates a new imageUploads that drops uploads after the timeout
+func newImageUploads(timeout time.Duration) *imageUploads {
+	return &imageUploads{
+		timeout: timeout,
+		uploads: make(map[string]*imageUpload),
+	}
+}
+
+// start starts a new upload of the image written by the writer
+func (uploads *imageUploads) start(writer ImageWriter) (*imageUpload, error) {
+	uploadID, err := uuid.NewRandom()
+	if err != nil {
+		return nil, fmt.Errorf("error generating upload ID: %w", err)
+	}
+
+	upload := &imageUpload{
+		uploads:   uploads,
+		id:        uploadID.String(),
+		writer:    writer,
+		expiresAt: time.Now().Add(uploads.timeout),
+	}
+
+	// The upload is locked before the uploads, as when it is discarded.
+	upload.mutex.Lock()
+	defer upload.mutex.Unlock()
+
+	uploads.mutex.Lock()
+	uploads.uploads[upload.id] = upload
+	uploads.mutex.Unlock()
+
+	upload.timer = time.AfterFunc(uploads.timeout, upload.expire)
+
+	return upload, nil
+}
+
+// find returns the upload with the id, or ErrRecordNotFound if there is none
+func (uploads *imageUploads) find(uploadID string) (*imageUpload, error) {
+	uploads.mutex.Lock()
+	defer uploads.mutex.Unlock()
+
+	upload := uploads.uploads[uploadID]
+	if upload == nil {
+		return nil, ErrRecordNotFound
+	}
+
+	return upload, nil
+}
+
+// remove removes the upload
+func (uploads *imageUploads) remove(upload *imageUpload) {
+	uploads.mutex.Lock()
+	defer uploads.mutex.Unlock()
+
+	delete(uploads.uploads, upload.id)
+}
+
+// expire discards the upload unless it received data since its timer was started
+func (upload *imageUpload) expire() {
+	upload.mutex.Lock()
+	defer upload.mutex.Unlock()
+
+	if upload.done || time.Now().Before(upload.expiresAt) {
+		return
+	}
+
+	upload.discard()
+}
+
+// status returns the number of bytes received so far and when the upload expires
+func (upload *imageUpload) status() (int64, time.Time, error) {
+	upload.mutex.Lock()
+	defer upload.mutex.Unlock()
+
+	if upload.done {
+		return 0, time.Time{}, ErrRecordNotFound
+	}
+
+	return upload.size, upload.expiresAt, nil
+}
+
+// write writes a chunk that starts at the offset and returns the number of bytes received so far. The upload is
+// discarded once it grows beyond maxSize or its image fails to be written.
+func (upload *imageUpload) write(offset int64, chunk []byte, maxSize int64) (int64, error) {
+	upload.mutex.Lock()
+	defer upload.mutex.Unlock()
+
+	if upload.done {
+		return 0, ErrRecordNotFound
+	}
+
+	if offset != upload.size {
+		return upload.size, fmt.Errorf("%w: expected offset %d, got %d", ErrImageUploadOffset, upload.size, offset)
+	}
+
+	if upload.size+int64(len(chunk)) > maxSize {
+		upload.discard()
+		return upload.size, fmt.Errorf("%w: the maximum size is %d bytes", ErrImageTooLarge, maxSize)
+	}
+
+	// A writer that failed may have written part of the chunk, so the data it holds no longer ends at the size
+	// received, and data that is not an image does not become one with more chunks.
+	if _, err := upload.writer.Write(chunk); err != nil {
+		upload.discard()
+		return upload.size, err
+	}
+
+	upload.size += int64(len(chunk))
+
+	upload.expiresAt = time.Now().Add(upload.uploads.timeout)
+	upload.timer.Reset(upload.uploads.timeout)
+
+	return upload.size, nil
+}
+
+// finalize stores the uploaded image in the image store it is written to if it matches the hex encoded SHA-256
+// checksum and returns its id and size. An image that does not match is discarded without being stored.
+func (upload *imageUpload) finalize(checksum string) (string, int64, error) {
+	upload.mutex.Lock()
+	defer upload.mutex.Unlock()
+
+	if upload.done {
+		return "", 0, ErrRecordNotFound
+	}
+
+	if received := upload.writer.Checksum(); received != strings.ToLower(checksum) {
+		upload.discard()
+		return "", 0, fmt.Errorf("%w: expected %s, got %s", ErrImageUploadChecksum, checksum, received)
+	}
+
+	upload.done = true
+	upload.timer.Stop()
+	upload.uploads.remove(upload)
+
+	imageID, err := upload.writer.Commit()
+	if err != nil {
+		return "", 0, err
+	}
+
+	return imageID, upload.size, nil
+}
+
+// discard drops the upload and aborts its image. The caller holds the mutex.
+func (upload *imageUpload) discard() {
+	upload.done = true
+	upload.timer.Stop()
+	upload.uploads.remove(upload)
+
+	_ = upload.writer.Abort()
+}
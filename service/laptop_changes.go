@@ -0,0 +1,112 @@
+package service
+
+import (
+	"errors"
+	"github.com/jwambugu/pcbook-grpc/protos/pb"
+	"sync"
+)
+
+// laptopChangesBufferSize is the number of changes a subscription buffers before its subscriber is considered too
+// slow and dropped
+const laptopChangesBufferSize = 256
+
+// ErrSubscriberTooSlow is returned by a subscription that was dropped because its buffer of changes filled up
+var ErrSubscriberTooSlow = errors.New("subscriber too slow to keep up with laptop changes")
+
+// LaptopChangeType is the kind of write that changed a laptop
+type LaptopChangeType int
+
+const (
+	LaptopCreated LaptopChangeType = iota + 1
+	LaptopUpdated
+	LaptopDeleted
+)
+
+// LaptopChange is a write to a laptop store. The laptops are shared between subscribers and must not be modified.
+type LaptopChange struct {
+	Type LaptopChangeType
+	// Laptop is the laptop as written, or as it was before it was deleted
+	Laptop *pb.Laptop
+	// Previous is the laptop before an update or a deletion, nil on creation
+	Previous *pb.Laptop
+}
+
+// LaptopSubscription receives the changes of a laptop store in the order they were written
+type LaptopSubscription struct {
+	changes     chan LaptopChange
+	err         error
+	broadcaster *laptopBroadcaster
+}
+
+// Changes returns the channel of changes, which is closed when the subscription ends
+func (subscription *LaptopSubscription) Changes() <-chan LaptopChange {
+	return subscription.changes
+}
+
+// Err returns ErrSubscriberTooSlow if the subscription was dropped, once the changes channel is closed
+func (subscription *LaptopSubscription) Err() error {
+	subscription.broadcaster.mutex.Lock()
+	defer subscription.broadcaster.mutex.Unlock()
+
+	return subscription.err
+}
+
+// Close ends the subscription
+func (subscription *LaptopSubscription) Close() {
+	subscription.broadcaster.mutex.Lock()
+	defer subscription.broadcaster.mutex.Unlock()
+
+	subscription.broadcaster.unsubscribe(subscription, nil)
+}
+
+// laptopBroadcaster fans the changes of a store out to its subscriptions. Publishing never blocks: a subscription
+// whose buffer is full is dropped instead of stalling the writer.
+type laptopBroadcaster struct {
+	mutex         sync.Mutex
+	subscriptions map[*LaptopSubscription]struct{}
+}
+
+func newLaptopBroadcaster() *laptopBroadcaster {
+	return &laptopBroadcaster{
+		subscriptions: make(map[*LaptopSubscription]struct{}),
+	}
+}
+
+// subscribe returns a new subscription to the changes published from now on
+func (broadcaster *laptopBroadcaster) subscribe() *LaptopSubscription {
+	broadcaster.mutex.Lock()
+	defer broadcaster.mutex.Unlock()
+
+	subscription := &LaptopSubscription{
+		changes:     make(chan LaptopChange, laptopChangesBufferSize),
+		broadcaster: broadcaster,
+	}
+
+	broadcaster.subscriptions[subscription] = struct{}{}
+	return subscription
+}
+
+// unsubscribe ends the subscription with the error. The caller must hold the mutex.
+func (broadcaster *laptopBroadcaster) unsubscribe(subscription *LaptopSubscription, err error) {
+	if _, ok := broadcaster.subscriptions[subscription]; !ok {
+		return
+	}
+
+	delete(broadcaster.subscriptions, subscription)
+	subscription.err = err
+	close(subscription.changes)
+}
+
+// publish sends the change to every subscription, dropping the ones that cannot take it
+func (broadcaster *laptopBroadcaster) publish(change LaptopChange) {
+	broadcaster.mutex.Lock()
+	defer broadcaster.mutex.Unlock()
+
+	for subscription := range broadcaster.subscriptions {
+		select {
+		case subscription.changes <- change:
+		default:
+			broadcaster.unsubscribe(subscription, ErrSubscriberTooSlow)
+		}
+	}
+}
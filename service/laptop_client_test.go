@@ -2,24 +2,66 @@ package service
 
 import (
 	"bufio"
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"github.com/jwambugu/pcbook-grpc/factory"
 	"github.com/jwambugu/pcbook-grpc/protos/pb"
 	"github.com/jwambugu/pcbook-grpc/serializer"
 	"github.com/stretchr/testify/require"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"image"
 	"io"
+	"math"
 	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"sort"
 	"testing"
+	"time"
 )
 
-func startLaptopTestServer(t *testing.T, laptopStore LaptopStore, imageStore ImageStore, ratingStore RatingStore) string {
-	laptopServer := NewLaptopServer(laptopStore, imageStore, ratingStore)
+func startLaptopTestServer(
+	t *testing.T,
+	laptopStore LaptopStore,
+	imageStore ImageStore,
+	ratingStore RatingStore,
+	options ...LaptopServerOption,
+) string {
+	laptopServer := NewLaptopServer(laptopStore, imageStore, ratingStore, options...)
 
-	grpcServer := grpc.NewServer()
+	return serveTestLaptopServer(t, laptopServer)
+}
+
+// startAuthLaptopTestServer starts a server that authorizes the RateLaptop calls of users with access tokens of the
+// JWT manager
+func startAuthLaptopTestServer(
+	t *testing.T,
+	jwtManager *JWTManager,
+	laptopStore LaptopStore,
+	ratingStore RatingStore,
+	options ...LaptopServerOption,
+) string {
+	interceptor := NewAuthInterceptor(jwtManager, map[string][]string{
+		"/pcbook.LaptopService/RateLaptop": {"admin", "user"},
+	})
+
+	laptopServer := NewLaptopServer(laptopStore, nil, ratingStore, options...)
+
+	return serveTestLaptopServer(t, laptopServer, grpc.StreamInterceptor(interceptor.Stream()))
+}
+
+// serveTestLaptopServer serves the laptop server on a new listener and returns its address
+func serveTestLaptopServer(t *testing.T, laptopServer *LaptopServer, serverOptions ...grpc.ServerOption) string {
+	grpcServer := grpc.NewServer(serverOptions...)
 	pb.RegisterLaptopServiceServer(grpcServer, laptopServer)
 
 	listener, err := net.Listen("tcp", ":0")
@@ -40,6 +82,14 @@ func newTestLaptopClient(t *testing.T, address string) pb.LaptopServiceClient {
 	return pb.NewLaptopServiceClient(conn)
 }
 
+// newTestUserContext returns a context that sends an access token of the user signed by the JWT manager
+func newTestUserContext(t *testing.T, jwtManager *JWTManager, username string) context.Context {
+	accessToken, err := jwtManager.Generate(&User{Username: username, Role: "user"})
+	require.NoError(t, err)
+
+	return metadata.AppendToOutgoingContext(context.Background(), "authorization", accessToken)
+}
+
 func requireSameLaptop(t *testing.T, expected *pb.Laptop, actual *pb.Laptop) {
 	expectedJSON, err := serializer.ProtobufToJSON(expected)
 	require.NoError(t, err)
@@ -73,6 +123,12 @@ func TestLaptopClient_CreateLaptop(t *testing.T) {
 	createdLaptop, err := laptopStore.Find(res.Id)
 	require.NoError(t, err)
 	require.NotNil(t, createdLaptop)
+	require.EqualValues(t, 1, createdLaptop.GetRevision())
+	require.False(t, createdLaptop.GetUpdatedAt().AsTime().Before(laptop.GetUpdatedAt().AsTime()))
+
+	// The server stamps the revision and updated_at of every laptop it saves.
+	laptop.Revision = createdLaptop.GetRevision()
+	laptop.UpdatedAt = createdLaptop.GetUpdatedAt()
 
 	requireSameLaptop(t, laptop, createdLaptop)
 }
@@ -81,9 +137,9 @@ func TestLaptopServer_SearchLaptop(t *testing.T) {
 	t.Parallel()
 
 	filter := &pb.Filter{
-		MaxPriceUsd:     2000,
-		MinCpuCores:     4,
-		MinCpuFrequency: 2.2,
+		MaxPriceUsd:     proto.Float64(2000),
+		MinCpuCores:     proto.Uint32(4),
+		MinCpuFrequency: proto.Float64(2.2),
 		MinRam: &pb.Memory{
 			Value: 8,
 			Unit:  pb.Memory_GIGABYTE,
@@ -102,7 +158,7 @@ func TestLaptopServer_SearchLaptop(t *testing.T) {
 		case 1:
 			laptop.Cpu.NumberOfCores = 2
 		case 2:
-			laptop.Cpu.MinimumFrequency = 2.0
+			laptop.Cpu.MaximumFrequency = 2.0
 		case 3:
 			laptop.Ram = &pb.Memory{Value: 4096, Unit: pb.Memory_MEGABYTE}
 		case 4:
@@ -148,13 +204,465 @@ func TestLaptopServer_SearchLaptop(t *testing.T) {
 	require.Equal(t, len(expectedIDS), found)
 }
 
+func TestLaptopServer_SearchLaptopOrderBy(t *testing.T) {
+	t.Parallel()
+
+	filter := &pb.Filter{}
+
+	laptopStore := NewInMemoryLaptopStore()
+	ratingStore := NewInMemoryRatingStore()
+	laptops := make([]*pb.Laptop, 4)
+
+	for i := range laptops {
+		laptop := factory.NewLaptop()
+		laptop.Ram = &pb.Memory{Value: 16, Unit: pb.Memory_GIGABYTE}
+		laptop.PriceUsd = float64(2000 - i*100)
+
+		err := laptopStore.Save(laptop)
+		require.NoError(t, err)
+
+		_, err = ratingStore.Rate(laptop.GetId(), "user", float64(i%2*5+i))
+		require.NoError(t, err)
+
+		laptops[i] = laptop
+	}
+
+	// The last laptop has the most RAM, the others tie on RAM and are ordered by price.
+	laptops[3].Ram = &pb.Memory{Value: 32768, Unit: pb.Memory_MEGABYTE}
+	err := laptopStore.Update(laptops[3])
+	require.NoError(t, err)
+
+	serverAddress := startLaptopTestServer(t, laptopStore, nil, ratingStore)
+	laptopClient := newTestLaptopClient(t, serverAddress)
+
+	unratedServerAddress := startLaptopTestServer(t, laptopStore, nil, nil)
+	unratedLaptopClient := newTestLaptopClient(t, unratedServerAddress)
+
+	testCases := []struct {
+		name        string
+		orderBy     string
+		unrated     bool
+		expectedIDS []string
+		code        codes.Code
+	}{
+		{
+			name:        "orders by several fields",
+			orderBy:     "ram desc, price_usd",
+			expectedIDS: []string{laptops[3].Id, laptops[2].Id, laptops[1].Id, laptops[0].Id},
+			code:        codes.OK,
+		},
+		{
+			name:        "orders by average rating",
+			orderBy:     "rating desc",
+			expectedIDS: []string{laptops[3].Id, laptops[1].Id, laptops[2].Id, laptops[0].Id},
+			code:        codes.OK,
+		},
+		{
+			name:    "fails to order by an unsupported field",
+			orderBy: "colour",
+			code:    codes.InvalidArgument,
+		},
+		{
+			name:    "fails to order by rating without a rating store",
+			orderBy: "rating desc",
+			unrated: true,
+			code:    codes.FailedPrecondition,
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			client := laptopClient
+			if tc.unrated {
+				client = unratedLaptopClient
+			}
+
+			req := &pb.SearchLaptopRequest{Filter: filter, OrderBy: tc.orderBy}
+			stream, err := client.SearchLaptop(context.Background(), req)
+			require.NoError(t, err)
+
+			var foundIDS []string
+
+			for {
+				res, err := stream.Recv()
+				if err == io.EOF {
+					break
+				}
+
+				if tc.code != codes.OK {
+					require.Equal(t, tc.code, status.Code(err))
+					return
+				}
+
+				require.NoError(t, err)
+				foundIDS = append(foundIDS, res.GetLaptop().GetId())
+			}
+
+			require.Equal(t, tc.expectedIDS, foundIDS)
+		})
+	}
+}
+
+func TestLaptopServer_SearchLaptopQuery(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := NewInMemoryLaptopStore()
+	var expectedIDS []string
+
+	for i, brand := range []string{"Dell", "Dell", "Apple", "Dell"} {
+		laptop := factory.NewLaptop()
+		laptop.Brand = brand
+		laptop.PriceUsd = float64(1000 + i*500)
+
+		err := laptopStore.Save(laptop)
+		require.NoError(t, err)
+
+		if brand == "Dell" && laptop.PriceUsd < 2500 {
+			expectedIDS = append([]string{laptop.GetId()}, expectedIDS...)
+		}
+	}
+
+	serverAddress := startLaptopTestServer(t, laptopStore, nil, nil)
+	laptopClient := newTestLaptopClient(t, serverAddress)
+
+	req := &pb.SearchLaptopRequest{
+		Filter: &pb.Filter{MaxPriceUsd: proto.Float64(5000)},
+		Query:  "brand:dell price<2500 sort:-price",
+	}
+
+	stream, err := laptopClient.SearchLaptop(context.Background(), req)
+	require.NoError(t, err)
+
+	var foundIDS []string
+
+	for {
+		res, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+
+		require.NoError(t, err)
+		foundIDS = append(foundIDS, res.GetLaptop().GetId())
+	}
+
+	require.Equal(t, expectedIDS, foundIDS)
+
+	stream, err = laptopClient.SearchLaptop(context.Background(), &pb.SearchLaptopRequest{Query: "brand:dell price<"})
+	require.NoError(t, err)
+
+	_, err = stream.Recv()
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	require.Contains(t, status.Convert(err).Message(), "position 18")
+}
+
+func TestLaptopServer_SearchLaptopText(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := NewInMemoryLaptopStore()
+	laptops := make(map[string]*pb.Laptop)
+
+	for _, name := range []string{"Thinkpad P53", "Thinkpad X1", "Macbook Pro", "Thinkpad P53s"} {
+		laptop := factory.NewLaptop()
+		laptop.Brand = "Lenovo"
+		laptop.Name = name
+
+		err := laptopStore.Save(laptop)
+		require.NoError(t, err)
+
+		laptops[name] = laptop
+	}
+
+	serverAddress := startLaptopTestServer(t, laptopStore, nil, nil)
+	laptopClient := newTestLaptopClient(t, serverAddress)
+
+	req := &pb.SearchLaptopRequest{Text: "thinkpad p53"}
+
+	stream, err := laptopClient.SearchLaptop(context.Background(), req)
+	require.NoError(t, err)
+
+	var foundIDS []string
+	var scores []float64
+
+	for {
+		res, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+
+		require.NoError(t, err)
+		foundIDS = append(foundIDS, res.GetLaptop().GetId())
+		scores = append(scores, res.GetScore())
+	}
+
+	// The exact match ranks above the laptop that only matches the prefix "p53".
+	require.Equal(t, []string{laptops["Thinkpad P53"].GetId(), laptops["Thinkpad P53s"].GetId()}, foundIDS)
+	require.Greater(t, scores[0], scores[1])
+}
+
+func TestLaptopServer_WatchLaptops(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := NewInMemoryLaptopStore()
+
+	existingLaptop := factory.NewLaptop()
+	existingLaptop.PriceUsd = 1000
+
+	err := laptopStore.Save(existingLaptop)
+	require.NoError(t, err)
+
+	serverAddress := startLaptopTestServer(t, laptopStore, nil, nil)
+	laptopClient := newTestLaptopClient(t, serverAddress)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	req := &pb.WatchLaptopsRequest{Filter: &pb.Filter{MaxPriceUsd: proto.Float64(2000)}}
+
+	stream, err := laptopClient.WatchLaptops(ctx, req)
+	require.NoError(t, err)
+
+	requireEvent := func(expectedEvent pb.WatchLaptopsResponse_Event, expectedID string) {
+		res, err := stream.Recv()
+		require.NoError(t, err)
+		require.Equal(t, expectedEvent, res.GetEvent())
+		require.Equal(t, expectedID, res.GetLaptop().GetId())
+	}
+
+	requireEvent(pb.WatchLaptopsResponse_EXISTING, existingLaptop.GetId())
+
+	// Laptops that never match the filter are not sent.
+	expensiveLaptop := factory.NewLaptop()
+	expensiveLaptop.PriceUsd = 3000
+
+	err = laptopStore.Save(expensiveLaptop)
+	require.NoError(t, err)
+
+	newLaptop := factory.NewLaptop()
+	newLaptop.PriceUsd = 1500
+
+	err = laptopStore.Save(newLaptop)
+	require.NoError(t, err)
+	requireEvent(pb.WatchLaptopsResponse_CREATED, newLaptop.GetId())
+
+	newLaptop.PriceUsd = 1600
+	err = laptopStore.Update(newLaptop)
+	require.NoError(t, err)
+	requireEvent(pb.WatchLaptopsResponse_UPDATED, newLaptop.GetId())
+
+	existingLaptop.PriceUsd = 2500
+	err = laptopStore.Update(existingLaptop)
+	require.NoError(t, err)
+	requireEvent(pb.WatchLaptopsResponse_DELETED, existingLaptop.GetId())
+
+	expensiveLaptop.PriceUsd = 1900
+	err = laptopStore.Update(expensiveLaptop)
+	require.NoError(t, err)
+	requireEvent(pb.WatchLaptopsResponse_CREATED, expensiveLaptop.GetId())
+
+	err = laptopStore.Delete(newLaptop.GetId(), 0)
+	require.NoError(t, err)
+	requireEvent(pb.WatchLaptopsResponse_DELETED, newLaptop.GetId())
+
+	cancel()
+
+	_, err = stream.Recv()
+	require.Equal(t, codes.Canceled, status.Code(err))
+}
+
+// replayTestLaptopStore is a laptop store whose searches run a hook after the first laptop found and skip the laptops
+// deleted since, as a store scanning its laptops while they are written would.
+type replayTestLaptopStore struct {
+	*InMemoryLaptopStore
+	afterFirst func(first *pb.Laptop) error
+}
+
+func (store *replayTestLaptopStore) Search(
+	ctx context.Context, filter *pb.Filter, found func(laptop *pb.Laptop) error,
+) error {
+	var first *pb.Laptop
+
+	return store.InMemoryLaptopStore.Search(ctx, filter, func(laptop *pb.Laptop) error {
+		if first == nil {
+			first = laptop
+
+			if err := found(laptop); err != nil {
+				return err
+			}
+
+			return store.afterFirst(first)
+		}
+
+		current, err := store.Find(laptop.GetId())
+		if err != nil || current == nil {
+			return err
+		}
+
+		return found(laptop)
+	})
+}
+
+func TestLaptopServer_WatchLaptopsReplay(t *testing.T) {
+	t.Parallel()
+
+	const updates = laptopChangesBufferSize + 10
+
+	laptopStore := &replayTestLaptopStore{InMemoryLaptopStore: NewInMemoryLaptopStore()}
+
+	var ids []string
+
+	for i := 0; i < 2; i++ {
+		laptop := factory.NewLaptop()
+
+		err := laptopStore.Save(laptop)
+		require.NoError(t, err)
+
+		ids = append(ids, laptop.GetId())
+	}
+
+	// waitForWatch waits until the subscriptions of the store have taken every change published
+	waitForWatch := func() error {
+		for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+			laptopStore.changes.mutex.Lock()
+
+			var buffered int
+			for subscription := range laptopStore.changes.subscriptions {
+				buffered += len(subscription.changes)
+			}
+
+			laptopStore.changes.mutex.Unlock()
+
+			if buffered == 0 {
+				return nil
+			}
+		}
+
+		return fmt.Errorf("the watch did not take the changes made during the replay")
+	}
+
+	// While the replay runs, the laptop it has not reached yet is deleted and the first one is updated more often
+	// than a subscription buffers. The watch takes the changes while the replay is still running.
+	laptopStore.afterFirst = func(first *pb.Laptop) error {
+		for _, id := range ids {
+			if id == first.GetId() {
+				continue
+			}
+
+			if err := laptopStore.Delete(id, 0); err != nil {
+				return err
+			}
+		}
+
+		updatedLaptop := proto.Clone(first).(*pb.Laptop)
+
+		for i := 0; i < updates; i++ {
+			updatedLaptop.PriceUsd++
+
+			if err := laptopStore.Update(updatedLaptop); err != nil {
+				return err
+			}
+
+			if err := waitForWatch(); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	serverAddress := startLaptopTestServer(t, laptopStore, nil, nil)
+	laptopClient := newTestLaptopClient(t, serverAddress)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stream, err := laptopClient.WatchLaptops(ctx, &pb.WatchLaptopsRequest{})
+	require.NoError(t, err)
+
+	res, err := stream.Recv()
+	require.NoError(t, err)
+	require.Equal(t, pb.WatchLaptopsResponse_EXISTING, res.GetEvent())
+
+	firstID := res.GetLaptop().GetId()
+
+	for i := 0; i < updates; i++ {
+		res, err := stream.Recv()
+		require.NoError(t, err)
+		require.Equal(t, pb.WatchLaptopsResponse_UPDATED, res.GetEvent())
+		require.Equal(t, firstID, res.GetLaptop().GetId())
+	}
+
+	// The deleted laptop was never sent, so its deletion is not either.
+	newLaptop := factory.NewLaptop()
+
+	err = laptopStore.Save(newLaptop)
+	require.NoError(t, err)
+
+	res, err = stream.Recv()
+	require.NoError(t, err)
+	require.Equal(t, pb.WatchLaptopsResponse_CREATED, res.GetEvent())
+	require.Equal(t, newLaptop.GetId(), res.GetLaptop().GetId())
+}
+
+func TestLaptopServer_ListLaptops(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := NewInMemoryLaptopStore()
+	expectedIDS := make([]string, 0, 7)
+
+	for i := 0; i < 7; i++ {
+		laptop := factory.NewLaptop()
+
+		err := laptopStore.Save(laptop)
+		require.NoError(t, err)
+
+		expectedIDS = append(expectedIDS, laptop.GetId())
+	}
+
+	sort.Strings(expectedIDS)
+
+	serverAddress := startLaptopTestServer(t, laptopStore, nil, nil)
+	laptopClient := newTestLaptopClient(t, serverAddress)
+
+	var foundIDS []string
+	req := &pb.ListLaptopsRequest{PageSize: 3}
+
+	for {
+		res, err := laptopClient.ListLaptops(context.Background(), req)
+		require.NoError(t, err)
+		require.LessOrEqual(t, len(res.GetLaptops()), 3)
+
+		for _, laptop := range res.GetLaptops() {
+			foundIDS = append(foundIDS, laptop.GetId())
+		}
+
+		if res.GetNextPageToken() == "" {
+			break
+		}
+
+		// Deleting a laptop that was already listed must not shift the following pages.
+		err = laptopStore.Delete(foundIDS[len(foundIDS)-1], 0)
+		require.NoError(t, err)
+
+		req.PageToken = res.GetNextPageToken()
+	}
+
+	require.Equal(t, expectedIDS, foundIDS)
+
+	_, err := laptopClient.ListLaptops(context.Background(), &pb.ListLaptopsRequest{PageToken: "!"})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
 func TestLaptopServer_UploadImage(t *testing.T) {
 	t.Parallel()
 
 	testImagesFolder := "../tmp"
 
 	laptopStore := NewInMemoryLaptopStore()
-	imageStore := NewDiskImageStore(testImagesFolder)
+	imageStore := openTestDiskImageStore(t, t.TempDir())
 
 	laptop := factory.NewLaptop()
 
@@ -218,56 +726,1134 @@ func TestLaptopServer_UploadImage(t *testing.T) {
 	require.NotEmpty(t, res.GetId())
 	require.EqualValues(t, size, res.GetSize())
 
-	uploadedImagePath := fmt.Sprintf("%s/%s%s", testImagesFolder, res.GetId(), ext)
-	require.FileExists(t, uploadedImagePath)
-	require.NoError(t, os.Remove(uploadedImagePath))
+	info, err := imageStore.Find(res.GetId())
+	require.NoError(t, err)
+	require.NotNil(t, info)
+	require.Equal(t, laptop.GetId(), info.LaptopID)
+	require.FileExists(t, info.Path)
 }
 
-func TestLaptopServer_RateLaptop(t *testing.T) {
+func TestLaptopServer_UploadImageDiscarded(t *testing.T) {
 	t.Parallel()
 
-	laptopStore := NewInMemoryLaptopStore()
-	ratingStore := NewInMemoryRatingStore()
+	// The image is larger than the size limit of the server.
+	image := factory.NewImage(".png", 32, 32)
+
+	testCases := []struct {
+		name   string
+		upload func(t *testing.T, stream pb.LaptopService_UploadImageClient, cancel context.CancelFunc)
+		code   codes.Code
+	}{
+		{
+			name: "too large",
+			upload: func(t *testing.T, stream pb.LaptopService_UploadImageClient, cancel context.CancelFunc) {
+				for i := 0; i < len(image); i += 512 {
+					end := i + 512
+					if end > len(image) {
+						end = len(image)
+					}
+
+					req := &pb.UploadImageRequest{
+						Data: &pb.UploadImageRequest_ChunkData{
+							ChunkData: image[i:end],
+						},
+					}
+
+					// The server stops receiving once the limit is exceeded.
+					if err := stream.Send(req); err == io.EOF {
+						return
+					}
+				}
+			},
+			code: codes.InvalidArgument,
+		},
+		{
+			name: "not an image",
+			upload: func(t *testing.T, stream pb.LaptopService_UploadImageClient, cancel context.CancelFunc) {
+				req := &pb.UploadImageRequest{
+					Data: &pb.UploadImageRequest_ChunkData{
+						ChunkData: []byte("#!/bin/sh\necho not an image\n"),
+					},
+				}
+
+				require.NoError(t, stream.Send(req))
+			},
+			code: codes.InvalidArgument,
+		},
+		{
+			name: "cancelled",
+			upload: func(t *testing.T, stream pb.LaptopService_UploadImageClient, cancel context.CancelFunc) {
+				req := &pb.UploadImageRequest{
+					Data: &pb.UploadImageRequest_ChunkData{
+						ChunkData: image[:512],
+					},
+				}
+
+				require.NoError(t, stream.Send(req))
+				cancel()
+			},
+			code: codes.Canceled,
+		},
+	}
 
-	laptop := factory.NewLaptop()
+	for _, tc := range testCases {
+		tc := tc
 
-	err := laptopStore.Save(laptop)
-	require.NoError(t, err)
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
 
-	serverAddress := startLaptopTestServer(t, laptopStore, nil, ratingStore)
-	laptopClient := newTestLaptopClient(t, serverAddress)
+			imagesFolder := t.TempDir()
 
-	stream, err := laptopClient.RateLaptop(context.Background())
-	require.NoError(t, err)
+			laptopStore := NewInMemoryLaptopStore()
+			imageStore := openTestDiskImageStore(t, imagesFolder)
 
-	scores := []float64{8, 7.5, 10}
-	averageScores := []float64{8, 7.75, 8.5}
+			laptop := factory.NewLaptop()
 
-	n := len(scores)
+			err := laptopStore.Save(laptop)
+			require.NoError(t, err)
 
-	for i := 0; i < n; i++ {
-		req := &pb.RateLaptopRequest{
-			LaptopId: laptop.GetId(),
-			Score:    scores[i],
-		}
+			serverAddress := startLaptopTestServer(t, laptopStore, imageStore, nil, WithMaxImageSize(1024))
+			laptopClient := newTestLaptopClient(t, serverAddress)
 
-		err = stream.Send(req)
-		require.NoError(t, err)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			stream, err := laptopClient.UploadImage(ctx)
+			require.NoError(t, err)
+
+			req := &pb.UploadImageRequest{
+				Data: &pb.UploadImageRequest_Info{
+					Info: &pb.ImageInfo{
+						LaptopId:      laptop.GetId(),
+						FileExtension: ".png",
+					},
+				},
+			}
+
+			err = stream.Send(req)
+			require.NoError(t, err)
+
+			tc.upload(t, stream, cancel)
+
+			_, err = stream.CloseAndRecv()
+			require.Equal(t, tc.code, status.Code(err))
+
+			// The partly written image is removed once the server gives up on the upload.
+			require.Eventually(t, func() bool {
+				entries, err := os.ReadDir(imagesFolder)
+				return err == nil && len(entries) == 0
+			}, time.Second, 10*time.Millisecond)
+
+			images, err := imageStore.List(laptop.GetId())
+			require.NoError(t, err)
+			require.Empty(t, images)
+		})
+	}
+}
+
+// sendImageChunks sends the chunks of an image upload starting at the offset in a single stream
+func sendImageChunks(
+	t *testing.T, laptopClient pb.LaptopServiceClient, uploadID string, offset uint64, chunks ...[]byte,
+) (*pb.UploadImageChunkResponse, error) {
+	stream, err := laptopClient.UploadImageChunk(context.Background())
+	require.NoError(t, err)
+
+	for _, chunk := range chunks {
+		req := &pb.UploadImageChunkRequest{
+			UploadId:  uploadID,
+			Offset:    offset,
+			ChunkData: chunk,
+		}
+
+		if err := stream.Send(req); err != nil {
+			break
+		}
+
+		offset += uint64(len(chunk))
+	}
+
+	return stream.CloseAndRecv()
+}
+
+func TestLaptopServer_ImageUploadSession(t *testing.T) {
+	t.Parallel()
+
+	imagesFolder := t.TempDir()
+
+	laptopStore := NewInMemoryLaptopStore()
+	imageStore := openTestDiskImageStore(t, imagesFolder)
+
+	laptop := factory.NewLaptop()
+
+	err := laptopStore.Save(laptop)
+	require.NoError(t, err)
+
+	image := factory.NewImage(".png", 2, 2)
+	imageSize := uint64(len(image))
+
+	serverAddress := startLaptopTestServer(t, laptopStore, imageStore, nil, WithMaxImageSize(int64(imageSize)))
+	laptopClient := newTestLaptopClient(t, serverAddress)
+
+	initImageUpload := func() string {
+		res, err := laptopClient.InitImageUpload(context.Background(), &pb.InitImageUploadRequest{
+			Info: &pb.ImageInfo{LaptopId: laptop.GetId(), FileExtension: ".png"},
+		})
+		require.NoError(t, err)
+		require.NotEmpty(t, res.GetUploadId())
+		require.True(t, res.GetExpiresAt().AsTime().After(time.Now()))
+
+		return res.GetUploadId()
 	}
 
-	err = stream.CloseSend()
+	uploadID := initImageUpload()
+
+	res, err := sendImageChunks(t, laptopClient, uploadID, 0, image[:10], image[10:20])
 	require.NoError(t, err)
+	require.Equal(t, uint64(20), res.GetReceivedSize())
+
+	// A stream that resends received data is rejected without changing the upload.
+	_, err = sendImageChunks(t, laptopClient, uploadID, 10, image[10:20])
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	statusRes, err := laptopClient.GetImageUploadStatus(
+		context.Background(), &pb.GetImageUploadStatusRequest{UploadId: uploadID},
+	)
+	require.NoError(t, err)
+	require.Equal(t, uint64(20), statusRes.GetReceivedSize())
+
+	res, err = sendImageChunks(t, laptopClient, uploadID, 20, image[20:])
+	require.NoError(t, err)
+	require.Equal(t, imageSize, res.GetReceivedSize())
+
+	checksum := sha256.Sum256(image)
+
+	finalizeRes, err := laptopClient.FinalizeImageUpload(context.Background(), &pb.FinalizeImageUploadRequest{
+		UploadId: uploadID,
+		Sha256:   hex.EncodeToString(checksum[:]),
+	})
+	require.NoError(t, err)
+	require.Equal(t, imageSize, finalizeRes.GetSize())
+
+	info, imageData, err := imageStore.Open(finalizeRes.GetId())
+	require.NoError(t, err)
+	require.Equal(t, laptop.GetId(), info.LaptopID)
+	require.Equal(t, 2, info.Width)
+
+	data, err := io.ReadAll(imageData)
+	require.NoError(t, err)
+	require.NoError(t, imageData.Close())
+	require.Equal(t, image, data)
+
+	_, err = laptopClient.GetImageUploadStatus(context.Background(), &pb.GetImageUploadStatusRequest{UploadId: uploadID})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	// An image that does not match its checksum is discarded.
+	uploadID = initImageUpload()
+
+	_, err = sendImageChunks(t, laptopClient, uploadID, 0, image)
+	require.NoError(t, err)
+
+	_, err = laptopClient.FinalizeImageUpload(context.Background(), &pb.FinalizeImageUploadRequest{
+		UploadId: uploadID,
+		Sha256:   hex.EncodeToString(make([]byte, sha256.Size)),
+	})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = laptopClient.GetImageUploadStatus(context.Background(), &pb.GetImageUploadStatusRequest{UploadId: uploadID})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	// So is an image that grows beyond the size limit.
+	uploadID = initImageUpload()
+
+	_, err = sendImageChunks(t, laptopClient, uploadID, 0, image, []byte("more data"))
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = laptopClient.GetImageUploadStatus(context.Background(), &pb.GetImageUploadStatusRequest{UploadId: uploadID})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	// And data that is not an image.
+	uploadID = initImageUpload()
+
+	_, err = sendImageChunks(t, laptopClient, uploadID, 0, []byte("#!/bin/sh\necho not an image\n"))
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = laptopClient.GetImageUploadStatus(context.Background(), &pb.GetImageUploadStatusRequest{UploadId: uploadID})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	images, err := imageStore.List(laptop.GetId())
+	require.NoError(t, err)
+	require.Len(t, images, 1)
+
+	entries, err := os.ReadDir(imagesFolder)
+	require.NoError(t, err)
+	require.Len(t, entries, 2, "expected only the image and the index to be left")
+}
+
+func TestLaptopServer_ImageUploadChecksumMismatch(t *testing.T) {
+	t.Parallel()
+
+	imagesFolder := t.TempDir()
+
+	laptopStore := NewInMemoryLaptopStore()
+	imageStore := openTestDiskImageStore(t, imagesFolder)
+
+	laptop := factory.NewLaptop()
+
+	err := laptopStore.Save(laptop)
+	require.NoError(t, err)
+
+	serverAddress := startLaptopTestServer(t, laptopStore, imageStore, nil)
+	laptopClient := newTestLaptopClient(t, serverAddress)
+
+	res, err := laptopClient.InitImageUpload(context.Background(), &pb.InitImageUploadRequest{
+		Info: &pb.ImageInfo{LaptopId: laptop.GetId(), FileExtension: ".png"},
+	})
+	require.NoError(t, err)
+
+	_, err = sendImageChunks(t, laptopClient, res.GetUploadId(), 0, factory.NewImage(".png", 2, 2))
+	require.NoError(t, err)
+
+	_, err = laptopClient.FinalizeImageUpload(context.Background(), &pb.FinalizeImageUploadRequest{
+		UploadId: res.GetUploadId(),
+		Sha256:   hex.EncodeToString(make([]byte, sha256.Size)),
+	})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	listRes, err := laptopClient.ListImages(context.Background(), &pb.ListImagesRequest{LaptopId: laptop.GetId()})
+	require.NoError(t, err)
+	require.Empty(t, listRes.GetImages())
+
+	// The image is checked before it is committed, so neither its file nor the index were ever written.
+	entries, err := os.ReadDir(imagesFolder)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
+
+// failingImageStore is a DiskImageStore whose image writers write half of the data they are given and then fail
+type failingImageStore struct {
+	*DiskImageStore
+}
+
+// failingImageWriter is an ImageWriter that writes half of the data it is given and then fails
+type failingImageWriter struct {
+	ImageWriter
+}
+
+func (store *failingImageStore) Create(laptopID, extension string) (ImageWriter, error) {
+	imageWriter, err := store.DiskImageStore.Create(laptopID, extension)
+	if err != nil {
+		return nil, err
+	}
+
+	return &failingImageWriter{ImageWriter: imageWriter}, nil
+}
+
+func (w *failingImageWriter) Write(p []byte) (int, error) {
+	n, err := w.ImageWriter.Write(p[:len(p)/2])
+	if err != nil {
+		return n, err
+	}
 
-	for idx := 0; ; idx++ {
+	return n, fmt.Errorf("no space left on device")
+}
+
+func TestLaptopServer_ImageUploadWriteFailed(t *testing.T) {
+	t.Parallel()
+
+	imagesFolder := t.TempDir()
+
+	laptopStore := NewInMemoryLaptopStore()
+	imageStore := &failingImageStore{DiskImageStore: openTestDiskImageStore(t, imagesFolder)}
+
+	laptop := factory.NewLaptop()
+
+	err := laptopStore.Save(laptop)
+	require.NoError(t, err)
+
+	serverAddress := startLaptopTestServer(t, laptopStore, imageStore, nil)
+	laptopClient := newTestLaptopClient(t, serverAddress)
+
+	res, err := laptopClient.InitImageUpload(context.Background(), &pb.InitImageUploadRequest{
+		Info: &pb.ImageInfo{LaptopId: laptop.GetId(), FileExtension: ".png"},
+	})
+	require.NoError(t, err)
+
+	// The writer holds part of the chunk, so the upload cannot be resumed from the size received before it.
+	uploadID := res.GetUploadId()
+
+	_, err = sendImageChunks(t, laptopClient, uploadID, 0, factory.NewImage(".png", 1, 1))
+	require.Equal(t, codes.Internal, status.Code(err))
+
+	_, err = laptopClient.GetImageUploadStatus(context.Background(), &pb.GetImageUploadStatusRequest{UploadId: uploadID})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	entries, err := os.ReadDir(imagesFolder)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
+
+func TestLaptopServer_ImageUploadExpired(t *testing.T) {
+	t.Parallel()
+
+	imagesFolder := t.TempDir()
+
+	laptopStore := NewInMemoryLaptopStore()
+	imageStore := openTestDiskImageStore(t, imagesFolder)
+
+	laptop := factory.NewLaptop()
+
+	err := laptopStore.Save(laptop)
+	require.NoError(t, err)
+
+	serverAddress := startLaptopTestServer(
+		t, laptopStore, imageStore, nil, WithImageUploadTimeout(100*time.Millisecond),
+	)
+	laptopClient := newTestLaptopClient(t, serverAddress)
+
+	res, err := laptopClient.InitImageUpload(context.Background(), &pb.InitImageUploadRequest{
+		Info: &pb.ImageInfo{LaptopId: laptop.GetId(), FileExtension: ".png"},
+	})
+	require.NoError(t, err)
+
+	uploadID := res.GetUploadId()
+	image := factory.NewImage(".png", 1, 1)
+
+	_, err = sendImageChunks(t, laptopClient, uploadID, 0, image[:5])
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		_, err := laptopClient.GetImageUploadStatus(
+			context.Background(), &pb.GetImageUploadStatusRequest{UploadId: uploadID},
+		)
+		return status.Code(err) == codes.NotFound
+	}, 2*time.Second, 10*time.Millisecond)
+
+	_, err = sendImageChunks(t, laptopClient, uploadID, 5, image[5:])
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	entries, err := os.ReadDir(imagesFolder)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
+
+func TestLaptopServer_DownloadListDeleteImage(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := NewInMemoryLaptopStore()
+	imageStore := openTestDiskImageStore(t, t.TempDir())
+
+	laptop := factory.NewLaptop()
+
+	err := laptopStore.Save(laptop)
+	require.NoError(t, err)
+
+	// The noise does not compress, so the image is sent in several chunks.
+	imageData := factory.NewImage(".png", 200, 200)
+	require.Greater(t, len(imageData), imageChunkSize)
+
+	imageID, err := SaveImage(imageStore, laptop.GetId(), ".png", bytes.NewReader(imageData))
+	require.NoError(t, err)
+
+	serverAddress := startLaptopTestServer(t, laptopStore, imageStore, nil)
+	laptopClient := newTestLaptopClient(t, serverAddress)
+
+	stream, err := laptopClient.DownloadImage(context.Background(), &pb.DownloadImageRequest{Id: imageID})
+	require.NoError(t, err)
+
+	res, err := stream.Recv()
+	require.NoError(t, err)
+
+	expectedDetails := &pb.ImageDetails{
+		Id:          imageID,
+		LaptopId:    laptop.GetId(),
+		ContentType: "image/png",
+		Size:        uint64(len(imageData)),
+		Width:       200,
+		Height:      200,
+	}
+	require.True(t, proto.Equal(expectedDetails, res.GetInfo()))
+
+	var downloadedData []byte
+
+	for {
 		res, err := stream.Recv()
 		if err == io.EOF {
-			require.Equal(t, n, idx)
 			break
 		}
 
+		require.NoError(t, err)
+		require.LessOrEqual(t, len(res.GetChunkData()), imageChunkSize)
+
+		downloadedData = append(downloadedData, res.GetChunkData()...)
+	}
+
+	require.Equal(t, imageData, downloadedData)
+
+	listRes, err := laptopClient.ListImages(context.Background(), &pb.ListImagesRequest{LaptopId: laptop.GetId()})
+	require.NoError(t, err)
+	require.Len(t, listRes.GetImages(), 1)
+	require.True(t, proto.Equal(expectedDetails, listRes.GetImages()[0]))
+
+	_, err = laptopClient.ListImages(context.Background(), &pb.ListImagesRequest{LaptopId: "unknown"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = laptopClient.DeleteImage(context.Background(), &pb.DeleteImageRequest{Id: imageID})
+	require.NoError(t, err)
+
+	_, err = laptopClient.DeleteImage(context.Background(), &pb.DeleteImageRequest{Id: imageID})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	stream, err = laptopClient.DownloadImage(context.Background(), &pb.DownloadImageRequest{Id: imageID})
+	require.NoError(t, err)
+
+	_, err = stream.Recv()
+	require.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestLaptopServer_ServeImage(t *testing.T) {
+	t.Parallel()
+
+	imageStore := openTestDiskImageStore(t, t.TempDir())
+	laptopServer := NewLaptopServer(NewInMemoryLaptopStore(), imageStore, nil)
+
+	image := factory.NewImage(".png", 1, 1)
+
+	imageID, err := SaveImage(imageStore, "laptop", ".png", bytes.NewReader(image))
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name        string
+		imageID     string
+		code        int
+		contentType string
+		body        string
+	}{
+		{
+			name:        "found",
+			imageID:     imageID,
+			code:        http.StatusOK,
+			contentType: "image/png",
+			body:        string(image),
+		},
+		{
+			name:    "not found",
+			imageID: "unknown",
+			code:    http.StatusNotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			req := httptest.NewRequest(http.MethodGet, "/v1/laptop/images/"+tc.imageID, nil)
+			recorder := httptest.NewRecorder()
+
+			laptopServer.ServeImage(recorder, req, map[string]string{"id": tc.imageID})
+
+			require.Equal(t, tc.code, recorder.Code)
+
+			if tc.code == http.StatusOK {
+				require.Equal(t, tc.contentType, recorder.Header().Get("Content-Type"))
+				require.Equal(t, tc.body, recorder.Body.String())
+			}
+		})
+	}
+}
+
+func TestAuthInterceptor_HTTP(t *testing.T) {
+	t.Parallel()
+
+	jwtManager := NewJWTManager("secret", time.Minute)
+	interceptor := NewAuthInterceptor(jwtManager, map[string][]string{
+		"/pcbook.LaptopService/DownloadImage": {"admin"},
+	})
+
+	adminToken, err := jwtManager.Generate(&User{Username: "admin", Role: "admin"})
+	require.NoError(t, err)
+
+	userToken, err := jwtManager.Generate(&User{Username: "user", Role: "user"})
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name        string
+		method      string
+		accessToken string
+		code        int
+	}{
+		{
+			name:        "admin",
+			method:      "/pcbook.LaptopService/DownloadImage",
+			accessToken: adminToken,
+			code:        http.StatusOK,
+		},
+		{
+			name:   "missing token",
+			method: "/pcbook.LaptopService/DownloadImage",
+			code:   http.StatusUnauthorized,
+		},
+		{
+			name:        "invalid token",
+			method:      "/pcbook.LaptopService/DownloadImage",
+			accessToken: "invalid",
+			code:        http.StatusUnauthorized,
+		},
+		{
+			name:        "forbidden role",
+			method:      "/pcbook.LaptopService/DownloadImage",
+			accessToken: userToken,
+			code:        http.StatusForbidden,
+		},
+		{
+			name:   "accessible by all users",
+			method: "/pcbook.LaptopService/ListImages",
+			code:   http.StatusOK,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			handler := interceptor.HTTP(tc.method, func(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+				claims, ok := UserClaimsFromContext(r.Context())
+				require.Equal(t, tc.accessToken != "", ok)
+
+				if ok {
+					require.Equal(t, "admin", claims.Username)
+				}
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/v1/laptop/images/image", nil)
+			if tc.accessToken != "" {
+				req.Header.Set("Authorization", tc.accessToken)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler(recorder, req, map[string]string{"id": "image"})
+
+			require.Equal(t, tc.code, recorder.Code)
+		})
+	}
+}
+
+// uploadTestImage uploads the image of a laptop with the UploadImage RPC
+func uploadTestImage(
+	t *testing.T, laptopClient pb.LaptopServiceClient, laptopID, extension string, image []byte,
+) *pb.UploadImageResponse {
+	stream, err := laptopClient.UploadImage(context.Background())
+	require.NoError(t, err)
+
+	req := &pb.UploadImageRequest{
+		Data: &pb.UploadImageRequest_Info{
+			Info: &pb.ImageInfo{
+				LaptopId:      laptopID,
+				FileExtension: extension,
+			},
+		},
+	}
+
+	require.NoError(t, stream.Send(req))
+
+	for offset := 0; offset < len(image); offset += imageChunkSize {
+		end := offset + imageChunkSize
+		if end > len(image) {
+			end = len(image)
+		}
+
+		req := &pb.UploadImageRequest{
+			Data: &pb.UploadImageRequest_ChunkData{
+				ChunkData: image[offset:end],
+			},
+		}
+
+		require.NoError(t, stream.Send(req))
+	}
+
+	res, err := stream.CloseAndRecv()
+	require.NoError(t, err)
+
+	return res
+}
+
+func TestLaptopServer_UploadImageDeduplicated(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := NewInMemoryLaptopStore()
+	imageStore := openTestDiskImageStore(t, t.TempDir())
+
+	laptop := factory.NewLaptop()
+	require.NoError(t, laptopStore.Save(laptop))
+
+	otherLaptop := factory.NewLaptop()
+	require.NoError(t, laptopStore.Save(otherLaptop))
+
+	serverAddress := startLaptopTestServer(t, laptopStore, imageStore, nil)
+	laptopClient := newTestLaptopClient(t, serverAddress)
+
+	image := factory.NewImage(".jpg", 16, 16)
+
+	res := uploadTestImage(t, laptopClient, laptop.GetId(), ".jpg", image)
+	require.False(t, res.GetDeduplicated())
+
+	otherRes := uploadTestImage(t, laptopClient, otherLaptop.GetId(), ".jpg", image)
+	require.True(t, otherRes.GetDeduplicated())
+	require.NotEqual(t, res.GetId(), otherRes.GetId())
+
+	// Resumable uploads are deduplicated too.
+	initRes, err := laptopClient.InitImageUpload(context.Background(), &pb.InitImageUploadRequest{
+		Info: &pb.ImageInfo{LaptopId: laptop.GetId(), FileExtension: ".jpg"},
+	})
+	require.NoError(t, err)
+
+	_, err = sendImageChunks(t, laptopClient, initRes.GetUploadId(), 0, image)
+	require.NoError(t, err)
+
+	checksum := sha256.Sum256(image)
+
+	finalizeRes, err := laptopClient.FinalizeImageUpload(context.Background(), &pb.FinalizeImageUploadRequest{
+		UploadId: initRes.GetUploadId(),
+		Sha256:   hex.EncodeToString(checksum[:]),
+	})
+	require.NoError(t, err)
+	require.True(t, finalizeRes.GetDeduplicated())
+
+	// Deleting an image keeps the data of the images sharing it.
+	_, err = laptopClient.DeleteImage(context.Background(), &pb.DeleteImageRequest{Id: res.GetId()})
+	require.NoError(t, err)
+
+	for _, imageID := range []string{otherRes.GetId(), finalizeRes.GetId()} {
+		_, imageData, err := imageStore.Open(imageID)
+		require.NoError(t, err)
+
+		data, err := io.ReadAll(imageData)
+		require.NoError(t, err)
+		require.NoError(t, imageData.Close())
+		require.Equal(t, image, data)
+	}
+}
+
+func TestLaptopServer_ImageRenditions(t *testing.T) {
+	t.Parallel()
+
+	type rendition struct {
+		contentType string
+		width       uint32
+		height      uint32
+	}
+
+	testCases := []struct {
+		name               string
+		extension          string
+		width              int
+		height             int
+		expectedRenditions map[string]rendition
+	}{
+		{
+			name:      "jpeg",
+			extension: ".jpg",
+			width:     600,
+			height:    300,
+			expectedRenditions: map[string]rendition{
+				"small":  {contentType: "image/jpeg", width: 160, height: 80},
+				"medium": {contentType: "image/jpeg", width: 480, height: 240},
+				"large":  {contentType: "image/jpeg", width: 600, height: 300},
+			},
+		},
+		{
+			name:      "png",
+			extension: ".png",
+			width:     300,
+			height:    600,
+			expectedRenditions: map[string]rendition{
+				"small":  {contentType: "image/png", width: 80, height: 160},
+				"medium": {contentType: "image/png", width: 240, height: 480},
+				"large":  {contentType: "image/png", width: 300, height: 600},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			laptopStore := NewInMemoryLaptopStore()
+			imageStore := openTestDiskImageStore(t, t.TempDir())
+
+			laptop := factory.NewLaptop()
+			require.NoError(t, laptopStore.Save(laptop))
+
+			imageRenderer := NewImageRenderer(imageStore, 2, 10)
+			t.Cleanup(imageRenderer.Close)
+
+			serverAddress := startLaptopTestServer(
+				t, laptopStore, imageStore, nil, WithMaxImageSize(8<<20), WithImageRenderer(imageRenderer),
+			)
+			laptopClient := newTestLaptopClient(t, serverAddress)
+
+			image := factory.NewImage(tc.extension, tc.width, tc.height)
+			imageID := uploadTestImage(t, laptopClient, laptop.GetId(), tc.extension, image).GetId()
+
+			var details *pb.ImageDetails
+
+			require.Eventually(t, func() bool {
+				res, err := laptopClient.ListImages(
+					context.Background(), &pb.ListImagesRequest{LaptopId: laptop.GetId()},
+				)
+				if err != nil || len(res.GetImages()) != 1 {
+					return false
+				}
+
+				details = res.GetImages()[0]
+				return details.GetRenditionStatus() == pb.ImageDetails_READY
+			}, 5*time.Second, 10*time.Millisecond)
+
+			require.Equal(t, imageID, details.GetId())
+			require.Empty(t, details.GetRendition())
+			require.Empty(t, details.GetRenditionError())
+			require.Len(t, details.GetRenditions(), len(tc.expectedRenditions))
+
+			for _, renditionDetails := range details.GetRenditions() {
+				expected, ok := tc.expectedRenditions[renditionDetails.GetRendition()]
+				require.True(t, ok)
+				require.Equal(t, imageID, renditionDetails.GetSourceId())
+				require.Equal(t, laptop.GetId(), renditionDetails.GetLaptopId())
+				require.Equal(t, expected.contentType, renditionDetails.GetContentType())
+				require.Equal(t, expected.width, renditionDetails.GetWidth())
+				require.Equal(t, expected.height, renditionDetails.GetHeight())
+
+				// Renditions are downloaded by their own id.
+				stream, err := laptopClient.DownloadImage(
+					context.Background(), &pb.DownloadImageRequest{Id: renditionDetails.GetId()},
+				)
+				require.NoError(t, err)
+
+				res, err := stream.Recv()
+				require.NoError(t, err)
+				require.True(t, proto.Equal(renditionDetails, res.GetInfo()))
+			}
+
+			// Deleting the uploaded image deletes its renditions.
+			_, err := laptopClient.DeleteImage(context.Background(), &pb.DeleteImageRequest{Id: imageID})
+			require.NoError(t, err)
+
+			images, err := imageStore.List(laptop.GetId())
+			require.NoError(t, err)
+			require.Empty(t, images)
+		})
+	}
+}
+
+func TestLaptopServer_ImageRenditionStatus(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name           string
+		queueSize      int
+		expectedStatus pb.ImageDetails_RenditionStatus
+		expectedError  string
+	}{
+		{
+			name:           "pending",
+			queueSize:      1,
+			expectedStatus: pb.ImageDetails_PENDING,
+		},
+		{
+			name:           "queue full",
+			expectedStatus: pb.ImageDetails_FAILED,
+			expectedError:  ErrRenditionQueueFull.Error(),
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			laptopStore := NewInMemoryLaptopStore()
+			imageStore := openTestDiskImageStore(t, t.TempDir())
+
+			laptop := factory.NewLaptop()
+			require.NoError(t, laptopStore.Save(laptop))
+
+			// Without workers, queued images are never resized.
+			imageRenderer := NewImageRenderer(imageStore, 0, tc.queueSize)
+			t.Cleanup(imageRenderer.Close)
+
+			serverAddress := startLaptopTestServer(t, laptopStore, imageStore, nil, WithImageRenderer(imageRenderer))
+			laptopClient := newTestLaptopClient(t, serverAddress)
+
+			// A failed rendition does not fail the upload.
+			imageID := uploadTestImage(t, laptopClient, laptop.GetId(), ".png", factory.NewImage(".png", 4, 4)).GetId()
+
+			res, err := laptopClient.ListImages(context.Background(), &pb.ListImagesRequest{LaptopId: laptop.GetId()})
+			require.NoError(t, err)
+			require.Len(t, res.GetImages(), 1)
+
+			details := res.GetImages()[0]
+			require.Equal(t, imageID, details.GetId())
+			require.Equal(t, tc.expectedStatus, details.GetRenditionStatus())
+			require.Equal(t, tc.expectedError, details.GetRenditionError())
+			require.Empty(t, details.GetRenditions())
+		})
+	}
+}
+
+func TestLaptopServer_ResumeRenditions(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := NewInMemoryLaptopStore()
+	imageStore := openTestDiskImageStore(t, t.TempDir())
+
+	laptop := factory.NewLaptop()
+	require.NoError(t, laptopStore.Save(laptop))
+
+	// Images stored while no renderer ran have no renditions, or only those generated before the server stopped.
+	var imageIDs []string
+
+	for i := 0; i < 3; i++ {
+		imageID, err := SaveImage(imageStore, laptop.GetId(), ".png", bytes.NewReader(factory.NewImage(".png", 4+i, 4)))
+		require.NoError(t, err)
+
+		imageIDs = append(imageIDs, imageID)
+	}
+
+	imageWriter, err := imageStore.CreateRendition(imageIDs[0], "small", ".png")
+	require.NoError(t, err)
+
+	_, err = imageWriter.Write(factory.NewImage(".png", 4, 4))
+	require.NoError(t, err)
+
+	_, err = imageWriter.Commit()
+	require.NoError(t, err)
+
+	// The queue holds fewer images than are resumed, which wait for room instead of failing.
+	imageRenderer := NewImageRenderer(imageStore, 1, 1)
+	t.Cleanup(imageRenderer.Close)
+
+	laptopServer := NewLaptopServer(laptopStore, imageStore, nil, WithImageRenderer(imageRenderer))
+	require.NoError(t, laptopServer.ResumeRenditions(context.Background()))
+
+	require.Eventually(t, func() bool {
+		images, err := imageStore.List(laptop.GetId())
+		require.NoError(t, err)
+
+		for _, details := range laptopServer.listImageDetails(images) {
+			if details.GetRenditionStatus() != pb.ImageDetails_READY {
+				return false
+			}
+		}
+
+		return true
+	}, 5*time.Second, 10*time.Millisecond)
+
+	images, err := imageStore.List(laptop.GetId())
+	require.NoError(t, err)
+	require.Len(t, images, len(imageIDs)*(1+len(imageRenditions)))
+}
+
+func TestRenditionExtension(t *testing.T) {
+	t.Parallel()
+
+	rect := image.Rect(0, 0, 1, 1)
+
+	transparent := image.NewNYCbCrA(rect, image.YCbCrSubsampleRatio444)
+	opaque := image.NewNYCbCrA(rect, image.YCbCrSubsampleRatio444)
+	opaque.A[0] = 255
+
+	testCases := []struct {
+		name      string
+		extension string
+		source    image.Image
+		expected  string
+	}{
+		{
+			name:      "jpeg",
+			extension: ".jpg",
+			source:    image.NewYCbCr(rect, image.YCbCrSubsampleRatio444),
+			expected:  ".jpg",
+		},
+		{
+			name:      "png",
+			extension: ".png",
+			source:    image.NewNRGBA(rect),
+			expected:  ".png",
+		},
+		{
+			name:      "gif",
+			extension: ".gif",
+			source:    image.NewPaletted(rect, nil),
+			expected:  ".png",
+		},
+		{
+			name:      "lossy webp",
+			extension: ".webp",
+			source:    image.NewYCbCr(rect, image.YCbCrSubsampleRatio420),
+			expected:  ".jpg",
+		},
+		{
+			name:      "webp with opaque alpha",
+			extension: ".webp",
+			source:    opaque,
+			expected:  ".jpg",
+		},
+		{
+			name:      "webp with transparent pixels",
+			extension: ".webp",
+			source:    transparent,
+			expected:  ".png",
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			require.Equal(t, tc.expected, renditionExtension(tc.extension, tc.source))
+		})
+	}
+}
+
+func TestLaptopServer_RateLaptop(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := NewInMemoryLaptopStore()
+	ratingStore := NewInMemoryRatingStore()
+	jwtManager := NewJWTManager("secret", time.Minute)
+
+	laptop := factory.NewLaptop()
+
+	err := laptopStore.Save(laptop)
+	require.NoError(t, err)
+
+	serverAddress := startAuthLaptopTestServer(t, jwtManager, laptopStore, ratingStore)
+	laptopClient := newTestLaptopClient(t, serverAddress)
+
+	// Rating a laptop again replaces the score of the user rather than adding to the rating.
+	ratings := []struct {
+		username     string
+		score        float64
+		count        uint32
+		averageScore float64
+	}{
+		{username: "alice", score: 8, count: 1, averageScore: 8},
+		{username: "alice", score: 7.5, count: 1, averageScore: 7.5},
+		{username: "alice", score: 10, count: 1, averageScore: 10},
+		{username: "bob", score: 5, count: 2, averageScore: 7.5},
+		{username: "alice", score: 1, count: 2, averageScore: 3},
+	}
+
+	for _, rating := range ratings {
+		stream, err := laptopClient.RateLaptop(newTestUserContext(t, jwtManager, rating.username))
+		require.NoError(t, err)
+
+		err = stream.Send(&pb.RateLaptopRequest{LaptopId: laptop.GetId(), Score: rating.score})
+		require.NoError(t, err)
+
+		res, err := stream.Recv()
 		require.NoError(t, err)
 		require.Equal(t, laptop.GetId(), res.GetLaptopId())
-		require.Equal(t, uint32(idx+1), res.GetRatingsCount())
-		require.Equal(t, averageScores[idx], res.GetAverageScore())
+		require.Equal(t, rating.count, res.GetRatingsCount())
+		require.Equal(t, rating.averageScore, res.GetAverageScore())
+
+		err = stream.CloseSend()
+		require.NoError(t, err)
+
+		_, err = stream.Recv()
+		require.Equal(t, io.EOF, err)
+	}
+}
+
+func TestLaptopServer_RateLaptopInvalid(t *testing.T) {
+	t.Parallel()
+
+	jwtManager := NewJWTManager("secret", time.Minute)
+
+	testCases := []struct {
+		name    string
+		ctx     context.Context
+		options []LaptopServerOption
+		score   float64
+		code    codes.Code
+	}{
+		{
+			name:  "no access token",
+			ctx:   context.Background(),
+			score: 5,
+			code:  codes.Unauthenticated,
+		},
+		{
+			name:  "score below the range",
+			ctx:   newTestUserContext(t, jwtManager, "alice"),
+			score: -50,
+			code:  codes.InvalidArgument,
+		},
+		{
+			name:  "score above the range",
+			ctx:   newTestUserContext(t, jwtManager, "alice"),
+			score: 1e9,
+			code:  codes.InvalidArgument,
+		},
+		{
+			name:  "score not a number",
+			ctx:   newTestUserContext(t, jwtManager, "alice"),
+			score: math.NaN(),
+			code:  codes.InvalidArgument,
+		},
+		{
+			name:    "score above a configured range",
+			ctx:     newTestUserContext(t, jwtManager, "alice"),
+			options: []LaptopServerOption{WithRatingScoreRange(0, 5)},
+			score:   6,
+			code:    codes.InvalidArgument,
+		},
+		{
+			name:    "score within a configured range",
+			ctx:     newTestUserContext(t, jwtManager, "alice"),
+			options: []LaptopServerOption{WithRatingScoreRange(0, 5)},
+			score:   0,
+			code:    codes.OK,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			laptopStore := NewInMemoryLaptopStore()
+			ratingStore := NewInMemoryRatingStore()
+
+			laptop := factory.NewLaptop()
+
+			err := laptopStore.Save(laptop)
+			require.NoError(t, err)
+
+			serverAddress := startAuthLaptopTestServer(t, jwtManager, laptopStore, ratingStore, tc.options...)
+			laptopClient := newTestLaptopClient(t, serverAddress)
+
+			stream, err := laptopClient.RateLaptop(tc.ctx)
+			require.NoError(t, err)
+
+			err = stream.Send(&pb.RateLaptopRequest{LaptopId: laptop.GetId(), Score: tc.score})
+			require.NoError(t, err)
+
+			_, err = stream.Recv()
+			require.Equal(t, tc.code, status.Code(err))
+
+			rating, err := ratingStore.Find(laptop.GetId())
+			require.NoError(t, err)
+			require.Equal(t, tc.code == codes.OK, rating != nil)
+		})
 	}
 }
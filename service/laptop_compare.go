@@ -0,0 +1,127 @@
+package service
+
+import (
+	"github.com/jwambugu/pcbook-grpc/protos/pb"
+	"github.com/jwambugu/pcbook-grpc/units"
+)
+
+const (
+	minComparedLaptops = 2
+	maxComparedLaptops = 5
+)
+
+// comparedSpec is a laptop spec compared by CompareLaptops
+type comparedSpec struct {
+	field          string
+	unit           string
+	higherIsBetter bool
+	value          func(laptop *pb.Laptop) float64
+}
+
+// comparedSpecs lists the specs CompareLaptops compares, in the order they are returned
+var comparedSpecs = []comparedSpec{
+	{
+		field: "price_usd",
+		unit:  "USD",
+		value: func(laptop *pb.Laptop) float64 {
+			return laptop.GetPriceUsd()
+		},
+	},
+	{
+		field:          "cpu_cores",
+		higherIsBetter: true,
+		value: func(laptop *pb.Laptop) float64 {
+			return float64(laptop.GetCpu().GetNumberOfCores())
+		},
+	},
+	{
+		field:          "cpu_frequency",
+		unit:           "GHz",
+		higherIsBetter: true,
+		value: func(laptop *pb.Laptop) float64 {
+			return laptop.GetCpu().GetMaximumFrequency()
+		},
+	},
+	{
+		field:          "ram",
+		unit:           "GB",
+		higherIsBetter: true,
+		value: func(laptop *pb.Laptop) float64 {
+			return float64(units.MemoryBits(laptop.GetRam())) / gigabyteBits
+		},
+	},
+	{
+		field:          "storage",
+		unit:           "GB",
+		higherIsBetter: true,
+		value: func(laptop *pb.Laptop) float64 {
+			return float64(storageBits(laptop, pb.Storage_UNKNOWN)) / gigabyteBits
+		},
+	},
+	{
+		field:          "gpu_memory",
+		unit:           "GB",
+		higherIsBetter: true,
+		value: func(laptop *pb.Laptop) float64 {
+			return float64(gpuMemoryBits(laptop)) / gigabyteBits
+		},
+	},
+	{
+		field:          "screen_pixels",
+		higherIsBetter: true,
+		value: func(laptop *pb.Laptop) float64 {
+			return float64(screenPixels(laptop))
+		},
+	},
+	{
+		field:          "screen_ppi",
+		unit:           "PPI",
+		higherIsBetter: true,
+		value:          screenPPI,
+	},
+	{
+		field: "weight",
+		unit:  "kg",
+		value: weightKg,
+	},
+}
+
+// compareLaptops compares every spec of comparedSpecs across the laptops
+func compareLaptops(laptops []*pb.Laptop) []*pb.SpecComparison {
+	comparisons := make([]*pb.SpecComparison, 0, len(comparedSpecs))
+
+	for _, spec := range comparedSpecs {
+		comparison := &pb.SpecComparison{
+			Field:          spec.field,
+			Unit:           spec.unit,
+			Values:         make([]float64, len(laptops)),
+			HigherIsBetter: spec.higherIsBetter,
+		}
+
+		for i, laptop := range laptops {
+			comparison.Values[i] = spec.value(laptop)
+		}
+
+		// A spec the laptop does not record is 0, which never wins.
+		var best float64
+		for _, value := range comparison.Values {
+			if value == 0 {
+				continue
+			}
+
+			if best == 0 || spec.higherIsBetter && value > best || !spec.higherIsBetter && value < best {
+				best = value
+			}
+		}
+
+		for i, value := range comparison.Values {
+			if best != 0 && value == best {
+				comparison.Winners = append(comparison.Winners, uint32(i))
+			}
+		}
+
+		comparisons = append(comparisons, comparison)
+	}
+
+	return comparisons
+}
@@ -0,0 +1,126 @@
+package service
+
+import (
+	"github.com/jwambugu/pcbook-grpc/protos/pb"
+	"github.com/jwambugu/pcbook-grpc/units"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// gigabyteBits is the number of bits in a gigabyte
+const gigabyteBits = 1 << 33
+
+// facetCounter accumulates the facet counts of laptops
+type facetCounter struct {
+	priceBucketUsd float64
+	total          uint32
+	brands         map[string]uint32
+	cpuBrands      map[string]uint32
+	gpuBrands      map[string]uint32
+	screenPanels   map[string]uint32
+	keyboards      map[string]uint32
+	releaseYears   map[string]uint32
+	// ramGb and priceUsd map the lower bound of each bucket to its count
+	ramGb    map[float64]uint32
+	priceUsd map[float64]uint32
+}
+
+func newFacetCounter(priceBucketUsd float64) *facetCounter {
+	return &facetCounter{
+		priceBucketUsd: priceBucketUsd,
+		brands:         make(map[string]uint32),
+		cpuBrands:      make(map[string]uint32),
+		gpuBrands:      make(map[string]uint32),
+		screenPanels:   make(map[string]uint32),
+		keyboards:      make(map[string]uint32),
+		releaseYears:   make(map[string]uint32),
+		ramGb:          make(map[float64]uint32),
+		priceUsd:       make(map[float64]uint32),
+	}
+}
+
+// ramBucket returns the lower bound of the power of two bucket of the RAM size in gigabytes
+func ramBucket(laptop *pb.Laptop) float64 {
+	gb := float64(units.MemoryBits(laptop.GetRam())) / gigabyteBits
+	if gb < 1 {
+		return 0
+	}
+
+	return math.Exp2(math.Floor(math.Log2(gb)))
+}
+
+// add counts the laptop in every facet
+func (counter *facetCounter) add(laptop *pb.Laptop) {
+	counter.total++
+	counter.brands[laptop.GetBrand()]++
+	counter.cpuBrands[laptop.GetCpu().GetBrand()]++
+	counter.screenPanels[laptop.GetScreen().GetPanel().String()]++
+	counter.keyboards[laptop.GetKeyboard().GetLayout().String()]++
+	counter.releaseYears[strconv.FormatUint(uint64(laptop.GetReleaseYear()), 10)]++
+	counter.ramGb[ramBucket(laptop)]++
+	counter.priceUsd[math.Floor(laptop.GetPriceUsd()/counter.priceBucketUsd)*counter.priceBucketUsd]++
+
+	gpuBrands := make(map[string]bool)
+
+	for _, gpu := range laptop.GetGpus() {
+		if !gpuBrands[gpu.GetBrand()] {
+			gpuBrands[gpu.GetBrand()] = true
+			counter.gpuBrands[gpu.GetBrand()]++
+		}
+	}
+}
+
+// facetCounts returns the counts ordered by descending count, then by value
+func facetCounts(counts map[string]uint32) []*pb.FacetCount {
+	facets := make([]*pb.FacetCount, 0, len(counts))
+
+	for value, count := range counts {
+		facets = append(facets, &pb.FacetCount{Value: value, Count: count})
+	}
+
+	sort.Slice(facets, func(i, j int) bool {
+		if facets[i].Count != facets[j].Count {
+			return facets[i].Count > facets[j].Count
+		}
+
+		return facets[i].Value < facets[j].Value
+	})
+
+	return facets
+}
+
+// bucketCounts returns the counts of the buckets ordered by their lower bound, the upper bound of each computed by
+// the max function
+func bucketCounts(counts map[float64]uint32, max func(min float64) float64) []*pb.BucketCount {
+	buckets := make([]*pb.BucketCount, 0, len(counts))
+
+	for min, count := range counts {
+		buckets = append(buckets, &pb.BucketCount{Min: min, Max: max(min), Count: count})
+	}
+
+	sort.Slice(buckets, func(i, j int) bool {
+		return buckets[i].Min < buckets[j].Min
+	})
+
+	return buckets
+}
+
+// facets returns the facet counts of every laptop added
+func (counter *facetCounter) facets() *pb.LaptopFacets {
+	return &pb.LaptopFacets{
+		Total:           counter.total,
+		Brands:          facetCounts(counter.brands),
+		CpuBrands:       facetCounts(counter.cpuBrands),
+		GpuBrands:       facetCounts(counter.gpuBrands),
+		ScreenPanels:    facetCounts(counter.screenPanels),
+		KeyboardLayouts: facetCounts(counter.keyboards),
+		RamGb: bucketCounts(counter.ramGb, func(min float64) float64 {
+			return math.Max(1, min*2)
+		}),
+		PriceUsd: bucketCounts(counter.priceUsd, func(min float64) float64 {
+			return min + counter.priceBucketUsd
+		}),
+		ReleaseYears: facetCounts(counter.releaseYears),
+	}
+}
@@ -0,0 +1,173 @@
+package service
+
+import (
+	"github.com/jwambugu/pcbook-grpc/protos/pb"
+	"github.com/jwambugu/pcbook-grpc/units"
+	"math"
+	"sort"
+)
+
+// indexedFields are the order fields the in-memory store keeps a secondary index for
+var indexedFields = []string{"price_usd", "cpu_cores", "cpu_frequency", "ram", "release_year"}
+
+// indexEntry is the indexed value of a laptop
+type indexEntry struct {
+	value float64
+	id    string
+}
+
+// less orders entries by value and then by id. NaN values, which compare false to everything, sort after every other
+// value so that the entries stay ordered.
+func (e indexEntry) less(other indexEntry) bool {
+	if isNaN, otherIsNaN := math.IsNaN(e.value), math.IsNaN(other.value); isNaN != otherIsNaN {
+		return otherIsNaN
+	} else if !isNaN && e.value != other.value {
+		return e.value < other.value
+	}
+
+	return e.id < other.id
+}
+
+// laptopIndex is a secondary index of laptop ids sorted by a value of the laptops. It is not safe for concurrent
+// use; the store that owns it guards it with its own lock.
+type laptopIndex struct {
+	key     func(laptop *pb.Laptop) float64
+	entries []indexEntry
+}
+
+func newLaptopIndex(key func(laptop *pb.Laptop) float64) *laptopIndex {
+	return &laptopIndex{key: key}
+}
+
+// position returns the position of the first entry that is not less than the given one
+func (index *laptopIndex) position(entry indexEntry) int {
+	return sort.Search(len(index.entries), func(i int) bool {
+		return !index.entries[i].less(entry)
+	})
+}
+
+// add indexes the laptop
+func (index *laptopIndex) add(laptop *pb.Laptop) {
+	entry := indexEntry{value: index.key(laptop), id: laptop.GetId()}
+
+	i := index.position(entry)
+	index.entries = append(index.entries, indexEntry{})
+	copy(index.entries[i+1:], index.entries[i:])
+	index.entries[i] = entry
+}
+
+// remove drops the laptop, as it was indexed, from the index
+func (index *laptopIndex) remove(laptop *pb.Laptop) {
+	entry := indexEntry{value: index.key(laptop), id: laptop.GetId()}
+
+	// A laptop is indexed once, so the entry of its id is the one at the position of its value.
+	i := index.position(entry)
+	if i < len(index.entries) && index.entries[i].id == entry.id {
+		index.entries = append(index.entries[:i], index.entries[i+1:]...)
+	}
+}
+
+// bounds returns the positions of the entries between lo and hi inclusive. A nil bound leaves that side open. NaN
+// values, sorted last, are never within a bound.
+func (index *laptopIndex) bounds(lo, hi *float64) (start, end int) {
+	end = len(index.entries)
+
+	if lo == nil && hi == nil {
+		return start, end
+	}
+
+	end = sort.Search(len(index.entries), func(i int) bool {
+		return math.IsNaN(index.entries[i].value)
+	})
+	entries := index.entries[:end]
+
+	if lo != nil {
+		start = sort.Search(len(entries), func(i int) bool {
+			return entries[i].value >= *lo
+		})
+	}
+
+	if hi != nil {
+		end = sort.Search(len(entries), func(i int) bool {
+			return entries[i].value > *hi
+		})
+	}
+
+	if end < start {
+		end = start
+	}
+
+	return start, end
+}
+
+// indexRange is a range of a secondary index that every laptop matching a filter falls in
+type indexRange struct {
+	field    string
+	min, max *float64
+}
+
+// filterIndexRanges returns the index ranges the filter constrains laptops to
+func filterIndexRanges(filter *pb.Filter) []indexRange {
+	var ranges []indexRange
+
+	optional := func(present bool, value float64) *float64 {
+		if !present {
+			return nil
+		}
+
+		return &value
+	}
+
+	add := func(field string, lo, hi *float64) {
+		if lo != nil || hi != nil {
+			ranges = append(ranges, indexRange{field: field, min: lo, max: hi})
+		}
+	}
+
+	add(
+		"price_usd",
+		optional(filter.MinPriceUsd != nil, filter.GetMinPriceUsd()),
+		optional(filter.MaxPriceUsd != nil, filter.GetMaxPriceUsd()),
+	)
+	add("cpu_cores", optional(filter.MinCpuCores != nil, float64(filter.GetMinCpuCores())), nil)
+	add("cpu_frequency", optional(filter.MinCpuFrequency != nil, filter.GetMinCpuFrequency()), nil)
+	add("ram", optional(filter.MinRam != nil, float64(units.MemoryBits(filter.GetMinRam()))), nil)
+	add(
+		"release_year",
+		optional(filter.MinReleaseYear != nil, float64(filter.GetMinReleaseYear())),
+		optional(filter.MaxReleaseYear != nil, float64(filter.GetMaxReleaseYear())),
+	)
+
+	return ranges
+}
+
+// planSearch returns the ids of the candidate laptops for the filter, read from the index whose range holds the
+// fewest laptops or from every stored id if the filter does not constrain an indexed field. Candidates still have to
+// be matched against the whole filter. The caller must hold the store's lock.
+func (store *InMemoryLaptopStore) planSearch(filter *pb.Filter) []string {
+	var best []indexEntry
+	planned := false
+
+	if filter != nil {
+		for _, r := range filterIndexRanges(filter) {
+			index := store.indexes[r.field]
+			start, end := index.bounds(r.min, r.max)
+
+			if !planned || end-start < len(best) {
+				best = index.entries[start:end]
+				planned = true
+			}
+		}
+	}
+
+	if !planned {
+		return append([]string(nil), store.ids...)
+	}
+
+	ids := make([]string, len(best))
+	for i, entry := range best {
+		ids[i] = entry.id
+	}
+
+	return ids
+}
@@ -0,0 +1,114 @@
+package service
+
+import (
+	"fmt"
+	"github.com/jwambugu/pcbook-grpc/protos/pb"
+	"github.com/jwambugu/pcbook-grpc/units"
+	"sort"
+	"strings"
+)
+
+// ratingOrderField is the order field that sorts laptops by their average rating
+const ratingOrderField = "rating"
+
+// orderFields maps the supported order fields to the laptop value they order by
+var orderFields = map[string]func(laptop *pb.Laptop) float64{
+	"price_usd": func(laptop *pb.Laptop) float64 {
+		return laptop.GetPriceUsd()
+	},
+	"release_year": func(laptop *pb.Laptop) float64 {
+		return float64(laptop.GetReleaseYear())
+	},
+	"cpu_cores": func(laptop *pb.Laptop) float64 {
+		return float64(laptop.GetCpu().GetNumberOfCores())
+	},
+	"cpu_frequency": func(laptop *pb.Laptop) float64 {
+		return laptop.GetCpu().GetMaximumFrequency()
+	},
+	"ram": func(laptop *pb.Laptop) float64 {
+		return float64(units.MemoryBits(laptop.GetRam()))
+	},
+	"weight": weightKg,
+}
+
+// orderKey is a single field of an order by specification
+type orderKey struct {
+	field      string
+	descending bool
+}
+
+// parseOrderBy parses an order by specification such as "price_usd, ram desc"
+func parseOrderBy(orderBy string) ([]orderKey, error) {
+	var keys []orderKey
+
+	if strings.TrimSpace(orderBy) == "" {
+		return keys, nil
+	}
+
+	for _, spec := range strings.Split(orderBy, ",") {
+		parts := strings.Fields(spec)
+		if len(parts) == 0 || len(parts) > 2 {
+			return nil, fmt.Errorf("invalid order by %q", spec)
+		}
+
+		key := orderKey{field: parts[0]}
+
+		if _, ok := orderFields[key.field]; !ok && key.field != ratingOrderField {
+			return nil, fmt.Errorf("unsupported order by field %q", key.field)
+		}
+
+		if len(parts) == 2 {
+			switch strings.ToLower(parts[1]) {
+			case "asc":
+			case "desc":
+				key.descending = true
+			default:
+				return nil, fmt.Errorf("invalid order by direction %q", parts[1])
+			}
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// ordersByRating returns true if any of the keys orders laptops by their average rating
+func ordersByRating(keys []orderKey) bool {
+	for _, key := range keys {
+		if key.field == ratingOrderField {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sortLaptops sorts the laptops by the keys, breaking ties by the laptop id. Average ratings are looked up in the
+// ratings map by laptop id.
+func sortLaptops(laptops []*pb.Laptop, keys []orderKey, ratings map[string]float64) {
+	value := func(laptop *pb.Laptop, field string) float64 {
+		if field == ratingOrderField {
+			return ratings[laptop.GetId()]
+		}
+
+		return orderFields[field](laptop)
+	}
+
+	sort.Slice(laptops, func(i, j int) bool {
+		for _, key := range keys {
+			a, b := value(laptops[i], key.field), value(laptops[j], key.field)
+			if a == b {
+				continue
+			}
+
+			if key.descending {
+				return a > b
+			}
+
+			return a < b
+		}
+
+		return laptops[i].GetId() < laptops[j].GetId()
+	})
+}
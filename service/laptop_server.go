@@ -1,35 +1,109 @@
 package service
 
 import (
-	"bytes"
 	"context"
 	"errors"
+	"fmt"
 	"github.com/google/uuid"
 	"github.com/jwambugu/pcbook-grpc/protos/pb"
+	"github.com/jwambugu/pcbook-grpc/query"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
 	"io"
 	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
 )
 
-const maxImageSize = 1 << 20 // 1MB
+const (
+	// DefaultMaxImageSize is the size limit of uploaded images when the server is not given one
+	DefaultMaxImageSize = 1 << 20 // 1MB
+	// DefaultMinRatingScore and DefaultMaxRatingScore bound the scores of ratings when the server is not given a range
+	DefaultMinRatingScore = 1
+	DefaultMaxRatingScore = 10
+	// imageChunkSize is the size of the chunks DownloadImage sends
+	imageChunkSize = 64 << 10 // 64KB
+
+	defaultPageSize = 20
+	maxPageSize     = 100
+
+	// defaultPriceBucketUsd is the size of the SearchFacets price buckets when the request does not set one
+	defaultPriceBucketUsd = 500
+)
 
 // LaptopServer is a gRPC server that implements the LaptopServer interface.
 type LaptopServer struct {
 	pb.UnimplementedLaptopServiceServer
 
-	laptopStore LaptopStore
-	imageStore  ImageStore
-	ratingStore RatingStore
+	laptopStore   LaptopStore
+	imageStore    ImageStore
+	ratingStore   RatingStore
+	maxImageSize  int64
+	imageUploads  *imageUploads
+	imageRenderer *ImageRenderer
+
+	minRatingScore float64
+	maxRatingScore float64
+}
+
+// LaptopServerOption configures a LaptopServer.
+type LaptopServerOption func(server *LaptopServer)
+
+// WithMaxImageSize sets the size limit of uploaded images in bytes.
+func WithMaxImageSize(size int64) LaptopServerOption {
+	return func(server *LaptopServer) {
+		server.maxImageSize = size
+	}
+}
+
+// WithImageUploadTimeout sets how long an image upload is kept without receiving any data.
+func WithImageUploadTimeout(timeout time.Duration) LaptopServerOption {
+	return func(server *LaptopServer) {
+		server.imageUploads = newImageUploads(timeout)
+	}
+}
+
+// WithImageRenderer sets the renderer that generates the renditions of uploaded images. Without one, no renditions
+// are generated.
+func WithImageRenderer(renderer *ImageRenderer) LaptopServerOption {
+	return func(server *LaptopServer) {
+		server.imageRenderer = renderer
+	}
+}
+
+// WithRatingScoreRange sets the lowest and the highest score a laptop can be rated.
+func WithRatingScoreRange(minScore, maxScore float64) LaptopServerOption {
+	return func(server *LaptopServer) {
+		server.minRatingScore = minScore
+		server.maxRatingScore = maxScore
+	}
 }
 
 // NewLaptopServer creates a new LaptopServer.
-func NewLaptopServer(laptopStore LaptopStore, imageStore ImageStore, ratingStore RatingStore) *LaptopServer {
-	return &LaptopServer{
-		laptopStore: laptopStore,
-		imageStore:  imageStore,
-		ratingStore: ratingStore,
+func NewLaptopServer(
+	laptopStore LaptopStore, imageStore ImageStore, ratingStore RatingStore, options ...LaptopServerOption,
+) *LaptopServer {
+	server := &LaptopServer{
+		laptopStore:  laptopStore,
+		imageStore:   imageStore,
+		ratingStore:  ratingStore,
+		maxImageSize: DefaultMaxImageSize,
+		imageUploads: newImageUploads(DefaultImageUploadTimeout),
+
+		minRatingScore: DefaultMinRatingScore,
+		maxRatingScore: DefaultMaxRatingScore,
 	}
+
+	for _, option := range options {
+		option(server)
+	}
+
+	return server
 }
 
 func contextError(ctx context.Context) error {
@@ -43,6 +117,146 @@ func contextError(ctx context.Context) error {
 	}
 }
 
+// storeErrorCode maps a store error to the gRPC status code returned to the client.
+func storeErrorCode(err error) codes.Code {
+	switch {
+	case errors.Is(err, ErrRecordExists):
+		return codes.AlreadyExists
+	case errors.Is(err, ErrRecordNotFound):
+		return codes.NotFound
+	case errors.Is(err, ErrRevisionMismatch):
+		return codes.Aborted
+	default:
+		return codes.Internal
+	}
+}
+
+// checkLaptopNumbers returns an error if a numeric spec of the laptop is NaN or infinite, which no filter or order
+// can compare
+func checkLaptopNumbers(laptop *pb.Laptop) error {
+	type number struct {
+		field string
+		value float64
+	}
+
+	numbers := []number{
+		{"price_usd", laptop.GetPriceUsd()},
+		{"weight_kg", laptop.GetWeightKg()},
+		{"weight_lb", laptop.GetWeightLb()},
+		{"cpu.minimum_frequency", laptop.GetCpu().GetMinimumFrequency()},
+		{"cpu.maximum_frequency", laptop.GetCpu().GetMaximumFrequency()},
+		{"screen.size_inches", float64(laptop.GetScreen().GetSizeInches())},
+	}
+
+	for i, gpu := range laptop.GetGpus() {
+		numbers = append(
+			numbers,
+			number{fmt.Sprintf("gpus[%d].minimum_frequency", i), gpu.GetMinimumFrequency()},
+			number{fmt.Sprintf("gpus[%d].maximum_frequency", i), gpu.GetMaximumFrequency()},
+		)
+	}
+
+	for _, n := range numbers {
+		if math.IsNaN(n.value) || math.IsInf(n.value, 0) {
+			return fmt.Errorf("%s must be a finite number, got %v", n.field, n.value)
+		}
+	}
+
+	return nil
+}
+
+// renderImage queues the generation of the renditions of an uploaded image, if the server has a renderer
+func (s *LaptopServer) renderImage(imageID string) {
+	if s.imageRenderer != nil {
+		s.imageRenderer.Enqueue(imageID)
+	}
+}
+
+// ResumeRenditions queues the uploaded images of every laptop whose renditions were neither generated nor failed, such
+// as the images still queued when the server stopped. It does nothing if the server has no renderer.
+func (s *LaptopServer) ResumeRenditions(ctx context.Context) error {
+	if s.imageRenderer == nil || s.imageStore == nil {
+		return nil
+	}
+
+	after := ""
+
+	for {
+		laptops, err := s.laptopStore.List(ctx, after, maxPageSize)
+		if err != nil {
+			return fmt.Errorf("failed to list laptops: %w", err)
+		}
+
+		for _, laptop := range laptops {
+			images, err := s.imageStore.List(laptop.GetId())
+			if err != nil {
+				return fmt.Errorf("failed to list laptop %s images: %w", laptop.GetId(), err)
+			}
+
+			for _, details := range s.listImageDetails(images) {
+				if details.GetRenditionStatus() != pb.ImageDetails_NONE {
+					continue
+				}
+
+				if err := s.imageRenderer.Resume(details.GetId()); err != nil {
+					return fmt.Errorf("failed to queue image %s renditions: %w", details.GetId(), err)
+				}
+			}
+		}
+
+		if len(laptops) < maxPageSize {
+			return nil
+		}
+
+		after = laptops[len(laptops)-1].GetId()
+	}
+}
+
+// listImageDetails returns the details of the uploaded images, each with its renditions
+func (s *LaptopServer) listImageDetails(images []*ImageInfo) []*pb.ImageDetails {
+	renditions := make(map[string][]*ImageInfo)
+	for _, info := range images {
+		if info.SourceID != "" {
+			renditions[info.SourceID] = append(renditions[info.SourceID], info)
+		}
+	}
+
+	var details []*pb.ImageDetails
+
+	for _, info := range images {
+		if info.SourceID != "" {
+			continue
+		}
+
+		sourceRenditions := renditions[info.ID]
+		pending := s.imageRenderer != nil && s.imageRenderer.Pending(info.ID)
+
+		image := imageDetails(info)
+		image.RenditionStatus = renditionStatus(info, sourceRenditions, pending)
+		image.RenditionError = info.RenditionError
+
+		for _, rendition := range sourceRenditions {
+			image.Renditions = append(image.Renditions, imageDetails(rendition))
+		}
+
+		details = append(details, image)
+	}
+
+	return details
+}
+
+// imageUploadErrorCode returns the status code of an error of an image upload
+func imageUploadErrorCode(err error) codes.Code {
+	switch {
+	case errors.Is(err, ErrImageUploadOffset):
+		return codes.FailedPrecondition
+	case errors.Is(err, ErrImageUploadChecksum), errors.Is(err, ErrImageTooLarge), errors.Is(err, ErrInvalidImage):
+		return codes.InvalidArgument
+	default:
+		return storeErrorCode(err)
+	}
+}
+
 // CreateLaptop is a unary RPC that creates a new laptop.
 func (s *LaptopServer) CreateLaptop(ctx context.Context, req *pb.CreateLaptopRequest) (*pb.CreateLaptopResponse, error) {
 	laptop := req.GetLaptop()
@@ -55,6 +269,10 @@ func (s *LaptopServer) CreateLaptop(ctx context.Context, req *pb.CreateLaptopReq
 		}
 	}
 
+	if err := checkLaptopNumbers(laptop); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid laptop: %v", err)
+	}
+
 	if len(laptop.Id) == 0 {
 		id, err := uuid.NewRandom()
 		if err != nil {
@@ -70,12 +288,7 @@ func (s *LaptopServer) CreateLaptop(ctx context.Context, req *pb.CreateLaptopReq
 	}
 
 	if err := s.laptopStore.Save(laptop); err != nil {
-		code := codes.Internal
-		if errors.Is(err, ErrRecordExists) {
-			code = codes.AlreadyExists
-		}
-
-		return nil, status.Errorf(code, "failed to save laptop: %v", err)
+		return nil, status.Errorf(storeErrorCode(err), "failed to save laptop: %v", err)
 	}
 
 	log.Printf("saved laptop with id - %s: %v", laptop.GetId(), laptop)
@@ -84,14 +297,198 @@ func (s *LaptopServer) CreateLaptop(ctx context.Context, req *pb.CreateLaptopReq
 	}, nil
 }
 
+// GetLaptop is a unary RPC that fetches a laptop by its id.
+func (s *LaptopServer) GetLaptop(ctx context.Context, req *pb.GetLaptopRequest) (*pb.GetLaptopResponse, error) {
+	laptopID := req.GetId()
+	log.Printf("recieved GetLaptop(_) request with id - %s", laptopID)
+
+	if err := contextError(ctx); err != nil {
+		log.Printf("context error: %v", err)
+		return nil, err
+	}
+
+	laptop, err := s.laptopStore.Find(laptopID)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to find laptop: %v", err)
+	}
+
+	if laptop == nil {
+		return nil, status.Errorf(codes.NotFound, "laptop %s not found", laptopID)
+	}
+
+	return &pb.GetLaptopResponse{
+		Laptop: laptop,
+	}, nil
+}
+
+// UpdateLaptop is a unary RPC that updates the fields of an existing laptop listed in the update mask.
+func (s *LaptopServer) UpdateLaptop(ctx context.Context, req *pb.UpdateLaptopRequest) (*pb.UpdateLaptopResponse, error) {
+	laptop := req.GetLaptop()
+	log.Printf("recieved UpdateLaptop(_) request with id - %s, mask - %v", laptop.GetId(), req.GetUpdateMask().GetPaths())
+
+	if _, err := uuid.Parse(laptop.GetId()); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "laptop ID is not a valid UUID: %v", err)
+	}
+
+	for _, path := range req.GetUpdateMask().GetPaths() {
+		if path == "id" {
+			return nil, status.Errorf(codes.InvalidArgument, "laptop ID cannot be updated")
+		}
+	}
+
+	revision, err := expectedRevision(ctx, req.GetRevision())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid revision: %v", err)
+	}
+
+	if err := contextError(ctx); err != nil {
+		log.Printf("context error: %v", err)
+		return nil, err
+	}
+
+	updatedLaptop, err := s.laptopStore.Find(laptop.GetId())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to find laptop: %v", err)
+	}
+
+	if updatedLaptop == nil {
+		return nil, status.Errorf(codes.NotFound, "laptop %s not found", laptop.GetId())
+	}
+
+	if revision != 0 && revision != updatedLaptop.GetRevision() {
+		return nil, status.Errorf(
+			codes.Aborted, "laptop %s is at revision %d, not %d", laptop.GetId(), updatedLaptop.GetRevision(), revision,
+		)
+	}
+
+	// Writing back the revision that was read makes the store reject the update if the laptop changed in between.
+	revision = updatedLaptop.GetRevision()
+
+	if err := applyFieldMask(updatedLaptop, laptop, req.GetUpdateMask()); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "failed to apply update mask: %v", err)
+	}
+
+	if err := checkLaptopNumbers(updatedLaptop); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid laptop: %v", err)
+	}
+
+	updatedLaptop.Id = laptop.GetId()
+	updatedLaptop.Revision = revision
+
+	if err := s.laptopStore.Update(updatedLaptop); err != nil {
+		return nil, status.Errorf(storeErrorCode(err), "failed to update laptop: %v", err)
+	}
+
+	log.Printf("updated laptop with id - %s", updatedLaptop.GetId())
+	return &pb.UpdateLaptopResponse{
+		Laptop: updatedLaptop,
+	}, nil
+}
+
+// DeleteLaptop is a unary RPC that deletes a laptop by its id.
+func (s *LaptopServer) DeleteLaptop(ctx context.Context, req *pb.DeleteLaptopRequest) (*pb.DeleteLaptopResponse, error) {
+	laptopID := req.GetId()
+	log.Printf("recieved DeleteLaptop(_) request with id - %s", laptopID)
+
+	revision, err := expectedRevision(ctx, req.GetRevision())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid revision: %v", err)
+	}
+
+	if err := contextError(ctx); err != nil {
+		log.Printf("context error: %v", err)
+		return nil, err
+	}
+
+	if err := s.laptopStore.Delete(laptopID, revision); err != nil {
+		return nil, status.Errorf(storeErrorCode(err), "failed to delete laptop: %v", err)
+	}
+
+	log.Printf("deleted laptop with id - %s", laptopID)
+	return &pb.DeleteLaptopResponse{}, nil
+}
+
+// ListLaptops is a unary RPC that returns a page of laptops ordered by their id.
+func (s *LaptopServer) ListLaptops(ctx context.Context, req *pb.ListLaptopsRequest) (*pb.ListLaptopsResponse, error) {
+	log.Printf("recieved ListLaptops(_) request with page size - %d, page token - %s", req.GetPageSize(), req.GetPageToken())
+
+	pageSize := int(req.GetPageSize())
+	if pageSize == 0 {
+		pageSize = defaultPageSize
+	}
+
+	if pageSize > maxPageSize {
+		pageSize = maxPageSize
+	}
+
+	after, err := decodePageToken(req.GetPageToken())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+
+	// Fetching one extra laptop tells whether there is a next page.
+	laptops, err := s.laptopStore.List(ctx, after, pageSize+1)
+	if err != nil {
+		if err := contextError(ctx); err != nil {
+			return nil, err
+		}
+
+		return nil, status.Errorf(codes.Internal, "failed to list laptops: %v", err)
+	}
+
+	res := &pb.ListLaptopsResponse{}
+
+	if len(laptops) > pageSize {
+		laptops = laptops[:pageSize]
+		res.NextPageToken = encodePageToken(laptops[pageSize-1].GetId())
+	}
+
+	res.Laptops = laptops
+	return res, nil
+}
+
 // SearchLaptop is a server-streaming RPC to search for laptops.
 func (s *LaptopServer) SearchLaptop(req *pb.SearchLaptopRequest, stream pb.LaptopService_SearchLaptopServer) error {
 	filter := req.GetFilter()
-	log.Printf("recieved SearchLaptop(_) request with filter - %v", filter)
+	orderBy := req.GetOrderBy()
+
+	log.Printf(
+		"recieved SearchLaptop(_) request with filter - %v, order by - %s, query - %s, text - %s", filter, orderBy,
+		req.GetQuery(), req.GetText(),
+	)
+
+	if req.GetQuery() != "" {
+		compiledQuery, err := query.Parse(req.GetQuery())
+		if err != nil {
+			return status.Errorf(codes.InvalidArgument, "invalid query: %v", err)
+		}
+
+		mergedFilter := &pb.Filter{}
+		if filter != nil {
+			proto.Merge(mergedFilter, filter)
+		}
+
+		proto.Merge(mergedFilter, compiledQuery.Filter)
+		filter = mergedFilter
+
+		if compiledQuery.OrderBy != "" {
+			orderBy = compiledQuery.OrderBy
+		}
+	}
+
+	orderKeys, err := parseOrderBy(orderBy)
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "%v", err)
+	}
 
-	err := s.laptopStore.Search(stream.Context(), filter, func(laptop *pb.Laptop) error {
+	if ordersByRating(orderKeys) && s.ratingStore == nil {
+		return status.Errorf(codes.FailedPrecondition, "cannot order by rating without a rating store")
+	}
+
+	send := func(laptop *pb.Laptop, score float64) error {
 		res := &pb.SearchLaptopResponse{
 			Laptop: laptop,
+			Score:  score,
 		}
 
 		if err := stream.Send(res); err != nil {
@@ -100,15 +497,306 @@ func (s *LaptopServer) SearchLaptop(req *pb.SearchLaptopRequest, stream pb.Lapto
 
 		log.Printf("sent SearchLaptop(_) response with laptop - %v", laptop.GetId())
 		return nil
+	}
+
+	// search finds the laptops matching the filter and the text, if any, most relevant first.
+	search := func(found func(laptop *pb.Laptop, score float64) error) error {
+		if req.GetText() != "" {
+			return s.laptopStore.SearchText(stream.Context(), req.GetText(), filter, found)
+		}
+
+		return s.laptopStore.Search(stream.Context(), filter, func(laptop *pb.Laptop) error {
+			return found(laptop, 0)
+		})
+	}
+
+	if len(orderKeys) == 0 {
+		if err := search(send); err != nil {
+			return status.Errorf(codes.Internal, "failed to search laptops: %v", err)
+		}
+
+		return nil
+	}
+
+	// Ordered results can only be sent once every match is known.
+	var laptops []*pb.Laptop
+	scores := make(map[string]float64)
+
+	err = search(func(laptop *pb.Laptop, score float64) error {
+		laptops = append(laptops, laptop)
+		scores[laptop.GetId()] = score
+		return nil
 	})
 
 	if err != nil {
 		return status.Errorf(codes.Internal, "failed to search laptops: %v", err)
 	}
 
+	var ratings map[string]float64
+
+	if ordersByRating(orderKeys) {
+		ratings = make(map[string]float64, len(laptops))
+
+		for _, laptop := range laptops {
+			rating, err := s.ratingStore.Find(laptop.GetId())
+			if err != nil {
+				return status.Errorf(codes.Internal, "failed to find laptop rating: %v", err)
+			}
+
+			ratings[laptop.GetId()] = rating.Average()
+		}
+	}
+
+	sortLaptops(laptops, orderKeys, ratings)
+
+	for _, laptop := range laptops {
+		if err := send(laptop, scores[laptop.GetId()]); err != nil {
+			return status.Errorf(codes.Internal, "failed to search laptops: %v", err)
+		}
+	}
+
 	return nil
 }
 
+// SearchFacets counts the laptops matching the filter per value of their commonly filtered properties
+func (s *LaptopServer) SearchFacets(
+	ctx context.Context, req *pb.SearchFacetsRequest,
+) (*pb.SearchFacetsResponse, error) {
+	log.Printf("recieved SearchFacets(_) request with filter - %v", req.GetFilter())
+
+	priceBucketUsd := req.GetPriceBucketUsd()
+	if priceBucketUsd == 0 {
+		priceBucketUsd = defaultPriceBucketUsd
+	}
+
+	if priceBucketUsd < 0 || math.IsNaN(priceBucketUsd) || math.IsInf(priceBucketUsd, 0) {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid price bucket size: %v", req.GetPriceBucketUsd())
+	}
+
+	facets, err := s.laptopStore.Facets(ctx, req.GetFilter(), priceBucketUsd)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to search facets: %v", err)
+	}
+
+	res := &pb.SearchFacetsResponse{
+		Facets: facets,
+	}
+
+	return res, nil
+}
+
+// WatchLaptops is a server-streaming RPC that sends the laptops matching the filter, then the laptops entering,
+// changing within and leaving the filter until the client cancels.
+func (s *LaptopServer) WatchLaptops(req *pb.WatchLaptopsRequest, stream pb.LaptopService_WatchLaptopsServer) error {
+	filter := req.GetFilter()
+	log.Printf("recieved WatchLaptops(_) request with filter - %v", filter)
+
+	send := func(event pb.WatchLaptopsResponse_Event, laptop *pb.Laptop) error {
+		res := &pb.WatchLaptopsResponse{
+			Event:  event,
+			Laptop: laptop,
+		}
+
+		if err := stream.Send(res); err != nil {
+			return err
+		}
+
+		log.Printf("sent WatchLaptops(_) %s event with laptop - %v", event, laptop.GetId())
+		return nil
+	}
+
+	// sent maps the laptops the client was sent and that have not left the filter since to the revision it was sent.
+	sent := make(map[string]uint64)
+
+	// Subscribing before the replay means no change is missed, but changes the replay already saw can show up again;
+	// they are recognised by their revision. Changes to laptops the client was never sent are events of their own.
+	subscription := s.laptopStore.Subscribe()
+	defer subscription.Close()
+
+	watch := func(change LaptopChange) error {
+		id := change.Laptop.GetId()
+		revision, seen := sent[id]
+
+		if change.Type != LaptopDeleted && seen && change.Laptop.GetRevision() <= revision {
+			return nil
+		}
+
+		if change.Type != LaptopDeleted && matchesFilter(filter, change.Laptop) {
+			event := pb.WatchLaptopsResponse_CREATED
+			if seen {
+				event = pb.WatchLaptopsResponse_UPDATED
+			}
+
+			sent[id] = change.Laptop.GetRevision()
+			return send(event, change.Laptop)
+		}
+
+		if !seen {
+			return nil
+		}
+
+		// A laptop created again with the same id starts over from the first revision.
+		delete(sent, id)
+		return send(pb.WatchLaptopsResponse_DELETED, change.Laptop)
+	}
+
+	// The changes made during the replay are kept aside until it ends, so that a long replay does not fill the
+	// buffer of the subscription.
+	replayCtx, cancelReplay := context.WithCancel(stream.Context())
+	defer cancelReplay()
+
+	replayed := make(chan error, 1)
+
+	go func() {
+		replayed <- s.laptopStore.Search(replayCtx, filter, func(laptop *pb.Laptop) error {
+			sent[laptop.GetId()] = laptop.GetRevision()
+			return send(pb.WatchLaptopsResponse_EXISTING, laptop)
+		})
+	}()
+
+	var changes []LaptopChange
+
+	for replaying := true; replaying; {
+		select {
+		case err := <-replayed:
+			if err != nil {
+				return status.Errorf(codes.Internal, "failed to watch laptops: %v", err)
+			}
+
+			replaying = false
+		case change, ok := <-subscription.Changes():
+			if !ok {
+				cancelReplay()
+				<-replayed
+
+				return status.Errorf(codes.ResourceExhausted, "failed to watch laptops: %v", subscription.Err())
+			}
+
+			changes = append(changes, change)
+		}
+	}
+
+	for _, change := range changes {
+		if err := watch(change); err != nil {
+			return status.Errorf(codes.Internal, "failed to watch laptops: %v", err)
+		}
+	}
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return contextError(stream.Context())
+		case change, ok := <-subscription.Changes():
+			if !ok {
+				return status.Errorf(codes.ResourceExhausted, "failed to watch laptops: %v", subscription.Err())
+			}
+
+			if err := watch(change); err != nil {
+				return status.Errorf(codes.Internal, "failed to watch laptops: %v", err)
+			}
+		}
+	}
+}
+
+// CompareLaptops is a unary RPC that compares the specs of 2 to 5 laptops in common units.
+func (s *LaptopServer) CompareLaptops(
+	ctx context.Context, req *pb.CompareLaptopsRequest,
+) (*pb.CompareLaptopsResponse, error) {
+	ids := req.GetIds()
+	log.Printf("recieved CompareLaptops(_) request with ids - %v", ids)
+
+	if len(ids) < minComparedLaptops || len(ids) > maxComparedLaptops {
+		return nil, status.Errorf(
+			codes.InvalidArgument, "can only compare %d to %d laptops, got %d", minComparedLaptops,
+			maxComparedLaptops, len(ids),
+		)
+	}
+
+	laptops := make([]*pb.Laptop, 0, len(ids))
+	seen := make(map[string]bool, len(ids))
+	var missingIDs []string
+
+	for _, id := range ids {
+		if seen[id] {
+			return nil, status.Errorf(codes.InvalidArgument, "laptop %s is listed more than once", id)
+		}
+
+		seen[id] = true
+
+		laptop, err := s.laptopStore.Find(id)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to find laptop: %v", err)
+		}
+
+		if laptop == nil {
+			missingIDs = append(missingIDs, id)
+			continue
+		}
+
+		laptops = append(laptops, laptop)
+	}
+
+	if len(missingIDs) > 0 {
+		return nil, status.Errorf(codes.NotFound, "laptops not found: %s", strings.Join(missingIDs, ", "))
+	}
+
+	res := &pb.CompareLaptopsResponse{
+		Laptops: laptops,
+		Specs:   compareLaptops(laptops),
+	}
+
+	return res, nil
+}
+
+// SimilarLaptops is a unary RPC that finds the laptops whose specs are nearest to a laptop's.
+func (s *LaptopServer) SimilarLaptops(
+	ctx context.Context, req *pb.SimilarLaptopsRequest,
+) (*pb.SimilarLaptopsResponse, error) {
+	log.Printf("recieved SimilarLaptops(_) request with id - %s, filter - %v", req.GetId(), req.GetFilter())
+
+	weights, err := similarityWeights(req.GetWeights())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid weights: %v", err)
+	}
+
+	limit := int(req.GetLimit())
+	switch {
+	case limit == 0:
+		limit = defaultSimilarLaptops
+	case limit > maxSimilarLaptops:
+		limit = maxSimilarLaptops
+	}
+
+	target, err := s.laptopStore.Find(req.GetId())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to find laptop: %v", err)
+	}
+
+	if target == nil {
+		return nil, status.Errorf(codes.NotFound, "laptop %s not found", req.GetId())
+	}
+
+	var candidates []*pb.Laptop
+
+	err = s.laptopStore.Search(ctx, req.GetFilter(), func(laptop *pb.Laptop) error {
+		if laptop.GetId() != target.GetId() {
+			candidates = append(candidates, laptop)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to search laptops: %v", err)
+	}
+
+	res := &pb.SimilarLaptopsResponse{
+		Laptops: nearestLaptops(target, candidates, weights, limit),
+	}
+
+	return res, nil
+}
+
 // UploadImage is a client-streaming RPC to upload images.
 func (s *LaptopServer) UploadImage(stream pb.LaptopService_UploadImageServer) error {
 	req, err := stream.Recv()
@@ -133,8 +821,16 @@ func (s *LaptopServer) UploadImage(stream pb.LaptopService_UploadImageServer) er
 		return status.Errorf(codes.InvalidArgument, "laptop %v not found", laptopID)
 	}
 
-	imageData := bytes.Buffer{}
-	imageSize := 0
+	// The image is written to the store as it arrives and discarded unless the upload completes.
+	imageWriter, err := s.imageStore.Create(laptopID, imageExtension)
+	if err != nil {
+		log.Printf("UploadImage(_) failed to create image: %v", err)
+		return status.Errorf(imageUploadErrorCode(err), "failed to create image: %v", err)
+	}
+
+	defer imageWriter.Abort()
+
+	var imageSize int64
 
 	for {
 		if err := contextError(stream.Context()); err != nil {
@@ -155,34 +851,37 @@ func (s *LaptopServer) UploadImage(stream pb.LaptopService_UploadImageServer) er
 
 		chunk := req.GetChunkData()
 		size := len(chunk)
-		imageSize += size
+		imageSize += int64(size)
 
 		log.Printf("UploadImage(_) streaming image data - size - %d", size)
 
-		if imageSize > maxImageSize {
-			log.Printf("UploadImage(_) image size exceeded the maximum size of %d bytes", maxImageSize)
-			return status.Errorf(codes.InvalidArgument, "image size exceeded the maximum size of %d bytes", maxImageSize)
+		if imageSize > s.maxImageSize {
+			log.Printf("UploadImage(_) image size exceeded the maximum size of %d bytes", s.maxImageSize)
+			return status.Errorf(codes.InvalidArgument, "image size exceeded the maximum size of %d bytes", s.maxImageSize)
 		}
 
 		// Simulate slow writes.
 		//time.Sleep(time.Second)
 
-		_, err = imageData.Write(chunk)
+		_, err = imageWriter.Write(chunk)
 		if err != nil {
 			log.Printf("UploadImage(_) failed to write image data: %v", err)
-			return status.Errorf(codes.Internal, "failed to write image data: %v", err)
+			return status.Errorf(imageUploadErrorCode(err), "failed to write image data: %v", err)
 		}
 	}
 
-	imageID, err := s.imageStore.Save(laptopID, imageExtension, imageData)
+	imageID, err := imageWriter.Commit()
 	if err != nil {
 		log.Printf("UploadImage(_) failed to save image: %v", err)
-		return status.Errorf(codes.Internal, "failed to save image: %v", err)
+		return status.Errorf(imageUploadErrorCode(err), "failed to save image: %v", err)
 	}
 
+	s.renderImage(imageID)
+
 	res := &pb.UploadImageResponse{
-		Id:   imageID,
-		Size: uint32(imageSize),
+		Id:           imageID,
+		Size:         uint32(imageSize),
+		Deduplicated: imageWriter.Deduplicated(),
 	}
 
 	if err := stream.SendAndClose(res); err != nil {
@@ -190,13 +889,306 @@ func (s *LaptopServer) UploadImage(stream pb.LaptopService_UploadImageServer) er
 		return status.Errorf(codes.Internal, "failed to send image data: %v", err)
 	}
 
-	log.Printf("UploadImage(_) saved image with id - %s, size - %d", imageID, imageSize)
+	log.Printf(
+		"UploadImage(_) saved image with id - %s, size - %d, deduplicated - %t", imageID, imageSize, res.Deduplicated,
+	)
+
+	return nil
+}
+
+// InitImageUpload is a unary RPC that starts an image upload whose chunks are sent by UploadImageChunk.
+func (s *LaptopServer) InitImageUpload(
+	ctx context.Context, req *pb.InitImageUploadRequest,
+) (*pb.InitImageUploadResponse, error) {
+	laptopID := req.GetInfo().GetLaptopId()
+	imageExtension := req.GetInfo().GetFileExtension()
+
+	log.Printf("recieved InitImageUpload(_) request for laptop - %s, extension - %s", laptopID, imageExtension)
+
+	laptop, err := s.laptopStore.Find(laptopID)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to find laptop: %v", err)
+	}
+
+	if laptop == nil {
+		return nil, status.Errorf(codes.InvalidArgument, "laptop %v not found", laptopID)
+	}
+
+	imageWriter, err := s.imageStore.Create(laptopID, imageExtension)
+	if err != nil {
+		return nil, status.Errorf(imageUploadErrorCode(err), "failed to create image: %v", err)
+	}
+
+	upload, err := s.imageUploads.start(imageWriter)
+	if err != nil {
+		_ = imageWriter.Abort()
+		return nil, status.Errorf(codes.Internal, "failed to start image upload: %v", err)
+	}
+
+	_, expiresAt, err := upload.status()
+	if err != nil {
+		return nil, status.Errorf(imageUploadErrorCode(err), "failed to start image upload: %v", err)
+	}
+
+	res := &pb.InitImageUploadResponse{
+		UploadId:  upload.id,
+		ExpiresAt: timestamppb.New(expiresAt),
+	}
+
+	return res, nil
+}
+
+// UploadImageChunk is a client-streaming RPC that receives chunks of image uploads started by InitImageUpload. A
+// stream that breaks can be followed by another one, starting at the size returned by GetImageUploadStatus.
+func (s *LaptopServer) UploadImageChunk(stream pb.LaptopService_UploadImageChunkServer) error {
+	var receivedSize int64
+
+	for {
+		if err := contextError(stream.Context()); err != nil {
+			log.Printf("context error: %v", err)
+			return err
+		}
+
+		req, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			log.Printf("UploadImageChunk(_) failed to stream image data: %v", err)
+			return status.Errorf(codes.Unknown, "failed to stream image data: %v", err)
+		}
+
+		upload, err := s.imageUploads.find(req.GetUploadId())
+		if err != nil {
+			return status.Errorf(imageUploadErrorCode(err), "failed to find image upload: %v", err)
+		}
+
+		receivedSize, err = upload.write(int64(req.GetOffset()), req.GetChunkData(), s.maxImageSize)
+		if err != nil {
+			log.Printf("UploadImageChunk(_) failed to write image data of upload - %s: %v", upload.id, err)
+			return status.Errorf(imageUploadErrorCode(err), "failed to write image data: %v", err)
+		}
+	}
+
+	res := &pb.UploadImageChunkResponse{
+		ReceivedSize: uint64(receivedSize),
+	}
+
+	if err := stream.SendAndClose(res); err != nil {
+		return status.Errorf(codes.Internal, "failed to send image upload response: %v", err)
+	}
+
+	return nil
+}
+
+// GetImageUploadStatus is a unary RPC that returns how much of an image upload was received.
+func (s *LaptopServer) GetImageUploadStatus(
+	ctx context.Context, req *pb.GetImageUploadStatusRequest,
+) (*pb.GetImageUploadStatusResponse, error) {
+	uploadID := req.GetUploadId()
+	log.Printf("recieved GetImageUploadStatus(_) request with upload id - %s", uploadID)
+
+	upload, err := s.imageUploads.find(uploadID)
+	if err != nil {
+		return nil, status.Errorf(imageUploadErrorCode(err), "failed to find image upload: %v", err)
+	}
+
+	receivedSize, expiresAt, err := upload.status()
+	if err != nil {
+		return nil, status.Errorf(imageUploadErrorCode(err), "failed to find image upload: %v", err)
+	}
+
+	res := &pb.GetImageUploadStatusResponse{
+		UploadId:     uploadID,
+		ReceivedSize: uint64(receivedSize),
+		ExpiresAt:    timestamppb.New(expiresAt),
+	}
+
+	return res, nil
+}
+
+// FinalizeImageUpload is a unary RPC that stores the image of an upload if it matches the SHA-256 checksum sent
+// by the client.
+func (s *LaptopServer) FinalizeImageUpload(
+	ctx context.Context, req *pb.FinalizeImageUploadRequest,
+) (*pb.FinalizeImageUploadResponse, error) {
+	uploadID := req.GetUploadId()
+	log.Printf("recieved FinalizeImageUpload(_) request with upload id - %s", uploadID)
+
+	if req.GetSha256() == "" {
+		return nil, status.Error(codes.InvalidArgument, "the sha256 checksum of the image is required")
+	}
+
+	upload, err := s.imageUploads.find(uploadID)
+	if err != nil {
+		return nil, status.Errorf(imageUploadErrorCode(err), "failed to find image upload: %v", err)
+	}
+
+	imageID, imageSize, err := upload.finalize(req.GetSha256())
+	if err != nil {
+		return nil, status.Errorf(imageUploadErrorCode(err), "failed to save image: %v", err)
+	}
+
+	// The upload is finished, its writer is no longer used by other requests.
+	deduplicated := upload.writer.Deduplicated()
+
+	log.Printf(
+		"FinalizeImageUpload(_) saved image with id - %s, size - %d, deduplicated - %t", imageID, imageSize, deduplicated,
+	)
+	s.renderImage(imageID)
+
+	res := &pb.FinalizeImageUploadResponse{
+		Id:           imageID,
+		Size:         uint64(imageSize),
+		Deduplicated: deduplicated,
+	}
+
+	return res, nil
+}
+
+// DownloadImage is a server-streaming RPC that sends the details of an image followed by its data in chunks.
+func (s *LaptopServer) DownloadImage(req *pb.DownloadImageRequest, stream pb.LaptopService_DownloadImageServer) error {
+	imageID := req.GetId()
+	log.Printf("recieved DownloadImage(_) request with id - %s", imageID)
+
+	info, imageData, err := s.imageStore.Open(imageID)
+	if err != nil {
+		return status.Errorf(storeErrorCode(err), "failed to open image: %v", err)
+	}
+
+	defer imageData.Close()
+
+	res := &pb.DownloadImageResponse{
+		Data: &pb.DownloadImageResponse_Info{
+			Info: imageDetails(info),
+		},
+	}
+
+	if err := stream.Send(res); err != nil {
+		return status.Errorf(codes.Internal, "failed to send image details: %v", err)
+	}
+
+	buffer := make([]byte, imageChunkSize)
+
+	for {
+		if err := contextError(stream.Context()); err != nil {
+			log.Printf("context error: %v", err)
+			return err
+		}
+
+		n, err := imageData.Read(buffer)
+		if n > 0 {
+			res := &pb.DownloadImageResponse{
+				Data: &pb.DownloadImageResponse_ChunkData{
+					ChunkData: buffer[:n],
+				},
+			}
+
+			if err := stream.Send(res); err != nil {
+				return status.Errorf(codes.Internal, "failed to send image data: %v", err)
+			}
+		}
+
+		if err == io.EOF {
+			break
+		}
 
+		if err != nil {
+			return status.Errorf(codes.Internal, "failed to read image data: %v", err)
+		}
+	}
+
+	log.Printf("sent DownloadImage(_) response with image - %s, size - %d", imageID, info.Size)
 	return nil
 }
 
-// RateLaptop is a bidirectional streaming RPC to rate laptops.
+// ListImages is a unary RPC that returns the details of the images of a laptop.
+func (s *LaptopServer) ListImages(ctx context.Context, req *pb.ListImagesRequest) (*pb.ListImagesResponse, error) {
+	laptopID := req.GetLaptopId()
+	log.Printf("recieved ListImages(_) request with laptop id - %s", laptopID)
+
+	if err := contextError(ctx); err != nil {
+		log.Printf("context error: %v", err)
+		return nil, err
+	}
+
+	laptop, err := s.laptopStore.Find(laptopID)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to find laptop: %v", err)
+	}
+
+	if laptop == nil {
+		return nil, status.Errorf(codes.NotFound, "laptop %s not found", laptopID)
+	}
+
+	images, err := s.imageStore.List(laptopID)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to list images: %v", err)
+	}
+
+	res := &pb.ListImagesResponse{
+		Images: s.listImageDetails(images),
+	}
+
+	return res, nil
+}
+
+// DeleteImage is a unary RPC that deletes an image.
+func (s *LaptopServer) DeleteImage(ctx context.Context, req *pb.DeleteImageRequest) (*pb.DeleteImageResponse, error) {
+	imageID := req.GetId()
+	log.Printf("recieved DeleteImage(_) request with id - %s", imageID)
+
+	if err := contextError(ctx); err != nil {
+		log.Printf("context error: %v", err)
+		return nil, err
+	}
+
+	if err := s.imageStore.Delete(imageID); err != nil {
+		return nil, status.Errorf(storeErrorCode(err), "failed to delete image: %v", err)
+	}
+
+	log.Printf("deleted image with id - %s", imageID)
+	return &pb.DeleteImageResponse{}, nil
+}
+
+// ServeImage serves the data of the image with the id path parameter over HTTP, it matches the signature of the
+// handlers of the REST gateway.
+func (s *LaptopServer) ServeImage(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+	imageID := pathParams["id"]
+	log.Printf("recieved GET image request with id - %s", imageID)
+
+	info, imageData, err := s.imageStore.Open(imageID)
+	if errors.Is(err, ErrRecordNotFound) {
+		http.Error(w, fmt.Sprintf("image %s not found", imageID), http.StatusNotFound)
+		return
+	}
+
+	if err != nil {
+		log.Printf("failed to open image: %v", err)
+		http.Error(w, "failed to open image", http.StatusInternalServerError)
+		return
+	}
+
+	defer imageData.Close()
+
+	w.Header().Set("Content-Type", imageContentType(info.Extension))
+	w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+
+	if _, err := io.Copy(w, imageData); err != nil {
+		log.Printf("failed to send image %s: %v", imageID, err)
+	}
+}
+
+// RateLaptop is a bidirectional streaming RPC to rate laptops. Each user has a single score per laptop, rating a laptop
+// again replaces the user's previous score.
 func (s *LaptopServer) RateLaptop(stream pb.LaptopService_RateLaptopServer) error {
+	claims, ok := UserClaimsFromContext(stream.Context())
+	if !ok {
+		log.Printf("RateLaptop(_) no authenticated user")
+		return status.Errorf(codes.Unauthenticated, "rating laptops requires an authenticated user")
+	}
+
 	for {
 		err := contextError(stream.Context())
 		if err != nil {
@@ -220,6 +1212,13 @@ func (s *LaptopServer) RateLaptop(stream pb.LaptopService_RateLaptopServer) erro
 
 		log.Printf("RateLaptop(_) received rating for laptop - %s, score - %f", laptopID, score)
 
+		if math.IsNaN(score) || score < s.minRatingScore || score > s.maxRatingScore {
+			log.Printf("RateLaptop(_) invalid score %v", score)
+			return status.Errorf(
+				codes.InvalidArgument, "score %v is not between %v and %v", score, s.minRatingScore, s.maxRatingScore,
+			)
+		}
+
 		foundLaptop, err := s.laptopStore.Find(laptopID)
 		if err != nil {
 			log.Printf("RateLaptop(_) failed to find laptop %v", err)
@@ -231,16 +1230,16 @@ func (s *LaptopServer) RateLaptop(stream pb.LaptopService_RateLaptopServer) erro
 			return status.Errorf(codes.NotFound, "laptop %s not found", laptopID)
 		}
 
-		rating, err := s.ratingStore.Add(laptopID, score)
+		rating, err := s.ratingStore.Rate(laptopID, claims.Username, score)
 		if err != nil {
-			log.Printf("RateLaptop(_) failed to add rating: %v", err)
-			return status.Errorf(codes.Internal, "failed to add rating: %v", err)
+			log.Printf("RateLaptop(_) failed to rate laptop: %v", err)
+			return status.Errorf(codes.Internal, "failed to rate laptop: %v", err)
 		}
 
 		res := &pb.RateLaptopResponse{
 			LaptopId:     laptopID,
 			RatingsCount: rating.Count,
-			AverageScore: rating.Sum / float64(rating.Count),
+			AverageScore: rating.Average(),
 		}
 
 		err = stream.Send(res)
@@ -4,9 +4,14 @@ import (
 	"context"
 	"github.com/jwambugu/pcbook-grpc/factory"
 	"github.com/jwambugu/pcbook-grpc/protos/pb"
+	"github.com/jwambugu/pcbook-grpc/units"
 	"github.com/stretchr/testify/require"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"math"
+	"strconv"
 	"testing"
 )
 
@@ -19,6 +24,12 @@ func TestLaptopServer_CreateLaptop(t *testing.T) {
 	laptopWithInvalidID := factory.NewLaptop()
 	laptopWithInvalidID.Id = "invalid-uuid"
 
+	laptopWithNaNPrice := factory.NewLaptop()
+	laptopWithNaNPrice.PriceUsd = math.NaN()
+
+	laptopWithInfiniteGPUFrequency := factory.NewLaptop()
+	laptopWithInfiniteGPUFrequency.Gpus[0].MaximumFrequency = math.Inf(1)
+
 	laptopWithDuplicateID := factory.NewLaptop()
 	storeDuplicateID := NewInMemoryLaptopStore()
 
@@ -43,6 +54,18 @@ func TestLaptopServer_CreateLaptop(t *testing.T) {
 			laptopStore: NewInMemoryLaptopStore(),
 			code:        codes.InvalidArgument,
 		},
+		{
+			name:        "fails to create a laptop with a NaN price",
+			laptop:      laptopWithNaNPrice,
+			laptopStore: NewInMemoryLaptopStore(),
+			code:        codes.InvalidArgument,
+		},
+		{
+			name:        "fails to create a laptop with an infinite GPU frequency",
+			laptop:      laptopWithInfiniteGPUFrequency,
+			laptopStore: NewInMemoryLaptopStore(),
+			code:        codes.InvalidArgument,
+		},
 		{
 			name:        "fails to create a laptop if the id already exists",
 			laptop:      laptopWithDuplicateID,
@@ -87,3 +110,542 @@ func TestLaptopServer_CreateLaptop(t *testing.T) {
 		})
 	}
 }
+
+func TestLaptopServer_GetLaptop(t *testing.T) {
+	t.Parallel()
+
+	laptop := factory.NewLaptop()
+	laptopStore := NewInMemoryLaptopStore()
+
+	err := laptopStore.Save(laptop)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name string
+		id   string
+		code codes.Code
+	}{
+		{
+			name: "gets a laptop successfully",
+			id:   laptop.Id,
+			code: codes.OK,
+		},
+		{
+			name: "fails to get a laptop that does not exist",
+			id:   factory.NewLaptop().Id,
+			code: codes.NotFound,
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			server := NewLaptopServer(laptopStore, nil, nil)
+			res, err := server.GetLaptop(context.Background(), &pb.GetLaptopRequest{Id: tc.id})
+
+			if tc.code != codes.OK {
+				require.Error(t, err)
+				require.Nil(t, res)
+				require.Equal(t, tc.code, status.Code(err))
+				return
+			}
+
+			require.NoError(t, err)
+			require.NotNil(t, res)
+			requireSameLaptop(t, laptop, res.GetLaptop())
+		})
+	}
+}
+
+func TestLaptopServer_UpdateLaptop(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name   string
+		update func(laptop *pb.Laptop) *pb.UpdateLaptopRequest
+		code   codes.Code
+	}{
+		{
+			name: "updates the masked fields only",
+			update: func(laptop *pb.Laptop) *pb.UpdateLaptopRequest {
+				return &pb.UpdateLaptopRequest{
+					Laptop: &pb.Laptop{
+						Id:       laptop.Id,
+						Name:     "ignored",
+						PriceUsd: 999,
+						Cpu:      &pb.CPU{NumberOfCores: 16},
+					},
+					UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"price_usd", "cpu.number_of_cores"}},
+				}
+			},
+			code: codes.OK,
+		},
+		{
+			name: "replaces the laptop with an empty mask",
+			update: func(laptop *pb.Laptop) *pb.UpdateLaptopRequest {
+				newLaptop := factory.NewLaptop()
+				newLaptop.Id = laptop.Id
+
+				return &pb.UpdateLaptopRequest{Laptop: newLaptop}
+			},
+			code: codes.OK,
+		},
+		{
+			name: "updates the laptop with a matching revision",
+			update: func(laptop *pb.Laptop) *pb.UpdateLaptopRequest {
+				return &pb.UpdateLaptopRequest{
+					Laptop:     &pb.Laptop{Id: laptop.Id, PriceUsd: 999, Cpu: &pb.CPU{NumberOfCores: 16}},
+					UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"price_usd", "cpu.number_of_cores"}},
+					Revision:   laptop.Revision,
+				}
+			},
+			code: codes.OK,
+		},
+		{
+			name: "fails to update the laptop with a stale revision",
+			update: func(laptop *pb.Laptop) *pb.UpdateLaptopRequest {
+				return &pb.UpdateLaptopRequest{
+					Laptop:     &pb.Laptop{Id: laptop.Id, PriceUsd: 999},
+					UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"price_usd"}},
+					Revision:   laptop.Revision + 1,
+				}
+			},
+			code: codes.Aborted,
+		},
+		{
+			name: "fails to update a laptop that does not exist",
+			update: func(laptop *pb.Laptop) *pb.UpdateLaptopRequest {
+				return &pb.UpdateLaptopRequest{Laptop: factory.NewLaptop()}
+			},
+			code: codes.NotFound,
+		},
+		{
+			name: "fails to update the laptop id",
+			update: func(laptop *pb.Laptop) *pb.UpdateLaptopRequest {
+				return &pb.UpdateLaptopRequest{
+					Laptop:     laptop,
+					UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"id"}},
+				}
+			},
+			code: codes.InvalidArgument,
+		},
+		{
+			name: "fails to update the price to a NaN",
+			update: func(laptop *pb.Laptop) *pb.UpdateLaptopRequest {
+				return &pb.UpdateLaptopRequest{
+					Laptop:     &pb.Laptop{Id: laptop.Id, PriceUsd: math.NaN()},
+					UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"price_usd"}},
+				}
+			},
+			code: codes.InvalidArgument,
+		},
+		{
+			name: "fails to update with an unknown field path",
+			update: func(laptop *pb.Laptop) *pb.UpdateLaptopRequest {
+				return &pb.UpdateLaptopRequest{
+					Laptop:     laptop,
+					UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"colour"}},
+				}
+			},
+			code: codes.InvalidArgument,
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			laptop := factory.NewLaptop()
+			laptopStore := NewInMemoryLaptopStore()
+
+			err := laptopStore.Save(laptop)
+			require.NoError(t, err)
+
+			req := tc.update(laptop)
+
+			server := NewLaptopServer(laptopStore, nil, nil)
+			res, err := server.UpdateLaptop(context.Background(), req)
+
+			if tc.code != codes.OK {
+				require.Error(t, err)
+				require.Nil(t, res)
+				require.Equal(t, tc.code, status.Code(err))
+				return
+			}
+
+			require.NoError(t, err)
+			require.NotNil(t, res)
+
+			storedLaptop, err := laptopStore.Find(laptop.Id)
+			require.NoError(t, err)
+			requireSameLaptop(t, res.GetLaptop(), storedLaptop)
+			require.Equal(t, laptop.GetRevision()+1, storedLaptop.GetRevision())
+			require.False(t, storedLaptop.GetUpdatedAt().AsTime().Before(laptop.GetUpdatedAt().AsTime()))
+
+			if len(req.GetUpdateMask().GetPaths()) == 0 {
+				require.Equal(t, req.GetLaptop().GetName(), storedLaptop.GetName())
+				require.Equal(t, req.GetLaptop().GetPriceUsd(), storedLaptop.GetPriceUsd())
+				return
+			}
+
+			require.Equal(t, laptop.GetName(), storedLaptop.GetName())
+			require.Equal(t, req.GetLaptop().GetPriceUsd(), storedLaptop.GetPriceUsd())
+			require.Equal(t, req.GetLaptop().GetCpu().GetNumberOfCores(), storedLaptop.GetCpu().GetNumberOfCores())
+			require.Equal(t, laptop.GetCpu().GetName(), storedLaptop.GetCpu().GetName())
+		})
+	}
+}
+
+func TestLaptopServer_UpdateLaptopIfMatch(t *testing.T) {
+	t.Parallel()
+
+	laptop := factory.NewLaptop()
+	laptopStore := NewInMemoryLaptopStore()
+
+	err := laptopStore.Save(laptop)
+	require.NoError(t, err)
+
+	server := NewLaptopServer(laptopStore, nil, nil)
+	req := &pb.UpdateLaptopRequest{
+		Laptop:     &pb.Laptop{Id: laptop.Id, PriceUsd: 999},
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"price_usd"}},
+	}
+
+	staleCtx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(ifMatchMetadataKey, `"7"`))
+	_, err = server.UpdateLaptop(staleCtx, req)
+	require.Equal(t, codes.Aborted, status.Code(err))
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(ifMatchMetadataKey, FormatETag(laptop.Revision)))
+	res, err := server.UpdateLaptop(ctx, req)
+	require.NoError(t, err)
+	require.Equal(t, laptop.Revision+1, res.GetLaptop().GetRevision())
+
+	// The If-Match revision has been consumed by the first update.
+	_, err = server.UpdateLaptop(ctx, req)
+	require.Equal(t, codes.Aborted, status.Code(err))
+}
+
+func TestLaptopServer_UpdateLaptopIfMatchList(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name    string
+		ifMatch func(revision uint64) []string
+		code    codes.Code
+	}{
+		{
+			name:    "wildcard",
+			ifMatch: func(revision uint64) []string { return []string{"*"} },
+			code:    codes.OK,
+		},
+		{
+			name: "list naming the revision once",
+			ifMatch: func(revision uint64) []string {
+				return []string{FormatETag(revision) + " , " + FormatETag(revision)}
+			},
+			code: codes.OK,
+		},
+		{
+			name: "repeated header",
+			ifMatch: func(revision uint64) []string {
+				return []string{FormatETag(revision), FormatETag(revision)}
+			},
+			code: codes.OK,
+		},
+		{
+			name: "list naming several revisions",
+			ifMatch: func(revision uint64) []string {
+				return []string{FormatETag(revision) + ", " + FormatETag(revision+1)}
+			},
+			code: codes.InvalidArgument,
+		},
+		{
+			name:    "wildcard in a list",
+			ifMatch: func(revision uint64) []string { return []string{"*, " + FormatETag(revision)} },
+			code:    codes.InvalidArgument,
+		},
+		{
+			name:    "weak etag",
+			ifMatch: func(revision uint64) []string { return []string{"W/" + FormatETag(revision)} },
+			code:    codes.InvalidArgument,
+		},
+		{
+			name:    "unquoted etag",
+			ifMatch: func(revision uint64) []string { return []string{strconv.FormatUint(revision, 10)} },
+			code:    codes.InvalidArgument,
+		},
+		{
+			name:    "empty list",
+			ifMatch: func(revision uint64) []string { return []string{" , "} },
+			code:    codes.InvalidArgument,
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			laptop := factory.NewLaptop()
+			laptopStore := NewInMemoryLaptopStore()
+
+			err := laptopStore.Save(laptop)
+			require.NoError(t, err)
+
+			server := NewLaptopServer(laptopStore, nil, nil)
+			req := &pb.UpdateLaptopRequest{
+				Laptop:     &pb.Laptop{Id: laptop.Id, PriceUsd: 999},
+				UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"price_usd"}},
+			}
+
+			md := metadata.MD{ifMatchMetadataKey: tc.ifMatch(laptop.Revision)}
+			_, err = server.UpdateLaptop(metadata.NewIncomingContext(context.Background(), md), req)
+			require.Equal(t, tc.code, status.Code(err), "%v", err)
+		})
+	}
+}
+
+func TestLaptopServer_DeleteLaptop(t *testing.T) {
+	t.Parallel()
+
+	laptop := factory.NewLaptop()
+	laptopStore := NewInMemoryLaptopStore()
+
+	err := laptopStore.Save(laptop)
+	require.NoError(t, err)
+
+	server := NewLaptopServer(laptopStore, nil, nil)
+
+	res, err := server.DeleteLaptop(context.Background(), &pb.DeleteLaptopRequest{Id: laptop.Id, Revision: laptop.Revision + 1})
+	require.Error(t, err)
+	require.Nil(t, res)
+	require.Equal(t, codes.Aborted, status.Code(err))
+
+	res, err = server.DeleteLaptop(context.Background(), &pb.DeleteLaptopRequest{Id: laptop.Id, Revision: laptop.Revision})
+	require.NoError(t, err)
+	require.NotNil(t, res)
+
+	deletedLaptop, err := laptopStore.Find(laptop.Id)
+	require.NoError(t, err)
+	require.Nil(t, deletedLaptop)
+
+	res, err = server.DeleteLaptop(context.Background(), &pb.DeleteLaptopRequest{Id: laptop.Id})
+	require.Error(t, err)
+	require.Nil(t, res)
+	require.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestLaptopServer_SearchFacets(t *testing.T) {
+	t.Parallel()
+
+	laptop := factory.NewLaptop()
+	laptop.PriceUsd = 1750
+	laptopStore := NewInMemoryLaptopStore()
+
+	err := laptopStore.Save(laptop)
+	require.NoError(t, err)
+
+	server := NewLaptopServer(laptopStore, nil, nil)
+
+	res, err := server.SearchFacets(context.Background(), &pb.SearchFacetsRequest{})
+	require.NoError(t, err)
+	require.Equal(t, uint32(1), res.GetFacets().GetTotal())
+	require.Len(t, res.GetFacets().GetPriceUsd(), 1)
+	require.Equal(t, 1500.0, res.GetFacets().GetPriceUsd()[0].GetMin())
+	require.Equal(t, 2000.0, res.GetFacets().GetPriceUsd()[0].GetMax())
+
+	res, err = server.SearchFacets(context.Background(), &pb.SearchFacetsRequest{PriceBucketUsd: 100})
+	require.NoError(t, err)
+	require.Equal(t, 1700.0, res.GetFacets().GetPriceUsd()[0].GetMin())
+
+	res, err = server.SearchFacets(context.Background(), &pb.SearchFacetsRequest{PriceBucketUsd: -100})
+	require.Error(t, err)
+	require.Nil(t, res)
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestLaptopServer_CompareLaptops(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := NewInMemoryLaptopStore()
+
+	heavyLaptop := newFilterTestLaptop()
+	heavyLaptop.Ram = &pb.Memory{Value: 16384, Unit: pb.Memory_MEGABYTE}
+	heavyLaptop.Weight = &pb.Laptop_WeightLb{WeightLb: 5.5}
+
+	lightLaptop := newFilterTestLaptop()
+	lightLaptop.Ram = &pb.Memory{Value: 8, Unit: pb.Memory_GIGABYTE}
+	lightLaptop.Weight = &pb.Laptop_WeightKg{WeightKg: 1.2}
+	lightLaptop.Screen.SizeInches = 13.3
+
+	for _, laptop := range []*pb.Laptop{heavyLaptop, lightLaptop} {
+		err := laptopStore.Save(laptop)
+		require.NoError(t, err)
+	}
+
+	server := NewLaptopServer(laptopStore, nil, nil)
+
+	res, err := server.CompareLaptops(
+		context.Background(), &pb.CompareLaptopsRequest{Ids: []string{heavyLaptop.Id, lightLaptop.Id}},
+	)
+	require.NoError(t, err)
+	require.Len(t, res.GetLaptops(), 2)
+	require.Equal(t, lightLaptop.Id, res.GetLaptops()[1].GetId())
+
+	specs := make(map[string]*pb.SpecComparison)
+	for _, spec := range res.GetSpecs() {
+		specs[spec.GetField()] = spec
+	}
+
+	require.Equal(t, []float64{16, 8}, specs["ram"].GetValues())
+	require.Equal(t, []uint32{0}, specs["ram"].GetWinners())
+	require.Equal(t, []float64{1536, 1536}, specs["storage"].GetValues())
+	require.Equal(t, []uint32{0, 1}, specs["storage"].GetWinners())
+	require.Equal(t, []float64{8, 8}, specs["gpu_memory"].GetValues())
+	require.InDelta(t, 5.5*units.KilogramsPerPound, specs["weight"].GetValues()[0], 1e-9)
+	require.Equal(t, []uint32{1}, specs["weight"].GetWinners())
+	require.Equal(t, []float64{1920 * 1080, 1920 * 1080}, specs["screen_pixels"].GetValues())
+	require.InDelta(t, 165.63, specs["screen_ppi"].GetValues()[1], 0.01)
+	require.Equal(t, []uint32{1}, specs["screen_ppi"].GetWinners())
+
+	// A laptop missing a spec does not win it, even where the lowest value is the best.
+	unweighedLaptop := newFilterTestLaptop()
+	unweighedLaptop.Weight = nil
+
+	for _, spec := range compareLaptops([]*pb.Laptop{unweighedLaptop, heavyLaptop}) {
+		if spec.GetField() == "weight" {
+			require.Equal(t, []uint32{1}, spec.GetWinners())
+		}
+	}
+
+	for _, spec := range compareLaptops([]*pb.Laptop{unweighedLaptop, unweighedLaptop}) {
+		if spec.GetField() == "weight" {
+			require.Empty(t, spec.GetWinners())
+		}
+	}
+
+	testCases := []struct {
+		name            string
+		ids             []string
+		code            codes.Code
+		expectedMessage string
+	}{
+		{
+			name: "too few laptops",
+			ids:  []string{heavyLaptop.Id},
+			code: codes.InvalidArgument,
+		},
+		{
+			name: "too many laptops",
+			ids:  []string{"a", "b", "c", "d", "e", "f"},
+			code: codes.InvalidArgument,
+		},
+		{
+			name: "duplicate laptop",
+			ids:  []string{heavyLaptop.Id, heavyLaptop.Id},
+			code: codes.InvalidArgument,
+		},
+		{
+			name:            "unknown laptops",
+			ids:             []string{"unknown-1", heavyLaptop.Id, "unknown-2"},
+			code:            codes.NotFound,
+			expectedMessage: "laptops not found: unknown-1, unknown-2",
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			res, err := server.CompareLaptops(context.Background(), &pb.CompareLaptopsRequest{Ids: tc.ids})
+			require.Error(t, err)
+			require.Nil(t, res)
+			require.Equal(t, tc.code, status.Code(err))
+
+			if tc.expectedMessage != "" {
+				require.Equal(t, tc.expectedMessage, status.Convert(err).Message())
+			}
+		})
+	}
+}
+
+func TestLaptopServer_SimilarLaptops(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := NewInMemoryLaptopStore()
+
+	target := newFilterTestLaptop()
+	target.Brand = "Lenovo"
+
+	twin := newFilterTestLaptop()
+	twin.Brand = "Dell"
+	twin.PriceUsd = target.PriceUsd + 50
+
+	cheapTwin := newFilterTestLaptop()
+	cheapTwin.Brand = "Lenovo"
+	cheapTwin.PriceUsd = 1000
+
+	budget := newFilterTestLaptop()
+	budget.Brand = "Lenovo"
+	budget.PriceUsd = 600
+	budget.Ram = &pb.Memory{Value: 4, Unit: pb.Memory_GIGABYTE}
+	budget.Cpu.NumberOfCores = 2
+
+	for _, laptop := range []*pb.Laptop{target, twin, cheapTwin, budget} {
+		err := laptopStore.Save(laptop)
+		require.NoError(t, err)
+	}
+
+	server := NewLaptopServer(laptopStore, nil, nil)
+
+	similarIDS := func(req *pb.SimilarLaptopsRequest) []string {
+		res, err := server.SimilarLaptops(context.Background(), req)
+		require.NoError(t, err)
+
+		var ids []string
+		for i, similarLaptop := range res.GetLaptops() {
+			if i > 0 {
+				require.GreaterOrEqual(t, similarLaptop.GetDistance(), res.GetLaptops()[i-1].GetDistance())
+			}
+
+			ids = append(ids, similarLaptop.GetLaptop().GetId())
+		}
+
+		return ids
+	}
+
+	require.Equal(t, []string{twin.Id, cheapTwin.Id, budget.Id}, similarIDS(&pb.SimilarLaptopsRequest{Id: target.Id}))
+	require.Equal(t, []string{twin.Id}, similarIDS(&pb.SimilarLaptopsRequest{Id: target.Id, Limit: 1}))
+	require.Equal(
+		t, []string{cheapTwin.Id, budget.Id},
+		similarIDS(&pb.SimilarLaptopsRequest{Id: target.Id, Filter: &pb.Filter{Brand: "lenovo"}}),
+	)
+
+	// Ignoring the price leaves the laptops with the same specs at no distance.
+	res, err := server.SimilarLaptops(
+		context.Background(), &pb.SimilarLaptopsRequest{Id: target.Id, Weights: map[string]float64{"price": 0}},
+	)
+	require.NoError(t, err)
+	require.Zero(t, res.GetLaptops()[0].GetDistance())
+	require.Zero(t, res.GetLaptops()[1].GetDistance())
+
+	res, err = server.SimilarLaptops(
+		context.Background(), &pb.SimilarLaptopsRequest{Id: target.Id, Weights: map[string]float64{"colour": 1}},
+	)
+	require.Error(t, err)
+	require.Nil(t, res)
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	res, err = server.SimilarLaptops(context.Background(), &pb.SimilarLaptopsRequest{Id: "unknown"})
+	require.Error(t, err)
+	require.Nil(t, res)
+	require.Equal(t, codes.NotFound, status.Code(err))
+}
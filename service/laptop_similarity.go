@@ -0,0 +1,134 @@
+package service
+
+import (
+	"fmt"
+	"github.com/jwambugu/pcbook-grpc/protos/pb"
+	"github.com/jwambugu/pcbook-grpc/units"
+	"math"
+	"sort"
+)
+
+const (
+	defaultSimilarLaptops = 5
+	maxSimilarLaptops     = 50
+)
+
+// similarityFeature is a dimension of the spec vector similar laptops are found by
+type similarityFeature struct {
+	name  string
+	value func(laptop *pb.Laptop) float64
+}
+
+// logScale returns a feature value that grows with the order of magnitude of the size, so that doubling a small
+// memory counts as much as doubling a large one
+func logScale(size func(laptop *pb.Laptop) uint64) func(laptop *pb.Laptop) float64 {
+	return func(laptop *pb.Laptop) float64 {
+		return math.Log2(1 + float64(size(laptop)))
+	}
+}
+
+// similarityFeatures lists the features of the spec vector
+var similarityFeatures = []similarityFeature{
+	{name: "price", value: orderFields["price_usd"]},
+	{name: "cpu_cores", value: orderFields["cpu_cores"]},
+	{name: "cpu_frequency", value: orderFields["cpu_frequency"]},
+	{name: "ram", value: logScale(func(laptop *pb.Laptop) uint64 {
+		return units.MemoryBits(laptop.GetRam())
+	})},
+	{name: "storage", value: logScale(func(laptop *pb.Laptop) uint64 {
+		return storageBits(laptop, pb.Storage_UNKNOWN)
+	})},
+	{name: "gpu_memory", value: logScale(gpuMemoryBits)},
+	{name: "screen_size", value: func(laptop *pb.Laptop) float64 {
+		return float64(laptop.GetScreen().GetSizeInches())
+	}},
+	{name: "screen_resolution", value: logScale(screenPixels)},
+	{name: "weight", value: weightKg},
+}
+
+// similarityWeights returns the weight of each of the similarityFeatures, 1 unless overridden
+func similarityWeights(overrides map[string]float64) ([]float64, error) {
+	weights := make([]float64, len(similarityFeatures))
+	positions := make(map[string]int, len(similarityFeatures))
+
+	for i, feature := range similarityFeatures {
+		weights[i] = 1
+		positions[feature.name] = i
+	}
+
+	for name, weight := range overrides {
+		i, ok := positions[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown feature %q", name)
+		}
+
+		if weight < 0 || math.IsNaN(weight) || math.IsInf(weight, 0) {
+			return nil, fmt.Errorf("invalid weight %v for feature %q", weight, name)
+		}
+
+		weights[i] = weight
+	}
+
+	return weights, nil
+}
+
+// featureVector returns the spec vector of the laptop
+func featureVector(laptop *pb.Laptop) []float64 {
+	vector := make([]float64, len(similarityFeatures))
+
+	for i, feature := range similarityFeatures {
+		vector[i] = feature.value(laptop)
+	}
+
+	return vector
+}
+
+// nearestLaptops returns the k candidates nearest to the target, nearest first. Every feature is scaled to [0, 1]
+// over the target and the candidates before the weighted euclidean distance is computed.
+func nearestLaptops(target *pb.Laptop, candidates []*pb.Laptop, weights []float64, k int) []*pb.SimilarLaptop {
+	targetVector := featureVector(target)
+	vectors := make([][]float64, len(candidates))
+
+	lo := append([]float64(nil), targetVector...)
+	hi := append([]float64(nil), targetVector...)
+
+	for i, candidate := range candidates {
+		vectors[i] = featureVector(candidate)
+
+		for j, value := range vectors[i] {
+			lo[j] = math.Min(lo[j], value)
+			hi[j] = math.Max(hi[j], value)
+		}
+	}
+
+	similarLaptops := make([]*pb.SimilarLaptop, len(candidates))
+
+	for i, candidate := range candidates {
+		var sum float64
+
+		for j, value := range vectors[i] {
+			if hi[j] == lo[j] {
+				continue
+			}
+
+			difference := (value - targetVector[j]) / (hi[j] - lo[j])
+			sum += weights[j] * difference * difference
+		}
+
+		similarLaptops[i] = &pb.SimilarLaptop{Laptop: candidate, Distance: math.Sqrt(sum)}
+	}
+
+	sort.Slice(similarLaptops, func(i, j int) bool {
+		if similarLaptops[i].Distance != similarLaptops[j].Distance {
+			return similarLaptops[i].Distance < similarLaptops[j].Distance
+		}
+
+		return similarLaptops[i].GetLaptop().GetId() < similarLaptops[j].GetLaptop().GetId()
+	})
+
+	if len(similarLaptops) > k {
+		similarLaptops = similarLaptops[:k]
+	}
+
+	return similarLaptops
+}
@@ -0,0 +1,74 @@
+package service
+
+import (
+	"github.com/jwambugu/pcbook-grpc/protos/pb"
+	"github.com/jwambugu/pcbook-grpc/units"
+	"math"
+)
+
+// weightKg returns the weight of the laptop in kilograms regardless of the unit it was recorded in
+func weightKg(laptop *pb.Laptop) float64 {
+	switch weight := laptop.GetWeight().(type) {
+	case *pb.Laptop_WeightKg:
+		return weight.WeightKg
+	case *pb.Laptop_WeightLb:
+		return weight.WeightLb * units.KilogramsPerPound
+	default:
+		return 0
+	}
+}
+
+// storageBits returns the total capacity in bits of the laptop storages using the driver, or of all storages if the
+// driver is unknown
+func storageBits(laptop *pb.Laptop, driver pb.Storage_Driver) uint64 {
+	var total uint64
+
+	for _, storage := range laptop.GetStorages() {
+		if driver == pb.Storage_UNKNOWN || storage.GetDriver() == driver {
+			total += units.MemoryBits(storage.GetMemory())
+		}
+	}
+
+	return total
+}
+
+// hasStorageDriver returns true if the laptop has a storage using the driver
+func hasStorageDriver(laptop *pb.Laptop, driver pb.Storage_Driver) bool {
+	for _, storage := range laptop.GetStorages() {
+		if storage.GetDriver() == driver {
+			return true
+		}
+	}
+
+	return false
+}
+
+// gpuMemoryBits returns the memory in bits of the laptop GPU with the most memory
+func gpuMemoryBits(laptop *pb.Laptop) uint64 {
+	var max uint64
+
+	for _, gpu := range laptop.GetGpus() {
+		if bits := units.MemoryBits(gpu.GetMemory()); bits > max {
+			max = bits
+		}
+	}
+
+	return max
+}
+
+// screenPixels returns the number of pixels of the laptop screen
+func screenPixels(laptop *pb.Laptop) uint64 {
+	resolution := laptop.GetScreen().GetResolution()
+	return uint64(resolution.GetWidth()) * uint64(resolution.GetHeight())
+}
+
+// screenPPI returns the pixels per inch of the laptop screen along its diagonal, or 0 if its size is unknown
+func screenPPI(laptop *pb.Laptop) float64 {
+	size := float64(laptop.GetScreen().GetSizeInches())
+	if size <= 0 {
+		return 0
+	}
+
+	resolution := laptop.GetScreen().GetResolution()
+	return math.Hypot(float64(resolution.GetWidth()), float64(resolution.GetHeight())) / size
+}
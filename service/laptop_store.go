@@ -4,92 +4,227 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"github.com/jinzhu/copier"
 	"github.com/jwambugu/pcbook-grpc/protos/pb"
+	"github.com/jwambugu/pcbook-grpc/units"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
 	"log"
+	"sort"
+	"strings"
 	"sync"
 )
 
-// ErrRecordExists is an error that is returned when a record already exists
-var ErrRecordExists = errors.New("record already exists")
+var (
+	// ErrRecordExists is an error that is returned when a record already exists
+	ErrRecordExists = errors.New("record already exists")
+	// ErrRecordNotFound is an error that is returned when a record does not exist
+	ErrRecordNotFound = errors.New("record not found")
+	// ErrRevisionMismatch is an error that is returned when a write is based on a stale revision of a record
+	ErrRevisionMismatch = errors.New("record revision mismatch")
+)
 
 // LaptopStore is an interface for storing laptops
 type LaptopStore interface {
-	// Save saves a laptop in the store
+	// Save saves a laptop in the store, stamping its revision and updated_at
 	Save(laptop *pb.Laptop) error
 	// Find finds a laptop by its id
 	Find(id string) (*pb.Laptop, error)
+	// Update replaces an existing laptop in the store if its revision matches the stored one, stamping the new
+	// revision and updated_at. A zero revision skips the check.
+	Update(laptop *pb.Laptop) error
+	// Delete removes a laptop from the store by its id if the revision matches the stored one. A zero revision skips
+	// the check.
+	Delete(id string, revision uint64) error
+	// List returns up to limit laptops ordered by their id, starting after the given id
+	List(ctx context.Context, after string, limit int) ([]*pb.Laptop, error)
 
 	// Search finds laptops by their properties using a filter, returns one by one laptop via the found function
 	Search(ctx context.Context, filter *pb.Filter, found func(laptop *pb.Laptop) error) error
+	// SearchText finds laptops whose brand, name, CPU or GPU match the text and the filter, returns them most relevant
+	// first with their relevance score via the found function
+	SearchText(
+		ctx context.Context, text string, filter *pb.Filter, found func(laptop *pb.Laptop, score float64) error,
+	) error
+	// Facets counts the laptops matching the filter per brand, CPU brand, GPU brand, screen panel, keyboard layout,
+	// RAM size bucket, price bucket of the given size and release year
+	Facets(ctx context.Context, filter *pb.Filter, priceBucketUsd float64) (*pb.LaptopFacets, error)
+	// Subscribe returns a subscription to the changes of the stored laptops made from now on
+	Subscribe() *LaptopSubscription
 }
 
 // InMemoryLaptopStore is an in-memory implementation of a LaptopStore
 type InMemoryLaptopStore struct {
 	mutext sync.RWMutex
 	data   map[string]*pb.Laptop
+	// ids holds the ids of all stored laptops in ascending order
+	ids []string
+	// textIndex indexes the text fields of all stored laptops
+	textIndex *textIndex
+	// indexes maps each of the indexedFields to the secondary index of all stored laptops by that field
+	indexes map[string]*laptopIndex
+	// changes publishes every write to the subscribers
+	changes *laptopBroadcaster
 }
 
 // NewInMemoryLaptopStore returns a new instance of an InMemoryLaptopStore
 func NewInMemoryLaptopStore() *InMemoryLaptopStore {
+	indexes := make(map[string]*laptopIndex, len(indexedFields))
+	for _, field := range indexedFields {
+		indexes[field] = newLaptopIndex(orderFields[field])
+	}
+
 	return &InMemoryLaptopStore{
-		data: make(map[string]*pb.Laptop),
+		data:      make(map[string]*pb.Laptop),
+		textIndex: newTextIndex(),
+		indexes:   indexes,
+		changes:   newLaptopBroadcaster(),
 	}
 }
 
 // matchesFilter returns true if the laptop matches the filter
 func matchesFilter(filter *pb.Filter, laptop *pb.Laptop) bool {
-	if laptop.GetPriceUsd() > filter.GetMaxPriceUsd() {
+	if filter == nil {
+		return true
+	}
+
+	// The bounds of the indexed numbers are negated so that a NaN value is never within them, as in the indexes.
+	if filter.MaxPriceUsd != nil && !(laptop.GetPriceUsd() <= filter.GetMaxPriceUsd()) {
 		return false
 	}
 
-	if laptop.GetCpu().GetNumberOfCores() < filter.GetMinCpuCores() {
+	if filter.MinCpuCores != nil && laptop.GetCpu().GetNumberOfCores() < filter.GetMinCpuCores() {
 		return false
 	}
 
-	if laptop.GetCpu().GetMaximumFrequency() < filter.GetMinCpuFrequency() {
+	if filter.MinCpuFrequency != nil && !(laptop.GetCpu().GetMaximumFrequency() >= filter.GetMinCpuFrequency()) {
 		return false
 	}
 
-	if toBits(laptop.GetRam()) < toBits(filter.GetMinRam()) {
+	if units.MemoryBits(laptop.GetRam()) < units.MemoryBits(filter.GetMinRam()) {
+		return false
+	}
+
+	if filter.GetBrand() != "" && !strings.EqualFold(laptop.GetBrand(), filter.GetBrand()) {
+		return false
+	}
+
+	if filter.GetName() != "" && !strings.Contains(strings.ToLower(laptop.GetName()), strings.ToLower(filter.GetName())) {
+		return false
+	}
+
+	if filter.MinPriceUsd != nil && !(laptop.GetPriceUsd() >= filter.GetMinPriceUsd()) {
+		return false
+	}
+
+	if filter.MaxWeightKg != nil && weightKg(laptop) > filter.GetMaxWeightKg() {
+		return false
+	}
+
+	if filter.MinReleaseYear != nil && laptop.GetReleaseYear() < filter.GetMinReleaseYear() {
+		return false
+	}
+
+	if filter.MaxReleaseYear != nil && laptop.GetReleaseYear() > filter.GetMaxReleaseYear() {
+		return false
+	}
+
+	return matchesGPUFilter(filter, laptop) &&
+		matchesStorageFilter(filter, laptop) &&
+		matchesScreenFilter(filter, laptop.GetScreen()) &&
+		matchesKeyboardFilter(filter, laptop.GetKeyboard())
+}
+
+// matchesGPUFilter returns true if the laptop has a GPU that matches the GPU criteria of the filter
+func matchesGPUFilter(filter *pb.Filter, laptop *pb.Laptop) bool {
+	if filter.GetGpuBrand() == "" && filter.GetMinGpuMemory() == nil {
+		return true
+	}
+
+	for _, gpu := range laptop.GetGpus() {
+		if filter.GetGpuBrand() != "" && !strings.EqualFold(gpu.GetBrand(), filter.GetGpuBrand()) {
+			continue
+		}
+
+		if units.MemoryBits(gpu.GetMemory()) < units.MemoryBits(filter.GetMinGpuMemory()) {
+			continue
+		}
+
+		return true
+	}
+
+	return false
+}
+
+// matchesStorageFilter returns true if the laptop storages match the storage criteria of the filter
+func matchesStorageFilter(filter *pb.Filter, laptop *pb.Laptop) bool {
+	driver := filter.GetStorageDriver()
+
+	if driver != pb.Storage_UNKNOWN && !hasStorageDriver(laptop, driver) {
+		return false
+	}
+
+	if filter.GetMinStorage() != nil && storageBits(laptop, driver) < units.MemoryBits(filter.GetMinStorage()) {
 		return false
 	}
 
 	return true
 }
 
-// toBits converts memory unit to bits
-func toBits(memory *pb.Memory) uint64 {
-	value := memory.GetValue()
+// matchesScreenFilter returns true if the screen matches the screen criteria of the filter
+func matchesScreenFilter(filter *pb.Filter, screen *pb.Screen) bool {
+	if filter.MinScreenSizeInches != nil && screen.GetSizeInches() < filter.GetMinScreenSizeInches() {
+		return false
+	}
+
+	if filter.MaxScreenSizeInches != nil && screen.GetSizeInches() > filter.GetMaxScreenSizeInches() {
+		return false
+	}
 
-	switch memory.GetUnit() {
-	case pb.Memory_BIT:
-		return value
-	case pb.Memory_BYTE:
-		return value << 3 // 8 = 2^3
-	case pb.Memory_KILOBYTE:
-		return value << 13 // 1024 * 8 = 2^10 * 2^3
-	case pb.Memory_MEGABYTE:
-		return value << 23 // 1024 * 1024 * 8 = 2^10 * 2^10 * 2^3
-	case pb.Memory_GIGABYTE:
-		return value << 33 // 1024 * 1024 * 1024 * 8 = 2^10 * 2^10 * 2^10 * 2^3
-	case pb.Memory_TERABYTE:
-		return value << 43 // 1024 * 1024 * 1024 * 1024 * 8 = 2^10 * 2^10 * 2^10 * 2^10 * 2^3
-	default:
-		return 0
+	if screen.GetResolution().GetWidth() < filter.GetMinScreenResolution().GetWidth() ||
+		screen.GetResolution().GetHeight() < filter.GetMinScreenResolution().GetHeight() {
+		return false
 	}
+
+	if filter.GetScreenPanel() != pb.Screen_UNKNOWN && screen.GetPanel() != filter.GetScreenPanel() {
+		return false
+	}
+
+	if filter.IsMultiTouch != nil && screen.GetIsMultiTouch() != filter.GetIsMultiTouch() {
+		return false
+	}
+
+	return true
 }
 
-func deepCopy(laptop *pb.Laptop) (*pb.Laptop, error) {
-	l := &pb.Laptop{}
+// matchesKeyboardFilter returns true if the keyboard matches the keyboard criteria of the filter
+func matchesKeyboardFilter(filter *pb.Filter, keyboard *pb.Keyboard) bool {
+	if filter.GetKeyboardLayout() != pb.Keyboard_UNKNOWN && keyboard.GetLayout() != filter.GetKeyboardLayout() {
+		return false
+	}
+
+	if filter.IsBacklit != nil && keyboard.GetIsBacklit() != filter.GetIsBacklit() {
+		return false
+	}
+
+	return true
+}
 
-	if err := copier.Copy(l, laptop); err != nil {
-		return nil, fmt.Errorf("error copying laptop: %v", err)
+func deepCopy(laptop *pb.Laptop) (*pb.Laptop, error) {
+	// proto.Clone copies the nested messages too, so stored laptops share nothing with their callers.
+	l, ok := proto.Clone(laptop).(*pb.Laptop)
+	if !ok {
+		return nil, fmt.Errorf("error copying laptop: unexpected %T", l)
 	}
 	return l, nil
 }
 
-// Save saves a laptop in the store
+// stampRevision sets the next revision and the current time on the laptop.
+func stampRevision(laptop *pb.Laptop, revision uint64) {
+	laptop.Revision = revision + 1
+	laptop.UpdatedAt = timestamppb.Now()
+}
+
+// Save saves a laptop in the store, stamping its revision and updated_at
 func (store *InMemoryLaptopStore) Save(laptop *pb.Laptop) error {
 	store.mutext.Lock()
 	defer store.mutext.Unlock()
@@ -98,13 +233,9 @@ func (store *InMemoryLaptopStore) Save(laptop *pb.Laptop) error {
 		return ErrRecordExists
 	}
 
-	newLaptop, err := deepCopy(laptop)
-	if err != nil {
-		return err
-	}
+	stampRevision(laptop, 0)
 
-	store.data[laptop.Id] = newLaptop
-	return nil
+	return store.put(laptop)
 }
 
 // Find finds a laptop by its id
@@ -120,18 +251,189 @@ func (store *InMemoryLaptopStore) Find(id string) (*pb.Laptop, error) {
 	return deepCopy(laptop)
 }
 
-// Search finds laptops by their properties using a filter, returns one by one laptop via the found function
+// Update replaces an existing laptop in the store if its revision matches the stored one, stamping the new
+// revision and updated_at. A zero revision skips the check.
+func (store *InMemoryLaptopStore) Update(laptop *pb.Laptop) error {
+	store.mutext.Lock()
+	defer store.mutext.Unlock()
+
+	storedLaptop := store.data[laptop.Id]
+	if err := checkRevision(storedLaptop, laptop.Revision); err != nil {
+		return err
+	}
+
+	stampRevision(laptop, storedLaptop.Revision)
+
+	return store.put(laptop)
+}
+
+// Delete removes a laptop from the store by its id if the revision matches the stored one. A zero revision skips
+// the check.
+func (store *InMemoryLaptopStore) Delete(id string, revision uint64) error {
+	store.mutext.Lock()
+	defer store.mutext.Unlock()
+
+	storedLaptop := store.data[id]
+	if err := checkRevision(storedLaptop, revision); err != nil {
+		return err
+	}
+
+	delete(store.data, id)
+	store.textIndex.remove(id)
+
+	for _, index := range store.indexes {
+		index.remove(storedLaptop)
+	}
+
+	store.changes.publish(LaptopChange{Type: LaptopDeleted, Laptop: storedLaptop, Previous: storedLaptop})
+
+	i := sort.SearchStrings(store.ids, id)
+	store.ids = append(store.ids[:i], store.ids[i+1:]...)
+
+	return nil
+}
+
+// checkRevision returns the error writing over the stored laptop with the revision fails with, if any. A zero
+// revision skips the check.
+func checkRevision(storedLaptop *pb.Laptop, revision uint64) error {
+	if storedLaptop == nil {
+		return ErrRecordNotFound
+	}
+
+	if revision != 0 && revision != storedLaptop.Revision {
+		return ErrRevisionMismatch
+	}
+
+	return nil
+}
+
+// restore stores the laptop as it is, without stamping its revision or publishing a change. It replaces any stored
+// laptop with the same id and is used to load laptops that were stored before.
+func (store *InMemoryLaptopStore) restore(laptop *pb.Laptop) error {
+	store.mutext.Lock()
+	defer store.mutext.Unlock()
+
+	_, _, err := store.set(laptop)
+	return err
+}
+
+// replace stores the laptop as it is, without stamping its revision, and publishes the change. It replaces any
+// stored laptop with the same id and is used to apply a write that was stamped before.
+func (store *InMemoryLaptopStore) replace(laptop *pb.Laptop) error {
+	store.mutext.Lock()
+	defer store.mutext.Unlock()
+
+	return store.put(laptop)
+}
+
+// put stores the laptop as it is and publishes the change. The caller holds the mutex.
+func (store *InMemoryLaptopStore) put(laptop *pb.Laptop) error {
+	newLaptop, storedLaptop, err := store.set(laptop)
+	if err != nil {
+		return err
+	}
+
+	if storedLaptop == nil {
+		store.changes.publish(LaptopChange{Type: LaptopCreated, Laptop: newLaptop})
+	} else {
+		store.changes.publish(LaptopChange{Type: LaptopUpdated, Laptop: newLaptop, Previous: storedLaptop})
+	}
+
+	return nil
+}
+
+// set stores a copy of the laptop, replacing any stored laptop with the same id, and returns the copy and the
+// laptop it replaced. The caller holds the mutex.
+func (store *InMemoryLaptopStore) set(laptop *pb.Laptop) (*pb.Laptop, *pb.Laptop, error) {
+	newLaptop, err := deepCopy(laptop)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	storedLaptop, exists := store.data[laptop.Id]
+
+	store.data[laptop.Id] = newLaptop
+	store.textIndex.add(newLaptop)
+
+	for _, index := range store.indexes {
+		if exists {
+			index.remove(storedLaptop)
+		}
+
+		index.add(newLaptop)
+	}
+
+	if !exists {
+		i := sort.SearchStrings(store.ids, laptop.Id)
+		store.ids = append(store.ids, "")
+		copy(store.ids[i+1:], store.ids[i:])
+		store.ids[i] = laptop.Id
+	}
+
+	return newLaptop, storedLaptop, nil
+}
+
+// List returns up to limit laptops ordered by their id, starting after the given id
+func (store *InMemoryLaptopStore) List(ctx context.Context, after string, limit int) ([]*pb.Laptop, error) {
+	store.mutext.RLock()
+	defer store.mutext.RUnlock()
+
+	start := sort.Search(len(store.ids), func(i int) bool {
+		return store.ids[i] > after
+	})
+
+	laptops := make([]*pb.Laptop, 0, limit)
+
+	for _, id := range store.ids[start:] {
+		if len(laptops) == limit {
+			break
+		}
+
+		if err := ctx.Err(); err != nil {
+			return nil, fmt.Errorf("listing laptops context cancelled: %w", err)
+		}
+
+		laptop, err := deepCopy(store.data[id])
+		if err != nil {
+			return nil, err
+		}
+
+		laptops = append(laptops, laptop)
+	}
+
+	return laptops, nil
+}
+
+// snapshot returns the stored laptops with the ids returned by the ids function, which runs under the read lock.
+// Stored laptops are replaced rather than modified on update, so the snapshot can be read after the lock is released.
+func (store *InMemoryLaptopStore) snapshot(ids func() []string) []*pb.Laptop {
+	store.mutext.RLock()
+	defer store.mutext.RUnlock()
+
+	snapshotIDs := ids()
+	laptops := make([]*pb.Laptop, len(snapshotIDs))
+
+	for i, id := range snapshotIDs {
+		laptops[i] = store.data[id]
+	}
+
+	return laptops
+}
+
+// Search finds laptops by their properties using a filter, returns one by one laptop via the found function. The
+// candidates are read from the most selective secondary index and the store is not locked while found runs.
 func (store *InMemoryLaptopStore) Search(
 	ctx context.Context, filter *pb.Filter,
 	match func(laptop *pb.Laptop) error,
 ) error {
-	store.mutext.RLock()
-	defer store.mutext.RUnlock()
+	laptops := store.snapshot(func() []string {
+		return store.planSearch(filter)
+	})
 
-	for _, laptop := range store.data {
+	for _, laptop := range laptops {
 		if ctx.Err() == context.Canceled || ctx.Err() == context.DeadlineExceeded {
 			log.Printf("error searching laptop: %s context cancelled: %v", laptop.Id, ctx.Err())
-			return errors.New("searching laptop context cancelled")
+			return fmt.Errorf("searching laptop context cancelled: %w", ctx.Err())
 		}
 
 		if matchesFilter(filter, laptop) {
@@ -148,3 +450,73 @@ func (store *InMemoryLaptopStore) Search(
 
 	return nil
 }
+
+// SearchText finds laptops whose brand, name, CPU or GPU match the text and the filter, returns them most relevant
+// first with their relevance score via the found function
+func (store *InMemoryLaptopStore) SearchText(
+	ctx context.Context, text string, filter *pb.Filter, found func(laptop *pb.Laptop, score float64) error,
+) error {
+	var results []scoredID
+
+	laptops := store.snapshot(func() []string {
+		results = store.textIndex.search(text)
+
+		ids := make([]string, len(results))
+		for i, result := range results {
+			ids[i] = result.id
+		}
+
+		return ids
+	})
+
+	for i, laptop := range laptops {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("searching laptop text context cancelled: %w", err)
+		}
+
+		if !matchesFilter(filter, laptop) {
+			continue
+		}
+
+		foundLaptop, err := deepCopy(laptop)
+		if err != nil {
+			return err
+		}
+
+		if err := found(foundLaptop, results[i].score); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Facets counts the laptops matching the filter per brand, CPU brand, GPU brand, screen panel, keyboard layout,
+// RAM size bucket, price bucket of the given size and release year
+func (store *InMemoryLaptopStore) Facets(
+	ctx context.Context, filter *pb.Filter, priceBucketUsd float64,
+) (*pb.LaptopFacets, error) {
+	laptops := store.snapshot(func() []string {
+		return store.planSearch(filter)
+	})
+
+	counter := newFacetCounter(priceBucketUsd)
+
+	for _, laptop := range laptops {
+		if err := ctx.Err(); err != nil {
+			return nil, fmt.Errorf("counting laptop facets context cancelled: %w", err)
+		}
+
+		if matchesFilter(filter, laptop) {
+			counter.add(laptop)
+		}
+	}
+
+	return counter.facets(), nil
+}
+
+// Subscribe returns a subscription to the changes of the stored laptops made from now on. Changes are published
+// while the write lock is held, so they are received in the order they were made.
+func (store *InMemoryLaptopStore) Subscribe() *LaptopSubscription {
+	return store.changes.subscribe()
+}
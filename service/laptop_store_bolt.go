@@ -0,0 +1,458 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"github.com/jwambugu/pcbook-grpc/protos/pb"
+	"github.com/jwambugu/pcbook-grpc/serializer"
+	bolt "go.etcd.io/bbolt"
+	"sync"
+)
+
+// BoltLaptopStore is a LaptopStore that persists laptops as protobuf bytes in a bbolt database opened with OpenBolt.
+// Each of the indexedFields has an index bucket that Search reads candidates from.
+type BoltLaptopStore struct {
+	db *bolt.DB
+	// mutex serializes writes so that changes are published in the order they were committed
+	mutex   sync.Mutex
+	changes *laptopBroadcaster
+}
+
+// NewBoltLaptopStore returns a new instance of a BoltLaptopStore
+func NewBoltLaptopStore(db *bolt.DB) *BoltLaptopStore {
+	return &BoltLaptopStore{
+		db:      db,
+		changes: newLaptopBroadcaster(),
+	}
+}
+
+// getBoltLaptop returns the laptop with the id, or nil if there is none
+func getBoltLaptop(tx *bolt.Tx, id []byte) (*pb.Laptop, error) {
+	data := tx.Bucket(boltLaptopsBucket).Get(id)
+	if data == nil {
+		return nil, nil
+	}
+
+	laptop := &pb.Laptop{}
+
+	if err := serializer.BinaryToProtobuf(data, laptop); err != nil {
+		return nil, fmt.Errorf("error decoding laptop: %v", err)
+	}
+
+	return laptop, nil
+}
+
+// putBoltLaptop writes the laptop and its index entries
+func putBoltLaptop(tx *bolt.Tx, laptop *pb.Laptop) error {
+	data, err := serializer.ProtobufToBinary(laptop)
+	if err != nil {
+		return fmt.Errorf("error encoding laptop: %v", err)
+	}
+
+	if err := tx.Bucket(boltLaptopsBucket).Put([]byte(laptop.GetId()), data); err != nil {
+		return fmt.Errorf("error writing laptop: %v", err)
+	}
+
+	for _, field := range indexedFields {
+		key := boltIndexKey(orderFields[field](laptop), laptop.GetId())
+
+		if err := tx.Bucket(boltIndexBucket(field)).Put(key, []byte{}); err != nil {
+			return fmt.Errorf("error writing laptop %s index: %v", field, err)
+		}
+	}
+
+	terms := tx.Bucket(boltTermsBucket)
+
+	for term, frequency := range termFrequencies(laptop) {
+		value := make([]byte, binary.MaxVarintLen64)
+		value = value[:binary.PutUvarint(value, uint64(frequency))]
+
+		if err := terms.Put(boltTermKey(term, laptop.GetId()), value); err != nil {
+			return fmt.Errorf("error writing laptop term: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// deleteBoltLaptop removes the stored laptop and its index entries
+func deleteBoltLaptop(tx *bolt.Tx, laptop *pb.Laptop) error {
+	if err := tx.Bucket(boltLaptopsBucket).Delete([]byte(laptop.GetId())); err != nil {
+		return fmt.Errorf("error deleting laptop: %v", err)
+	}
+
+	for _, field := range indexedFields {
+		key := boltIndexKey(orderFields[field](laptop), laptop.GetId())
+
+		if err := tx.Bucket(boltIndexBucket(field)).Delete(key); err != nil {
+			return fmt.Errorf("error deleting laptop %s index: %v", field, err)
+		}
+	}
+
+	terms := tx.Bucket(boltTermsBucket)
+
+	for term := range termFrequencies(laptop) {
+		if err := terms.Delete(boltTermKey(term, laptop.GetId())); err != nil {
+			return fmt.Errorf("error deleting laptop term: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// write runs fn in a read-write transaction while holding the write mutex and publishes the change it returns once
+// committed
+func (store *BoltLaptopStore) write(fn func(tx *bolt.Tx) (*LaptopChange, error)) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	var change *LaptopChange
+
+	err := store.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		change, err = fn(tx)
+		return err
+	})
+
+	if err != nil {
+		return err
+	}
+
+	store.changes.publish(*change)
+	return nil
+}
+
+// Save saves a laptop in the store, stamping its revision and updated_at
+func (store *BoltLaptopStore) Save(laptop *pb.Laptop) error {
+	return store.write(func(tx *bolt.Tx) (*LaptopChange, error) {
+		if tx.Bucket(boltLaptopsBucket).Get([]byte(laptop.GetId())) != nil {
+			return nil, ErrRecordExists
+		}
+
+		stampRevision(laptop, 0)
+
+		newLaptop, err := deepCopy(laptop)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := putBoltLaptop(tx, newLaptop); err != nil {
+			return nil, err
+		}
+
+		return &LaptopChange{Type: LaptopCreated, Laptop: newLaptop}, nil
+	})
+}
+
+// Find finds a laptop by its id
+func (store *BoltLaptopStore) Find(id string) (*pb.Laptop, error) {
+	var laptop *pb.Laptop
+
+	err := store.db.View(func(tx *bolt.Tx) error {
+		var err error
+		laptop, err = getBoltLaptop(tx, []byte(id))
+		return err
+	})
+
+	return laptop, err
+}
+
+// Update replaces an existing laptop in the store if its revision matches the stored one, stamping the new
+// revision and updated_at. A zero revision skips the check.
+func (store *BoltLaptopStore) Update(laptop *pb.Laptop) error {
+	return store.write(func(tx *bolt.Tx) (*LaptopChange, error) {
+		storedLaptop, err := getBoltLaptop(tx, []byte(laptop.GetId()))
+		if err != nil {
+			return nil, err
+		}
+
+		if storedLaptop == nil {
+			return nil, ErrRecordNotFound
+		}
+
+		if laptop.Revision != 0 && laptop.Revision != storedLaptop.Revision {
+			return nil, ErrRevisionMismatch
+		}
+
+		stampRevision(laptop, storedLaptop.Revision)
+
+		updatedLaptop, err := deepCopy(laptop)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := deleteBoltLaptop(tx, storedLaptop); err != nil {
+			return nil, err
+		}
+
+		if err := putBoltLaptop(tx, updatedLaptop); err != nil {
+			return nil, err
+		}
+
+		return &LaptopChange{Type: LaptopUpdated, Laptop: updatedLaptop, Previous: storedLaptop}, nil
+	})
+}
+
+// Delete removes a laptop from the store by its id if the revision matches the stored one. A zero revision skips
+// the check.
+func (store *BoltLaptopStore) Delete(id string, revision uint64) error {
+	return store.write(func(tx *bolt.Tx) (*LaptopChange, error) {
+		storedLaptop, err := getBoltLaptop(tx, []byte(id))
+		if err != nil {
+			return nil, err
+		}
+
+		if storedLaptop == nil {
+			return nil, ErrRecordNotFound
+		}
+
+		if revision != 0 && revision != storedLaptop.Revision {
+			return nil, ErrRevisionMismatch
+		}
+
+		if err := deleteBoltLaptop(tx, storedLaptop); err != nil {
+			return nil, err
+		}
+
+		return &LaptopChange{Type: LaptopDeleted, Laptop: storedLaptop, Previous: storedLaptop}, nil
+	})
+}
+
+// List returns up to limit laptops ordered by their id, starting after the given id
+func (store *BoltLaptopStore) List(ctx context.Context, after string, limit int) ([]*pb.Laptop, error) {
+	laptops := make([]*pb.Laptop, 0, limit)
+
+	err := store.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(boltLaptopsBucket).Cursor()
+
+		key, _ := cursor.Seek([]byte(after))
+		if key != nil && string(key) == after {
+			key, _ = cursor.Next()
+		}
+
+		for ; key != nil && len(laptops) < limit; key, _ = cursor.Next() {
+			if err := ctx.Err(); err != nil {
+				return fmt.Errorf("listing laptops context cancelled: %w", err)
+			}
+
+			laptop, err := getBoltLaptop(tx, key)
+			if err != nil {
+				return err
+			}
+
+			laptops = append(laptops, laptop)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return laptops, nil
+}
+
+// boltIndexScan iterates over the ids of an index range
+type boltIndexScan struct {
+	bucket   *bolt.Bucket
+	min, max []byte
+}
+
+// each calls fn with the id of every entry of the range until fn returns false
+func (scan boltIndexScan) each(fn func(id []byte) bool) {
+	cursor := scan.bucket.Cursor()
+
+	var key []byte
+	if scan.min != nil {
+		key, _ = cursor.Seek(scan.min)
+	} else {
+		key, _ = cursor.First()
+	}
+
+	for ; key != nil; key, _ = cursor.Next() {
+		if scan.max != nil && bytes.Compare(key[:8], scan.max) > 0 {
+			return
+		}
+
+		if !fn(key[8:]) {
+			return
+		}
+	}
+}
+
+// planBoltSearch returns the scan of the index whose range holds the fewest laptops, or nil if the filter does not
+// constrain an indexed field. Counting stops as soon as a range holds more laptops than the smallest one so far.
+func planBoltSearch(tx *bolt.Tx, filter *pb.Filter) *boltIndexScan {
+	if filter == nil {
+		return nil
+	}
+
+	var best *boltIndexScan
+	bestCount := -1
+
+	for _, r := range filterIndexRanges(filter) {
+		scan := &boltIndexScan{bucket: tx.Bucket(boltIndexBucket(r.field))}
+
+		if r.min != nil {
+			scan.min = boltSortableFloat(*r.min)
+		}
+
+		if r.max != nil {
+			scan.max = boltSortableFloat(*r.max)
+		}
+
+		count := 0
+		scan.each(func(id []byte) bool {
+			count++
+			return bestCount < 0 || count <= bestCount
+		})
+
+		if bestCount < 0 || count < bestCount {
+			best = scan
+			bestCount = count
+		}
+	}
+
+	return best
+}
+
+// searchBoltTx calls found with every laptop matching the filter, reading the candidates from the planned index scan or
+// from every stored laptop
+func searchBoltTx(ctx context.Context, tx *bolt.Tx, filter *pb.Filter, found func(laptop *pb.Laptop) error) error {
+	var err error
+
+	visit := func(id []byte) bool {
+		if err = ctx.Err(); err != nil {
+			err = fmt.Errorf("searching laptop context cancelled: %w", err)
+			return false
+		}
+
+		var laptop *pb.Laptop
+
+		laptop, err = getBoltLaptop(tx, id)
+		if err != nil || laptop == nil || !matchesFilter(filter, laptop) {
+			return err == nil
+		}
+
+		err = found(laptop)
+		return err == nil
+	}
+
+	if scan := planBoltSearch(tx, filter); scan != nil {
+		scan.each(visit)
+		return err
+	}
+
+	cursor := tx.Bucket(boltLaptopsBucket).Cursor()
+	for key, _ := cursor.First(); key != nil && visit(key); key, _ = cursor.Next() {
+	}
+
+	return err
+}
+
+// Search finds laptops by their properties using a filter, returns one by one laptop via the found function. The
+// search runs in a single read transaction, so found must not write to the store.
+func (store *BoltLaptopStore) Search(
+	ctx context.Context, filter *pb.Filter,
+	found func(laptop *pb.Laptop) error,
+) error {
+	return store.db.View(func(tx *bolt.Tx) error {
+		return searchBoltTx(ctx, tx, filter, found)
+	})
+}
+
+// boltTokenScores returns the score of a single query token for every laptop it matches, as the in-memory text index
+// scores it
+func boltTokenScores(tx *bolt.Tx, token string, documents int) map[string]float64 {
+	postings := make(map[string]map[string]int)
+	prefix := []byte(token)
+	cursor := tx.Bucket(boltTermsBucket).Cursor()
+
+	for key, value := cursor.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix); key, value = cursor.Next() {
+		separator := bytes.IndexByte(key, 0)
+		term, id := string(key[:separator]), string(key[separator+1:])
+		frequency, _ := binary.Uvarint(value)
+
+		if postings[term] == nil {
+			postings[term] = make(map[string]int)
+		}
+
+		postings[term][id] = int(frequency)
+	}
+
+	scores := make(map[string]float64)
+
+	for term, termPostings := range postings {
+		addTermScores(scores, token, term, termPostings, documents)
+	}
+
+	return scores
+}
+
+// SearchText finds laptops whose brand, name, CPU or GPU match the text and the filter, returns them most relevant
+// first with their relevance score via the found function. Like Search, found must not write to the store.
+func (store *BoltLaptopStore) SearchText(
+	ctx context.Context, text string, filter *pb.Filter, found func(laptop *pb.Laptop, score float64) error,
+) error {
+	return store.db.View(func(tx *bolt.Tx) error {
+		documents := tx.Bucket(boltLaptopsBucket).Stats().KeyN
+
+		results, err := rankText(text, func(token string) (map[string]float64, error) {
+			return boltTokenScores(tx, token, documents), nil
+		})
+
+		if err != nil {
+			return err
+		}
+
+		for _, result := range results {
+			if err := ctx.Err(); err != nil {
+				return fmt.Errorf("searching laptop text context cancelled: %w", err)
+			}
+
+			laptop, err := getBoltLaptop(tx, []byte(result.id))
+			if err != nil {
+				return err
+			}
+
+			if laptop == nil || !matchesFilter(filter, laptop) {
+				continue
+			}
+
+			if err := found(laptop, result.score); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Facets counts the laptops matching the filter per brand, CPU brand, GPU brand, screen panel, keyboard layout,
+// RAM size bucket, price bucket of the given size and release year
+func (store *BoltLaptopStore) Facets(
+	ctx context.Context, filter *pb.Filter, priceBucketUsd float64,
+) (*pb.LaptopFacets, error) {
+	counter := newFacetCounter(priceBucketUsd)
+
+	err := store.db.View(func(tx *bolt.Tx) error {
+		return searchBoltTx(ctx, tx, filter, func(laptop *pb.Laptop) error {
+			counter.add(laptop)
+			return nil
+		})
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return counter.facets(), nil
+}
+
+// Subscribe returns a subscription to the changes of the stored laptops made from now on through this store
+func (store *BoltLaptopStore) Subscribe() *LaptopSubscription {
+	return store.changes.subscribe()
+}
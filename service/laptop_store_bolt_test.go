@@ -0,0 +1,123 @@
+package service
+
+import (
+	"context"
+	"github.com/jwambugu/pcbook-grpc/factory"
+	"github.com/jwambugu/pcbook-grpc/protos/pb"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+	"google.golang.org/protobuf/proto"
+	"math"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+// openTestBolt opens a new bbolt database in a temporary directory that is closed when the test ends.
+func openTestBolt(t *testing.T) (*bolt.DB, string) {
+	path := filepath.Join(t.TempDir(), "pcbook.bolt")
+
+	db, err := OpenBolt(path)
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	return db, path
+}
+
+func TestBoltSortableFloat(t *testing.T) {
+	t.Parallel()
+
+	values := []float64{math.Inf(-1), -1000.5, -1, -0.001, 0, 0.001, 1, 2, 1000.5, math.Inf(1)}
+
+	keys := make([]string, len(values))
+	for i, value := range values {
+		keys[i] = string(boltSortableFloat(value))
+	}
+
+	require.True(t, sort.StringsAreSorted(keys))
+}
+
+func TestBoltLaptopStore(t *testing.T) {
+	t.Parallel()
+
+	db, path := openTestBolt(t)
+	store := NewBoltLaptopStore(db)
+
+	laptop := newFilterTestLaptop()
+	laptop.PriceUsd = 1500
+
+	err := store.Save(laptop)
+	require.NoError(t, err)
+	require.ErrorIs(t, store.Save(laptop), ErrRecordExists)
+
+	otherLaptop := factory.NewLaptop()
+	otherLaptop.Brand = "Dell"
+	otherLaptop.Name = "XPS 15"
+	otherLaptop.PriceUsd = 2500
+
+	err = store.Save(otherLaptop)
+	require.NoError(t, err)
+
+	// Moving the laptop in the price index leaves no stale entry behind.
+	laptop.PriceUsd = 3000
+	laptop.Name = "Thinkpad X1"
+	err = store.Update(laptop)
+	require.NoError(t, err)
+
+	searchIDS := func(filter *pb.Filter) []string {
+		var foundIDS []string
+
+		err := store.Search(context.Background(), filter, func(laptop *pb.Laptop) error {
+			foundIDS = append(foundIDS, laptop.GetId())
+			return nil
+		})
+
+		require.NoError(t, err)
+		return foundIDS
+	}
+
+	require.Empty(t, searchIDS(&pb.Filter{MaxPriceUsd: proto.Float64(2000)}))
+	require.Equal(t, []string{otherLaptop.Id}, searchIDS(&pb.Filter{MaxPriceUsd: proto.Float64(2800)}))
+	require.Equal(
+		t, []string{laptop.Id},
+		searchIDS(&pb.Filter{MinPriceUsd: proto.Float64(2800), MinReleaseYear: proto.Uint32(2000)}),
+	)
+
+	var foundNames []string
+
+	err = store.SearchText(context.Background(), "thinkpad", nil, func(laptop *pb.Laptop, score float64) error {
+		foundNames = append(foundNames, laptop.GetName())
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"Thinkpad X1"}, foundNames)
+
+	// Laptops outlive the database.
+	require.NoError(t, db.Close())
+
+	db, err = OpenBolt(path)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store = NewBoltLaptopStore(db)
+
+	foundLaptop, err := store.Find(laptop.GetId())
+	require.NoError(t, err)
+	require.True(t, proto.Equal(laptop, foundLaptop))
+
+	laptops, err := store.List(context.Background(), "", 10)
+	require.NoError(t, err)
+	require.Len(t, laptops, 2)
+
+	require.ErrorIs(t, store.Delete(laptop.GetId(), 1), ErrRevisionMismatch)
+	require.NoError(t, store.Delete(laptop.GetId(), 0))
+	require.ErrorIs(t, store.Delete(laptop.GetId(), 0), ErrRecordNotFound)
+	require.Empty(t, searchIDS(&pb.Filter{MinPriceUsd: proto.Float64(2800)}))
+
+	laptops, err = store.List(context.Background(), otherLaptop.GetId(), 10)
+	require.NoError(t, err)
+	require.Empty(t, laptops)
+}
@@ -0,0 +1,606 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/jwambugu/pcbook-grpc/protos/pb"
+	"github.com/jwambugu/pcbook-grpc/serializer"
+	"github.com/jwambugu/pcbook-grpc/units"
+	"math"
+	"strings"
+	"sync"
+)
+
+// SQLiteLaptopStore is a LaptopStore that persists laptops in a SQLite database opened with OpenSQLite. Laptops are
+// stored as protobuf bytes next to the columns their filter criteria are pushed down to.
+type SQLiteLaptopStore struct {
+	db *sql.DB
+	// mutex serializes writes so that changes are published in the order they were committed
+	mutex   sync.Mutex
+	changes *laptopBroadcaster
+}
+
+// NewSQLiteLaptopStore returns a new instance of a SQLiteLaptopStore
+func NewSQLiteLaptopStore(db *sql.DB) *SQLiteLaptopStore {
+	return &SQLiteLaptopStore{
+		db:      db,
+		changes: newLaptopBroadcaster(),
+	}
+}
+
+// sqliteQueryer is implemented by both a database and a transaction. Queries are not given the contexts of the
+// searches: the driver interrupts the connection of a query whose context is cancelled from another goroutine, which
+// races with the connection being closed. Searches check their context between queries and rows instead.
+type sqliteQueryer interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// sqliteBits converts a size in bits to a SQLite integer, saturating sizes that do not fit
+func sqliteBits(bits uint64) int64 {
+	if bits > math.MaxInt64 {
+		return math.MaxInt64
+	}
+
+	return int64(bits)
+}
+
+// sqliteBool converts a boolean to a SQLite integer
+func sqliteBool(value bool) int {
+	if value {
+		return 1
+	}
+
+	return 0
+}
+
+// findSQLiteLaptop returns the laptop with the id, or nil if there is none
+func findSQLiteLaptop(queryer sqliteQueryer, id string) (*pb.Laptop, error) {
+	var data []byte
+
+	err := queryer.QueryRow("SELECT data FROM laptops WHERE id = ?", id).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("error finding laptop: %v", err)
+	}
+
+	return unmarshalSQLiteLaptop(data)
+}
+
+func unmarshalSQLiteLaptop(data []byte) (*pb.Laptop, error) {
+	laptop := &pb.Laptop{}
+
+	if err := serializer.BinaryToProtobuf(data, laptop); err != nil {
+		return nil, fmt.Errorf("error decoding laptop: %v", err)
+	}
+
+	return laptop, nil
+}
+
+// insertSQLiteLaptop writes the laptop with its GPU, storage and text index rows
+func insertSQLiteLaptop(tx *sql.Tx, laptop *pb.Laptop) error {
+	data, err := serializer.ProtobufToBinary(laptop)
+	if err != nil {
+		return fmt.Errorf("error encoding laptop: %v", err)
+	}
+
+	screen := laptop.GetScreen()
+
+	_, err = tx.Exec(
+		`INSERT INTO laptops (
+			id, revision, data, brand_key, name_key, price_usd, cpu_cores, cpu_frequency, ram_bits, screen_size,
+			screen_width, screen_height, screen_panel, is_multi_touch, keyboard_layout, is_backlit, weight_kg,
+			release_year
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		laptop.GetId(), laptop.GetRevision(), data, strings.ToLower(laptop.GetBrand()),
+		strings.ToLower(laptop.GetName()), laptop.GetPriceUsd(), laptop.GetCpu().GetNumberOfCores(),
+		laptop.GetCpu().GetMaximumFrequency(), sqliteBits(units.MemoryBits(laptop.GetRam())),
+		float64(screen.GetSizeInches()), screen.GetResolution().GetWidth(), screen.GetResolution().GetHeight(),
+		screen.GetPanel(), sqliteBool(screen.GetIsMultiTouch()), laptop.GetKeyboard().GetLayout(),
+		sqliteBool(laptop.GetKeyboard().GetIsBacklit()), weightKg(laptop), laptop.GetReleaseYear(),
+	)
+	if err != nil {
+		return fmt.Errorf("error inserting laptop: %v", err)
+	}
+
+	for _, gpu := range laptop.GetGpus() {
+		_, err := tx.Exec(
+			"INSERT INTO laptop_gpus (laptop_id, brand_key, memory_bits) VALUES (?, ?, ?)",
+			laptop.GetId(), strings.ToLower(gpu.GetBrand()), sqliteBits(units.MemoryBits(gpu.GetMemory())),
+		)
+		if err != nil {
+			return fmt.Errorf("error inserting laptop gpu: %v", err)
+		}
+	}
+
+	for _, storage := range laptop.GetStorages() {
+		_, err := tx.Exec(
+			"INSERT INTO laptop_storages (laptop_id, driver, memory_bits) VALUES (?, ?, ?)",
+			laptop.GetId(), storage.GetDriver(), sqliteBits(units.MemoryBits(storage.GetMemory())),
+		)
+		if err != nil {
+			return fmt.Errorf("error inserting laptop storage: %v", err)
+		}
+	}
+
+	for term, frequency := range termFrequencies(laptop) {
+		_, err := tx.Exec(
+			"INSERT INTO laptop_terms (term, laptop_id, frequency) VALUES (?, ?, ?)",
+			term, laptop.GetId(), frequency,
+		)
+		if err != nil {
+			return fmt.Errorf("error inserting laptop term: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// deleteSQLiteLaptop removes the laptop with its GPU, storage and text index rows
+func deleteSQLiteLaptop(tx *sql.Tx, id string) error {
+	for _, table := range []string{"laptop_gpus", "laptop_storages", "laptop_terms"} {
+		if _, err := tx.Exec("DELETE FROM "+table+" WHERE laptop_id = ?", id); err != nil {
+			return fmt.Errorf("error deleting laptop rows from %s: %v", table, err)
+		}
+	}
+
+	if _, err := tx.Exec("DELETE FROM laptops WHERE id = ?", id); err != nil {
+		return fmt.Errorf("error deleting laptop: %v", err)
+	}
+
+	return nil
+}
+
+// write runs fn in a transaction while holding the write mutex and publishes the change it returns once committed
+func (store *SQLiteLaptopStore) write(fn func(tx *sql.Tx) (*LaptopChange, error)) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	tx, err := store.db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+
+	change, err := fn(tx)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %v", err)
+	}
+
+	store.changes.publish(*change)
+	return nil
+}
+
+// Save saves a laptop in the store, stamping its revision and updated_at
+func (store *SQLiteLaptopStore) Save(laptop *pb.Laptop) error {
+	return store.write(func(tx *sql.Tx) (*LaptopChange, error) {
+		storedLaptop, err := findSQLiteLaptop(tx, laptop.GetId())
+		if err != nil {
+			return nil, err
+		}
+
+		if storedLaptop != nil {
+			return nil, ErrRecordExists
+		}
+
+		stampRevision(laptop, 0)
+
+		newLaptop, err := deepCopy(laptop)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := insertSQLiteLaptop(tx, newLaptop); err != nil {
+			return nil, err
+		}
+
+		return &LaptopChange{Type: LaptopCreated, Laptop: newLaptop}, nil
+	})
+}
+
+// Find finds a laptop by its id
+func (store *SQLiteLaptopStore) Find(id string) (*pb.Laptop, error) {
+	return findSQLiteLaptop(store.db, id)
+}
+
+// Update replaces an existing laptop in the store if its revision matches the stored one, stamping the new
+// revision and updated_at. A zero revision skips the check.
+func (store *SQLiteLaptopStore) Update(laptop *pb.Laptop) error {
+	return store.write(func(tx *sql.Tx) (*LaptopChange, error) {
+		storedLaptop, err := findSQLiteLaptop(tx, laptop.GetId())
+		if err != nil {
+			return nil, err
+		}
+
+		if storedLaptop == nil {
+			return nil, ErrRecordNotFound
+		}
+
+		if laptop.Revision != 0 && laptop.Revision != storedLaptop.Revision {
+			return nil, ErrRevisionMismatch
+		}
+
+		stampRevision(laptop, storedLaptop.Revision)
+
+		updatedLaptop, err := deepCopy(laptop)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := deleteSQLiteLaptop(tx, laptop.GetId()); err != nil {
+			return nil, err
+		}
+
+		if err := insertSQLiteLaptop(tx, updatedLaptop); err != nil {
+			return nil, err
+		}
+
+		return &LaptopChange{Type: LaptopUpdated, Laptop: updatedLaptop, Previous: storedLaptop}, nil
+	})
+}
+
+// Delete removes a laptop from the store by its id if the revision matches the stored one. A zero revision skips
+// the check.
+func (store *SQLiteLaptopStore) Delete(id string, revision uint64) error {
+	return store.write(func(tx *sql.Tx) (*LaptopChange, error) {
+		storedLaptop, err := findSQLiteLaptop(tx, id)
+		if err != nil {
+			return nil, err
+		}
+
+		if storedLaptop == nil {
+			return nil, ErrRecordNotFound
+		}
+
+		if revision != 0 && revision != storedLaptop.Revision {
+			return nil, ErrRevisionMismatch
+		}
+
+		if err := deleteSQLiteLaptop(tx, id); err != nil {
+			return nil, err
+		}
+
+		return &LaptopChange{Type: LaptopDeleted, Laptop: storedLaptop, Previous: storedLaptop}, nil
+	})
+}
+
+// queryLaptops returns the laptops the query selects the data column of. Rows are read to the end before returning
+// so that callers can write to the store while handling the laptops.
+func (store *SQLiteLaptopStore) queryLaptops(ctx context.Context, query string, args ...interface{}) ([]*pb.Laptop, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("searching laptop context cancelled: %w", err)
+	}
+
+	rows, err := store.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying laptops: %v", err)
+	}
+
+	defer rows.Close()
+
+	var laptops []*pb.Laptop
+
+	for rows.Next() {
+		if err := ctx.Err(); err != nil {
+			return nil, fmt.Errorf("searching laptop context cancelled: %w", err)
+		}
+
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, fmt.Errorf("error reading laptop: %v", err)
+		}
+
+		laptop, err := unmarshalSQLiteLaptop(data)
+		if err != nil {
+			return nil, err
+		}
+
+		laptops = append(laptops, laptop)
+	}
+
+	if err := rows.Err(); err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, fmt.Errorf("searching laptop context cancelled: %w", ctxErr)
+		}
+
+		return nil, fmt.Errorf("error reading laptops: %v", err)
+	}
+
+	return laptops, nil
+}
+
+// List returns up to limit laptops ordered by their id, starting after the given id
+func (store *SQLiteLaptopStore) List(ctx context.Context, after string, limit int) ([]*pb.Laptop, error) {
+	laptops, err := store.queryLaptops(ctx, "SELECT data FROM laptops WHERE id > ? ORDER BY id LIMIT ?", after, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	if laptops == nil {
+		laptops = []*pb.Laptop{}
+	}
+
+	return laptops, nil
+}
+
+// sqliteFilter returns the SQL condition on the laptops table selecting the laptops that match the filter, and its
+// arguments
+func sqliteFilter(filter *pb.Filter) (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+
+	where := func(condition string, conditionArgs ...interface{}) {
+		conditions = append(conditions, condition)
+		args = append(args, conditionArgs...)
+	}
+
+	if filter == nil {
+		return "1", nil
+	}
+
+	if filter.MaxPriceUsd != nil {
+		where("price_usd <= ?", filter.GetMaxPriceUsd())
+	}
+
+	if filter.MinPriceUsd != nil {
+		where("price_usd >= ?", filter.GetMinPriceUsd())
+	}
+
+	if filter.MinCpuCores != nil {
+		where("cpu_cores >= ?", filter.GetMinCpuCores())
+	}
+
+	if filter.MinCpuFrequency != nil {
+		where("cpu_frequency >= ?", filter.GetMinCpuFrequency())
+	}
+
+	if filter.GetMinRam() != nil {
+		where("ram_bits >= ?", sqliteBits(units.MemoryBits(filter.GetMinRam())))
+	}
+
+	if filter.GetBrand() != "" {
+		where("brand_key = ?", strings.ToLower(filter.GetBrand()))
+	}
+
+	if filter.GetName() != "" {
+		where("instr(name_key, ?) > 0", strings.ToLower(filter.GetName()))
+	}
+
+	if filter.MaxWeightKg != nil {
+		where("weight_kg <= ?", filter.GetMaxWeightKg())
+	}
+
+	if filter.MinReleaseYear != nil {
+		where("release_year >= ?", filter.GetMinReleaseYear())
+	}
+
+	if filter.MaxReleaseYear != nil {
+		where("release_year <= ?", filter.GetMaxReleaseYear())
+	}
+
+	if filter.GetGpuBrand() != "" || filter.GetMinGpuMemory() != nil {
+		where(
+			`EXISTS (
+				SELECT 1 FROM laptop_gpus
+				WHERE laptop_gpus.laptop_id = laptops.id AND (? = '' OR brand_key = ?) AND memory_bits >= ?
+			)`,
+			strings.ToLower(filter.GetGpuBrand()), strings.ToLower(filter.GetGpuBrand()),
+			sqliteBits(units.MemoryBits(filter.GetMinGpuMemory())),
+		)
+	}
+
+	driver := filter.GetStorageDriver()
+
+	if driver != pb.Storage_UNKNOWN {
+		where(
+			"EXISTS (SELECT 1 FROM laptop_storages WHERE laptop_storages.laptop_id = laptops.id AND driver = ?)",
+			driver,
+		)
+	}
+
+	if filter.GetMinStorage() != nil {
+		where(
+			`(
+				SELECT COALESCE(SUM(memory_bits), 0) FROM laptop_storages
+				WHERE laptop_storages.laptop_id = laptops.id AND (? = 0 OR driver = ?)
+			) >= ?`,
+			driver, driver, sqliteBits(units.MemoryBits(filter.GetMinStorage())),
+		)
+	}
+
+	if filter.MinScreenSizeInches != nil {
+		where("screen_size >= ?", float64(filter.GetMinScreenSizeInches()))
+	}
+
+	if filter.MaxScreenSizeInches != nil {
+		where("screen_size <= ?", float64(filter.GetMaxScreenSizeInches()))
+	}
+
+	if resolution := filter.GetMinScreenResolution(); resolution != nil {
+		where("screen_width >= ? AND screen_height >= ?", resolution.GetWidth(), resolution.GetHeight())
+	}
+
+	if filter.GetScreenPanel() != pb.Screen_UNKNOWN {
+		where("screen_panel = ?", filter.GetScreenPanel())
+	}
+
+	if filter.IsMultiTouch != nil {
+		where("is_multi_touch = ?", sqliteBool(filter.GetIsMultiTouch()))
+	}
+
+	if filter.GetKeyboardLayout() != pb.Keyboard_UNKNOWN {
+		where("keyboard_layout = ?", filter.GetKeyboardLayout())
+	}
+
+	if filter.IsBacklit != nil {
+		where("is_backlit = ?", sqliteBool(filter.GetIsBacklit()))
+	}
+
+	if len(conditions) == 0 {
+		return "1", nil
+	}
+
+	return strings.Join(conditions, " AND "), args
+}
+
+// searchLaptops returns the laptops matching the filter ordered by their id. The filter is evaluated by SQLite and
+// checked again against the decoded laptops, as SQLite only folds the case of ASCII letters.
+func (store *SQLiteLaptopStore) searchLaptops(ctx context.Context, filter *pb.Filter) ([]*pb.Laptop, error) {
+	condition, args := sqliteFilter(filter)
+
+	laptops, err := store.queryLaptops(ctx, "SELECT data FROM laptops WHERE "+condition+" ORDER BY id", args...)
+	if err != nil {
+		return nil, err
+	}
+
+	matchingLaptops := laptops[:0]
+
+	for _, laptop := range laptops {
+		if matchesFilter(filter, laptop) {
+			matchingLaptops = append(matchingLaptops, laptop)
+		}
+	}
+
+	return matchingLaptops, nil
+}
+
+// Search finds laptops by their properties using a filter, returns one by one laptop via the found function. The
+// filter criteria are pushed down to SQLite and the database is not read while found runs.
+func (store *SQLiteLaptopStore) Search(
+	ctx context.Context, filter *pb.Filter,
+	found func(laptop *pb.Laptop) error,
+) error {
+	laptops, err := store.searchLaptops(ctx, filter)
+	if err != nil {
+		return err
+	}
+
+	for _, laptop := range laptops {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("searching laptop context cancelled: %w", err)
+		}
+
+		if err := found(laptop); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// tokenScores returns the score of a single query token for every laptop it matches, as the in-memory text index
+// scores it
+func (store *SQLiteLaptopStore) tokenScores(ctx context.Context, token string, documents int) (map[string]float64, error) {
+	// Terms only hold letters and digits, so no term that starts with the token sorts after token + "\xff".
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("searching laptop context cancelled: %w", err)
+	}
+
+	rows, err := store.db.Query(
+		"SELECT term, laptop_id, frequency FROM laptop_terms WHERE term >= ? AND term < ?", token, token+"\xff",
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error querying laptop terms: %v", err)
+	}
+
+	defer rows.Close()
+
+	postings := make(map[string]map[string]int)
+
+	for rows.Next() {
+		var term, id string
+		var frequency int
+
+		if err := rows.Scan(&term, &id, &frequency); err != nil {
+			return nil, fmt.Errorf("error reading laptop term: %v", err)
+		}
+
+		if postings[term] == nil {
+			postings[term] = make(map[string]int)
+		}
+
+		postings[term][id] = frequency
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading laptop terms: %v", err)
+	}
+
+	scores := make(map[string]float64)
+
+	for term, termPostings := range postings {
+		addTermScores(scores, token, term, termPostings, documents)
+	}
+
+	return scores, nil
+}
+
+// SearchText finds laptops whose brand, name, CPU or GPU match the text and the filter, returns them most relevant
+// first with their relevance score via the found function
+func (store *SQLiteLaptopStore) SearchText(
+	ctx context.Context, text string, filter *pb.Filter, found func(laptop *pb.Laptop, score float64) error,
+) error {
+	var documents int
+	if err := store.db.QueryRow("SELECT COUNT(*) FROM laptops").Scan(&documents); err != nil {
+		return fmt.Errorf("error counting laptops: %v", err)
+	}
+
+	results, err := rankText(text, func(token string) (map[string]float64, error) {
+		return store.tokenScores(ctx, token, documents)
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, result := range results {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("searching laptop text context cancelled: %w", err)
+		}
+
+		laptop, err := findSQLiteLaptop(store.db, result.id)
+		if err != nil {
+			return err
+		}
+
+		if laptop == nil || !matchesFilter(filter, laptop) {
+			continue
+		}
+
+		if err := found(laptop, result.score); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Facets counts the laptops matching the filter per brand, CPU brand, GPU brand, screen panel, keyboard layout,
+// RAM size bucket, price bucket of the given size and release year
+func (store *SQLiteLaptopStore) Facets(
+	ctx context.Context, filter *pb.Filter, priceBucketUsd float64,
+) (*pb.LaptopFacets, error) {
+	laptops, err := store.searchLaptops(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	counter := newFacetCounter(priceBucketUsd)
+
+	for _, laptop := range laptops {
+		counter.add(laptop)
+	}
+
+	return counter.facets(), nil
+}
+
+// Subscribe returns a subscription to the changes of the stored laptops made from now on through this store
+func (store *SQLiteLaptopStore) Subscribe() *LaptopSubscription {
+	return store.changes.subscribe()
+}
@@ -0,0 +1,97 @@
+package service
+
+import (
+	"context"
+	"github.com/jwambugu/pcbook-grpc/factory"
+	"github.com/jwambugu/pcbook-grpc/protos/pb"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"testing"
+)
+
+func TestSQLiteLaptopStore(t *testing.T) {
+	t.Parallel()
+
+	db, path := openTestSQLite(t)
+	store := NewSQLiteLaptopStore(db)
+	subscription := store.Subscribe()
+
+	laptop := newFilterTestLaptop()
+
+	err := store.Save(laptop)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), laptop.GetRevision())
+	require.ErrorIs(t, store.Save(laptop), ErrRecordExists)
+
+	foundLaptop, err := store.Find(laptop.GetId())
+	require.NoError(t, err)
+	require.True(t, proto.Equal(laptop, foundLaptop))
+
+	laptop.Name = "Thinkpad X1"
+	laptop.Revision = 5
+	require.ErrorIs(t, store.Update(laptop), ErrRevisionMismatch)
+
+	laptop.Revision = 1
+	err = store.Update(laptop)
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), laptop.GetRevision())
+
+	otherLaptop := factory.NewLaptop()
+	otherLaptop.Brand = "Dell"
+	otherLaptop.Name = "XPS 15"
+
+	err = store.Save(otherLaptop)
+	require.NoError(t, err)
+
+	var foundScores []float64
+
+	err = store.SearchText(context.Background(), "thinkpad x", nil, func(laptop *pb.Laptop, score float64) error {
+		foundScores = append(foundScores, score)
+		require.Equal(t, "Thinkpad X1", laptop.GetName())
+		return nil
+	})
+	require.NoError(t, err)
+	require.Len(t, foundScores, 1)
+
+	// The text index follows updates.
+	err = store.SearchText(context.Background(), "p53", nil, func(laptop *pb.Laptop, score float64) error {
+		t.Errorf("found laptop %s by its old name", laptop.GetId())
+		return nil
+	})
+	require.NoError(t, err)
+
+	facets, err := store.Facets(context.Background(), &pb.Filter{Brand: "lenovo"}, 500)
+	require.NoError(t, err)
+	require.Equal(t, uint32(1), facets.GetTotal())
+
+	for _, expectedType := range []LaptopChangeType{LaptopCreated, LaptopUpdated, LaptopCreated} {
+		change := <-subscription.Changes()
+		require.Equal(t, expectedType, change.Type)
+	}
+
+	// Laptops outlive the database connection.
+	require.NoError(t, db.Close())
+
+	db, err = OpenSQLite(path)
+	require.NoError(t, err)
+	defer db.Close()
+
+	store = NewSQLiteLaptopStore(db)
+
+	laptops, err := store.List(context.Background(), "", 10)
+	require.NoError(t, err)
+	require.Len(t, laptops, 2)
+
+	foundLaptop, err = store.Find(laptop.GetId())
+	require.NoError(t, err)
+	require.True(t, proto.Equal(laptop, foundLaptop))
+
+	require.ErrorIs(t, store.Delete(laptop.GetId(), 1), ErrRevisionMismatch)
+	require.NoError(t, store.Delete(laptop.GetId(), 2))
+	require.ErrorIs(t, store.Delete(laptop.GetId(), 0), ErrRecordNotFound)
+
+	laptops, err = store.List(context.Background(), "", 10)
+	require.NoError(t, err)
+	require.Len(t, laptops, 1)
+	require.Equal(t, otherLaptop.GetId(), laptops[0].GetId())
+}
@@ -0,0 +1,358 @@
+package service
+
+import (
+	"context"
+	"github.com/jwambugu/pcbook-grpc/factory"
+	"github.com/jwambugu/pcbook-grpc/protos/pb"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"math"
+	"testing"
+)
+
+// newFilterTestLaptop returns a laptop with fixed specs that filter test cases tweak to (not) match a criterion.
+func newFilterTestLaptop() *pb.Laptop {
+	laptop := factory.NewLaptop()
+
+	laptop.Brand = "Lenovo"
+	laptop.Name = "Thinkpad P53"
+	laptop.PriceUsd = 2000
+	laptop.Cpu.NumberOfCores = 6
+	laptop.Cpu.MaximumFrequency = 4.0
+	laptop.Ram = &pb.Memory{Value: 16, Unit: pb.Memory_GIGABYTE}
+	laptop.Gpus = []*pb.GPU{
+		{Brand: "AMD", Name: "RX 580", Memory: &pb.Memory{Value: 2, Unit: pb.Memory_GIGABYTE}},
+		{Brand: "NVIDIA", Name: "RTX 2070", Memory: &pb.Memory{Value: 8, Unit: pb.Memory_GIGABYTE}},
+	}
+	laptop.Storages = []*pb.Storage{
+		{Driver: pb.Storage_SSD, Memory: &pb.Memory{Value: 512, Unit: pb.Memory_GIGABYTE}},
+		{Driver: pb.Storage_HDD, Memory: &pb.Memory{Value: 1, Unit: pb.Memory_TERABYTE}},
+	}
+	laptop.Screen = &pb.Screen{
+		SizeInches:   15.6,
+		Resolution:   &pb.Screen_Resolution{Width: 1920, Height: 1080},
+		Panel:        pb.Screen_IPS,
+		IsMultiTouch: false,
+	}
+	laptop.Keyboard = &pb.Keyboard{Layout: pb.Keyboard_QWERTY, IsBacklit: true}
+	laptop.Weight = &pb.Laptop_WeightKg{WeightKg: 2.5}
+	laptop.ReleaseYear = 2019
+
+	return laptop
+}
+
+func TestInMemoryLaptopStore_SearchText(t *testing.T) {
+	t.Parallel()
+
+	store := NewInMemoryLaptopStore()
+
+	dell := factory.NewLaptop()
+	dell.Brand = "Dell"
+	dell.Name = "XPS 15"
+	dell.Gpus = []*pb.GPU{{Brand: "NVIDIA", Name: "GTX 1650"}}
+
+	lenovo := factory.NewLaptop()
+	lenovo.Brand = "Lenovo"
+	lenovo.Name = "Legion 5"
+	lenovo.Gpus = []*pb.GPU{{Brand: "NVIDIA", Name: "RTX 2060"}}
+
+	for _, laptop := range []*pb.Laptop{dell, lenovo} {
+		err := store.Save(laptop)
+		require.NoError(t, err)
+	}
+
+	searchText := func(text string, filter *pb.Filter) []string {
+		var foundIDS []string
+
+		err := store.SearchText(context.Background(), text, filter, func(laptop *pb.Laptop, score float64) error {
+			require.Greater(t, score, 0.0)
+			foundIDS = append(foundIDS, laptop.GetId())
+			return nil
+		})
+
+		require.NoError(t, err)
+		return foundIDS
+	}
+
+	require.Equal(t, []string{dell.GetId()}, searchText("dell nvidia", nil))
+	require.Equal(t, []string{lenovo.GetId()}, searchText("LEG rtx", nil))
+	require.Len(t, searchText("nvidia", nil), 2)
+	require.Equal(t, []string{lenovo.GetId()}, searchText("nvidia", &pb.Filter{Brand: "lenovo"}))
+	require.Empty(t, searchText("dell macbook", nil))
+	require.Empty(t, searchText("  ", nil))
+
+	// Updates and deletes keep the index in sync.
+	dell.Name = "Inspiron 15"
+	err := store.Update(dell)
+	require.NoError(t, err)
+
+	require.Empty(t, searchText("xps", nil))
+	require.Equal(t, []string{dell.GetId()}, searchText("inspiron", nil))
+
+	err = store.Delete(lenovo.GetId(), 0)
+	require.NoError(t, err)
+
+	require.Equal(t, []string{dell.GetId()}, searchText("nvidia", nil))
+	require.Empty(t, searchText("legion", nil))
+}
+
+func TestInMemoryLaptopStore_PlanSearch(t *testing.T) {
+	t.Parallel()
+
+	store := NewInMemoryLaptopStore()
+	laptops := make([]*pb.Laptop, 10)
+
+	for i := range laptops {
+		laptop := factory.NewLaptop()
+		laptop.PriceUsd = float64(1000 + i*100)
+		laptop.ReleaseYear = uint32(2010 + i%2)
+
+		err := store.Save(laptop)
+		require.NoError(t, err)
+
+		laptops[i] = laptop
+	}
+
+	// The price range holds two laptops while the release year range holds five, so the price index is picked.
+	filter := &pb.Filter{
+		MinPriceUsd:    proto.Float64(1700),
+		MaxPriceUsd:    proto.Float64(1800),
+		MinReleaseYear: proto.Uint32(2011),
+	}
+
+	require.Equal(t, []string{laptops[7].GetId(), laptops[8].GetId()}, store.planSearch(filter))
+	require.Len(t, store.planSearch(&pb.Filter{Brand: "Dell"}), 10)
+	require.Len(t, store.planSearch(nil), 10)
+
+	// Updates move laptops within the indexes and deletes drop them.
+	laptops[0].PriceUsd = 1750
+	err := store.Update(laptops[0])
+	require.NoError(t, err)
+
+	err = store.Delete(laptops[8].GetId(), 0)
+	require.NoError(t, err)
+
+	require.Equal(t, []string{laptops[7].GetId(), laptops[0].GetId()}, store.planSearch(filter))
+	require.Empty(t, store.planSearch(&pb.Filter{MaxPriceUsd: proto.Float64(1000)}))
+
+	var foundIDS []string
+
+	err = store.Search(context.Background(), filter, func(laptop *pb.Laptop) error {
+		foundIDS = append(foundIDS, laptop.GetId())
+		return nil
+	})
+
+	require.NoError(t, err)
+	require.Equal(t, []string{laptops[7].GetId()}, foundIDS)
+}
+
+func TestInMemoryLaptopStore_NaNPrice(t *testing.T) {
+	t.Parallel()
+
+	store := NewInMemoryLaptopStore()
+	laptops := make([]*pb.Laptop, 3)
+
+	for i, price := range []float64{1000, math.NaN(), 2000} {
+		laptop := factory.NewLaptop()
+		laptop.PriceUsd = price
+
+		err := store.Save(laptop)
+		require.NoError(t, err)
+
+		laptops[i] = laptop
+	}
+
+	search := func(filter *pb.Filter) []string {
+		var foundIDs []string
+
+		err := store.Search(context.Background(), filter, func(laptop *pb.Laptop) error {
+			foundIDs = append(foundIDs, laptop.GetId())
+			return nil
+		})
+		require.NoError(t, err)
+
+		return foundIDs
+	}
+
+	// A NaN price is within no price bound, but the laptop is still found without one.
+	require.Equal(t, []string{laptops[0].GetId()}, search(&pb.Filter{MaxPriceUsd: proto.Float64(1500)}))
+	require.Equal(t, []string{laptops[2].GetId()}, search(&pb.Filter{MinPriceUsd: proto.Float64(1500)}))
+	require.Len(t, search(nil), 3)
+
+	// Updating and deleting laptops with a NaN price leaves no stale entries in the indexes.
+	laptops[0].PriceUsd = math.NaN()
+	err := store.Update(laptops[0])
+	require.NoError(t, err)
+
+	require.Empty(t, search(&pb.Filter{MaxPriceUsd: proto.Float64(1500)}))
+
+	for _, laptop := range laptops[:2] {
+		err = store.Delete(laptop.GetId(), 0)
+		require.NoError(t, err)
+	}
+
+	for field, index := range store.indexes {
+		require.Len(t, index.entries, 1, field)
+		require.Equal(t, laptops[2].GetId(), index.entries[0].id, field)
+	}
+
+	require.Equal(t, []string{laptops[2].GetId()}, search(&pb.Filter{MinPriceUsd: proto.Float64(0)}))
+}
+
+func TestInMemoryLaptopStore_SearchDoesNotBlockWriters(t *testing.T) {
+	t.Parallel()
+
+	store := NewInMemoryLaptopStore()
+
+	for i := 0; i < 3; i++ {
+		err := store.Save(factory.NewLaptop())
+		require.NoError(t, err)
+	}
+
+	var foundIDS []string
+
+	// Writing to the store from the found function deadlocks if the search holds the lock while it runs.
+	err := store.Search(context.Background(), nil, func(laptop *pb.Laptop) error {
+		foundIDS = append(foundIDS, laptop.GetId())
+		return store.Delete(laptop.GetId(), 0)
+	})
+
+	require.NoError(t, err)
+	require.Len(t, foundIDS, 3)
+
+	laptops, err := store.List(context.Background(), "", 10)
+	require.NoError(t, err)
+	require.Empty(t, laptops)
+}
+
+func TestInMemoryLaptopStore_Facets(t *testing.T) {
+	t.Parallel()
+
+	store := NewInMemoryLaptopStore()
+
+	specs := []struct {
+		brand    string
+		gpus     []string
+		ramGb    uint64
+		priceUsd float64
+		year     uint32
+	}{
+		{brand: "Dell", gpus: []string{"NVIDIA"}, ramGb: 8, priceUsd: 1200, year: 2019},
+		{brand: "Dell", gpus: []string{"NVIDIA", "NVIDIA"}, ramGb: 12, priceUsd: 1499, year: 2019},
+		{brand: "Apple", gpus: []string{"AMD", "Intel"}, ramGb: 32, priceUsd: 2600, year: 2020},
+		{brand: "Lenovo", gpus: []string{"AMD"}, ramGb: 4, priceUsd: 3500, year: 2018},
+	}
+
+	for _, spec := range specs {
+		laptop := newFilterTestLaptop()
+		laptop.Brand = spec.brand
+		laptop.Ram = &pb.Memory{Value: spec.ramGb, Unit: pb.Memory_GIGABYTE}
+		laptop.PriceUsd = spec.priceUsd
+		laptop.ReleaseYear = spec.year
+		laptop.Cpu.Brand = "Intel"
+		laptop.Gpus = nil
+
+		for _, brand := range spec.gpus {
+			laptop.Gpus = append(laptop.Gpus, &pb.GPU{Brand: brand})
+		}
+
+		err := store.Save(laptop)
+		require.NoError(t, err)
+	}
+
+	facets, err := store.Facets(context.Background(), &pb.Filter{MaxPriceUsd: proto.Float64(3000)}, 500)
+	require.NoError(t, err)
+
+	expectedFacets := &pb.LaptopFacets{
+		Total: 3,
+		Brands: []*pb.FacetCount{
+			{Value: "Dell", Count: 2},
+			{Value: "Apple", Count: 1},
+		},
+		CpuBrands: []*pb.FacetCount{{Value: "Intel", Count: 3}},
+		GpuBrands: []*pb.FacetCount{
+			{Value: "NVIDIA", Count: 2},
+			{Value: "AMD", Count: 1},
+			{Value: "Intel", Count: 1},
+		},
+		ScreenPanels:    []*pb.FacetCount{{Value: "IPS", Count: 3}},
+		KeyboardLayouts: []*pb.FacetCount{{Value: "QWERTY", Count: 3}},
+		RamGb: []*pb.BucketCount{
+			{Min: 8, Max: 16, Count: 2},
+			{Min: 32, Max: 64, Count: 1},
+		},
+		PriceUsd: []*pb.BucketCount{
+			{Min: 1000, Max: 1500, Count: 2},
+			{Min: 2500, Max: 3000, Count: 1},
+		},
+		ReleaseYears: []*pb.FacetCount{
+			{Value: "2019", Count: 2},
+			{Value: "2020", Count: 1},
+		},
+	}
+
+	require.True(t, proto.Equal(expectedFacets, facets), "got facets %v", facets)
+
+	facets, err = store.Facets(context.Background(), &pb.Filter{Brand: "Asus"}, 500)
+	require.NoError(t, err)
+	require.True(t, proto.Equal(&pb.LaptopFacets{}, facets), "got facets %v", facets)
+}
+
+func TestInMemoryLaptopStore_Subscribe(t *testing.T) {
+	t.Parallel()
+
+	store := NewInMemoryLaptopStore()
+	subscription := store.Subscribe()
+
+	laptop := factory.NewLaptop()
+
+	err := store.Save(laptop)
+	require.NoError(t, err)
+
+	err = store.Update(laptop)
+	require.NoError(t, err)
+
+	err = store.Delete(laptop.GetId(), 0)
+	require.NoError(t, err)
+
+	for _, expectedType := range []LaptopChangeType{LaptopCreated, LaptopUpdated, LaptopDeleted} {
+		change := <-subscription.Changes()
+		require.Equal(t, expectedType, change.Type)
+		require.Equal(t, laptop.GetId(), change.Laptop.GetId())
+	}
+
+	subscription.Close()
+
+	_, ok := <-subscription.Changes()
+	require.False(t, ok)
+	require.NoError(t, subscription.Err())
+}
+
+func TestInMemoryLaptopStore_SubscribeSlowSubscriber(t *testing.T) {
+	t.Parallel()
+
+	store := NewInMemoryLaptopStore()
+	slowSubscription := store.Subscribe()
+	subscription := store.Subscribe()
+
+	// Saving more laptops than a subscription buffers must not block on the subscriber that never reads.
+	for i := 0; i <= laptopChangesBufferSize; i++ {
+		err := store.Save(factory.NewLaptop())
+		require.NoError(t, err)
+
+		if i < laptopChangesBufferSize {
+			<-subscription.Changes()
+		}
+	}
+
+	for range slowSubscription.Changes() {
+	}
+
+	require.ErrorIs(t, slowSubscription.Err(), ErrSubscriberTooSlow)
+
+	change := <-subscription.Changes()
+	require.Equal(t, LaptopCreated, change.Type)
+	require.NoError(t, subscription.Err())
+
+	subscription.Close()
+}
@@ -0,0 +1,21 @@
+package service
+
+import (
+	"encoding/base64"
+	"fmt"
+)
+
+// encodePageToken returns an opaque page token that resumes a listing after the given laptop id.
+func encodePageToken(lastID string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(lastID))
+}
+
+// decodePageToken returns the laptop id a page token resumes after. An empty token starts from the first laptop.
+func decodePageToken(token string) (string, error) {
+	lastID, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return "", fmt.Errorf("invalid page token: %v", err)
+	}
+
+	return string(lastID), nil
+}
@@ -1,11 +1,16 @@
 package service
 
-import "sync"
+import (
+	"github.com/jwambugu/pcbook-grpc/protos/pb"
+	"sync"
+)
 
 // RatingStore is an interface for storing and retrieving laptop ratings.
 type RatingStore interface {
-	// Add adds a new laptop to the store.
-	Add(laptopID string, score float64) (*Rating, error)
+	// Rate sets the score a user rated a laptop, replacing the score the user rated the laptop before.
+	Rate(laptopID, username string, score float64) (*Rating, error)
+	// Find returns the rating of a laptop, or nil if the laptop has not been rated.
+	Find(laptopID string) (*Rating, error)
 }
 
 // Rating contains the rating information for a given laptop.
@@ -14,35 +19,116 @@ type Rating struct {
 	Sum   float64
 }
 
+// Average returns the average score of the rating.
+func (rating *Rating) Average() float64 {
+	if rating == nil || rating.Count == 0 {
+		return 0
+	}
+
+	return rating.Sum / float64(rating.Count)
+}
+
 // InMemoryRatingStore stores laptop ratings in memory.
 type InMemoryRatingStore struct {
 	mutext  sync.RWMutex
 	ratings map[string]*Rating
+	// scores maps laptop ids to the score of each user who rated the laptop
+	scores map[string]map[string]float64
 }
 
 // NewInMemoryRatingStore creates a new InMemoryRatingStore.
 func NewInMemoryRatingStore() *InMemoryRatingStore {
 	return &InMemoryRatingStore{
 		ratings: make(map[string]*Rating),
+		scores:  make(map[string]map[string]float64),
 	}
 }
 
-// Add adds a new laptop to the store.
-func (store *InMemoryRatingStore) Add(laptopID string, score float64) (*Rating, error) {
+// Rate sets the score a user rated a laptop, replacing the score the user rated the laptop before.
+func (store *InMemoryRatingStore) Rate(laptopID, username string, score float64) (*Rating, error) {
 	store.mutext.Lock()
 	defer store.mutext.Unlock()
 
-	rating := store.ratings[laptopID]
-	if rating == nil {
-		rating = &Rating{
-			Count: 1,
-			Sum:   score,
-		}
-	} else {
+	scores := store.scores[laptopID]
+	if scores == nil {
+		scores = make(map[string]float64)
+		store.scores[laptopID] = scores
+	}
+
+	scores[username] = score
+
+	rating := store.sum(laptopID)
+
+	return &Rating{
+		Count: rating.Count,
+		Sum:   rating.Sum,
+	}, nil
+}
+
+// sum sums the scores of the users who rated a laptop into its rating. Summing the scores again rather than adding the
+// difference a replaced score makes keeps the sum from drifting as users rate the laptop again.
+func (store *InMemoryRatingStore) sum(laptopID string) *Rating {
+	rating := &Rating{}
+
+	for _, score := range store.scores[laptopID] {
 		rating.Count++
 		rating.Sum += score
 	}
 
 	store.ratings[laptopID] = rating
-	return rating, nil
+
+	return rating
+}
+
+// Find returns the rating of a laptop, or nil if the laptop has not been rated.
+func (store *InMemoryRatingStore) Find(laptopID string) (*Rating, error) {
+	store.mutext.RLock()
+	defer store.mutext.RUnlock()
+
+	rating := store.ratings[laptopID]
+	if rating == nil {
+		return nil, nil
+	}
+
+	return &Rating{
+		Count: rating.Count,
+		Sum:   rating.Sum,
+	}, nil
+}
+
+// all returns the ratings of all rated laptops
+func (store *InMemoryRatingStore) all() []*pb.StoredRating {
+	store.mutext.RLock()
+	defer store.mutext.RUnlock()
+
+	ratings := make([]*pb.StoredRating, 0, len(store.scores))
+
+	for laptopID, scores := range store.scores {
+		storedRating := &pb.StoredRating{
+			LaptopId: laptopID,
+			Scores:   make(map[string]float64, len(scores)),
+		}
+
+		for username, score := range scores {
+			storedRating.Scores[username] = score
+		}
+
+		ratings = append(ratings, storedRating)
+	}
+
+	return ratings
+}
+
+// restore stores the rating of a laptop as it is, replacing any stored rating of the laptop
+func (store *InMemoryRatingStore) restore(rating *pb.StoredRating) {
+	store.mutext.Lock()
+	defer store.mutext.Unlock()
+
+	scores := make(map[string]float64, len(rating.GetScores()))
+	for username, score := range rating.GetScores() {
+		scores[username] = score
+	}
+
+	store.scores[rating.GetLaptopId()] = scores
+	store.sum(rating.GetLaptopId())
 }
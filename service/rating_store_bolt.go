@@ -0,0 +1,86 @@
+package service
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	bolt "go.etcd.io/bbolt"
+	"math"
+)
+
+// BoltRatingStore stores laptop ratings in a bbolt database opened with OpenBolt.
+type BoltRatingStore struct {
+	db *bolt.DB
+}
+
+// NewBoltRatingStore creates a new BoltRatingStore.
+func NewBoltRatingStore(db *bolt.DB) *BoltRatingStore {
+	return &BoltRatingStore{
+		db: db,
+	}
+}
+
+// Rate sets the score a user rated a laptop, replacing the score the user rated the laptop before. The rating is
+// summed from the scores in the same transaction as the score of the user is stored.
+func (store *BoltRatingStore) Rate(laptopID, username string, score float64) (*Rating, error) {
+	var rating *Rating
+
+	err := store.db.Update(func(tx *bolt.Tx) error {
+		value := make([]byte, 8)
+		binary.BigEndian.PutUint64(value, math.Float64bits(score))
+
+		if err := tx.Bucket(boltRatingsBucket).Put(boltRatingKey(laptopID, username), value); err != nil {
+			return err
+		}
+
+		var err error
+		rating, err = sumBoltRating(tx, laptopID)
+		return err
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("error rating laptop: %v", err)
+	}
+
+	return rating, nil
+}
+
+// sumBoltRating sums the scores of the users who rated a laptop into its rating, or returns nil if the laptop has
+// not been rated
+func sumBoltRating(tx *bolt.Tx, laptopID string) (*Rating, error) {
+	rating := &Rating{}
+	prefix := boltRatingKey(laptopID, "")
+
+	cursor := tx.Bucket(boltRatingsBucket).Cursor()
+	for key, value := cursor.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix); key, value = cursor.Next() {
+		if len(value) != 8 {
+			return nil, fmt.Errorf("invalid score of %d bytes", len(value))
+		}
+
+		rating.Count++
+		rating.Sum += math.Float64frombits(binary.BigEndian.Uint64(value))
+	}
+
+	if rating.Count == 0 {
+		return nil, nil
+	}
+
+	return rating, nil
+}
+
+// Find returns the rating of a laptop, or nil if the laptop has not been rated.
+func (store *BoltRatingStore) Find(laptopID string) (*Rating, error) {
+	var rating *Rating
+
+	err := store.db.View(func(tx *bolt.Tx) error {
+		var err error
+		rating, err = sumBoltRating(tx, laptopID)
+		return err
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("error finding rating: %v", err)
+	}
+
+	return rating, nil
+}
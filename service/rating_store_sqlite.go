@@ -0,0 +1,83 @@
+package service
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// SQLiteRatingStore stores laptop ratings in a SQLite database opened with OpenSQLite.
+type SQLiteRatingStore struct {
+	db *sql.DB
+}
+
+// NewSQLiteRatingStore creates a new SQLiteRatingStore.
+func NewSQLiteRatingStore(db *sql.DB) *SQLiteRatingStore {
+	return &SQLiteRatingStore{
+		db: db,
+	}
+}
+
+// Rate sets the score a user rated a laptop, replacing the score the user rated the laptop before. The rating is
+// summed from the scores in the same transaction as the score of the user is stored.
+func (store *SQLiteRatingStore) Rate(laptopID, username string, score float64) (*Rating, error) {
+	tx, err := store.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %v", err)
+	}
+
+	rating, err := rateSQLiteLaptop(tx, laptopID, username, score)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, fmt.Errorf("error rating laptop: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing transaction: %v", err)
+	}
+
+	return rating, nil
+}
+
+// rateSQLiteLaptop stores the score of the user and sums the rating of the laptop again
+func rateSQLiteLaptop(tx *sql.Tx, laptopID, username string, score float64) (*Rating, error) {
+	_, err := tx.Exec(
+		`INSERT INTO ratings (laptop_id, username, score) VALUES (?, ?, ?)
+		ON CONFLICT (laptop_id, username) DO UPDATE SET score = excluded.score`,
+		laptopID, username, score,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return sumSQLiteRating(tx, laptopID)
+}
+
+// sumSQLiteRating sums the scores of the users who rated a laptop into its rating, or returns nil if the laptop has
+// not been rated
+func sumSQLiteRating(q sqliteQueryer, laptopID string) (*Rating, error) {
+	rating := &Rating{}
+
+	err := q.QueryRow("SELECT COUNT(*), TOTAL(score) FROM ratings WHERE laptop_id = ?", laptopID).
+		Scan(&rating.Count, &rating.Sum)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if rating.Count == 0 {
+		return nil, nil
+	}
+
+	return rating, nil
+}
+
+// Find returns the rating of a laptop, or nil if the laptop has not been rated.
+func (store *SQLiteRatingStore) Find(laptopID string) (*Rating, error) {
+	rating, err := sumSQLiteRating(store.db, laptopID)
+	if err != nil {
+		return nil, fmt.Errorf("error finding rating: %v", err)
+	}
+
+	return rating, nil
+}
@@ -0,0 +1,446 @@
+package service
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// s3DateFormat is the format of the x-amz-date header
+	s3DateFormat = "20060102T150405Z"
+	// s3ListPageSize is the number of keys asked for in each page of a listing
+	s3ListPageSize = 1000
+)
+
+// s3Error is an error returned by an S3-compatible API
+type s3Error struct {
+	StatusCode int
+	Code       string `xml:"Code"`
+	Message    string `xml:"Message"`
+}
+
+// Error returns the code and message of the error.
+func (err *s3Error) Error() string {
+	return fmt.Sprintf("s3 error %d %s: %s", err.StatusCode, err.Code, err.Message)
+}
+
+// s3Part is a part of a multipart upload
+type s3Part struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+// s3Client calls the object API of an S3-compatible service for the objects of a bucket, addressing the bucket in
+// the path of the endpoint and signing the requests with AWS Signature Version 4.
+type s3Client struct {
+	httpClient      *http.Client
+	endpoint        *url.URL
+	region          string
+	bucket          string
+	accessKeyID     string
+	secretAccessKey string
+	sessionToken    string
+	now             func() time.Time
+}
+
+// putObject stores the data as the object with the key
+func (client *s3Client) putObject(key string, data []byte) error {
+	res, err := client.do(http.MethodPut, key, nil, nil, data)
+	if err != nil {
+		return err
+	}
+
+	return res.Body.Close()
+}
+
+// getObject returns a reader of the data of the object with the key, or ErrRecordNotFound if there is none
+func (client *s3Client) getObject(key string) (io.ReadCloser, error) {
+	res, err := client.do(http.MethodGet, key, nil, nil, nil)
+	if isS3NotFound(err) {
+		return nil, fmt.Errorf("%w: object %s", ErrRecordNotFound, key)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return res.Body, nil
+}
+
+// readObject returns the data of the object with the key, or ErrRecordNotFound if there is none
+func (client *s3Client) readObject(key string) ([]byte, error) {
+	body, err := client.getObject(key)
+	if err != nil {
+		return nil, err
+	}
+
+	defer body.Close()
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading object %s: %v", key, err)
+	}
+
+	return data, nil
+}
+
+// objectExists tells whether there is an object with the key
+func (client *s3Client) objectExists(key string) (bool, error) {
+	res, err := client.do(http.MethodHead, key, nil, nil, nil)
+	if isS3NotFound(err) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	return true, res.Body.Close()
+}
+
+// deleteObject removes the object with the key, deleting a missing object succeeds
+func (client *s3Client) deleteObject(key string) error {
+	res, err := client.do(http.MethodDelete, key, nil, nil, nil)
+	if isS3NotFound(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return res.Body.Close()
+}
+
+// copyObject copies the object with the source key to the destination key
+func (client *s3Client) copyObject(sourceKey, destinationKey string) error {
+	header := http.Header{}
+	header.Set("X-Amz-Copy-Source", "/"+client.bucket+"/"+s3Escape(sourceKey, false))
+
+	var result struct{}
+
+	// A copy can fail after the response status was sent, the error is then its body.
+	return client.doXML(http.MethodPut, destinationKey, nil, header, nil, &result)
+}
+
+// listObjects returns the keys of the objects starting with the prefix in lexical order
+func (client *s3Client) listObjects(prefix string) ([]string, error) {
+	var keys []string
+	var continuationToken string
+
+	for {
+		query := url.Values{}
+		query.Set("list-type", "2")
+		query.Set("prefix", prefix)
+		query.Set("max-keys", strconv.Itoa(s3ListPageSize))
+
+		if continuationToken != "" {
+			query.Set("continuation-token", continuationToken)
+		}
+
+		var result struct {
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+			Contents              []struct {
+				Key string `xml:"Key"`
+			} `xml:"Contents"`
+		}
+
+		if err := client.doXML(http.MethodGet, "", query, nil, nil, &result); err != nil {
+			return nil, err
+		}
+
+		for _, object := range result.Contents {
+			keys = append(keys, object.Key)
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return keys, nil
+		}
+
+		continuationToken = result.NextContinuationToken
+	}
+}
+
+// createMultipartUpload starts a multipart upload of the object with the key and returns its id
+func (client *s3Client) createMultipartUpload(key string) (string, error) {
+	query := url.Values{}
+	query.Set("uploads", "")
+
+	var result struct {
+		UploadID string `xml:"UploadId"`
+	}
+
+	if err := client.doXML(http.MethodPost, key, query, nil, nil, &result); err != nil {
+		return "", err
+	}
+
+	if result.UploadID == "" {
+		return "", fmt.Errorf("error starting multipart upload of %s: no upload id returned", key)
+	}
+
+	return result.UploadID, nil
+}
+
+// uploadPart uploads a part of a multipart upload and returns the part to complete the upload with
+func (client *s3Client) uploadPart(key, uploadID string, partNumber int, data []byte) (s3Part, error) {
+	query := url.Values{}
+	query.Set("partNumber", strconv.Itoa(partNumber))
+	query.Set("uploadId", uploadID)
+
+	res, err := client.do(http.MethodPut, key, query, nil, data)
+	if err != nil {
+		return s3Part{}, err
+	}
+
+	_ = res.Body.Close()
+
+	etag := res.Header.Get("ETag")
+	if etag == "" {
+		return s3Part{}, fmt.Errorf("error uploading part %d of %s: no ETag returned", partNumber, key)
+	}
+
+	return s3Part{PartNumber: partNumber, ETag: etag}, nil
+}
+
+// completeMultipartUpload assembles the uploaded parts into the object
+func (client *s3Client) completeMultipartUpload(key, uploadID string, parts []s3Part) error {
+	query := url.Values{}
+	query.Set("uploadId", uploadID)
+
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []s3Part `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return fmt.Errorf("error completing multipart upload of %s: %v", key, err)
+	}
+
+	var result struct{}
+
+	// Completing can fail after the response status was sent, the error is then its body.
+	return client.doXML(http.MethodPost, key, query, nil, body, &result)
+}
+
+// abortMultipartUpload discards a multipart upload and its parts
+func (client *s3Client) abortMultipartUpload(key, uploadID string) error {
+	query := url.Values{}
+	query.Set("uploadId", uploadID)
+
+	res, err := client.do(http.MethodDelete, key, query, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	return res.Body.Close()
+}
+
+// doXML sends a request and decodes its XML response into result, failing if the response is an error document
+func (client *s3Client) doXML(
+	method, key string, query url.Values, header http.Header, body []byte, result interface{},
+) error {
+	res, err := client.do(method, key, query, header, body)
+	if err != nil {
+		return err
+	}
+
+	defer res.Body.Close()
+
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return fmt.Errorf("error reading %s %s response: %v", method, key, err)
+	}
+
+	var root struct {
+		XMLName xml.Name
+	}
+
+	if err := xml.Unmarshal(data, &root); err == nil && root.XMLName.Local == "Error" {
+		return s3ResponseError(res.StatusCode, data)
+	}
+
+	if err := xml.Unmarshal(data, result); err != nil {
+		return fmt.Errorf("error decoding %s %s response: %v", method, key, err)
+	}
+
+	return nil
+}
+
+// do sends a signed request for the object with the key, or for the bucket if the key is empty. Responses with an
+// error status are returned as an *s3Error, other responses are returned with their body open.
+func (client *s3Client) do(
+	method, key string, query url.Values, header http.Header, body []byte,
+) (*http.Response, error) {
+	objectPath := "/" + client.bucket + "/" + key
+
+	requestURL := *client.endpoint
+	requestURL.Path = strings.TrimSuffix(client.endpoint.Path, "/") + objectPath
+	requestURL.RawPath = strings.TrimSuffix(client.endpoint.EscapedPath(), "/") + s3Escape(objectPath, false)
+	requestURL.RawQuery = s3CanonicalQuery(query)
+
+	req, err := http.NewRequest(method, requestURL.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("error creating %s %s request: %v", method, key, err)
+	}
+
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	if client.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", client.sessionToken)
+	}
+
+	payloadHash := sha256.Sum256(body)
+	signS3Request(req, hex.EncodeToString(payloadHash[:]), client.accessKeyID, client.secretAccessKey, client.region,
+		client.now())
+
+	res, err := client.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error sending %s %s request: %v", method, key, err)
+	}
+
+	if res.StatusCode >= http.StatusMultipleChoices {
+		defer res.Body.Close()
+
+		data, _ := io.ReadAll(res.Body)
+		return nil, s3ResponseError(res.StatusCode, data)
+	}
+
+	return res, nil
+}
+
+// s3ResponseError returns the error described by an error response
+func s3ResponseError(statusCode int, body []byte) error {
+	err := &s3Error{StatusCode: statusCode}
+
+	// Responses to HEAD requests have no body, the status tells the error.
+	if xml.Unmarshal(body, err) != nil || err.Code == "" {
+		err.Code = http.StatusText(statusCode)
+	}
+
+	return err
+}
+
+// isS3NotFound tells whether the error is the response to a request for a missing object
+func isS3NotFound(err error) bool {
+	s3Err, ok := err.(*s3Error)
+	return ok && s3Err.StatusCode == http.StatusNotFound
+}
+
+// signS3Request sets the x-amz-date, x-amz-content-sha256 and Authorization headers of the request as AWS Signature
+// Version 4 asks for. Every header set before is signed along with the host.
+func signS3Request(req *http.Request, payloadHash, accessKeyID, secretAccessKey, region string, now time.Time) {
+	amzDate := now.UTC().Format(s3DateFormat)
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", amzDate[:8], region)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+
+	headers := map[string]string{"host": host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	canonicalRequestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hex.EncodeToString(canonicalRequestHash[:]),
+	}, "\n")
+
+	signingKey := []byte("AWS4" + secretAccessKey)
+	for _, part := range []string{amzDate[:8], region, "s3", "aws4_request"} {
+		signingKey = hmacSHA256(signingKey, part)
+	}
+
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKeyID, scope, signedHeaders, signature,
+	))
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of the data with the key
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write([]byte(data))
+
+	return mac.Sum(nil)
+}
+
+// s3CanonicalQuery returns the query string sorted and escaped as AWS Signature Version 4 asks for
+func s3CanonicalQuery(query url.Values) string {
+	params := make([]string, 0, len(query))
+
+	for name, values := range query {
+		for _, value := range values {
+			params = append(params, s3Escape(name, true)+"="+s3Escape(value, true))
+		}
+	}
+
+	sort.Strings(params)
+
+	return strings.Join(params, "&")
+}
+
+// s3Escape percent-encodes every byte of s but the unreserved characters, and the slashes unless escapeSlash is set
+func s3Escape(s string, escapeSlash bool) string {
+	var escaped strings.Builder
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '.', c == '_', c == '~':
+			escaped.WriteByte(c)
+		case c == '/' && !escapeSlash:
+			escaped.WriteByte(c)
+		default:
+			fmt.Fprintf(&escaped, "%%%02X", c)
+		}
+	}
+
+	return escaped.String()
+}
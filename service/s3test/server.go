@@ -0,0 +1,381 @@
+// Package s3test provides an in-process fake of the object API of S3 to test S3 clients against.
+package s3test
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// minPartSize is the smallest size S3 accepts for the parts of a multipart upload but the last one
+const minPartSize = 5 << 20
+
+type (
+	// Server is a fake S3 bucket served over HTTP in path-style, keeping its objects in memory. It supports the
+	// object, ListObjectsV2 and multipart upload requests, and rejects requests not signed with its access key.
+	Server struct {
+		*httptest.Server
+
+		bucket      string
+		accessKeyID string
+
+		mutex            sync.Mutex
+		objects          map[string][]byte
+		uploads          map[string]*upload
+		nextUploadID     int
+		completedUploads int
+		maxKeys          int
+	}
+
+	// upload is a multipart upload in progress
+	upload struct {
+		key   string
+		parts map[int][]byte
+	}
+
+	// errorResponse is the body of error responses
+	errorResponse struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string   `xml:"Code"`
+		Message string   `xml:"Message"`
+	}
+)
+
+// NewServer starts a new Server of an empty bucket accepting requests signed with the access key, closing it when
+// the test ends.
+func NewServer(t *testing.T, bucket, accessKeyID string) *Server {
+	server := &Server{
+		bucket:      bucket,
+		accessKeyID: accessKeyID,
+		objects:     make(map[string][]byte),
+		uploads:     make(map[string]*upload),
+		maxKeys:     1000,
+	}
+
+	server.Server = httptest.NewServer(http.HandlerFunc(server.serveHTTP))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+// SetMaxKeys sets the number of keys listed at most in a page, regardless of the number asked for.
+func (server *Server) SetMaxKeys(maxKeys int) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	server.maxKeys = maxKeys
+}
+
+// Keys returns the keys of the objects of the bucket in lexical order.
+func (server *Server) Keys() []string {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	keys := make([]string, 0, len(server.objects))
+	for key := range server.objects {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+// Object returns the data of the object with the key, or nil if there is none.
+func (server *Server) Object(key string) []byte {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	return server.objects[key]
+}
+
+// Uploads returns the number of multipart uploads in progress.
+func (server *Server) Uploads() int {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	return len(server.uploads)
+}
+
+// CompletedUploads returns the number of multipart uploads completed.
+func (server *Server) CompletedUploads() int {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	return server.completedUploads
+}
+
+// serveHTTP handles a request for the bucket or one of its objects
+func (server *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "IncompleteBody", err.Error())
+		return
+	}
+
+	credential := "AWS4-HMAC-SHA256 Credential=" + server.accessKeyID + "/"
+	if !strings.HasPrefix(r.Header.Get("Authorization"), credential) {
+		writeError(w, http.StatusForbidden, "AccessDenied", "the request is not signed with a known access key")
+		return
+	}
+
+	payloadHash := sha256.Sum256(body)
+	if r.Header.Get("X-Amz-Content-Sha256") != hex.EncodeToString(payloadHash[:]) {
+		writeError(w, http.StatusBadRequest, "XAmzContentSHA256Mismatch", "the payload hash does not match the body")
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	bucket, key := path, ""
+
+	if i := strings.Index(path, "/"); i >= 0 {
+		bucket, key = path[:i], path[i+1:]
+	}
+
+	if bucket != server.bucket {
+		writeError(w, http.StatusNotFound, "NoSuchBucket", "the bucket does not exist")
+		return
+	}
+
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	query := r.URL.Query()
+
+	switch {
+	case key == "" && r.Method == http.MethodGet:
+		server.list(w, query.Get("prefix"), query.Get("continuation-token"))
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		server.createUpload(w, key)
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		server.completeUpload(w, key, query.Get("uploadId"), body)
+	case r.Method == http.MethodPut && query.Has("uploadId"):
+		server.uploadPart(w, key, query.Get("uploadId"), query.Get("partNumber"), body)
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		server.abortUpload(w, key, query.Get("uploadId"))
+	case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+		server.copyObject(w, key, r.Header.Get("X-Amz-Copy-Source"))
+	case r.Method == http.MethodPut:
+		server.objects[key] = body
+		w.Header().Set("ETag", etag(body))
+	case r.Method == http.MethodGet, r.Method == http.MethodHead:
+		data, ok := server.objects[key]
+		if !ok {
+			writeError(w, http.StatusNotFound, "NoSuchKey", "the object does not exist")
+			return
+		}
+
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.Header().Set("ETag", etag(data))
+
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(data)
+		}
+	case r.Method == http.MethodDelete:
+		delete(server.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusNotImplemented, "NotImplemented", "the request is not supported")
+	}
+}
+
+// list lists the keys starting with the prefix after the continuation token, which is the last key listed before
+func (server *Server) list(w http.ResponseWriter, prefix, continuationToken string) {
+	var keys []string
+
+	for key := range server.objects {
+		if strings.HasPrefix(key, prefix) && key > continuationToken {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Strings(keys)
+
+	result := struct {
+		XMLName               xml.Name `xml:"ListBucketResult"`
+		Name                  string   `xml:"Name"`
+		Prefix                string   `xml:"Prefix"`
+		KeyCount              int      `xml:"KeyCount"`
+		IsTruncated           bool     `xml:"IsTruncated"`
+		NextContinuationToken string   `xml:"NextContinuationToken,omitempty"`
+		Contents              []struct {
+			Key  string `xml:"Key"`
+			Size int    `xml:"Size"`
+		} `xml:"Contents"`
+	}{
+		Name:   server.bucket,
+		Prefix: prefix,
+	}
+
+	if len(keys) > server.maxKeys {
+		keys = keys[:server.maxKeys]
+		result.IsTruncated = true
+		result.NextContinuationToken = keys[len(keys)-1]
+	}
+
+	result.KeyCount = len(keys)
+
+	for _, key := range keys {
+		result.Contents = append(result.Contents, struct {
+			Key  string `xml:"Key"`
+			Size int    `xml:"Size"`
+		}{Key: key, Size: len(server.objects[key])})
+	}
+
+	writeXML(w, result)
+}
+
+// createUpload starts a multipart upload of the object with the key
+func (server *Server) createUpload(w http.ResponseWriter, key string) {
+	server.nextUploadID++
+	uploadID := fmt.Sprintf("upload-%d", server.nextUploadID)
+
+	server.uploads[uploadID] = &upload{key: key, parts: make(map[int][]byte)}
+
+	writeXML(w, struct {
+		XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+		Bucket   string   `xml:"Bucket"`
+		Key      string   `xml:"Key"`
+		UploadID string   `xml:"UploadId"`
+	}{Bucket: server.bucket, Key: key, UploadID: uploadID})
+}
+
+// uploadPart stores a part of a multipart upload
+func (server *Server) uploadPart(w http.ResponseWriter, key, uploadID, partNumber string, data []byte) {
+	upload := server.uploads[uploadID]
+	if upload == nil || upload.key != key {
+		writeError(w, http.StatusNotFound, "NoSuchUpload", "the upload does not exist")
+		return
+	}
+
+	number, err := strconv.Atoi(partNumber)
+	if err != nil || number < 1 {
+		writeError(w, http.StatusBadRequest, "InvalidArgument", "the part number is not a positive integer")
+		return
+	}
+
+	upload.parts[number] = data
+	w.Header().Set("ETag", etag(data))
+}
+
+// completeUpload assembles the parts listed in the body into the object of the upload
+func (server *Server) completeUpload(w http.ResponseWriter, key, uploadID string, body []byte) {
+	upload := server.uploads[uploadID]
+	if upload == nil || upload.key != key {
+		writeError(w, http.StatusNotFound, "NoSuchUpload", "the upload does not exist")
+		return
+	}
+
+	var request struct {
+		Parts []struct {
+			PartNumber int    `xml:"PartNumber"`
+			ETag       string `xml:"ETag"`
+		} `xml:"Part"`
+	}
+
+	if err := xml.Unmarshal(body, &request); err != nil || len(request.Parts) == 0 {
+		writeError(w, http.StatusBadRequest, "MalformedXML", "the list of parts is not valid")
+		return
+	}
+
+	var data bytes.Buffer
+
+	for i, part := range request.Parts {
+		partData, ok := upload.parts[part.PartNumber]
+		if !ok || part.ETag != etag(partData) {
+			writeError(w, http.StatusBadRequest, "InvalidPart", fmt.Sprintf("part %d was not uploaded", part.PartNumber))
+			return
+		}
+
+		if i > 0 && part.PartNumber <= request.Parts[i-1].PartNumber {
+			writeError(w, http.StatusBadRequest, "InvalidPartOrder", "the parts are not in ascending order")
+			return
+		}
+
+		if i < len(request.Parts)-1 && len(partData) < minPartSize {
+			writeError(w, http.StatusBadRequest, "EntityTooSmall", fmt.Sprintf("part %d is too small", part.PartNumber))
+			return
+		}
+
+		data.Write(partData)
+	}
+
+	server.objects[key] = data.Bytes()
+	delete(server.uploads, uploadID)
+	server.completedUploads++
+
+	writeXML(w, struct {
+		XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+		Bucket  string   `xml:"Bucket"`
+		Key     string   `xml:"Key"`
+		ETag    string   `xml:"ETag"`
+	}{Bucket: server.bucket, Key: key, ETag: etag(data.Bytes())})
+}
+
+// abortUpload discards a multipart upload
+func (server *Server) abortUpload(w http.ResponseWriter, key, uploadID string) {
+	upload := server.uploads[uploadID]
+	if upload == nil || upload.key != key {
+		writeError(w, http.StatusNotFound, "NoSuchUpload", "the upload does not exist")
+		return
+	}
+
+	delete(server.uploads, uploadID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// copyObject copies the object named by the copy source, /<bucket>/<key>, to the key
+func (server *Server) copyObject(w http.ResponseWriter, key, copySource string) {
+	sourceKey := strings.TrimPrefix(copySource, "/"+server.bucket+"/")
+
+	data, ok := server.objects[sourceKey]
+	if !ok {
+		writeError(w, http.StatusNotFound, "NoSuchKey", "the source object does not exist")
+		return
+	}
+
+	server.objects[key] = data
+
+	writeXML(w, struct {
+		XMLName xml.Name `xml:"CopyObjectResult"`
+		ETag    string   `xml:"ETag"`
+	}{ETag: etag(data)})
+}
+
+// etag returns the quoted MD5 checksum of the data, as S3 tags objects that were not uploaded in parts
+func etag(data []byte) string {
+	checksum := md5.Sum(data)
+	return `"` + hex.EncodeToString(checksum[:]) + `"`
+}
+
+// writeXML writes the XML encoded response
+func writeXML(w http.ResponseWriter, response interface{}) {
+	data, err := xml.Marshal(response)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	_, _ = w.Write(append([]byte(xml.Header), data...))
+}
+
+// writeError writes an error response
+func writeError(w http.ResponseWriter, statusCode int, code, message string) {
+	data, _ := xml.Marshal(errorResponse{Code: code, Message: message})
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(statusCode)
+	_, _ = w.Write(append([]byte(xml.Header), data...))
+}
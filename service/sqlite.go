@@ -0,0 +1,134 @@
+package service
+
+import (
+	"database/sql"
+	"fmt"
+	_ "modernc.org/sqlite"
+	"os"
+	"path/filepath"
+)
+
+// sqliteMigrations holds the schema changes of the SQLite database in the order they are applied. The schema version
+// is the number of migrations applied, kept in the user_version pragma. Applied migrations must never change.
+var sqliteMigrations = []string{
+	`CREATE TABLE laptops (
+		id              TEXT PRIMARY KEY,
+		revision        INTEGER NOT NULL,
+		data            BLOB NOT NULL,
+		brand_key       TEXT NOT NULL,
+		name_key        TEXT NOT NULL,
+		price_usd       REAL NOT NULL,
+		cpu_cores       INTEGER NOT NULL,
+		cpu_frequency   REAL NOT NULL,
+		ram_bits        INTEGER NOT NULL,
+		screen_size     REAL NOT NULL,
+		screen_width    INTEGER NOT NULL,
+		screen_height   INTEGER NOT NULL,
+		screen_panel    INTEGER NOT NULL,
+		is_multi_touch  INTEGER NOT NULL,
+		keyboard_layout INTEGER NOT NULL,
+		is_backlit      INTEGER NOT NULL,
+		weight_kg       REAL NOT NULL,
+		release_year    INTEGER NOT NULL
+	);
+	CREATE INDEX laptops_price_usd ON laptops (price_usd);
+	CREATE INDEX laptops_cpu_cores ON laptops (cpu_cores);
+	CREATE INDEX laptops_cpu_frequency ON laptops (cpu_frequency);
+	CREATE INDEX laptops_ram_bits ON laptops (ram_bits);
+	CREATE INDEX laptops_release_year ON laptops (release_year);
+	CREATE INDEX laptops_brand_key ON laptops (brand_key);
+
+	CREATE TABLE laptop_gpus (
+		laptop_id   TEXT NOT NULL REFERENCES laptops (id) ON DELETE CASCADE,
+		brand_key   TEXT NOT NULL,
+		memory_bits INTEGER NOT NULL
+	);
+	CREATE INDEX laptop_gpus_laptop_id ON laptop_gpus (laptop_id);
+
+	CREATE TABLE laptop_storages (
+		laptop_id   TEXT NOT NULL REFERENCES laptops (id) ON DELETE CASCADE,
+		driver      INTEGER NOT NULL,
+		memory_bits INTEGER NOT NULL
+	);
+	CREATE INDEX laptop_storages_laptop_id ON laptop_storages (laptop_id);
+
+	CREATE TABLE laptop_terms (
+		term      TEXT NOT NULL,
+		laptop_id TEXT NOT NULL REFERENCES laptops (id) ON DELETE CASCADE,
+		frequency INTEGER NOT NULL,
+		PRIMARY KEY (term, laptop_id)
+	);
+	CREATE INDEX laptop_terms_laptop_id ON laptop_terms (laptop_id);`,
+
+	`CREATE TABLE ratings (
+		laptop_id TEXT NOT NULL,
+		username  TEXT NOT NULL,
+		score     REAL NOT NULL,
+		PRIMARY KEY (laptop_id, username)
+	);`,
+
+	`CREATE TABLE users (
+		username        TEXT PRIMARY KEY,
+		hashed_password TEXT NOT NULL,
+		role            TEXT NOT NULL
+	);`,
+}
+
+// OpenSQLite opens the SQLite database at the path, creating it if needed, and migrates it to the latest schema
+func OpenSQLite(path string) (*sql.DB, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("error creating sqlite database directory: %v", err)
+	}
+
+	db, err := sql.Open("sqlite", fmt.Sprintf("file:%s?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)", path))
+	if err != nil {
+		return nil, fmt.Errorf("error opening sqlite database: %v", err)
+	}
+
+	// SQLite allows a single writer, sharing one connection avoids busy errors between the stores' transactions.
+	db.SetMaxOpenConns(1)
+
+	if err := migrateSQLite(db); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// migrateSQLite applies the migrations the database has not applied yet, each in its own transaction
+func migrateSQLite(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return fmt.Errorf("error reading sqlite schema version: %v", err)
+	}
+
+	if version > len(sqliteMigrations) {
+		return fmt.Errorf("sqlite schema version %d is newer than the latest known version %d", version,
+			len(sqliteMigrations))
+	}
+
+	for ; version < len(sqliteMigrations); version++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return fmt.Errorf("error starting sqlite migration %d: %v", version+1, err)
+		}
+
+		if _, err := tx.Exec(sqliteMigrations[version]); err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("error applying sqlite migration %d: %v", version+1, err)
+		}
+
+		// The pragma does not accept bound parameters.
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", version+1)); err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("error applying sqlite migration %d: %v", version+1, err)
+		}
+
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("error committing sqlite migration %d: %v", version+1, err)
+		}
+	}
+
+	return nil
+}
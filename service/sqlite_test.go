@@ -0,0 +1,39 @@
+package service
+
+import (
+	"database/sql"
+	"github.com/stretchr/testify/require"
+	"path/filepath"
+	"testing"
+)
+
+// openTestSQLite opens a new SQLite database in a temporary directory that is closed when the test ends.
+func openTestSQLite(t *testing.T) (*sql.DB, string) {
+	path := filepath.Join(t.TempDir(), "pcbook.db")
+
+	db, err := OpenSQLite(path)
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	return db, path
+}
+
+func TestOpenSQLite(t *testing.T) {
+	t.Parallel()
+
+	db, path := openTestSQLite(t)
+
+	var version int
+	err := db.QueryRow("PRAGMA user_version").Scan(&version)
+	require.NoError(t, err)
+	require.Equal(t, len(sqliteMigrations), version)
+	require.NoError(t, db.Close())
+
+	// Reopening a migrated database applies no migration twice.
+	db, err = OpenSQLite(path)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+}
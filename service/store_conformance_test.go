@@ -0,0 +1,190 @@
+package service_test
+
+import (
+	"database/sql"
+	"github.com/jwambugu/pcbook-grpc/service"
+	"github.com/jwambugu/pcbook-grpc/service/s3test"
+	"github.com/jwambugu/pcbook-grpc/service/storetest"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+	"path/filepath"
+	"testing"
+)
+
+// openSQLite opens a new SQLite database in a temporary directory that is closed when the test ends
+func openSQLite(t *testing.T) *sql.DB {
+	db, err := service.OpenSQLite(filepath.Join(t.TempDir(), "pcbook.db"))
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	return db
+}
+
+// openBolt opens a new bbolt database in a temporary directory that is closed when the test ends
+func openBolt(t *testing.T) *bolt.DB {
+	db, err := service.OpenBolt(filepath.Join(t.TempDir(), "pcbook.bolt"))
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	return db
+}
+
+// openDiskImageStore opens a new DiskImageStore in a temporary directory
+func openDiskImageStore(t *testing.T) *service.DiskImageStore {
+	store, err := service.OpenDiskImageStore(t.TempDir())
+	require.NoError(t, err)
+
+	return store
+}
+
+// openS3ImageStore opens a new S3ImageStore of a bucket of a fake S3 server, listing keys in pages of maxKeys
+func openS3ImageStore(t *testing.T, partSize int64, maxKeys int) *service.S3ImageStore {
+	server := s3test.NewServer(t, "pcbook", "access-key")
+	server.SetMaxKeys(maxKeys)
+
+	store, err := service.NewS3ImageStore(service.S3Config{
+		Endpoint:        server.URL,
+		Bucket:          "pcbook",
+		Prefix:          "images/",
+		AccessKeyID:     "access-key",
+		SecretAccessKey: "secret-key",
+		PartSize:        partSize,
+	})
+	require.NoError(t, err)
+
+	return store
+}
+
+// openWAL opens a new write-ahead log over new in-memory stores in a temporary directory that is closed when the test
+// ends
+func openWAL(t *testing.T) *service.WAL {
+	wal, err := service.OpenWAL(t.TempDir(), service.WALStores{
+		Laptops: service.NewInMemoryLaptopStore(),
+		Ratings: service.NewInMemoryRatingStore(),
+		Users:   service.NewInMemoryUserStore(),
+		Images:  openDiskImageStore(t),
+	}, service.WALOptions{Sync: service.WALSyncNever})
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		_ = wal.Close()
+	})
+
+	return wal
+}
+
+func TestLaptopStores(t *testing.T) {
+	t.Parallel()
+
+	factories := map[string]storetest.LaptopStoreFactory{
+		"InMemory": func(t *testing.T) service.LaptopStore {
+			return service.NewInMemoryLaptopStore()
+		},
+		"SQLite": func(t *testing.T) service.LaptopStore {
+			return service.NewSQLiteLaptopStore(openSQLite(t))
+		},
+		"Bolt": func(t *testing.T) service.LaptopStore {
+			return service.NewBoltLaptopStore(openBolt(t))
+		},
+		"WAL": func(t *testing.T) service.LaptopStore {
+			return openWAL(t).LaptopStore()
+		},
+	}
+
+	for name, newStore := range factories {
+		newStore := newStore
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			storetest.RunLaptopStoreTests(t, newStore)
+		})
+	}
+}
+
+func TestRatingStores(t *testing.T) {
+	t.Parallel()
+
+	factories := map[string]storetest.RatingStoreFactory{
+		"InMemory": func(t *testing.T) service.RatingStore {
+			return service.NewInMemoryRatingStore()
+		},
+		"SQLite": func(t *testing.T) service.RatingStore {
+			return service.NewSQLiteRatingStore(openSQLite(t))
+		},
+		"Bolt": func(t *testing.T) service.RatingStore {
+			return service.NewBoltRatingStore(openBolt(t))
+		},
+		"WAL": func(t *testing.T) service.RatingStore {
+			return openWAL(t).RatingStore()
+		},
+	}
+
+	for name, newStore := range factories {
+		newStore := newStore
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			storetest.RunRatingStoreTests(t, newStore)
+		})
+	}
+}
+
+func TestUserStores(t *testing.T) {
+	t.Parallel()
+
+	factories := map[string]storetest.UserStoreFactory{
+		"InMemory": func(t *testing.T) service.UserStore {
+			return service.NewInMemoryUserStore()
+		},
+		"SQLite": func(t *testing.T) service.UserStore {
+			return service.NewSQLiteUserStore(openSQLite(t))
+		},
+		"WAL": func(t *testing.T) service.UserStore {
+			return openWAL(t).UserStore()
+		},
+	}
+
+	for name, newStore := range factories {
+		newStore := newStore
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			storetest.RunUserStoreTests(t, newStore)
+		})
+	}
+}
+
+func TestImageStores(t *testing.T) {
+	t.Parallel()
+
+	factories := map[string]storetest.ImageStoreFactory{
+		"Disk": func(t *testing.T) service.ImageStore {
+			return openDiskImageStore(t)
+		},
+		"WAL": func(t *testing.T) service.ImageStore {
+			return openWAL(t).ImageStore()
+		},
+		"S3": func(t *testing.T) service.ImageStore {
+			return openS3ImageStore(t, service.DefaultS3PartSize, 1000)
+		},
+		// Every image is listed in pages.
+		"S3Paged": func(t *testing.T) service.ImageStore {
+			return openS3ImageStore(t, service.DefaultS3PartSize, 1)
+		},
+	}
+
+	for name, newStore := range factories {
+		newStore := newStore
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			storetest.RunImageStoreTests(t, newStore)
+		})
+	}
+}
@@ -0,0 +1,412 @@
+package storetest
+
+import (
+	"bytes"
+	"encoding/base64"
+	"github.com/jwambugu/pcbook-grpc/factory"
+	"github.com/jwambugu/pcbook-grpc/service"
+	"github.com/stretchr/testify/require"
+	"io"
+	"sort"
+	"sync"
+	"testing"
+)
+
+// sampleWebP is a lossless 1x1 WebP image
+const sampleWebP = "UklGRhoAAABXRUJQVlA4TA0AAAAvAAAAEAcQERGIiP4HAA=="
+
+// ImageStoreFactory returns a new empty store for a test, cleaning it up when the test ends
+type ImageStoreFactory func(t *testing.T) service.ImageStore
+
+// RunImageStoreTests checks that the stores returned by newStore behave as a service.ImageStore
+func RunImageStoreTests(t *testing.T, newStore ImageStoreFactory) {
+	t.Run("SaveFindOpenDelete", func(t *testing.T) {
+		t.Parallel()
+
+		store := newStore(t)
+
+		image := factory.NewImage(".png", 3, 2)
+
+		imageID, err := service.SaveImage(store, "laptop", ".png", bytes.NewReader(image))
+		require.NoError(t, err)
+
+		info, err := store.Find(imageID)
+		require.NoError(t, err)
+		require.Equal(t, imageID, info.ID)
+		require.Equal(t, "laptop", info.LaptopID)
+		require.Equal(t, ".png", info.Extension)
+		require.Equal(t, int64(len(image)), info.Size)
+		require.Equal(t, 3, info.Width)
+		require.Equal(t, 2, info.Height)
+
+		// Changing the found info does not change the stored one.
+		info.LaptopID = "changed"
+
+		info, imageData, err := store.Open(imageID)
+		require.NoError(t, err)
+		require.Equal(t, "laptop", info.LaptopID)
+
+		data, err := io.ReadAll(imageData)
+		require.NoError(t, err)
+		require.NoError(t, imageData.Close())
+		require.Equal(t, image, data)
+
+		err = store.Delete(imageID)
+		require.NoError(t, err)
+
+		info, err = store.Find(imageID)
+		require.NoError(t, err)
+		require.Nil(t, info)
+
+		_, _, err = store.Open(imageID)
+		require.ErrorIs(t, err, service.ErrRecordNotFound)
+		require.ErrorIs(t, store.Delete(imageID), service.ErrRecordNotFound)
+	})
+
+	t.Run("Validate", func(t *testing.T) {
+		t.Parallel()
+
+		webp, err := base64.StdEncoding.DecodeString(sampleWebP)
+		require.NoError(t, err)
+
+		jpeg := factory.NewImage(".jpg", 4, 3)
+
+		testCases := []struct {
+			name              string
+			extension         string
+			data              []byte
+			expectedExtension string
+			expectedWidth     int
+			expectedHeight    int
+			expectedErr       error
+		}{
+			{
+				name:              "jpeg",
+				extension:         ".JPEG",
+				data:              jpeg,
+				expectedExtension: ".jpg",
+				expectedWidth:     4,
+				expectedHeight:    3,
+			},
+			{
+				name:              "png",
+				extension:         ".png",
+				data:              factory.NewImage(".png", 5, 7),
+				expectedExtension: ".png",
+				expectedWidth:     5,
+				expectedHeight:    7,
+			},
+			{
+				name:              "gif",
+				extension:         ".gif",
+				data:              factory.NewImage(".gif", 2, 9),
+				expectedExtension: ".gif",
+				expectedWidth:     2,
+				expectedHeight:    9,
+			},
+			{
+				name:              "webp",
+				extension:         ".webp",
+				data:              webp,
+				expectedExtension: ".webp",
+				expectedWidth:     1,
+				expectedHeight:    1,
+			},
+			{
+				name:              "derived extension",
+				data:              jpeg,
+				expectedExtension: ".jpg",
+				expectedWidth:     4,
+				expectedHeight:    3,
+			},
+			{
+				name:        "extension mismatch",
+				extension:   ".png",
+				data:        jpeg,
+				expectedErr: service.ErrInvalidImage,
+			},
+			{
+				name:        "unsupported extension",
+				extension:   ".exe",
+				data:        jpeg,
+				expectedErr: service.ErrInvalidImage,
+			},
+			{
+				name:        "path extension",
+				extension:   "/../../etc/passwd",
+				data:        jpeg,
+				expectedErr: service.ErrInvalidImage,
+			},
+			{
+				name:        "not an image",
+				extension:   ".jpg",
+				data:        []byte("#!/bin/sh\necho not an image\n"),
+				expectedErr: service.ErrInvalidImage,
+			},
+			{
+				name:        "too short",
+				data:        []byte("GIF"),
+				expectedErr: service.ErrInvalidImage,
+			},
+			{
+				name:        "truncated header",
+				extension:   ".png",
+				data:        factory.NewImage(".png", 1, 1)[:20],
+				expectedErr: service.ErrInvalidImage,
+			},
+		}
+
+		for _, tc := range testCases {
+			tc := tc
+
+			t.Run(tc.name, func(t *testing.T) {
+				t.Parallel()
+
+				store := newStore(t)
+
+				imageID, err := service.SaveImage(store, "laptop", tc.extension, bytes.NewReader(tc.data))
+				if tc.expectedErr != nil {
+					require.ErrorIs(t, err, tc.expectedErr)
+
+					images, err := store.List("laptop")
+					require.NoError(t, err)
+					require.Empty(t, images)
+
+					return
+				}
+
+				require.NoError(t, err)
+
+				info, err := store.Find(imageID)
+				require.NoError(t, err)
+				require.Equal(t, tc.expectedExtension, info.Extension)
+				require.Equal(t, tc.expectedWidth, info.Width)
+				require.Equal(t, tc.expectedHeight, info.Height)
+			})
+		}
+	})
+
+	t.Run("Abort", func(t *testing.T) {
+		t.Parallel()
+
+		store := newStore(t)
+
+		imageWriter, err := store.Create("laptop", ".jpg")
+		require.NoError(t, err)
+
+		image := factory.NewImage(".jpg", 1, 1)
+
+		_, err = imageWriter.Write(image)
+		require.NoError(t, err)
+		require.NoError(t, imageWriter.Abort())
+
+		_, err = imageWriter.Write(image)
+		require.ErrorIs(t, err, service.ErrImageWriterClosed)
+
+		_, err = imageWriter.Commit()
+		require.ErrorIs(t, err, service.ErrImageWriterClosed)
+
+		images, err := store.List("laptop")
+		require.NoError(t, err)
+		require.Empty(t, images)
+
+		// Aborting a committed image keeps it.
+		imageWriter, err = store.Create("laptop", ".jpg")
+		require.NoError(t, err)
+
+		_, err = imageWriter.Write(image)
+		require.NoError(t, err)
+
+		imageID, err := imageWriter.Commit()
+		require.NoError(t, err)
+		require.NoError(t, imageWriter.Abort())
+
+		info, err := store.Find(imageID)
+		require.NoError(t, err)
+		require.NotNil(t, info)
+		require.Equal(t, int64(len(image)), info.Size)
+	})
+
+	t.Run("List", func(t *testing.T) {
+		t.Parallel()
+
+		store := newStore(t)
+
+		var imageIDS []string
+
+		for _, laptopID := range []string{"laptop", "other-laptop", "laptop"} {
+			imageID, err := service.SaveImage(store, laptopID, ".jpg", bytes.NewReader(factory.NewImage(".jpg", 1, 1)))
+			require.NoError(t, err)
+
+			if laptopID == "laptop" {
+				imageIDS = append(imageIDS, imageID)
+			}
+		}
+
+		sort.Strings(imageIDS)
+
+		images, err := store.List("laptop")
+		require.NoError(t, err)
+		require.Len(t, images, len(imageIDS))
+
+		for i, info := range images {
+			require.Equal(t, imageIDS[i], info.ID)
+			require.Equal(t, "laptop", info.LaptopID)
+		}
+
+		images, err = store.List("unknown")
+		require.NoError(t, err)
+		require.Empty(t, images)
+	})
+
+	t.Run("Deduplicate", func(t *testing.T) {
+		t.Parallel()
+
+		store := newStore(t)
+		image := factory.NewImage(".gif", 3, 3)
+
+		var imageIDS []string
+
+		for i, laptopID := range []string{"laptop", "other-laptop"} {
+			imageWriter, err := store.Create(laptopID, ".gif")
+			require.NoError(t, err)
+
+			_, err = imageWriter.Write(image)
+			require.NoError(t, err)
+
+			imageID, err := imageWriter.Commit()
+			require.NoError(t, err)
+			require.Equal(t, i > 0, imageWriter.Deduplicated())
+
+			info, err := store.Find(imageID)
+			require.NoError(t, err)
+			require.Equal(t, laptopID, info.LaptopID)
+
+			imageIDS = append(imageIDS, imageID)
+		}
+
+		require.NotEqual(t, imageIDS[0], imageIDS[1])
+
+		// Deleting an image keeps the data of the other image sharing it.
+		require.NoError(t, store.Delete(imageIDS[0]))
+
+		_, imageData, err := store.Open(imageIDS[1])
+		require.NoError(t, err)
+
+		data, err := io.ReadAll(imageData)
+		require.NoError(t, err)
+		require.NoError(t, imageData.Close())
+		require.Equal(t, image, data)
+
+		require.NoError(t, store.Delete(imageIDS[1]))
+
+		_, _, err = store.Open(imageIDS[1])
+		require.ErrorIs(t, err, service.ErrRecordNotFound)
+	})
+
+	t.Run("Renditions", func(t *testing.T) {
+		t.Parallel()
+
+		store := newStore(t)
+
+		sourceID, err := service.SaveImage(store, "laptop", ".jpg", bytes.NewReader(factory.NewImage(".jpg", 8, 6)))
+		require.NoError(t, err)
+
+		imageWriter, err := store.CreateRendition(sourceID, "small", ".png")
+		require.NoError(t, err)
+
+		_, err = imageWriter.Write(factory.NewImage(".png", 4, 3))
+		require.NoError(t, err)
+
+		renditionID, err := imageWriter.Commit()
+		require.NoError(t, err)
+		require.NotEqual(t, sourceID, renditionID)
+
+		info, err := store.Find(renditionID)
+		require.NoError(t, err)
+		require.Equal(t, "laptop", info.LaptopID)
+		require.Equal(t, sourceID, info.SourceID)
+		require.Equal(t, "small", info.Rendition)
+		require.Equal(t, ".png", info.Extension)
+		require.Equal(t, 4, info.Width)
+		require.Equal(t, 3, info.Height)
+
+		images, err := store.List("laptop")
+		require.NoError(t, err)
+		require.Len(t, images, 2)
+
+		require.NoError(t, store.SetRenditionError(sourceID, "failed to resize"))
+
+		info, err = store.Find(sourceID)
+		require.NoError(t, err)
+		require.Equal(t, "failed to resize", info.RenditionError)
+		require.ErrorIs(t, store.SetRenditionError("unknown", "failed"), service.ErrRecordNotFound)
+
+		_, err = store.CreateRendition("unknown", "small", ".png")
+		require.ErrorIs(t, err, service.ErrRecordNotFound)
+
+		// A rendition of an image deleted while it is written is not stored.
+		imageWriter, err = store.CreateRendition(sourceID, "medium", ".png")
+		require.NoError(t, err)
+
+		_, err = imageWriter.Write(factory.NewImage(".png", 8, 6))
+		require.NoError(t, err)
+
+		// Deleting an image deletes its renditions.
+		require.NoError(t, store.Delete(sourceID))
+
+		_, err = imageWriter.Commit()
+		require.ErrorIs(t, err, service.ErrRecordNotFound)
+
+		images, err = store.List("laptop")
+		require.NoError(t, err)
+		require.Empty(t, images)
+	})
+
+	t.Run("ConcurrentWriters", func(t *testing.T) {
+		t.Parallel()
+
+		const writers = 8
+
+		store := newStore(t)
+		image := factory.NewImage(".gif", 2, 2)
+
+		var wg sync.WaitGroup
+
+		imageIDS := make(chan string, writers)
+		errs := make(chan error, writers)
+
+		for i := 0; i < writers; i++ {
+			wg.Add(1)
+
+			go func() {
+				defer wg.Done()
+
+				imageID, err := service.SaveImage(store, "laptop", ".gif", bytes.NewReader(image))
+				if err != nil {
+					errs <- err
+					return
+				}
+
+				imageIDS <- imageID
+			}()
+		}
+
+		wg.Wait()
+		close(errs)
+		close(imageIDS)
+
+		for err := range errs {
+			require.NoError(t, err)
+		}
+
+		// Every image gets its own id.
+		uniqueIDS := make(map[string]struct{})
+		for imageID := range imageIDS {
+			require.NotEmpty(t, imageID)
+			uniqueIDS[imageID] = struct{}{}
+		}
+
+		require.Len(t, uniqueIDS, writers)
+	})
+}
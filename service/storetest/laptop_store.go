@@ -0,0 +1,631 @@
+// Package storetest provides the tests every implementation of the stores of the service package must pass.
+package storetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jwambugu/pcbook-grpc/factory"
+	"github.com/jwambugu/pcbook-grpc/protos/pb"
+	"github.com/jwambugu/pcbook-grpc/service"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"sync"
+	"testing"
+)
+
+// LaptopStoreFactory returns a new empty store for a test, cleaning it up when the test ends
+type LaptopStoreFactory func(t *testing.T) service.LaptopStore
+
+// RunLaptopStoreTests checks that the stores returned by newStore behave as a service.LaptopStore
+func RunLaptopStoreTests(t *testing.T, newStore LaptopStoreFactory) {
+	t.Run("SaveDuplicateID", func(t *testing.T) {
+		t.Parallel()
+		testSaveDuplicateID(t, newStore(t))
+	})
+
+	t.Run("CopyIsolation", func(t *testing.T) {
+		t.Parallel()
+		testCopyIsolation(t, newStore(t))
+	})
+
+	t.Run("UpdateRevision", func(t *testing.T) {
+		t.Parallel()
+		testUpdateRevision(t, newStore(t))
+	})
+
+	t.Run("DeleteRevision", func(t *testing.T) {
+		t.Parallel()
+		testDeleteRevision(t, newStore(t))
+	})
+
+	t.Run("List", func(t *testing.T) {
+		t.Parallel()
+		testList(t, newStore(t))
+	})
+
+	t.Run("SearchFilter", func(t *testing.T) {
+		t.Parallel()
+		testSearchFilter(t, newStore)
+	})
+
+	t.Run("SearchEmptyFilter", func(t *testing.T) {
+		t.Parallel()
+		testSearchEmptyFilter(t, newStore(t))
+	})
+
+	t.Run("SearchContextCancelled", func(t *testing.T) {
+		t.Parallel()
+		testSearchContextCancelled(t, newStore(t))
+	})
+
+	t.Run("SearchFoundError", func(t *testing.T) {
+		t.Parallel()
+		testSearchFoundError(t, newStore(t))
+	})
+
+	t.Run("Subscribe", func(t *testing.T) {
+		t.Parallel()
+		testSubscribe(t, newStore(t))
+	})
+
+	t.Run("ConcurrentWriters", func(t *testing.T) {
+		t.Parallel()
+		testConcurrentWriters(t, newStore(t))
+	})
+}
+
+// newFilterLaptop returns a laptop with fixed specs that filter test cases tweak to (not) match a criterion
+func newFilterLaptop() *pb.Laptop {
+	laptop := factory.NewLaptop()
+
+	laptop.Brand = "Lenovo"
+	laptop.Name = "Thinkpad P53"
+	laptop.PriceUsd = 2000
+	laptop.Cpu.NumberOfCores = 6
+	laptop.Cpu.MaximumFrequency = 4.0
+	laptop.Ram = &pb.Memory{Value: 16, Unit: pb.Memory_GIGABYTE}
+	laptop.Gpus = []*pb.GPU{
+		{Brand: "AMD", Name: "RX 580", Memory: &pb.Memory{Value: 2, Unit: pb.Memory_GIGABYTE}},
+		{Brand: "NVIDIA", Name: "RTX 2070", Memory: &pb.Memory{Value: 8, Unit: pb.Memory_GIGABYTE}},
+	}
+	laptop.Storages = []*pb.Storage{
+		{Driver: pb.Storage_SSD, Memory: &pb.Memory{Value: 512, Unit: pb.Memory_GIGABYTE}},
+		{Driver: pb.Storage_HDD, Memory: &pb.Memory{Value: 1, Unit: pb.Memory_TERABYTE}},
+	}
+	laptop.Screen = &pb.Screen{
+		SizeInches:   15.6,
+		Resolution:   &pb.Screen_Resolution{Width: 1920, Height: 1080},
+		Panel:        pb.Screen_IPS,
+		IsMultiTouch: false,
+	}
+	laptop.Keyboard = &pb.Keyboard{Layout: pb.Keyboard_QWERTY, IsBacklit: true}
+	laptop.Weight = &pb.Laptop_WeightKg{WeightKg: 2.5}
+	laptop.ReleaseYear = 2019
+
+	return laptop
+}
+
+// saveLaptops saves n new laptops in the store and returns them
+func saveLaptops(t *testing.T, store service.LaptopStore, n int) []*pb.Laptop {
+	laptops := make([]*pb.Laptop, n)
+
+	for i := range laptops {
+		laptops[i] = factory.NewLaptop()
+
+		err := store.Save(laptops[i])
+		require.NoError(t, err)
+	}
+
+	return laptops
+}
+
+// requireStoredLaptop checks that the store holds the expected laptop
+func requireStoredLaptop(t *testing.T, store service.LaptopStore, expected *pb.Laptop) {
+	foundLaptop, err := store.Find(expected.GetId())
+	require.NoError(t, err)
+	require.NotNil(t, foundLaptop)
+	require.True(t, proto.Equal(expected, foundLaptop), "expected %v, found %v", expected, foundLaptop)
+}
+
+func testSaveDuplicateID(t *testing.T, store service.LaptopStore) {
+	laptop := factory.NewLaptop()
+
+	err := store.Save(laptop)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), laptop.GetRevision())
+	require.NotNil(t, laptop.GetUpdatedAt())
+
+	duplicateLaptop := factory.NewLaptop()
+	duplicateLaptop.Id = laptop.GetId()
+
+	err = store.Save(duplicateLaptop)
+	require.ErrorIs(t, err, service.ErrRecordExists)
+
+	requireStoredLaptop(t, store, laptop)
+
+	foundLaptop, err := store.Find("unknown")
+	require.NoError(t, err)
+	require.Nil(t, foundLaptop)
+}
+
+func testCopyIsolation(t *testing.T, store service.LaptopStore) {
+	laptop := newFilterLaptop()
+
+	err := store.Save(laptop)
+	require.NoError(t, err)
+
+	expectedLaptop := proto.Clone(laptop).(*pb.Laptop)
+
+	// Changing the saved laptop does not change the stored one.
+	laptop.Name = "Changed"
+	laptop.Gpus[0].Brand = "Changed"
+	requireStoredLaptop(t, store, expectedLaptop)
+
+	// Neither does changing a found, listed or searched laptop.
+	foundLaptop, err := store.Find(laptop.GetId())
+	require.NoError(t, err)
+
+	foundLaptop.Name = "Changed"
+	foundLaptop.Storages[0].Memory.Value = 1
+	requireStoredLaptop(t, store, expectedLaptop)
+
+	laptops, err := store.List(context.Background(), "", 10)
+	require.NoError(t, err)
+	require.Len(t, laptops, 1)
+
+	laptops[0].Name = "Changed"
+	laptops[0].Ram.Value = 1
+	requireStoredLaptop(t, store, expectedLaptop)
+
+	err = store.Search(context.Background(), nil, func(laptop *pb.Laptop) error {
+		laptop.Name = "Changed"
+		laptop.Screen.Panel = pb.Screen_OLED
+		return nil
+	})
+	require.NoError(t, err)
+	requireStoredLaptop(t, store, expectedLaptop)
+
+	// Changing an updated laptop after the update does not change the stored one either.
+	laptop = proto.Clone(expectedLaptop).(*pb.Laptop)
+	laptop.Name = "Thinkpad X1"
+
+	err = store.Update(laptop)
+	require.NoError(t, err)
+
+	expectedLaptop = proto.Clone(laptop).(*pb.Laptop)
+
+	laptop.Keyboard.Layout = pb.Keyboard_AZERTY
+	requireStoredLaptop(t, store, expectedLaptop)
+}
+
+func testUpdateRevision(t *testing.T, store service.LaptopStore) {
+	laptop := factory.NewLaptop()
+
+	err := store.Update(laptop)
+	require.ErrorIs(t, err, service.ErrRecordNotFound)
+
+	err = store.Save(laptop)
+	require.NoError(t, err)
+
+	laptop.Name = "Thinkpad X1"
+
+	err = store.Update(laptop)
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), laptop.GetRevision())
+
+	staleLaptop := proto.Clone(laptop).(*pb.Laptop)
+	staleLaptop.Revision = 1
+	staleLaptop.Name = "Stale"
+
+	err = store.Update(staleLaptop)
+	require.ErrorIs(t, err, service.ErrRevisionMismatch)
+	requireStoredLaptop(t, store, laptop)
+
+	// A zero revision skips the check.
+	laptop.Revision = 0
+	laptop.Name = "Thinkpad X1 Carbon"
+
+	err = store.Update(laptop)
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), laptop.GetRevision())
+	requireStoredLaptop(t, store, laptop)
+}
+
+func testDeleteRevision(t *testing.T, store service.LaptopStore) {
+	laptops := saveLaptops(t, store, 2)
+
+	err := store.Delete("unknown", 0)
+	require.ErrorIs(t, err, service.ErrRecordNotFound)
+
+	err = store.Delete(laptops[0].GetId(), 2)
+	require.ErrorIs(t, err, service.ErrRevisionMismatch)
+	requireStoredLaptop(t, store, laptops[0])
+
+	err = store.Delete(laptops[0].GetId(), 1)
+	require.NoError(t, err)
+
+	err = store.Delete(laptops[1].GetId(), 0)
+	require.NoError(t, err)
+
+	for _, laptop := range laptops {
+		foundLaptop, err := store.Find(laptop.GetId())
+		require.NoError(t, err)
+		require.Nil(t, foundLaptop)
+
+		err = store.Delete(laptop.GetId(), 0)
+		require.ErrorIs(t, err, service.ErrRecordNotFound)
+	}
+}
+
+func testList(t *testing.T, store service.LaptopStore) {
+	laptops := saveLaptops(t, store, 5)
+
+	expectedIDS := make(map[string]struct{})
+	for _, laptop := range laptops {
+		expectedIDS[laptop.GetId()] = struct{}{}
+	}
+
+	var (
+		after    string
+		foundIDS []string
+	)
+
+	for {
+		page, err := store.List(context.Background(), after, 2)
+		require.NoError(t, err)
+		require.LessOrEqual(t, len(page), 2)
+
+		if len(page) == 0 {
+			break
+		}
+
+		for _, laptop := range page {
+			require.Greater(t, laptop.GetId(), after)
+
+			after = laptop.GetId()
+			foundIDS = append(foundIDS, after)
+		}
+	}
+
+	require.Len(t, foundIDS, len(laptops))
+
+	for _, id := range foundIDS {
+		require.Contains(t, expectedIDS, id)
+	}
+}
+
+func testSearchFilter(t *testing.T, newStore LaptopStoreFactory) {
+	testCases := []struct {
+		name   string
+		filter *pb.Filter
+		// match optionally changes the laptop so that it matches the filter.
+		match func(laptop *pb.Laptop)
+		// miss changes the laptop so that it no longer matches the filter.
+		miss func(laptop *pb.Laptop)
+	}{
+		{
+			name:   "max price",
+			filter: &pb.Filter{MaxPriceUsd: proto.Float64(2000)},
+			miss:   func(laptop *pb.Laptop) { laptop.PriceUsd = 2000.01 },
+		},
+		{
+			name:   "max price of zero",
+			filter: &pb.Filter{MaxPriceUsd: proto.Float64(0)},
+			match:  func(laptop *pb.Laptop) { laptop.PriceUsd = 0 },
+			miss:   func(laptop *pb.Laptop) { laptop.PriceUsd = 1 },
+		},
+		{
+			name:   "min cpu cores",
+			filter: &pb.Filter{MinCpuCores: proto.Uint32(6)},
+			miss:   func(laptop *pb.Laptop) { laptop.Cpu.NumberOfCores = 4 },
+		},
+		{
+			name:   "min cpu frequency",
+			filter: &pb.Filter{MinCpuFrequency: proto.Float64(4.0)},
+			miss:   func(laptop *pb.Laptop) { laptop.Cpu.MaximumFrequency = 3.9 },
+		},
+		{
+			name:   "min ram across units",
+			filter: &pb.Filter{MinRam: &pb.Memory{Value: 16384, Unit: pb.Memory_MEGABYTE}},
+			miss:   func(laptop *pb.Laptop) { laptop.Ram = &pb.Memory{Value: 8, Unit: pb.Memory_GIGABYTE} },
+		},
+		{
+			name:   "brand",
+			filter: &pb.Filter{Brand: "lenovo"},
+			miss:   func(laptop *pb.Laptop) { laptop.Brand = "Dell" },
+		},
+		{
+			name:   "name substring",
+			filter: &pb.Filter{Name: "p53"},
+			miss:   func(laptop *pb.Laptop) { laptop.Name = "Thinkpad X1" },
+		},
+		{
+			name:   "min price",
+			filter: &pb.Filter{MinPriceUsd: proto.Float64(2000)},
+			miss:   func(laptop *pb.Laptop) { laptop.PriceUsd = 1999 },
+		},
+		{
+			name:   "gpu brand",
+			filter: &pb.Filter{GpuBrand: "nvidia"},
+			miss:   func(laptop *pb.Laptop) { laptop.Gpus = laptop.Gpus[:1] },
+		},
+		{
+			name:   "gpu brand and min gpu memory on the same gpu",
+			filter: &pb.Filter{GpuBrand: "AMD", MinGpuMemory: &pb.Memory{Value: 2048, Unit: pb.Memory_MEGABYTE}},
+			miss:   func(laptop *pb.Laptop) { laptop.Gpus[0].Memory.Value = 1 },
+		},
+		{
+			name:   "min total storage",
+			filter: &pb.Filter{MinStorage: &pb.Memory{Value: 1536, Unit: pb.Memory_GIGABYTE}},
+			miss:   func(laptop *pb.Laptop) { laptop.Storages[0].Memory.Value = 256 },
+		},
+		{
+			name:   "min storage of a driver",
+			filter: &pb.Filter{MinStorage: &pb.Memory{Value: 512, Unit: pb.Memory_GIGABYTE}, StorageDriver: pb.Storage_SSD},
+			miss:   func(laptop *pb.Laptop) { laptop.Storages[0].Memory.Value = 500 },
+		},
+		{
+			name:   "storage driver",
+			filter: &pb.Filter{StorageDriver: pb.Storage_HDD},
+			miss:   func(laptop *pb.Laptop) { laptop.Storages = laptop.Storages[:1] },
+		},
+		{
+			name:   "screen size range",
+			filter: &pb.Filter{MinScreenSizeInches: proto.Float32(15), MaxScreenSizeInches: proto.Float32(16)},
+			miss:   func(laptop *pb.Laptop) { laptop.Screen.SizeInches = 17.3 },
+		},
+		{
+			name:   "min screen resolution",
+			filter: &pb.Filter{MinScreenResolution: &pb.Screen_Resolution{Width: 1920, Height: 1080}},
+			miss:   func(laptop *pb.Laptop) { laptop.Screen.Resolution.Height = 1200; laptop.Screen.Resolution.Width = 1600 },
+		},
+		{
+			name:   "screen panel",
+			filter: &pb.Filter{ScreenPanel: pb.Screen_IPS},
+			miss:   func(laptop *pb.Laptop) { laptop.Screen.Panel = pb.Screen_OLED },
+		},
+		{
+			name:   "multi touch",
+			filter: &pb.Filter{IsMultiTouch: proto.Bool(false)},
+			miss:   func(laptop *pb.Laptop) { laptop.Screen.IsMultiTouch = true },
+		},
+		{
+			name:   "keyboard layout",
+			filter: &pb.Filter{KeyboardLayout: pb.Keyboard_QWERTY},
+			miss:   func(laptop *pb.Laptop) { laptop.Keyboard.Layout = pb.Keyboard_AZERTY },
+		},
+		{
+			name:   "backlit keyboard",
+			filter: &pb.Filter{IsBacklit: proto.Bool(true)},
+			miss:   func(laptop *pb.Laptop) { laptop.Keyboard.IsBacklit = false },
+		},
+		{
+			name:   "max weight across units",
+			filter: &pb.Filter{MaxWeightKg: proto.Float64(2.5)},
+			miss:   func(laptop *pb.Laptop) { laptop.Weight = &pb.Laptop_WeightLb{WeightLb: 5.6} },
+		},
+		{
+			name:   "release year range",
+			filter: &pb.Filter{MinReleaseYear: proto.Uint32(2018), MaxReleaseYear: proto.Uint32(2019)},
+			miss:   func(laptop *pb.Laptop) { laptop.ReleaseYear = 2017 },
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			store := newStore(t)
+
+			matchingLaptop := newFilterLaptop()
+			if tc.match != nil {
+				tc.match(matchingLaptop)
+			}
+
+			err := store.Save(matchingLaptop)
+			require.NoError(t, err)
+
+			missingLaptop := newFilterLaptop()
+			tc.miss(missingLaptop)
+			err = store.Save(missingLaptop)
+			require.NoError(t, err)
+
+			var foundIDS []string
+
+			err = store.Search(context.Background(), tc.filter, func(laptop *pb.Laptop) error {
+				foundIDS = append(foundIDS, laptop.GetId())
+				return nil
+			})
+
+			require.NoError(t, err)
+			require.Equal(t, []string{matchingLaptop.GetId()}, foundIDS)
+		})
+	}
+}
+
+func testSearchEmptyFilter(t *testing.T, store service.LaptopStore) {
+	expectedIDS := make(map[string]struct{})
+
+	for _, laptop := range saveLaptops(t, store, 5) {
+		expectedIDS[laptop.GetId()] = struct{}{}
+	}
+
+	for _, filter := range []*pb.Filter{nil, {}} {
+		foundIDS := make(map[string]struct{})
+
+		err := store.Search(context.Background(), filter, func(laptop *pb.Laptop) error {
+			foundIDS[laptop.GetId()] = struct{}{}
+			return nil
+		})
+
+		require.NoError(t, err)
+		require.Equal(t, expectedIDS, foundIDS)
+	}
+}
+
+func testSearchContextCancelled(t *testing.T, store service.LaptopStore) {
+	saveLaptops(t, store, 3)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	found := 0
+
+	err := store.Search(ctx, nil, func(laptop *pb.Laptop) error {
+		found++
+		cancel()
+		return nil
+	})
+
+	require.ErrorIs(t, err, context.Canceled)
+	require.Equal(t, 1, found)
+
+	// A search started after the deadline finds nothing.
+	ctx, cancel = context.WithTimeout(context.Background(), 0)
+	defer cancel()
+
+	err = store.Search(ctx, nil, func(laptop *pb.Laptop) error {
+		t.Errorf("found laptop %s after the deadline", laptop.GetId())
+		return nil
+	})
+
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func testSearchFoundError(t *testing.T, store service.LaptopStore) {
+	for i := 0; i < 3; i++ {
+		laptop := factory.NewLaptop()
+		laptop.Name = "Thinkpad"
+
+		err := store.Save(laptop)
+		require.NoError(t, err)
+	}
+
+	errFound := errors.New("found error")
+	found := 0
+
+	err := store.Search(context.Background(), nil, func(laptop *pb.Laptop) error {
+		found++
+		return errFound
+	})
+
+	require.ErrorIs(t, err, errFound)
+	require.Equal(t, 1, found)
+
+	found = 0
+
+	err = store.SearchText(context.Background(), "thinkpad", nil, func(laptop *pb.Laptop, score float64) error {
+		found++
+		return errFound
+	})
+
+	require.ErrorIs(t, err, errFound)
+	require.Equal(t, 1, found)
+}
+
+func testSubscribe(t *testing.T, store service.LaptopStore) {
+	subscription := store.Subscribe()
+	defer subscription.Close()
+
+	laptop := factory.NewLaptop()
+
+	err := store.Save(laptop)
+	require.NoError(t, err)
+
+	laptop.Name = "Thinkpad X1"
+
+	err = store.Update(laptop)
+	require.NoError(t, err)
+
+	err = store.Delete(laptop.GetId(), 0)
+	require.NoError(t, err)
+
+	// Failed writes publish no change.
+	err = store.Delete(laptop.GetId(), 0)
+	require.ErrorIs(t, err, service.ErrRecordNotFound)
+
+	for _, expectedType := range []service.LaptopChangeType{
+		service.LaptopCreated, service.LaptopUpdated, service.LaptopDeleted,
+	} {
+		change := <-subscription.Changes()
+		require.Equal(t, expectedType, change.Type)
+		require.Equal(t, laptop.GetId(), change.Laptop.GetId())
+	}
+
+	select {
+	case change := <-subscription.Changes():
+		t.Errorf("unexpected %v change", change.Type)
+	default:
+	}
+}
+
+func testConcurrentWriters(t *testing.T, store service.LaptopStore) {
+	const (
+		writers = 8
+		updates = 5
+	)
+
+	var wg sync.WaitGroup
+
+	errs := make(chan error, writers*2)
+	laptops := make([]*pb.Laptop, writers)
+
+	for i := range laptops {
+		laptops[i] = factory.NewLaptop()
+	}
+
+	for i := 0; i < writers; i++ {
+		wg.Add(2)
+
+		go func(laptop *pb.Laptop) {
+			defer wg.Done()
+
+			if err := store.Save(laptop); err != nil {
+				errs <- err
+				return
+			}
+
+			for j := 0; j < updates; j++ {
+				laptop.PriceUsd = float64(1000 + j)
+
+				if err := store.Update(laptop); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(laptops[i])
+
+		go func() {
+			defer wg.Done()
+
+			err := store.Search(context.Background(), &pb.Filter{MaxPriceUsd: proto.Float64(5000)}, func(laptop *pb.Laptop) error {
+				if laptop.GetRevision() == 0 {
+					return fmt.Errorf("found laptop %s without a revision", laptop.GetId())
+				}
+
+				return nil
+			})
+
+			if err != nil {
+				errs <- err
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		require.NoError(t, err)
+	}
+
+	for _, laptop := range laptops {
+		require.Equal(t, uint64(updates+1), laptop.GetRevision())
+		requireStoredLaptop(t, store, laptop)
+	}
+
+	storedLaptops, err := store.List(context.Background(), "", writers*2)
+	require.NoError(t, err)
+	require.Len(t, storedLaptops, writers)
+}
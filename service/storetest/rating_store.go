@@ -0,0 +1,163 @@
+package storetest
+
+import (
+	"fmt"
+	"github.com/jwambugu/pcbook-grpc/service"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+)
+
+// RatingStoreFactory returns a new empty store for a test, cleaning it up when the test ends
+type RatingStoreFactory func(t *testing.T) service.RatingStore
+
+// RunRatingStoreTests checks that the stores returned by newStore behave as a service.RatingStore
+func RunRatingStoreTests(t *testing.T, newStore RatingStoreFactory) {
+	t.Run("Rate", func(t *testing.T) {
+		t.Parallel()
+
+		store := newStore(t)
+
+		rating, err := store.Find("laptop")
+		require.NoError(t, err)
+		require.Nil(t, rating)
+
+		for i, username := range []string{"alice", "bob", "carol"} {
+			rating, err = store.Rate("laptop", username, float64(5-i))
+			require.NoError(t, err)
+			require.Equal(t, uint32(i+1), rating.Count)
+		}
+
+		require.Equal(t, &service.Rating{Count: 3, Sum: 12}, rating)
+
+		rating, err = store.Rate("other-laptop", "alice", 1)
+		require.NoError(t, err)
+		require.Equal(t, &service.Rating{Count: 1, Sum: 1}, rating)
+
+		rating, err = store.Find("laptop")
+		require.NoError(t, err)
+		require.Equal(t, &service.Rating{Count: 3, Sum: 12}, rating)
+	})
+
+	t.Run("Replace", func(t *testing.T) {
+		t.Parallel()
+
+		store := newStore(t)
+
+		_, err := store.Rate("laptop", "alice", 5)
+		require.NoError(t, err)
+
+		_, err = store.Rate("laptop", "bob", 3)
+		require.NoError(t, err)
+
+		// Rating a laptop again replaces the score of the user, however often it is repeated.
+		for i := 0; i < 3; i++ {
+			rating, err := store.Rate("laptop", "alice", 9)
+			require.NoError(t, err)
+			require.Equal(t, &service.Rating{Count: 2, Sum: 12}, rating)
+		}
+
+		rating, err := store.Rate("laptop", "bob", 1)
+		require.NoError(t, err)
+		require.Equal(t, &service.Rating{Count: 2, Sum: 10}, rating)
+
+		// The score of a user is kept per laptop.
+		rating, err = store.Rate("other-laptop", "alice", 2)
+		require.NoError(t, err)
+		require.Equal(t, &service.Rating{Count: 1, Sum: 2}, rating)
+
+		rating, err = store.Find("laptop")
+		require.NoError(t, err)
+		require.Equal(t, &service.Rating{Count: 2, Sum: 10}, rating)
+	})
+
+	t.Run("NoDrift", func(t *testing.T) {
+		t.Parallel()
+
+		store := newStore(t)
+		otherStore := newStore(t)
+
+		_, err := store.Rate("laptop", "alice", 0.1)
+		require.NoError(t, err)
+
+		// Scores that are not exact in binary leave no trace in the sum once they are replaced.
+		for i := 0; i < 100; i++ {
+			for _, score := range []float64{0.1, 0.7, 0.3, 0.9} {
+				_, err = store.Rate("laptop", "bob", score)
+				require.NoError(t, err)
+			}
+		}
+
+		_, err = otherStore.Rate("laptop", "alice", 0.1)
+		require.NoError(t, err)
+
+		expected, err := otherStore.Rate("laptop", "bob", 0.9)
+		require.NoError(t, err)
+
+		rating, err := store.Find("laptop")
+		require.NoError(t, err)
+		require.Equal(t, expected, rating)
+	})
+
+	t.Run("CopyIsolation", func(t *testing.T) {
+		t.Parallel()
+
+		store := newStore(t)
+
+		rating, err := store.Rate("laptop", "alice", 5)
+		require.NoError(t, err)
+
+		rating.Count = 100
+
+		rating, err = store.Find("laptop")
+		require.NoError(t, err)
+
+		rating.Sum = 100
+
+		rating, err = store.Find("laptop")
+		require.NoError(t, err)
+		require.Equal(t, &service.Rating{Count: 1, Sum: 5}, rating)
+	})
+
+	t.Run("ConcurrentWriters", func(t *testing.T) {
+		t.Parallel()
+
+		const (
+			writers = 8
+			rates   = 10
+		)
+
+		store := newStore(t)
+
+		var wg sync.WaitGroup
+
+		errs := make(chan error, writers)
+
+		for i := 0; i < writers; i++ {
+			wg.Add(1)
+
+			go func(username string) {
+				defer wg.Done()
+
+				for j := 1; j <= rates; j++ {
+					if _, err := store.Rate("laptop", username, float64(j)); err != nil {
+						errs <- err
+						return
+					}
+				}
+			}(fmt.Sprintf("user-%d", i))
+		}
+
+		wg.Wait()
+		close(errs)
+
+		for err := range errs {
+			require.NoError(t, err)
+		}
+
+		// Only the last score of each user counts.
+		rating, err := store.Find("laptop")
+		require.NoError(t, err)
+		require.Equal(t, &service.Rating{Count: writers, Sum: writers * rates}, rating)
+	})
+}
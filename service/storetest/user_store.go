@@ -0,0 +1,110 @@
+package storetest
+
+import (
+	"fmt"
+	"github.com/jwambugu/pcbook-grpc/service"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+)
+
+// UserStoreFactory returns a new empty store for a test, cleaning it up when the test ends
+type UserStoreFactory func(t *testing.T) service.UserStore
+
+// RunUserStoreTests checks that the stores returned by newStore behave as a service.UserStore
+func RunUserStoreTests(t *testing.T, newStore UserStoreFactory) {
+	t.Run("SaveDuplicateUsername", func(t *testing.T) {
+		t.Parallel()
+
+		store := newStore(t)
+		user := &service.User{Username: "admin", HashedPassword: "hashed", Role: "admin"}
+
+		err := store.Save(user)
+		require.NoError(t, err)
+
+		err = store.Save(&service.User{Username: "admin", HashedPassword: "other", Role: "user"})
+		require.ErrorIs(t, err, service.ErrRecordExists)
+
+		foundUser, err := store.FindByUsername("admin")
+		require.NoError(t, err)
+		require.Equal(t, user, foundUser)
+
+		foundUser, err = store.FindByUsername("unknown")
+		require.NoError(t, err)
+		require.Nil(t, foundUser)
+	})
+
+	t.Run("CopyIsolation", func(t *testing.T) {
+		t.Parallel()
+
+		store := newStore(t)
+		user := &service.User{Username: "admin", HashedPassword: "hashed", Role: "admin"}
+
+		err := store.Save(user)
+		require.NoError(t, err)
+
+		user.Role = "user"
+
+		foundUser, err := store.FindByUsername("admin")
+		require.NoError(t, err)
+
+		foundUser.HashedPassword = "changed"
+
+		foundUser, err = store.FindByUsername("admin")
+		require.NoError(t, err)
+		require.Equal(t, &service.User{Username: "admin", HashedPassword: "hashed", Role: "admin"}, foundUser)
+	})
+
+	t.Run("ConcurrentWriters", func(t *testing.T) {
+		t.Parallel()
+
+		const writers = 8
+
+		store := newStore(t)
+
+		var wg sync.WaitGroup
+
+		errs := make(chan error, writers*2)
+
+		// Every user is saved twice, exactly one of the saves succeeds.
+		saved := make(chan string, writers*2)
+
+		for i := 0; i < writers*2; i++ {
+			wg.Add(1)
+
+			go func(username string) {
+				defer wg.Done()
+
+				err := store.Save(&service.User{Username: username, HashedPassword: "hashed", Role: "user"})
+				if err == nil {
+					saved <- username
+				} else if err != service.ErrRecordExists {
+					errs <- err
+				}
+			}(fmt.Sprintf("user-%d", i/2))
+		}
+
+		wg.Wait()
+		close(errs)
+		close(saved)
+
+		for err := range errs {
+			require.NoError(t, err)
+		}
+
+		savedUsernames := make(map[string]int)
+		for username := range saved {
+			savedUsernames[username]++
+		}
+
+		require.Len(t, savedUsernames, writers)
+
+		for username, saves := range savedUsernames {
+			require.Equal(t, 1, saves, username)
+
+			foundUser, err := store.FindByUsername(username)
+			require.NoError(t, err)
+			require.NotNil(t, foundUser)
+		}
+	})
+}
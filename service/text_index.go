@@ -0,0 +1,202 @@
+package service
+
+import (
+	"github.com/jwambugu/pcbook-grpc/protos/pb"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// prefixMatchWeight is the weight of a query token that only matches the prefix of an indexed term
+const prefixMatchWeight = 0.5
+
+// textIndex is an inverted index over the brand, name, CPU and GPU names of laptops. It is not safe for concurrent
+// use; the store that owns it guards it with its own lock.
+type textIndex struct {
+	// postings maps each term to the ids of the laptops containing it and how often it occurs in each
+	postings map[string]map[string]int
+	// documents maps each laptop id to the distinct terms indexed for it
+	documents map[string][]string
+	// terms holds every indexed term in ascending order for prefix lookups
+	terms []string
+}
+
+// scoredID is a laptop id and its relevance score for a text query
+type scoredID struct {
+	id    string
+	score float64
+}
+
+func newTextIndex() *textIndex {
+	return &textIndex{
+		postings:  make(map[string]map[string]int),
+		documents: make(map[string][]string),
+	}
+}
+
+// tokenize splits text into lower case tokens of letters and digits
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// laptopText returns the text fields of the laptop that are indexed
+func laptopText(laptop *pb.Laptop) []string {
+	text := []string{laptop.GetBrand(), laptop.GetName(), laptop.GetCpu().GetBrand(), laptop.GetCpu().GetName()}
+
+	for _, gpu := range laptop.GetGpus() {
+		text = append(text, gpu.GetBrand(), gpu.GetName())
+	}
+
+	return text
+}
+
+// termFrequencies returns how often each term occurs in the indexed text of the laptop
+func termFrequencies(laptop *pb.Laptop) map[string]int {
+	frequencies := make(map[string]int)
+
+	for _, text := range laptopText(laptop) {
+		for _, token := range tokenize(text) {
+			frequencies[token]++
+		}
+	}
+
+	return frequencies
+}
+
+// add indexes the laptop, replacing any previous version of it
+func (index *textIndex) add(laptop *pb.Laptop) {
+	index.remove(laptop.GetId())
+
+	frequencies := termFrequencies(laptop)
+	terms := make([]string, 0, len(frequencies))
+
+	for term, frequency := range frequencies {
+		postings := index.postings[term]
+		if postings == nil {
+			postings = make(map[string]int)
+			index.postings[term] = postings
+
+			i := sort.SearchStrings(index.terms, term)
+			index.terms = append(index.terms, "")
+			copy(index.terms[i+1:], index.terms[i:])
+			index.terms[i] = term
+		}
+
+		postings[laptop.GetId()] = frequency
+		terms = append(terms, term)
+	}
+
+	index.documents[laptop.GetId()] = terms
+}
+
+// remove drops the laptop from the index
+func (index *textIndex) remove(id string) {
+	for _, term := range index.documents[id] {
+		postings := index.postings[term]
+		delete(postings, id)
+
+		if len(postings) == 0 {
+			delete(index.postings, term)
+
+			i := sort.SearchStrings(index.terms, term)
+			index.terms = append(index.terms[:i], index.terms[i+1:]...)
+		}
+	}
+
+	delete(index.documents, id)
+}
+
+// search returns the ids of the laptops matching every token of the text, most relevant first
+func (index *textIndex) search(text string) []scoredID {
+	results, _ := rankText(text, func(token string) (map[string]float64, error) {
+		return index.tokenScores(token), nil
+	})
+
+	return results
+}
+
+// tokenScores returns the score of a single query token for every laptop it matches
+func (index *textIndex) tokenScores(token string) map[string]float64 {
+	scores := make(map[string]float64)
+
+	for i := sort.SearchStrings(index.terms, token); i < len(index.terms); i++ {
+		term := index.terms[i]
+		if !strings.HasPrefix(term, token) {
+			break
+		}
+
+		addTermScores(scores, token, term, index.postings[term], len(index.documents))
+	}
+
+	return scores
+}
+
+// rankText returns the ids of the laptops matching every token of the text, most relevant first. A token matches an
+// indexed term that is equal to it or, with a lower weight, starts with it. Relevance is the sum over the tokens of
+// the best matching term's frequency weighted by its inverse document frequency, as computed by tokenScores.
+func rankText(text string, tokenScores func(token string) (map[string]float64, error)) ([]scoredID, error) {
+	tokens := tokenize(text)
+	if len(tokens) == 0 {
+		return nil, nil
+	}
+
+	var scores map[string]float64
+
+	for _, token := range tokens {
+		tokenScores, err := tokenScores(token)
+		if err != nil {
+			return nil, err
+		}
+
+		if scores == nil {
+			scores = tokenScores
+			continue
+		}
+
+		for id, score := range scores {
+			tokenScore, ok := tokenScores[id]
+			if !ok {
+				delete(scores, id)
+				continue
+			}
+
+			scores[id] = score + tokenScore
+		}
+	}
+
+	results := make([]scoredID, 0, len(scores))
+	for id, score := range scores {
+		results = append(results, scoredID{id: id, score: score})
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].score != results[j].score {
+			return results[i].score > results[j].score
+		}
+
+		return results[i].id < results[j].id
+	})
+
+	return results, nil
+}
+
+// addTermScores records the score of the token for the laptops whose ids and term frequencies are in postings, if
+// it beats the score of a term the token matched before. Documents is the number of indexed laptops.
+func addTermScores(scores map[string]float64, token, term string, postings map[string]int, documents int) {
+	weight := 1.0
+	if term != token {
+		weight = prefixMatchWeight
+	}
+
+	idf := math.Log(1 + float64(documents)/float64(len(postings)))
+
+	for id, frequency := range postings {
+		score := weight * float64(frequency) * idf
+		if score > scores[id] {
+			scores[id] = score
+		}
+	}
+}
@@ -1,6 +1,9 @@
 package service
 
-import "sync"
+import (
+	"github.com/jwambugu/pcbook-grpc/protos/pb"
+	"sync"
+)
 
 // UserStore is an interface for managing users.
 type UserStore interface {
@@ -48,3 +51,33 @@ func (store *InMemoryUserStore) FindByUsername(username string) (*User, error) {
 
 	return user.Clone(), nil
 }
+
+// all returns all stored users
+func (store *InMemoryUserStore) all() []*pb.StoredUser {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	users := make([]*pb.StoredUser, 0, len(store.users))
+
+	for _, user := range store.users {
+		users = append(users, &pb.StoredUser{
+			Username:       user.Username,
+			HashedPassword: user.HashedPassword,
+			Role:           user.Role,
+		})
+	}
+
+	return users
+}
+
+// restore stores the user as it is, replacing any stored user with the same username
+func (store *InMemoryUserStore) restore(user *pb.StoredUser) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.users[user.GetUsername()] = &User{
+		Username:       user.GetUsername(),
+		HashedPassword: user.GetHashedPassword(),
+		Role:           user.GetRole(),
+	}
+}
@@ -0,0 +1,58 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// SQLiteUserStore is a SQLite implementation of UserStore using a database opened with OpenSQLite.
+type SQLiteUserStore struct {
+	db *sql.DB
+}
+
+// NewSQLiteUserStore creates a new SQLiteUserStore.
+func NewSQLiteUserStore(db *sql.DB) *SQLiteUserStore {
+	return &SQLiteUserStore{
+		db: db,
+	}
+}
+
+// Save saves a new user in the store.
+func (store *SQLiteUserStore) Save(user *User) error {
+	_, err := store.db.Exec(
+		"INSERT INTO users (username, hashed_password, role) VALUES (?, ?, ?)",
+		user.Username, user.HashedPassword, user.Role,
+	)
+
+	if err != nil {
+		var sqliteErr *sqlite.Error
+		if errors.As(err, &sqliteErr) && sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY {
+			return ErrRecordExists
+		}
+
+		return fmt.Errorf("error saving user: %v", err)
+	}
+
+	return nil
+}
+
+// FindByUsername fetches a user by their username.
+func (store *SQLiteUserStore) FindByUsername(username string) (*User, error) {
+	user := &User{}
+
+	err := store.db.QueryRow("SELECT username, hashed_password, role FROM users WHERE username = ?", username).
+		Scan(&user.Username, &user.HashedPassword, &user.Role)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("error finding user: %v", err)
+	}
+
+	return user, nil
+}
@@ -0,0 +1,604 @@
+package service
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/jwambugu/pcbook-grpc/protos/pb"
+	"github.com/jwambugu/pcbook-grpc/serializer"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	walFileName         = "wal.log"
+	walSnapshotFileName = "snapshot.pb"
+
+	// maxWALFrameSize bounds the size of a record or snapshot, a larger length can only come from corruption
+	maxWALFrameSize = 1 << 30
+	// defaultWALSyncInterval is how often WALSyncInterval syncs the log when no interval is configured
+	defaultWALSyncInterval = time.Second
+)
+
+var (
+	// ErrWALCorrupted is an error that is returned when a record or snapshot fails its checksum or is out of sequence
+	ErrWALCorrupted = errors.New("write-ahead log corrupted")
+	// ErrWALClosed is an error that is returned when writing to a closed write-ahead log
+	ErrWALClosed = errors.New("write-ahead log closed")
+
+	walChecksumTable = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// WALSyncMode decides when the write-ahead log is flushed to stable storage
+type WALSyncMode int
+
+const (
+	// WALSyncAlways syncs the log after every record, so a saved write survives a crash of the machine
+	WALSyncAlways WALSyncMode = iota
+	// WALSyncInterval syncs the log every sync interval, so a crash of the machine loses at most the last interval
+	WALSyncInterval
+	// WALSyncNever leaves syncing to the operating system, so only a crash of the server is survived
+	WALSyncNever
+)
+
+// WALOptions configures a WAL
+type WALOptions struct {
+	// Sync decides when the log is flushed to stable storage
+	Sync WALSyncMode
+	// SyncInterval is how often WALSyncInterval syncs the log, one second by default
+	SyncInterval time.Duration
+	// SnapshotRecords takes a snapshot once the log holds that many records, zero disables it
+	SnapshotRecords int
+	// SnapshotInterval takes a snapshot that often unless the log is empty, zero disables it
+	SnapshotInterval time.Duration
+}
+
+// WALStores are the in-memory stores whose writes a WAL logs
+type WALStores struct {
+	Laptops *InMemoryLaptopStore
+	Ratings *InMemoryRatingStore
+	Users   *InMemoryUserStore
+	Images  *DiskImageStore
+}
+
+// WAL makes the in-memory stores durable. Every write to the stores is appended to a log of checksummed records,
+// which is compacted into a snapshot of the stores from time to time. Opening a WAL loads the snapshot and replays
+// the records logged after it.
+//
+// Records and the snapshot are framed as the uvarint length of the protobuf bytes and its CRC-32C, followed by the
+// bytes and their CRC-32C. Checking the length apart tells a corrupted length from a record cut off by a crash.
+type WAL struct {
+	mutex   sync.Mutex
+	dir     string
+	options WALOptions
+	stores  WALStores
+	file    *os.File
+	// sequence is the sequence of the last logged record
+	sequence uint64
+	// records is the number of records in the log
+	records int
+	// dirty is set when records were written since the log was last synced
+	dirty bool
+	// err is the first error writing the log, after which the log takes no more writes
+	err    error
+	closed bool
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// OpenWAL opens the write-ahead log in the directory, creating it if needed, and restores the stores from it
+func OpenWAL(dir string, stores WALStores, options WALOptions) (*WAL, error) {
+	if options.SyncInterval <= 0 {
+		options.SyncInterval = defaultWALSyncInterval
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("error creating write-ahead log directory: %v", err)
+	}
+
+	wal := &WAL{
+		dir:     dir,
+		options: options,
+		stores:  stores,
+		done:    make(chan struct{}),
+	}
+
+	if err := wal.loadSnapshot(); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(filepath.Join(dir, walFileName), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("error opening write-ahead log: %v", err)
+	}
+
+	wal.file = file
+
+	if err := wal.replay(); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+
+	if options.Sync == WALSyncInterval || options.SnapshotInterval > 0 {
+		wal.wg.Add(1)
+		go wal.run()
+	}
+
+	return wal, nil
+}
+
+// LaptopStore returns the laptop store whose writes are logged
+func (wal *WAL) LaptopStore() *WALLaptopStore {
+	return &WALLaptopStore{InMemoryLaptopStore: wal.stores.Laptops, wal: wal}
+}
+
+// RatingStore returns the rating store whose writes are logged
+func (wal *WAL) RatingStore() *WALRatingStore {
+	return &WALRatingStore{InMemoryRatingStore: wal.stores.Ratings, wal: wal}
+}
+
+// UserStore returns the user store whose writes are logged
+func (wal *WAL) UserStore() *WALUserStore {
+	return &WALUserStore{InMemoryUserStore: wal.stores.Users, wal: wal}
+}
+
+// ImageStore returns the image store whose image metadata is logged
+func (wal *WAL) ImageStore() *WALImageStore {
+	return &WALImageStore{DiskImageStore: wal.stores.Images, wal: wal}
+}
+
+// walChecksum appends the CRC-32C of the data to the frame
+func walChecksum(frame, data []byte) []byte {
+	checksum := make([]byte, crc32.Size)
+	binary.BigEndian.PutUint32(checksum, crc32.Checksum(data, walChecksumTable))
+
+	return append(frame, checksum...)
+}
+
+// walFrameHeader returns the length of the protobuf bytes of a frame as a uvarint
+func walFrameHeader(size uint64) []byte {
+	header := make([]byte, binary.MaxVarintLen64)
+	return header[:binary.PutUvarint(header, size)]
+}
+
+// walFrame returns the frame of the protobuf bytes of a record or snapshot
+func walFrame(data []byte) []byte {
+	header := walFrameHeader(uint64(len(data)))
+
+	frame := make([]byte, 0, len(header)+len(data)+2*crc32.Size)
+	frame = append(frame, header...)
+	frame = walChecksum(frame, header)
+	frame = append(frame, data...)
+
+	return walChecksum(frame, data)
+}
+
+// walFrameSize returns the size of the frame of protobuf bytes of the size
+func walFrameSize(size int) int {
+	return len(walFrameHeader(uint64(size))) + size + 2*crc32.Size
+}
+
+// readWALFrame reads the protobuf bytes of the next frame. It returns io.EOF if the reader has no more frames and
+// io.ErrUnexpectedEOF if the last frame was not written to the end.
+func readWALFrame(reader *bufio.Reader) ([]byte, error) {
+	size, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	headerChecksum := make([]byte, crc32.Size)
+	if _, err := io.ReadFull(reader, headerChecksum); err != nil {
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+
+		return nil, err
+	}
+
+	if binary.BigEndian.Uint32(headerChecksum) != crc32.Checksum(walFrameHeader(size), walChecksumTable) {
+		return nil, fmt.Errorf("%w: length checksum mismatch", ErrWALCorrupted)
+	}
+
+	if size > maxWALFrameSize {
+		return nil, fmt.Errorf("%w: frame of %d bytes", ErrWALCorrupted, size)
+	}
+
+	frame := make([]byte, size+crc32.Size)
+	if _, err := io.ReadFull(reader, frame); err != nil {
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+
+		return nil, err
+	}
+
+	data := frame[:size]
+	if binary.BigEndian.Uint32(frame[size:]) != crc32.Checksum(data, walChecksumTable) {
+		return nil, fmt.Errorf("%w: checksum mismatch", ErrWALCorrupted)
+	}
+
+	return data, nil
+}
+
+// loadSnapshot restores the stores from the snapshot, if one was taken
+func (wal *WAL) loadSnapshot() error {
+	file, err := os.Open(filepath.Join(wal.dir, walSnapshotFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("error opening write-ahead log snapshot: %v", err)
+	}
+
+	defer file.Close()
+
+	data, err := readWALFrame(bufio.NewReader(file))
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = fmt.Errorf("%w: truncated snapshot", ErrWALCorrupted)
+	}
+
+	if err != nil {
+		return fmt.Errorf("error reading write-ahead log snapshot: %w", err)
+	}
+
+	snapshot := &pb.WALSnapshot{}
+	if err := serializer.BinaryToProtobuf(data, snapshot); err != nil {
+		return fmt.Errorf("error reading write-ahead log snapshot: %w", err)
+	}
+
+	for _, laptop := range snapshot.GetLaptops() {
+		if err := wal.stores.Laptops.restore(laptop); err != nil {
+			return err
+		}
+	}
+
+	for _, rating := range snapshot.GetRatings() {
+		wal.stores.Ratings.restore(rating)
+	}
+
+	for _, user := range snapshot.GetUsers() {
+		wal.stores.Users.restore(user)
+	}
+
+	for _, image := range snapshot.GetImages() {
+		wal.stores.Images.restore(image)
+	}
+
+	wal.sequence = snapshot.GetSequence()
+	return nil
+}
+
+// replay applies the records logged after the snapshot to the stores. A last record that was not written to the end
+// is cut off the log, as a crash while appending leaves it behind.
+func (wal *WAL) replay() error {
+	reader := bufio.NewReader(wal.file)
+
+	var offset int64
+
+	for {
+		data, err := readWALFrame(reader)
+		if err == io.EOF {
+			return nil
+		}
+
+		if err == io.ErrUnexpectedEOF {
+			log.Printf("truncating write-ahead log incomplete record at offset %d", offset)
+
+			if err := wal.file.Truncate(offset); err != nil {
+				return fmt.Errorf("error truncating write-ahead log: %v", err)
+			}
+
+			return nil
+		}
+
+		if err != nil {
+			return fmt.Errorf("error reading write-ahead log record at offset %d: %w", offset, err)
+		}
+
+		record := &pb.WALRecord{}
+		if err := serializer.BinaryToProtobuf(data, record); err != nil {
+			return fmt.Errorf("error reading write-ahead log record at offset %d: %w", offset, err)
+		}
+
+		offset += int64(walFrameSize(len(data)))
+		wal.records++
+
+		// Records up to the snapshot are left behind by a crash while compacting the log.
+		if record.GetSequence() <= wal.sequence {
+			continue
+		}
+
+		if record.GetSequence() != wal.sequence+1 {
+			return fmt.Errorf("%w: record %d follows record %d", ErrWALCorrupted, record.GetSequence(), wal.sequence)
+		}
+
+		if err := wal.apply(record); err != nil {
+			return fmt.Errorf("error replaying write-ahead log record %d: %w", record.GetSequence(), err)
+		}
+
+		wal.sequence = record.GetSequence()
+	}
+}
+
+// apply applies a logged record to the stores
+func (wal *WAL) apply(record *pb.WALRecord) error {
+	switch mutation := record.GetMutation().(type) {
+	case *pb.WALRecord_LaptopSaved:
+		return wal.stores.Laptops.restore(mutation.LaptopSaved)
+	case *pb.WALRecord_LaptopUpdated:
+		return wal.stores.Laptops.restore(mutation.LaptopUpdated)
+	case *pb.WALRecord_LaptopDeleted:
+		return wal.stores.Laptops.Delete(mutation.LaptopDeleted, 0)
+	case *pb.WALRecord_LaptopRated:
+		_, err := wal.stores.Ratings.Rate(
+			mutation.LaptopRated.GetLaptopId(), mutation.LaptopRated.GetUsername(), mutation.LaptopRated.GetScore(),
+		)
+		return err
+	case *pb.WALRecord_UserSaved:
+		wal.stores.Users.restore(mutation.UserSaved)
+		return nil
+	case *pb.WALRecord_ImageSaved:
+		wal.stores.Images.restore(mutation.ImageSaved)
+		return nil
+	case *pb.WALRecord_ImageDeleted:
+		wal.stores.Images.remove(mutation.ImageDeleted)
+		return nil
+	default:
+		return fmt.Errorf("%w: unknown mutation %T", ErrWALCorrupted, mutation)
+	}
+}
+
+// write logs the record prepare returns and then applies the write to the stores with apply. The log is locked
+// from preparing the record until the write is applied, so that the stores do not change in between and records are
+// logged in the order the writes are applied. A write whose record fails to log leaves the stores unchanged.
+func (wal *WAL) write(prepare func() (*pb.WALRecord, error), apply func() error) error {
+	wal.mutex.Lock()
+	defer wal.mutex.Unlock()
+
+	if wal.closed {
+		return ErrWALClosed
+	}
+
+	if wal.err != nil {
+		return fmt.Errorf("write-ahead log failed: %w", wal.err)
+	}
+
+	record, err := prepare()
+	if err != nil {
+		return err
+	}
+
+	if err := wal.append(record); err != nil {
+		wal.err = err
+		return err
+	}
+
+	// The stores no longer match the log when a logged write fails to apply.
+	if err := apply(); err != nil {
+		wal.err = err
+		return err
+	}
+
+	if wal.options.SnapshotRecords > 0 && wal.records >= wal.options.SnapshotRecords {
+		if err := wal.snapshot(); err != nil {
+			wal.err = err
+			return err
+		}
+	}
+
+	return nil
+}
+
+// append logs the record as the next one in the sequence. The caller holds the mutex.
+func (wal *WAL) append(record *pb.WALRecord) error {
+	record.Sequence = wal.sequence + 1
+
+	data, err := serializer.ProtobufToBinary(record)
+	if err != nil {
+		return fmt.Errorf("error writing write-ahead log record: %w", err)
+	}
+
+	if _, err := wal.file.Write(walFrame(data)); err != nil {
+		return fmt.Errorf("error writing write-ahead log record: %v", err)
+	}
+
+	wal.sequence++
+	wal.records++
+
+	switch wal.options.Sync {
+	case WALSyncAlways:
+		if err := wal.file.Sync(); err != nil {
+			return fmt.Errorf("error syncing write-ahead log: %v", err)
+		}
+	case WALSyncInterval:
+		wal.dirty = true
+	}
+
+	return nil
+}
+
+// Snapshot compacts the log into a snapshot of the stores
+func (wal *WAL) Snapshot() error {
+	wal.mutex.Lock()
+	defer wal.mutex.Unlock()
+
+	if wal.closed {
+		return ErrWALClosed
+	}
+
+	if wal.err != nil {
+		return fmt.Errorf("write-ahead log failed: %w", wal.err)
+	}
+
+	if err := wal.snapshot(); err != nil {
+		wal.err = err
+		return err
+	}
+
+	return nil
+}
+
+// snapshot writes a snapshot of the stores next to the log and empties the log. The snapshot replaces the previous
+// one only once it is synced, and a crash before the log is emptied leaves records the snapshot already holds, which
+// replay skips by their sequence. The caller holds the mutex.
+func (wal *WAL) snapshot() error {
+	laptops := wal.stores.Laptops
+	snapshot := &pb.WALSnapshot{
+		Sequence: wal.sequence,
+		Laptops: laptops.snapshot(func() []string {
+			return laptops.ids
+		}),
+		Ratings: wal.stores.Ratings.all(),
+		Users:   wal.stores.Users.all(),
+		Images:  wal.stores.Images.all(),
+	}
+
+	data, err := serializer.ProtobufToBinary(snapshot)
+	if err != nil {
+		return fmt.Errorf("error writing write-ahead log snapshot: %w", err)
+	}
+
+	snapshotPath := filepath.Join(wal.dir, walSnapshotFileName)
+	if err := writeFileSynced(snapshotPath+".tmp", walFrame(data)); err != nil {
+		return fmt.Errorf("error writing write-ahead log snapshot: %v", err)
+	}
+
+	if err := os.Rename(snapshotPath+".tmp", snapshotPath); err != nil {
+		return fmt.Errorf("error writing write-ahead log snapshot: %v", err)
+	}
+
+	if err := syncDir(wal.dir); err != nil {
+		return fmt.Errorf("error writing write-ahead log snapshot: %v", err)
+	}
+
+	if err := wal.file.Truncate(0); err != nil {
+		return fmt.Errorf("error truncating write-ahead log: %v", err)
+	}
+
+	if err := wal.file.Sync(); err != nil {
+		return fmt.Errorf("error syncing write-ahead log: %v", err)
+	}
+
+	wal.records = 0
+	wal.dirty = false
+
+	return nil
+}
+
+// writeFileSynced writes the data to a new file at the path and syncs it
+func writeFileSynced(path string, data []byte) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.Write(data); err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+// syncDir syncs the directory so that the files renamed into it survive a crash
+func syncDir(dir string) error {
+	file, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	return file.Sync()
+}
+
+// run syncs the log and takes snapshots in the background until the log is closed
+func (wal *WAL) run() {
+	defer wal.wg.Done()
+
+	var syncTicks, snapshotTicks <-chan time.Time
+
+	if wal.options.Sync == WALSyncInterval {
+		ticker := time.NewTicker(wal.options.SyncInterval)
+		defer ticker.Stop()
+
+		syncTicks = ticker.C
+	}
+
+	if wal.options.SnapshotInterval > 0 {
+		ticker := time.NewTicker(wal.options.SnapshotInterval)
+		defer ticker.Stop()
+
+		snapshotTicks = ticker.C
+	}
+
+	for {
+		select {
+		case <-wal.done:
+			return
+		case <-syncTicks:
+			wal.mutex.Lock()
+
+			if wal.dirty && wal.err == nil {
+				if err := wal.file.Sync(); err != nil {
+					wal.err = fmt.Errorf("error syncing write-ahead log: %v", err)
+					log.Print(wal.err)
+				}
+
+				wal.dirty = false
+			}
+
+			wal.mutex.Unlock()
+		case <-snapshotTicks:
+			wal.mutex.Lock()
+
+			if wal.records > 0 && wal.err == nil {
+				if err := wal.snapshot(); err != nil {
+					wal.err = err
+					log.Print(wal.err)
+				}
+			}
+
+			wal.mutex.Unlock()
+		}
+	}
+}
+
+// Close syncs and closes the log, the stores take no more writes afterwards
+func (wal *WAL) Close() error {
+	wal.mutex.Lock()
+
+	if wal.closed {
+		wal.mutex.Unlock()
+		return nil
+	}
+
+	wal.closed = true
+	wal.mutex.Unlock()
+
+	close(wal.done)
+	wal.wg.Wait()
+
+	syncErr := wal.file.Sync()
+	if err := wal.file.Close(); err != nil {
+		return fmt.Errorf("error closing write-ahead log: %v", err)
+	}
+
+	if syncErr != nil {
+		return fmt.Errorf("error syncing write-ahead log: %v", syncErr)
+	}
+
+	return nil
+}
@@ -0,0 +1,242 @@
+package service
+
+import "github.com/jwambugu/pcbook-grpc/protos/pb"
+
+// WALLaptopStore is an InMemoryLaptopStore whose writes are logged to a WAL
+type WALLaptopStore struct {
+	*InMemoryLaptopStore
+	wal *WAL
+}
+
+// Save saves a laptop in the store, stamping its revision and updated_at
+func (store *WALLaptopStore) Save(laptop *pb.Laptop) error {
+	return store.wal.write(func() (*pb.WALRecord, error) {
+		storedLaptop, err := store.Find(laptop.Id)
+		if err != nil {
+			return nil, err
+		}
+
+		if storedLaptop != nil {
+			return nil, ErrRecordExists
+		}
+
+		stampRevision(laptop, 0)
+
+		return &pb.WALRecord{Mutation: &pb.WALRecord_LaptopSaved{LaptopSaved: laptop}}, nil
+	}, func() error {
+		return store.replace(laptop)
+	})
+}
+
+// Update replaces an existing laptop in the store if its revision matches the stored one, stamping the new
+// revision and updated_at. A zero revision skips the check.
+func (store *WALLaptopStore) Update(laptop *pb.Laptop) error {
+	return store.wal.write(func() (*pb.WALRecord, error) {
+		storedLaptop, err := store.Find(laptop.Id)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := checkRevision(storedLaptop, laptop.Revision); err != nil {
+			return nil, err
+		}
+
+		stampRevision(laptop, storedLaptop.Revision)
+
+		return &pb.WALRecord{Mutation: &pb.WALRecord_LaptopUpdated{LaptopUpdated: laptop}}, nil
+	}, func() error {
+		return store.replace(laptop)
+	})
+}
+
+// Delete removes a laptop from the store by its id if the revision matches the stored one. A zero revision skips
+// the check.
+func (store *WALLaptopStore) Delete(id string, revision uint64) error {
+	return store.wal.write(func() (*pb.WALRecord, error) {
+		storedLaptop, err := store.Find(id)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := checkRevision(storedLaptop, revision); err != nil {
+			return nil, err
+		}
+
+		return &pb.WALRecord{Mutation: &pb.WALRecord_LaptopDeleted{LaptopDeleted: id}}, nil
+	}, func() error {
+		return store.InMemoryLaptopStore.Delete(id, 0)
+	})
+}
+
+// WALRatingStore is an InMemoryRatingStore whose writes are logged to a WAL
+type WALRatingStore struct {
+	*InMemoryRatingStore
+	wal *WAL
+}
+
+// Rate sets the score a user rated a laptop, replacing the score the user rated the laptop before.
+func (store *WALRatingStore) Rate(laptopID, username string, score float64) (*Rating, error) {
+	var rating *Rating
+
+	err := store.wal.write(func() (*pb.WALRecord, error) {
+		return &pb.WALRecord{
+			Mutation: &pb.WALRecord_LaptopRated{
+				LaptopRated: &pb.LaptopRated{
+					LaptopId: laptopID,
+					Username: username,
+					Score:    score,
+				},
+			},
+		}, nil
+	}, func() error {
+		var err error
+
+		rating, err = store.InMemoryRatingStore.Rate(laptopID, username, score)
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return rating, nil
+}
+
+// WALUserStore is an InMemoryUserStore whose writes are logged to a WAL
+type WALUserStore struct {
+	*InMemoryUserStore
+	wal *WAL
+}
+
+// Save saves a new user in the store.
+func (store *WALUserStore) Save(user *User) error {
+	return store.wal.write(func() (*pb.WALRecord, error) {
+		storedUser, err := store.FindByUsername(user.Username)
+		if err != nil {
+			return nil, err
+		}
+
+		if storedUser != nil {
+			return nil, ErrRecordExists
+		}
+
+		return &pb.WALRecord{
+			Mutation: &pb.WALRecord_UserSaved{
+				UserSaved: &pb.StoredUser{
+					Username:       user.Username,
+					HashedPassword: user.HashedPassword,
+					Role:           user.Role,
+				},
+			},
+		}, nil
+	}, func() error {
+		return store.InMemoryUserStore.Save(user)
+	})
+}
+
+// WALImageStore is a DiskImageStore whose image metadata is logged to a WAL
+type WALImageStore struct {
+	*DiskImageStore
+	wal *WAL
+}
+
+// walImageWriter is an image writer of a DiskImageStore whose commit is logged to a WAL
+type walImageWriter struct {
+	*diskImageWriter
+	store *WALImageStore
+}
+
+// Create starts writing a new image of a laptop, which is only stored once the returned writer is committed.
+func (store *WALImageStore) Create(laptopID, extension string) (ImageWriter, error) {
+	imageWriter, err := store.create(laptopID, "", "", extension)
+	if err != nil {
+		return nil, err
+	}
+
+	return &walImageWriter{diskImageWriter: imageWriter, store: store}, nil
+}
+
+// Commit moves the written image to its path, logs its metadata and then stores it.
+func (w *walImageWriter) Commit() (string, error) {
+	// The image file is written before the log is locked, only its metadata is logged.
+	info, err := w.finish()
+	if err != nil {
+		return "", err
+	}
+
+	// The file is moved before the metadata is logged, as a replayed image needs its file. The file of an image
+	// whose metadata fails to log is left behind, which is only wasted space.
+	store := w.store.DiskImageStore
+
+	err = w.store.wal.write(func() (*pb.WALRecord, error) {
+		store.mutex.Lock()
+		defer store.mutex.Unlock()
+
+		if err := w.move(info); err != nil {
+			return nil, err
+		}
+
+		return &pb.WALRecord{Mutation: &pb.WALRecord_ImageSaved{ImageSaved: storedImage(info)}}, nil
+	}, func() error {
+		store.mutex.Lock()
+		defer store.mutex.Unlock()
+
+		return store.save(info)
+	})
+
+	if err != nil {
+		_ = w.Abort()
+		return "", err
+	}
+
+	return info.ID, nil
+}
+
+// CreateRendition starts writing a resized version of an image, which is only stored once the returned writer is
+// committed and the image still exists.
+func (store *WALImageStore) CreateRendition(sourceID, rendition, extension string) (ImageWriter, error) {
+	imageWriter, err := store.createRendition(sourceID, rendition, extension)
+	if err != nil {
+		return nil, err
+	}
+
+	return &walImageWriter{diskImageWriter: imageWriter, store: store}, nil
+}
+
+// SetRenditionError records why the renditions of an image could not be generated.
+func (store *WALImageStore) SetRenditionError(imageID, message string) error {
+	return store.wal.write(func() (*pb.WALRecord, error) {
+		info, err := store.Find(imageID)
+		if err != nil {
+			return nil, err
+		}
+
+		if info == nil {
+			return nil, ErrRecordNotFound
+		}
+
+		info.RenditionError = message
+
+		return &pb.WALRecord{Mutation: &pb.WALRecord_ImageSaved{ImageSaved: storedImage(info)}}, nil
+	}, func() error {
+		return store.DiskImageStore.SetRenditionError(imageID, message)
+	})
+}
+
+// Delete removes an image from the store together with its renditions.
+func (store *WALImageStore) Delete(imageID string) error {
+	return store.wal.write(func() (*pb.WALRecord, error) {
+		info, err := store.Find(imageID)
+		if err != nil {
+			return nil, err
+		}
+
+		if info == nil {
+			return nil, ErrRecordNotFound
+		}
+
+		return &pb.WALRecord{Mutation: &pb.WALRecord_ImageDeleted{ImageDeleted: imageID}}, nil
+	}, func() error {
+		return store.DiskImageStore.Delete(imageID)
+	})
+}
@@ -0,0 +1,351 @@
+package service
+
+import (
+	"bytes"
+	"github.com/jwambugu/pcbook-grpc/factory"
+	"github.com/jwambugu/pcbook-grpc/protos/pb"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// openTestWAL opens the write-ahead log in the directory over new in-memory stores and closes it when the test ends
+func openTestWAL(t *testing.T, dir string, options WALOptions) *WAL {
+	wal, err := OpenWAL(dir, WALStores{
+		Laptops: NewInMemoryLaptopStore(),
+		Ratings: NewInMemoryRatingStore(),
+		Users:   NewInMemoryUserStore(),
+		Images:  openTestDiskImageStore(t, t.TempDir()),
+	}, options)
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		_ = wal.Close()
+	})
+
+	return wal
+}
+
+// walTestWrites writes to every store of the write-ahead log and returns the laptops left in the laptop store
+func walTestWrites(t *testing.T, wal *WAL) []*pb.Laptop {
+	laptopStore := wal.LaptopStore()
+
+	laptop := factory.NewLaptop()
+	require.NoError(t, laptopStore.Save(laptop))
+
+	laptop.Name = "Thinkpad X1"
+	require.NoError(t, laptopStore.Update(laptop))
+
+	deletedLaptop := factory.NewLaptop()
+	require.NoError(t, laptopStore.Save(deletedLaptop))
+	require.NoError(t, laptopStore.Delete(deletedLaptop.GetId(), 0))
+
+	// Failed writes are not logged.
+	require.ErrorIs(t, laptopStore.Save(laptop), ErrRecordExists)
+
+	_, err := wal.RatingStore().Rate(laptop.GetId(), "alice", 5)
+	require.NoError(t, err)
+	_, err = wal.RatingStore().Rate(laptop.GetId(), "alice", 4)
+	require.NoError(t, err)
+
+	user := &User{Username: "admin", HashedPassword: "hashed", Role: "admin"}
+	require.NoError(t, wal.UserStore().Save(user))
+
+	imageStore := wal.ImageStore()
+
+	imageID, err := SaveImage(imageStore, laptop.GetId(), ".jpg", bytes.NewReader(factory.NewImage(".jpg", 1, 1)))
+	require.NoError(t, err)
+	require.NoError(t, imageStore.SetRenditionError(imageID, "failed to resize"))
+
+	// Deleting an image deletes its renditions.
+	deletedImageID, err := SaveImage(imageStore, laptop.GetId(), ".png", bytes.NewReader(factory.NewImage(".png", 2, 2)))
+	require.NoError(t, err)
+
+	imageWriter, err := imageStore.CreateRendition(deletedImageID, "small", ".png")
+	require.NoError(t, err)
+
+	_, err = imageWriter.Write(factory.NewImage(".png", 1, 1))
+	require.NoError(t, err)
+
+	_, err = imageWriter.Commit()
+	require.NoError(t, err)
+	require.NoError(t, imageStore.Delete(deletedImageID))
+
+	return []*pb.Laptop{laptop}
+}
+
+// requireWALTestState checks that the stores of the write-ahead log hold what walTestWrites wrote
+func requireWALTestState(t *testing.T, wal *WAL, laptops []*pb.Laptop) {
+	for _, laptop := range laptops {
+		foundLaptop, err := wal.LaptopStore().Find(laptop.GetId())
+		require.NoError(t, err)
+		require.True(t, proto.Equal(laptop, foundLaptop))
+
+		rating, err := wal.RatingStore().Find(laptop.GetId())
+		require.NoError(t, err)
+		require.Equal(t, &Rating{Count: 1, Sum: 4}, rating)
+	}
+
+	storedLaptops := wal.stores.Laptops.snapshot(func() []string {
+		return wal.stores.Laptops.ids
+	})
+	require.Len(t, storedLaptops, len(laptops))
+
+	user, err := wal.UserStore().FindByUsername("admin")
+	require.NoError(t, err)
+	require.Equal(t, &User{Username: "admin", HashedPassword: "hashed", Role: "admin"}, user)
+
+	images := wal.stores.Images.all()
+	require.Len(t, images, 1)
+	require.Equal(t, laptops[0].GetId(), images[0].GetLaptopId())
+	require.Equal(t, ".jpg", images[0].GetExtension())
+	require.Equal(t, "failed to resize", images[0].GetRenditionError())
+}
+
+func TestWAL_Replay(t *testing.T) {
+	t.Parallel()
+
+	for _, syncMode := range []WALSyncMode{WALSyncAlways, WALSyncInterval, WALSyncNever} {
+		dir := t.TempDir()
+		options := WALOptions{Sync: syncMode}
+
+		wal := openTestWAL(t, dir, options)
+		laptops := walTestWrites(t, wal)
+		require.NoError(t, wal.Close())
+		require.ErrorIs(t, wal.LaptopStore().Save(factory.NewLaptop()), ErrWALClosed)
+
+		wal = openTestWAL(t, dir, options)
+		requireWALTestState(t, wal, laptops)
+
+		// Writes after a replay continue the sequence.
+		laptop := factory.NewLaptop()
+		require.NoError(t, wal.LaptopStore().Save(laptop))
+		require.NoError(t, wal.Close())
+
+		wal = openTestWAL(t, dir, options)
+
+		foundLaptop, err := wal.LaptopStore().Find(laptop.GetId())
+		require.NoError(t, err)
+		require.True(t, proto.Equal(laptop, foundLaptop))
+	}
+}
+
+func TestWAL_Snapshot(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, walFileName)
+
+	wal := openTestWAL(t, dir, WALOptions{SnapshotRecords: 4})
+	laptops := walTestWrites(t, wal)
+
+	// Twelve records were written, the log was compacted after the fourth, the eighth and the twelfth.
+	require.FileExists(t, filepath.Join(dir, walSnapshotFileName))
+
+	info, err := os.Stat(logPath)
+	require.NoError(t, err)
+	require.Zero(t, info.Size())
+
+	require.NoError(t, wal.Close())
+
+	wal = openTestWAL(t, dir, WALOptions{})
+	requireWALTestState(t, wal, laptops)
+
+	// A crash after the snapshot was taken but before the log was emptied leaves the compacted records behind. The
+	// snapshot keeps the score of each user, so rating the laptop again replaces it.
+	rating, err := wal.RatingStore().Rate(laptops[0].GetId(), "alice", 1)
+	require.NoError(t, err)
+	require.Equal(t, &Rating{Count: 1, Sum: 1}, rating)
+
+	compactedLog, err := os.ReadFile(logPath)
+	require.NoError(t, err)
+	require.NoError(t, wal.Snapshot())
+	require.NoError(t, wal.Close())
+	require.NoError(t, os.WriteFile(logPath, compactedLog, 0o600))
+
+	wal = openTestWAL(t, dir, WALOptions{})
+
+	rating, err = wal.RatingStore().Find(laptops[0].GetId())
+	require.NoError(t, err)
+	require.Equal(t, &Rating{Count: 1, Sum: 1}, rating)
+}
+
+func TestWAL_Corrupted(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name    string
+		corrupt func(t *testing.T, dir string)
+	}{
+		{
+			name: "record checksum",
+			corrupt: func(t *testing.T, dir string) {
+				path := filepath.Join(dir, walFileName)
+
+				data, err := os.ReadFile(path)
+				require.NoError(t, err)
+
+				data[len(data)/2] ^= 0xff
+				require.NoError(t, os.WriteFile(path, data, 0o600))
+			},
+		},
+		{
+			name: "record length",
+			corrupt: func(t *testing.T, dir string) {
+				path := filepath.Join(dir, walFileName)
+
+				data, err := os.ReadFile(path)
+				require.NoError(t, err)
+
+				// The length of the first record no longer fits in the log.
+				data[0] = 0xff
+				require.NoError(t, os.WriteFile(path, data, 0o600))
+			},
+		},
+		{
+			name: "snapshot checksum",
+			corrupt: func(t *testing.T, dir string) {
+				path := filepath.Join(dir, walSnapshotFileName)
+
+				data, err := os.ReadFile(path)
+				require.NoError(t, err)
+
+				data[len(data)-1] ^= 0xff
+				require.NoError(t, os.WriteFile(path, data, 0o600))
+			},
+		},
+		{
+			name: "record sequence",
+			corrupt: func(t *testing.T, dir string) {
+				path := filepath.Join(dir, walFileName)
+
+				data, err := proto.Marshal(&pb.WALRecord{
+					Sequence: 100,
+					Mutation: &pb.WALRecord_LaptopDeleted{LaptopDeleted: "laptop"},
+				})
+				require.NoError(t, err)
+
+				file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o600)
+				require.NoError(t, err)
+
+				_, err = file.Write(walFrame(data))
+				require.NoError(t, err)
+				require.NoError(t, file.Close())
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+
+			wal := openTestWAL(t, dir, WALOptions{})
+			walTestWrites(t, wal)
+			require.NoError(t, wal.Snapshot())
+
+			for i := 0; i < 3; i++ {
+				require.NoError(t, wal.LaptopStore().Save(factory.NewLaptop()))
+			}
+
+			require.NoError(t, wal.Close())
+
+			tc.corrupt(t, dir)
+
+			_, err := OpenWAL(dir, WALStores{
+				Laptops: NewInMemoryLaptopStore(),
+				Ratings: NewInMemoryRatingStore(),
+				Users:   NewInMemoryUserStore(),
+				Images:  openTestDiskImageStore(t, t.TempDir()),
+			}, WALOptions{})
+			require.ErrorIs(t, err, ErrWALCorrupted)
+		})
+	}
+}
+
+func TestWAL_IncompleteRecord(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, walFileName)
+
+	wal := openTestWAL(t, dir, WALOptions{})
+	laptops := walTestWrites(t, wal)
+	require.NoError(t, wal.Close())
+
+	info, err := os.Stat(logPath)
+	require.NoError(t, err)
+
+	// A crash while appending leaves a record that was not written to the end.
+	data, err := proto.Marshal(&pb.WALRecord{
+		Sequence: 9,
+		Mutation: &pb.WALRecord_LaptopSaved{LaptopSaved: factory.NewLaptop()},
+	})
+	require.NoError(t, err)
+
+	file, err := os.OpenFile(logPath, os.O_WRONLY|os.O_APPEND, 0o600)
+	require.NoError(t, err)
+
+	_, err = file.Write(walFrame(data)[:len(data)/2])
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	wal = openTestWAL(t, dir, WALOptions{})
+	requireWALTestState(t, wal, laptops)
+
+	truncatedInfo, err := os.Stat(logPath)
+	require.NoError(t, err)
+	require.Equal(t, info.Size(), truncatedInfo.Size())
+
+	laptop := factory.NewLaptop()
+	require.NoError(t, wal.LaptopStore().Save(laptop))
+	require.NoError(t, wal.Close())
+
+	wal = openTestWAL(t, dir, WALOptions{})
+
+	foundLaptop, err := wal.LaptopStore().Find(laptop.GetId())
+	require.NoError(t, err)
+	require.NotNil(t, foundLaptop)
+}
+
+func TestWAL_FailedAppend(t *testing.T) {
+	t.Parallel()
+
+	wal := openTestWAL(t, t.TempDir(), WALOptions{})
+	laptopStore := wal.LaptopStore()
+
+	laptop := factory.NewLaptop()
+	require.NoError(t, laptopStore.Save(laptop))
+
+	storedLaptop, err := laptopStore.Find(laptop.GetId())
+	require.NoError(t, err)
+
+	// A write whose record fails to log is not applied, and the log takes no more writes.
+	require.NoError(t, wal.file.Close())
+
+	updatedLaptop, err := deepCopy(laptop)
+	require.NoError(t, err)
+
+	updatedLaptop.Name = "Thinkpad X1"
+	require.Error(t, laptopStore.Update(updatedLaptop))
+
+	foundLaptop, err := laptopStore.Find(laptop.GetId())
+	require.NoError(t, err)
+	require.True(t, proto.Equal(storedLaptop, foundLaptop))
+
+	_, err = wal.RatingStore().Rate(laptop.GetId(), "alice", 5)
+	require.Error(t, err)
+
+	rating, err := wal.RatingStore().Find(laptop.GetId())
+	require.NoError(t, err)
+	require.Nil(t, rating)
+
+	_, err = SaveImage(wal.ImageStore(), laptop.GetId(), ".jpg", bytes.NewReader(factory.NewImage(".jpg", 1, 1)))
+	require.Error(t, err)
+	require.Empty(t, wal.stores.Images.all())
+}
@@ -0,0 +1,57 @@
+// Package units converts the memory sizes and weights of laptops to the units they are compared in.
+package units
+
+import (
+	"math"
+
+	"github.com/jwambugu/pcbook-grpc/protos/pb"
+)
+
+// KilogramsPerPound converts a weight in pounds to kilograms
+const KilogramsPerPound = 0.45359237
+
+// memoryShift returns the power of two converting the memory unit to bits
+func memoryShift(unit pb.Memory_Unit) (uint, bool) {
+	switch unit {
+	case pb.Memory_BIT:
+		return 0, true
+	case pb.Memory_BYTE:
+		return 3, true // 8 = 2^3
+	case pb.Memory_KILOBYTE:
+		return 13, true // 1024 * 8 = 2^10 * 2^3
+	case pb.Memory_MEGABYTE:
+		return 23, true // 1024 * 1024 * 8 = 2^10 * 2^10 * 2^3
+	case pb.Memory_GIGABYTE:
+		return 33, true // 1024 * 1024 * 1024 * 8 = 2^10 * 2^10 * 2^10 * 2^3
+	case pb.Memory_TERABYTE:
+		return 43, true // 1024 * 1024 * 1024 * 1024 * 8 = 2^10 * 2^10 * 2^10 * 2^10 * 2^3
+	default:
+		return 0, false
+	}
+}
+
+// MaxMemoryValue returns the largest value in the memory unit that converts to bits without overflowing.
+func MaxMemoryValue(unit pb.Memory_Unit) uint64 {
+	shift, ok := memoryShift(unit)
+	if !ok {
+		return 0
+	}
+
+	return math.MaxUint64 >> shift
+}
+
+// MemoryBits converts memory unit to bits, saturating at math.MaxUint64 instead of overflowing.
+func MemoryBits(memory *pb.Memory) uint64 {
+	value := memory.GetValue()
+
+	shift, ok := memoryShift(memory.GetUnit())
+	if !ok {
+		return 0
+	}
+
+	if value > math.MaxUint64>>shift {
+		return math.MaxUint64
+	}
+
+	return value << shift
+}